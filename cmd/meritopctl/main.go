@@ -0,0 +1,406 @@
+// Command meritopctl manages the etcd layout of a job with the controller
+// package, e.g. to set up a job before its tasks start:
+//
+//	meritopctl -job=regression -tasks=2 init
+//	meritopctl -job=regression status
+//	meritopctl -job=regression epoch set 3
+//	meritopctl -job=regression shutdown
+//	meritopctl -job=regression destroy
+//
+// A job can be set up from a spec file instead, see controller.JobSpec:
+//
+//	meritopctl -spec=regression.json init
+//
+// Jobs of a team can be kept apart under a root of their own with -root, and
+// listed with:
+//
+//	meritopctl -root=team jobs
+//
+// Jobs set up with a TTL, e.g. -ttl=24h, are abandoned once no task has
+// heartbeated for as long. Those whose controllers have gone as well are torn
+// down with:
+//
+//	meritopctl -root=team reap
+//
+// A job set up can be watched by replicas of its controller, run on a few
+// machines, one of which leads the job and cleans it up once it has finished:
+//
+//	meritopctl -job=regression -replica=ctl-a run
+//
+// Jobs under the root can be managed over HTTP as well, see controllerhttp,
+// which exports their metrics for Prometheus at /metrics:
+//
+//	meritopctl -root=team serve :8080
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop/controller"
+	"github.com/go-distributed/meritop/controller/controllerhttp"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+const usage = `usage: meritopctl [flags] <command> [args]
+
+commands:
+  jobs           list jobs registered under the root
+  reap           tear down jobs under the root abandoned for their TTL
+  serve <addr>   serve jobs under the root over HTTP on addr
+  init           set up the etcd layout of the job, from -spec if given
+  destroy        delete the job from etcd
+  status         print the state of the job
+  events         print the audit log of the job
+  progress       print progress tasks reported in each epoch of the job
+  metrics        print counters of failures, reassignments and epochs of the
+                 job, and its free tasks
+  epoch get      print the current epoch of the job
+  epoch set <n>  move the job to epoch n, rolling it back if n is earlier
+  resize <n>     grow or shrink the running job to n tasks
+  shutdown       make all tasks of the job exit
+  fault <kind> <task>
+                 inject a fault into the task to test recovery, kind being
+                 expire (its health), scramble (its registration) or revoke
+                 (it from its node)
+  gc             remove stale entries of the job, or list them with -dry-run
+  run            watch the job as one of replicas of its controller until it's
+                 cleaned up
+
+flags:
+`
+
+func main() {
+	etcdURLs := flag.String("etcd", "http://localhost:4001", "comma separated etcd URLs")
+	etcdCA := flag.String("etcd-ca", "", "CA file to verify etcd with")
+	etcdCert := flag.String("etcd-cert", "", "certificate file to present to etcd, with -etcd-key")
+	etcdKey := flag.String("etcd-key", "", "key file of -etcd-cert")
+	etcdUser := flag.String("etcd-user", "", "user[:password] of etcd auth")
+	job := flag.String("job", "", "job name")
+	root := flag.String("root", "", "etcd root the job is kept under")
+	owner := flag.String("owner", "", "who the job is registered to, for init")
+	ntask := flag.Uint64("tasks", 0, "number of tasks of the job, for init")
+	auth := flag.Bool("auth", false, "make tasks authenticate data requests, for init")
+	replica := flag.String("replica", "", "ID of the replica of the controller, for run (host:pid by default)")
+	dryRun := flag.Bool("dry-run", false, "only list stale entries, for gc")
+	specFile := flag.String("spec", "", "job spec file in JSON, for init instead of -job and -tasks")
+	ttl := flag.Duration("ttl", 0, "how long the job lives without tasks heartbeating, for init (forever by default)")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(0)
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	info := etcdutil.ClientInfo{CAFile: *etcdCA, CertFile: *etcdCert, KeyFile: *etcdKey}
+	if *etcdUser != "" {
+		userPass := strings.SplitN(*etcdUser, ":", 2)
+		info.Username = userPass[0]
+		if len(userPass) == 2 {
+			info.Password = userPass[1]
+		}
+	}
+	client, err := etcdutil.NewClient(strings.Split(*etcdURLs, ","), info)
+	if err != nil {
+		log.Fatalf("creating etcd client: %v", err)
+	}
+	args := flag.Args()
+	switch args[0] {
+	case "jobs":
+		if err := jobs(client, *root); err != nil {
+			log.Fatalf("%s: %v", args[0], err)
+		}
+		return
+	case "reap":
+		reaped, err := controller.ReapAbandonedJobs(client, *root)
+		for _, job := range reaped {
+			fmt.Println(job)
+		}
+		if err != nil {
+			log.Fatalf("%s: %v", args[0], err)
+		}
+		return
+	case "serve":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		log.Fatal(http.ListenAndServe(args[1], controllerhttp.NewServer(client, *root)))
+	case "init":
+		if *specFile == "" {
+			break
+		}
+		if err := initFromSpec(client, *root, *specFile); err != nil {
+			log.Fatalf("%s: %v", args[0], err)
+		}
+		return
+	}
+	if *job == "" {
+		log.Fatalf("Please specify a job name")
+	}
+
+	c := controller.New(*job, client, *ntask)
+	c.SetRoot(*root)
+	if *owner != "" {
+		c.SetOwner(*owner)
+	}
+	switch args[0] {
+	case "init":
+		if *ntask == 0 {
+			log.Fatalf("Please specify the number of tasks")
+		}
+		if *auth {
+			c.EnableAuth()
+		}
+		if *ttl > 0 {
+			c.SetTTL(*ttl)
+		}
+		err = c.InitEtcdLayout()
+	case "destroy":
+		err = c.DestroyEtcdLayout()
+	case "status":
+		err = status(c)
+	case "events":
+		err = events(c)
+	case "metrics":
+		err = metrics(c)
+	case "progress":
+		err = progress(c)
+	case "epoch":
+		err = epoch(c, args[1:])
+	case "resize":
+		err = resize(c, args[1:])
+	case "shutdown":
+		err = c.ShutdownJob()
+	case "fault":
+		err = fault(c, args[1:])
+	case "gc":
+		err = gc(c, *dryRun)
+	case "run":
+		err = run(c, *replica)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", args[0], err)
+	}
+}
+
+// initFromSpec sets up the job of the spec file. The job is checkpointed by
+// its spec only while a controller runs it, e.g. served over HTTP.
+func initFromSpec(client *etcd.Client, root, filename string) error {
+	spec, err := controller.ReadJobSpecFile(filename)
+	if err != nil {
+		return err
+	}
+	c, err := controller.NewFromSpec(client, root, spec)
+	if err != nil {
+		return err
+	}
+	return c.InitEtcdLayout()
+}
+
+func jobs(client *etcd.Client, root string) error {
+	jobs, err := controller.ListJobs(client, root)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tOWNER\tCREATED")
+	for _, j := range jobs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", j.Name, j.Owner, j.Created.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func status(c *controller.Controller) error {
+	s, err := c.Status()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("job: %s\n", s.Name)
+	fmt.Printf("epoch: %d\n", s.Epoch)
+	fmt.Printf("tasks: %d\n", s.NumOfTasks)
+	fmt.Printf("done: %t\n", s.Done)
+	if s.Checkpointed {
+		fmt.Printf("last checkpoint: %d\n", s.LastCheckpoint)
+	} else {
+		fmt.Printf("last checkpoint: none\n")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tADDRESS\tHEALTHY\tSTATE\tGENERATION\tRESTARTS\tEPOCHS DONE")
+	for _, t := range s.Tasks {
+		fmt.Fprintf(w, "%d\t%s\t%t\t%s\t%d\t%d\t%v\n",
+			t.ID, t.Address, t.Healthy, taskState(s, t), t.Generation, t.Restarts, t.EpochsDone)
+	}
+	return w.Flush()
+}
+
+func events(c *controller.Controller) error {
+	events, err := c.Events()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tKIND\tACTOR\tEPOCH\tTASK\tDETAIL")
+	for _, e := range events {
+		epoch := strconv.FormatUint(e.Epoch, 10)
+		if e.Epoch == etcdutil.ExitEpoch {
+			epoch = "exit"
+		}
+		task := "-"
+		if e.Kind == etcdutil.EventRegister || e.Kind == etcdutil.EventFailure {
+			task = strconv.FormatUint(e.TaskID, 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Time.Format(time.RFC3339), e.Kind, e.Actor, epoch, task, e.Detail)
+	}
+	return w.Flush()
+}
+
+func metrics(c *controller.Controller) error {
+	m, err := c.Metrics()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("failures: %d\n", m.Failures)
+	fmt.Printf("reassignments: %d\n", m.Reassignments)
+	fmt.Printf("epochs completed: %d\n", m.EpochsCompleted)
+	fmt.Printf("free tasks: %d\n", m.FreeTasks)
+	return nil
+}
+
+func progress(c *controller.Controller) error {
+	eps, err := c.Progress()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "EPOCH\tREPORTED\tITEMS\tLOSS\tDURATION")
+	for _, ep := range eps {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%g\t%v\n", ep.Epoch, ep.Reported, ep.Items, ep.Loss, ep.Duration)
+	}
+	return w.Flush()
+}
+
+func taskState(s *controller.JobStatus, t controller.TaskStatus) string {
+	switch {
+	case t.Poisoned:
+		return "poisoned: " + s.Poisoned[t.ID]
+	case t.Exited:
+		return "exited"
+	case t.Free:
+		return "free"
+	case t.Address != "":
+		return "running"
+	}
+	return "unknown"
+}
+
+func epoch(c *controller.Controller, args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "get":
+		epoch, err := c.Epoch()
+		if err != nil {
+			return err
+		}
+		fmt.Println(epoch)
+		return nil
+	case len(args) == 2 && args[0] == "set":
+		epoch, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad epoch %q", args[1])
+		}
+		return c.SetEpoch(epoch)
+	}
+	flag.Usage()
+	os.Exit(2)
+	return nil
+}
+
+func gc(c *controller.Controller, dryRun bool) error {
+	r, err := c.GC(dryRun)
+	if err != nil {
+		return err
+	}
+	for _, keys := range [][]string{r.Healthy, r.Free, r.Metas, r.Progress} {
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+	}
+	return nil
+}
+
+// run leads the job while elected, until the job has been cleaned up, or an
+// interrupt gives the lead up to other replicas.
+func run(c *controller.Controller, replica string) error {
+	if replica == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		replica = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	c.EnableLeaderElection(replica)
+	c.EnableAutoCleanup(nil)
+	if err := c.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.WaitForCleanup() }()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	select {
+	case err := <-done:
+		c.Stop()
+		return err
+	case <-interrupt:
+		return c.Stop()
+	}
+}
+
+func fault(c *controller.Controller, args []string) error {
+	if len(args) != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad task %q", args[1])
+	}
+	switch args[0] {
+	case "expire":
+		return c.ExpireHealth(id)
+	case "scramble":
+		return c.ScrambleRegistration(id)
+	case "revoke":
+		return c.RevokeTask(id)
+	}
+	return fmt.Errorf("unknown fault %q", args[0])
+}
+
+func resize(c *controller.Controller, args []string) error {
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	n, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad number of tasks %q", args[0])
+	}
+	return c.Resize(n)
+}
@@ -0,0 +1,16 @@
+package meritop
+
+// Codec serializes the data tasks exchange with each other, so that task
+// implementations don't need to hand-roll encoding. Types of values need to
+// be registered before they can be unmarshaled, since the wire format carries
+// the type of the value.
+type Codec interface {
+	// Register makes the type of v known to the codec. It should be done on
+	// both ends, e.g. in Task.Init.
+	Register(v interface{})
+
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal returns a value of the registered type encoded in data.
+	Unmarshal(data []byte) (interface{}, error)
+}
@@ -0,0 +1,110 @@
+package meritop
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Config is the configuration of a job, i.e. keys and values the controller
+// sets in etcd for all tasks. Typed getters return def if the key is missing,
+// and a *ConfigError if its value is malformed.
+type Config map[string]string
+
+// ConfigError is returned for a value of key which can't be parsed.
+type ConfigError struct {
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config %s=%q: %v", e.Key, e.Value, e.Err)
+}
+
+// MissingConfigError is returned by Config.Require for keys which aren't set.
+type MissingConfigError struct {
+	Keys []string
+}
+
+func (e *MissingConfigError) Error() string {
+	return fmt.Sprintf("config keys missing: %v", e.Keys)
+}
+
+// Require checks that all keys are set.
+func (c Config) Require(keys ...string) error {
+	var missing []string
+	for _, key := range keys {
+		if _, ok := c[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return &MissingConfigError{Keys: missing}
+	}
+	return nil
+}
+
+// String returns the value of key, or def if it's missing.
+func (c Config) String(key, def string) string {
+	if v, ok := c[key]; ok {
+		return v
+	}
+	return def
+}
+
+// Int returns the value of key as an int, or def if it's missing.
+func (c Config) Int(key string, def int) (int, error) {
+	v, ok := c[key]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return def, &ConfigError{Key: key, Value: v, Err: err}
+	}
+	return i, nil
+}
+
+// Uint64 returns the value of key as an uint64, or def if it's missing.
+func (c Config) Uint64(key string, def uint64) (uint64, error) {
+	v, ok := c[key]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return def, &ConfigError{Key: key, Value: v, Err: err}
+	}
+	return i, nil
+}
+
+// Duration returns the value of key, e.g. "1m30s", as a time.Duration, or def
+// if it's missing.
+func (c Config) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := c[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def, &ConfigError{Key: key, Value: v, Err: err}
+	}
+	return d, nil
+}
+
+// Bool returns the value of key, e.g. "true" or "0", as a bool, or def if it's
+// missing.
+func (c Config) Bool(key string, def bool) (bool, error) {
+	v, ok := c[key]
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, &ConfigError{Key: key, Value: v, Err: err}
+	}
+	return b, nil
+}
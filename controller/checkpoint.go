@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// checkpointPeriodically checkpoints the job each time it has gone
+// checkpointEvery epochs past the last checkpoint, until the job has been shut
+// down or the controller stops. A checkpoint under way when the controller
+// stops isn't waited for.
+func (c *Controller) checkpointPeriodically(checkpointStop chan bool) {
+	epochC := make(chan uint64, 1)
+	stop := make(chan bool)
+	defer close(stop)
+	epoch, err := etcdutil.GetAndWatchEpoch(c.etcdclient, c.name, epochC, stop)
+	if err != nil {
+		c.logger.Errorf("watching epoch of job %s for checkpoints failed: %v", c.name, err)
+		return
+	}
+	last, ok, err := c.LastCheckpoint()
+	if err != nil {
+		c.logger.Errorf("reading last checkpoint of job %s failed: %v", c.name, err)
+		return
+	}
+	if !ok {
+		last = 0
+	}
+	for {
+		if epoch == etcdutil.ExitEpoch {
+			return
+		}
+		// Tasks are asked to checkpoint at the start of the next epoch.
+		if epoch+1 >= last+c.checkpointEvery {
+			c.logger.Infof("checkpointing job %s at epoch %d", c.name, epoch+1)
+			if last, err = c.Checkpoint(); err != nil {
+				c.logger.Errorf("checkpointing job %s failed: %v", c.name, err)
+				return
+			}
+		}
+		select {
+		case epoch = <-epochC:
+		case <-checkpointStop:
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// errCleanupStopped is returned by cleanup if it's been stopped before the job
+// finished.
+var errCleanupStopped = errors.New("controller: cleanup stopped")
+
+// EnableAutoCleanup makes the controller tear the etcd layout of the job down
+// once the job has finished: it has been shut down, and all tasks have exited
+// or expired. If archive isn't nil, it's given the status of the job first,
+// e.g. to keep a summary of it. The layout is left if archive fails. It needs
+// to be called before Start.
+func (c *Controller) EnableAutoCleanup(archive func(*JobStatus) error) {
+	c.autoCleanup = true
+	c.archive = archive
+}
+
+// WaitForCleanup blocks until the job has been cleaned up with auto cleanup
+// enabled, or the controller stopped, and returns what went wrong.
+func (c *Controller) WaitForCleanup() error {
+	if !c.autoCleanup {
+		return nil
+	}
+	return <-c.cleanupDone
+}
+
+// cleanupWhenDone waits for the job to finish, and cleans it up, until the
+// controller stops. A replica of the controller stepping down leaves it to the
+// next leader.
+func (c *Controller) cleanupWhenDone(cleanupStop chan bool) {
+	err := c.cleanup(cleanupStop)
+	if err == errCleanupStopped {
+		if c.replicaID != "" && !c.stopping() {
+			return
+		}
+		err = nil
+	}
+	c.cleanupDone <- err
+}
+
+func (c *Controller) cleanup(cleanupStop chan bool) error {
+	// Watches of etcdutil are stopped by closing, once they return or the
+	// controller stops.
+	stop := make(chan bool)
+	returned := make(chan struct{})
+	defer close(returned)
+	go func() {
+		select {
+		case <-returned:
+		case <-cleanupStop:
+		}
+		close(stop)
+	}()
+
+	if ok, err := etcdutil.WaitEpoch(c.etcdclient, c.name, etcdutil.ExitEpoch, stop); !ok {
+		return stoppedOr(err)
+	}
+	c.logger.Infof("job %s has been shut down, waiting for tasks to exit", c.name)
+	if ok, err := etcdutil.WaitTasksGone(c.etcdclient, c.name, stop); !ok {
+		return stoppedOr(err)
+	}
+	if c.archive != nil {
+		s, err := c.Status()
+		if err != nil {
+			return err
+		}
+		if err := c.archive(s); err != nil {
+			c.logger.Errorf("archiving job %s failed, etcd layout is left: %v", c.name, err)
+			return err
+		}
+	}
+	c.logger.Infof("job %s has finished, cleaning up etcd layout", c.name)
+	c.stopWatches()
+	return c.DestroyEtcdLayout()
+}
+
+func stoppedOr(err error) error {
+	if err == nil {
+		return errCleanupStopped
+	}
+	return etcdutil.WrapError(err)
+}
@@ -1,64 +1,429 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
 	"os"
+	"path"
 	"strconv"
+	"sync"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
+	merrors "github.com/go-distributed/meritop/errors"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
 )
 
+// ErrNoCheckpoint is returned by ResumeFromCheckpoint if all tasks haven't
+// checkpointed at any epoch yet.
+var ErrNoCheckpoint = errors.New("controller: job has no checkpoint")
+
 // This is the controller of a job.
 // A job needs controller to setup etcd data layout, request
 // cluster containers, etc. to setup framework to run.
 type Controller struct {
+	// name is the job kept under root, see etcdutil.JobName.
 	name           string
-	etcdclient     *etcd.Client
+	job            string
+	root           string
+	owner          string
+	etcdclient     etcdutil.Client
 	numOfTasks     uint64
 	failDetectStop chan bool
-	logger         *log.Logger
+	poisonStop     chan bool
+	logger         meritop.Logger
 	jobStatusChan  chan string
+	auth           bool
+	// initConfig is set as the configuration of the job as it's set up.
+	initConfig map[string]string
+	// topology is kept in etcd as the job is set up, see JobTopology.
+	topology *TopologySpec
+	// newTopology, if set, gets the topology tasks run on, to validate it.
+	newTopology func() meritop.Topology
+	// If checkpointEvery is set, the job is checkpointed every as many epochs.
+	checkpointEvery uint64
+	checkpointStop  chan bool
+	// If paced, only the controller advances the epoch, every paceInterval,
+	// or once all tasks are done with it if paceInterval is 0.
+	paced        bool
+	paceInterval time.Duration
+	paceStop     chan bool
+	// With auto cleanup, the job is cleaned up once it has finished, and
+	// archived first if archive is set.
+	autoCleanup bool
+	archive     func(*JobStatus) error
+	cleanupStop chan bool
+	cleanupDone chan error
+	// With leader election, the controller is one of replicas, identified
+	// by replicaID, and watches the job only while leading it.
+	replicaID    string
+	leaderMu     sync.Mutex
+	leading      bool
+	stopped      bool
+	electionStop chan bool
+	electionDone chan struct{}
+	stopOnce     sync.Once
+	// With ttl, the job has a lease tasks keep, and is torn down once they
+	// haven't for ttl.
+	ttl       time.Duration
+	leaseStop chan bool
+	// watchOnce stops watches started the last time.
+	watchOnce *sync.Once
 }
 
-func New(name string, etcd *etcd.Client, numOfTasks uint64) *Controller {
+// New creates the controller of the job of numOfTasks tasks, coordinating them
+// through etcd, e.g. a client created by etcdutil.NewClient for etcd requiring
+// TLS or auth.
+func New(name string, etcd etcdutil.Client, numOfTasks uint64) *Controller {
 	return &Controller{
 		name:       name,
+		job:        name,
 		etcdclient: etcd,
 		numOfTasks: numOfTasks,
-		logger:     log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate),
+		logger:     meritop.NewStdLogger(log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate), meritop.LevelInfo),
+	}
+}
+
+// SetLogger makes the controller log to l instead of stdout.
+func (c *Controller) SetLogger(l meritop.Logger) {
+	c.logger = l
+}
+
+// SetRoot keeps the job under root in etcd, e.g. to share an etcd cluster
+// among teams. Tasks of the job have to be given the same root, see
+// framework.WithEtcdRoot. It needs to be called before anything else.
+func (c *Controller) SetRoot(root string) {
+	c.root = root
+	c.name = etcdutil.JobName(root, c.job)
+}
+
+// SetOwner sets who the job is registered to, user@host of the controller by
+// default.
+func (c *Controller) SetOwner(owner string) {
+	c.owner = owner
+}
+
+// EnableAuth makes tasks of the job authenticate data requests with a token
+// the controller creates in etcd. It needs to be called before Start.
+func (c *Controller) EnableAuth() {
+	c.auth = true
+}
+
+// SetConfig sets keys of the configuration of the job, which tasks get with
+// meritop.ConfiguredFramework. Tasks load it as they start, and running ones
+// are told about changes with meritop.ConfigWatcher.
+func (c *Controller) SetConfig(cfg map[string]string) error {
+	if err := c.checkLeader(); err != nil {
+		return err
 	}
+	return etcdutil.WrapError(etcdutil.SetConfig(c.etcdclient, c.name, cfg))
 }
 
 // A controller typical workflow:
 // 1. controller sets up etcd layout before any task starts running.
 // 2. Being ready, controller lets other tasks to run and reports any failure found.
+//
+// Replicas of the controller, see EnableLeaderElection, campaign to lead the
+// job instead, and watch it while leading it.
 func (c *Controller) Start() error {
+	if c.replicaID != "" {
+		if c.autoCleanup {
+			c.cleanupDone = make(chan error, 1)
+		}
+		c.electionStop = make(chan bool)
+		c.electionDone = make(chan struct{})
+		go c.runElection()
+		c.logger.Infof("Controller replica %s starting, name: %s\n", c.replicaID, c.name)
+		return nil
+	}
 	if err := c.InitEtcdLayout(); err != nil {
 		return err
 	}
+	c.startWatches()
+	c.logger.Infof("Controller starting, name: %s, numberOfTask: %d\n", c.name, c.numOfTasks)
+	return nil
+}
+
+// startWatches starts watching the job for failures, and whatever else the
+// controller has been asked to do while the job runs.
+func (c *Controller) startWatches() {
+	c.watchOnce = new(sync.Once)
 	// Currently no previous changes will be watches before watch is setup.
 	// We assumes that ttl is usually a few seconds. watch is setup before that.
+	c.failDetectStop = make(chan bool, 1)
 	go c.startFailureDetection()
-	c.logger.Printf("Controller starting, name: %s, numberOfTask: %d\n", c.name, c.numOfTasks)
+	c.poisonStop = make(chan bool, 1)
+	go c.watchPoisoned()
+	if c.autoCleanup {
+		c.cleanupStop = make(chan bool)
+		if c.cleanupDone == nil {
+			c.cleanupDone = make(chan error, 1)
+		}
+		go c.cleanupWhenDone(c.cleanupStop)
+	}
+	if c.paced {
+		c.paceStop = make(chan bool)
+		go c.paceEpochs(c.paceStop)
+	}
+	if c.checkpointEvery > 0 {
+		c.checkpointStop = make(chan bool)
+		go c.checkpointPeriodically(c.checkpointStop)
+	}
+	if c.ttl > 0 {
+		c.leaseStop = make(chan bool)
+		go c.teardownWhenAbandoned(c.leaseStop)
+	}
+}
+
+// RollbackEpoch rolls the job back to the start of epoch, e.g. the one a task
+// died in, so that all tasks compute it again consistently. Tasks themselves
+// only move epoch forward.
+func (c *Controller) RollbackEpoch(epoch uint64) error {
+	if err := c.checkLeader(); err != nil {
+		return err
+	}
+	if err := etcdutil.RollbackEpoch(c.etcdclient, c.name, epoch); err != nil {
+		return etcdutil.WrapError(err)
+	}
+	c.recordEvent(etcdutil.Event{Kind: etcdutil.EventEpoch, Epoch: epoch, Detail: "rolled back"})
 	return nil
 }
 
+// Epoch returns the current epoch of the job.
+func (c *Controller) Epoch() (uint64, error) {
+	epoch, err := etcdutil.GetEpoch(c.etcdclient, c.name)
+	return epoch, etcdutil.WrapError(err)
+}
+
+// SetEpoch moves the job to epoch. Setting an earlier one rolls the job back
+// like RollbackEpoch.
+func (c *Controller) SetEpoch(epoch uint64) error {
+	if err := c.checkLeader(); err != nil {
+		return err
+	}
+	for {
+		cur, err := c.Epoch()
+		if err != nil {
+			return err
+		}
+		switch {
+		case epoch == cur:
+			return nil
+		case epoch < cur:
+			return c.RollbackEpoch(epoch)
+		}
+		err = etcdutil.CASEpoch(c.etcdclient, c.name, cur, epoch)
+		if err == nil {
+			c.recordEvent(etcdutil.Event{Kind: etcdutil.EventEpoch, Epoch: epoch, Detail: "set"})
+		}
+		if merrors.Kind(err) != merrors.ErrEpochConflict {
+			return err
+		}
+		// Tasks moved the epoch on meanwhile.
+	}
+}
+
+// ShutdownJob makes all tasks of the job exit, as Framework.ShutdownJob does.
+func (c *Controller) ShutdownJob() error {
+	if err := c.SetEpoch(etcdutil.ExitEpoch); err != nil {
+		return err
+	}
+	if err := etcdutil.SetJobStatus(c.etcdclient, c.name, 0); err != nil {
+		return etcdutil.WrapError(err)
+	}
+	c.recordEvent(etcdutil.Event{Kind: etcdutil.EventShutdown})
+	return nil
+}
+
+// FailTask makes the node running the task give it up as if it failed, e.g.
+// since it hangs. The task is freed for a standby to take over.
+func (c *Controller) FailTask(taskID uint64) error {
+	if err := c.checkLeader(); err != nil {
+		return err
+	}
+	return etcdutil.WrapError(etcdutil.FailTask(c.etcdclient, c.name, taskID, c.actor()))
+}
+
+// PoisonedTasks returns tasks which failed too many times and aren't taken
+// over any more, with reasons.
+func (c *Controller) PoisonedTasks() (map[uint64]string, error) {
+	tasks, err := etcdutil.PoisonedTasks(c.etcdclient, c.name)
+	return tasks, etcdutil.WrapError(err)
+}
+
+// UnpoisonTask frees the poisoned task to be taken over again, e.g. once what
+// made it fail has been fixed.
+func (c *Controller) UnpoisonTask(taskID uint64) error {
+	if err := c.checkLeader(); err != nil {
+		return err
+	}
+	return etcdutil.WrapError(etcdutil.UnpoisonTask(c.etcdclient, c.name, taskID))
+}
+
+// watchPoisoned raises the alarm for tasks poisoned, which stall the job
+// until someone looks into them.
+func (c *Controller) watchPoisoned() {
+	etcdutil.WatchPoisoned(c.etcdclient, c.name, c.poisonStop, func(taskID uint64, reason string) {
+		c.logger.Errorf("ALERT: job %s: task %d is poisoned: %s", c.name, taskID, reason)
+	})
+}
+
+// Checkpoint asks all tasks to checkpoint at the start of the next epoch,
+// waits until they all have, and records it as the last checkpoint of the job,
+// which is returned.
+func (c *Controller) Checkpoint() (uint64, error) {
+	if err := c.checkLeader(); err != nil {
+		return 0, err
+	}
+	resp, err := c.etcdclient.Get(etcdutil.EpochPath(c.name), false, false)
+	if err != nil {
+		return 0, etcdutil.WrapError(err)
+	}
+	epoch, err := strconv.ParseUint(resp.Node.Value, 10, 64)
+	if err != nil {
+		return 0, etcdutil.WrapError(err)
+	}
+	epoch++
+	n, ok, err := etcdutil.GetNumOfTasks(c.etcdclient, c.name)
+	if err != nil {
+		return 0, etcdutil.WrapError(err)
+	}
+	if !ok {
+		n = c.numOfTasks
+	}
+	if err := etcdutil.RequestCheckpoint(c.etcdclient, c.name, epoch); err != nil {
+		return 0, etcdutil.WrapError(err)
+	}
+	if err := etcdutil.WaitCheckpoint(c.etcdclient, c.name, epoch, int(n)); err != nil {
+		return 0, etcdutil.WrapError(err)
+	}
+	return epoch, etcdutil.WrapError(etcdutil.SetLastCheckpoint(c.etcdclient, c.name, epoch))
+}
+
+// LastCheckpoint returns the last epoch all tasks checkpointed at. It returns
+// false if there is none.
+func (c *Controller) LastCheckpoint() (uint64, bool, error) {
+	epoch, ok, err := etcdutil.LastCheckpoint(c.etcdclient, c.name)
+	return epoch, ok, etcdutil.WrapError(err)
+}
+
+// ResumeFromCheckpoint rolls the job back to the last epoch all tasks
+// checkpointed at, e.g. after the whole job restarted. Tasks restore their
+// states with meritop.Restorable.
+func (c *Controller) ResumeFromCheckpoint() (uint64, error) {
+	if err := c.checkLeader(); err != nil {
+		return 0, err
+	}
+	epoch, ok, err := c.LastCheckpoint()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrNoCheckpoint
+	}
+	return epoch, c.RollbackEpoch(epoch)
+}
+
 func (c *Controller) WaitForJobDone() error {
 	<-c.jobStatusChan
 	return nil
 }
 
+// Stop stops watching the job, and destroys it. Replicas give the lead up
+// instead, and leave the job to others.
 func (c *Controller) Stop() error {
+	c.stopOnce.Do(func() {
+		c.leaderMu.Lock()
+		c.stopped = true
+		c.leaderMu.Unlock()
+		if c.electionStop != nil {
+			close(c.electionStop)
+			<-c.electionDone
+		}
+	})
+	if c.replicaID != "" {
+		c.logger.Infof("Controller replica %s stopping...\n", c.replicaID)
+		return nil
+	}
+	c.stopWatches()
 	c.DestroyEtcdLayout()
-	c.stopFailureDetection()
-	c.logger.Printf("Controller stoping...\n")
+	c.logger.Infof("Controller stoping...\n")
 	return nil
 }
 
+// stopWatches stops detecting failures and watching poisoned tasks, before
+// the etcd layout is destroyed, so that it isn't taken for tasks failing.
+func (c *Controller) stopWatches() {
+	if c.watchOnce == nil {
+		return
+	}
+	c.watchOnce.Do(func() {
+		if c.cleanupStop != nil {
+			close(c.cleanupStop)
+		}
+		c.stopFailureDetection()
+		c.poisonStop <- true
+		if c.checkpointStop != nil {
+			close(c.checkpointStop)
+		}
+		if c.paceStop != nil {
+			close(c.paceStop)
+		}
+		if c.leaseStop != nil {
+			close(c.leaseStop)
+		}
+	})
+}
+
+// InitEtcdLayout registers the job, and sets up its layout in etcd. It fails
+// with merrors.ErrJobExists if a job of the same name runs under the root, and
+// before registering it if the topology doesn't fit the number of tasks, see
+// SetTopology.
 func (c *Controller) InitEtcdLayout() error {
+	if err := c.checkLeader(); err != nil {
+		return err
+	}
+	if err := c.validateTopology(c.numOfTasks); err != nil {
+		return err
+	}
+	if err := c.register(); err != nil {
+		return err
+	}
+	if c.ttl > 0 {
+		if err := etcdutil.SetLease(c.etcdclient, c.name, c.ttl); err != nil {
+			return etcdutil.WrapError(err)
+		}
+	}
+	if len(c.initConfig) > 0 {
+		if err := c.SetConfig(c.initConfig); err != nil {
+			return err
+		}
+	}
+	if c.paced {
+		if err := etcdutil.SetControllerPaced(c.etcdclient, c.name); err != nil {
+			return etcdutil.WrapError(err)
+		}
+	}
+	if c.topology != nil {
+		topology, err := json.Marshal(c.topology)
+		if err != nil {
+			return err
+		}
+		if err := etcdutil.SetTopology(c.etcdclient, c.name, string(topology)); err != nil {
+			return etcdutil.WrapError(err)
+		}
+	}
 	// Initilize the job epoch to 0
 	etcdutil.MustCreate(c.etcdclient, c.logger, etcdutil.EpochPath(c.name), "0", 0)
+	etcdutil.MustCreate(c.etcdclient, c.logger, etcdutil.NumOfTasksPath(c.name), strconv.FormatUint(c.numOfTasks, 10), 0)
+	if c.auth {
+		token, err := etcdutil.NewAuthToken()
+		if err != nil {
+			return err
+		}
+		etcdutil.MustCreate(c.etcdclient, c.logger, etcdutil.AuthTokenPath(c.name), token, 0)
+	}
 	c.setupWatchOnJobStatus()
 	// initiate etcd data layout for tasks
 	// currently it creates as many unassigned tasks as task masters.
@@ -73,14 +438,21 @@ func (c *Controller) InitEtcdLayout() error {
 	return nil
 }
 
+// DestroyEtcdLayout deletes everything of the job from etcd, and takes it off
+// the registry. Other jobs are left alone.
 func (c *Controller) DestroyEtcdLayout() error {
-	_, err := c.etcdclient.Delete("/", true)
-	return err
+	if err := c.checkLeader(); err != nil {
+		return err
+	}
+	_, err := c.etcdclient.Delete(path.Join("/", c.name), true)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	return etcdutil.UnregisterJob(c.etcdclient, c.root, c.job)
 }
 
 func (c *Controller) startFailureDetection() error {
-	c.failDetectStop = make(chan bool, 1)
-	return etcdutil.DetectFailure(c.etcdclient, c.name, c.failDetectStop, c.logger)
+	return etcdutil.DetectFailure(c.etcdclient, c.name, c.actor(), c.failDetectStop, c.logger)
 }
 
 func (c *Controller) setupWatchOnJobStatus() {
@@ -89,8 +461,10 @@ func (c *Controller) setupWatchOnJobStatus() {
 	resp := etcdutil.MustCreate(c.etcdclient, c.logger, key, "", 0)
 	go func() {
 		resp, err := c.etcdclient.Watch(key, resp.EtcdIndex+1, false, nil, nil)
+		// etcd might have gone, e.g. with the job destroyed.
 		if err != nil {
-			c.logger.Panicf("Watch on job status (%v) failed: %v", key, err)
+			c.logger.Errorf("Watch on job status (%v) failed: %v", key, err)
+			return
 		}
 		c.jobStatusChan <- resp.Node.Value
 	}()
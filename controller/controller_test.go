@@ -2,10 +2,17 @@ package controller
 
 import (
 	"fmt"
+	"path"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
+	merrors "github.com/go-distributed/meritop/errors"
+	"github.com/go-distributed/meritop/example"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
 )
 
@@ -28,11 +35,7 @@ func TestControllerInitEtcdLayout(t *testing.T) {
 	}
 
 	for i, tt := range tests {
-		c := &Controller{
-			name:       tt.name,
-			etcdclient: etcdClient,
-			numOfTasks: tt.numberOfTasks,
-		}
+		c := New(tt.name, etcdClient, tt.numberOfTasks)
 		c.InitEtcdLayout()
 
 		for taskID := uint64(0); taskID < tt.numberOfTasks; taskID++ {
@@ -53,3 +56,742 @@ func TestControllerInitEtcdLayout(t *testing.T) {
 		c.DestroyEtcdLayout()
 	}
 }
+
+func TestControllerRepair(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+	c := New("test-repair", etcdClient, 3)
+	c.InitEtcdLayout()
+	defer c.DestroyEtcdLayout()
+
+	// Epoch and number of tasks are lost or garbled, and so is a meta.
+	etcdClient.Delete(etcdutil.EpochPath(c.name), false)
+	etcdClient.Set(etcdutil.NumOfTasksPath(c.name), "garbage", 0)
+	etcdClient.Delete(etcdutil.ChildMetaPath(c.name, 1), false)
+	// Task 0 is alive but not registered, task 1 is alive but free, and
+	// nobody works for task 2, which isn't free.
+	etcdClient.Delete(etcdutil.FreeTaskPath(c.name, "0"), false)
+	etcdClient.Set(etcdutil.TaskHealthyPath(c.name, 0), "health", 0)
+	etcdClient.Set(etcdutil.TaskHealthyPath(c.name, 1), "health", 0)
+	etcdClient.Set(etcdutil.TaskMasterPath(c.name, 1), "addr", 0)
+	etcdClient.Delete(etcdutil.FreeTaskPath(c.name, "2"), false)
+
+	r, err := c.Repair()
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	if !reflect.DeepEqual(r.Freed, []uint64{0, 2}) {
+		t.Errorf("freed tasks want = [0 2], get = %v", r.Freed)
+	}
+	if !reflect.DeepEqual(r.Unfreed, []uint64{1}) {
+		t.Errorf("unfreed tasks want = [1], get = %v", r.Unfreed)
+	}
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{etcdutil.EpochPath(c.name), "0"},
+		{etcdutil.NumOfTasksPath(c.name), "3"},
+		{etcdutil.ChildMetaPath(c.name, 1), ""},
+	}
+	for i, tt := range tests {
+		resp, err := etcdClient.Get(tt.key, false, false)
+		if err != nil {
+			t.Errorf("#%d: etcdClient.Get %v failed: %v", i, tt.key, err)
+			continue
+		}
+		if resp.Node.Value != tt.value {
+			t.Errorf("#%d: %s want = %q, get = %q", i, tt.key, tt.value, resp.Node.Value)
+		}
+	}
+	for _, key := range []string{
+		etcdutil.TaskHealthyPath(c.name, 0),
+		etcdutil.FreeTaskPath(c.name, "1"),
+	} {
+		if _, err := etcdClient.Get(key, false, false); err == nil {
+			t.Errorf("%s not deleted", key)
+		}
+	}
+
+	// Nothing is left to repair.
+	r, err = c.Repair()
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	if len(r.Rebuilt)+len(r.Freed)+len(r.Unfreed) != 0 {
+		t.Errorf("second repair = %+v, want nothing", r)
+	}
+}
+
+func TestControllerSetEpoch(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	c := New("test-epoch", etcd.NewClient([]string{url}), 2)
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer c.DestroyEtcdLayout()
+
+	for i, epoch := range []uint64{3, 3, 1} {
+		if err := c.SetEpoch(epoch); err != nil {
+			t.Fatalf("#%d: SetEpoch(%d) failed: %v", i, epoch, err)
+		}
+		got, err := c.Epoch()
+		if err != nil {
+			t.Fatalf("#%d: Epoch failed: %v", i, err)
+		}
+		if got != epoch {
+			t.Errorf("#%d: epoch = %d, want %d", i, got, epoch)
+		}
+	}
+
+	if err := c.ShutdownJob(); err != nil {
+		t.Fatalf("ShutdownJob failed: %v", err)
+	}
+	if err := c.WaitForJobDone(); err != nil {
+		t.Fatalf("WaitForJobDone failed: %v", err)
+	}
+	if got, _ := c.Epoch(); got != etcdutil.ExitEpoch {
+		t.Errorf("epoch after shutdown = %d, want %d", got, uint64(etcdutil.ExitEpoch))
+	}
+}
+
+func TestControllerStatus(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+	c := New("test-status", etcdClient, 3)
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer c.DestroyEtcdLayout()
+
+	if !etcdutil.TryOccupyTask(etcdClient, c.name, 1, "127.0.0.1:8001") {
+		t.Fatalf("TryOccupyTask failed")
+	}
+	if err := etcdutil.MarkEpochDone(etcdClient, c.name, 0, 1); err != nil {
+		t.Fatalf("MarkEpochDone failed: %v", err)
+	}
+	if err := etcdutil.PoisonTask(etcdClient, c.name, 2, "crashing"); err != nil {
+		t.Fatalf("PoisonTask failed: %v", err)
+	}
+
+	s, err := c.Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if s.Epoch != 0 || s.NumOfTasks != 3 || s.Done {
+		t.Errorf("status = epoch %d, %d tasks, done %t, want epoch 0, 3 tasks, not done", s.Epoch, s.NumOfTasks, s.Done)
+	}
+	if want := []uint64{0}; !reflect.DeepEqual(s.Free, want) {
+		t.Errorf("free tasks = %v, want %v", s.Free, want)
+	}
+	if want := map[uint64]string{2: "crashing"}; !reflect.DeepEqual(s.Poisoned, want) {
+		t.Errorf("poisoned tasks = %v, want %v", s.Poisoned, want)
+	}
+	if len(s.Tasks) != 3 {
+		t.Fatalf("len(tasks) = %d, want 3", len(s.Tasks))
+	}
+	want := TaskStatus{ID: 1, Address: "127.0.0.1:8001", Healthy: true, EpochsDone: []uint64{0}}
+	if !reflect.DeepEqual(s.Tasks[1], want) {
+		t.Errorf("task 1 = %+v, want %+v", s.Tasks[1], want)
+	}
+	if s.Tasks[2].Healthy || !s.Tasks[2].Poisoned {
+		t.Errorf("task 2 = %+v, want poisoned and not healthy", s.Tasks[2])
+	}
+}
+
+func TestControllerResize(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+	c := New("test-resize", etcdClient, 2)
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer c.DestroyEtcdLayout()
+
+	tests := []struct {
+		n    uint64
+		free []uint64
+	}{
+		{4, []uint64{0, 1, 2, 3}},
+		{1, []uint64{0}},
+		{3, []uint64{0, 1, 2}},
+	}
+	for i, tt := range tests {
+		if err := c.Resize(tt.n); err != nil {
+			t.Fatalf("#%d: Resize(%d) failed: %v", i, tt.n, err)
+		}
+		s, err := c.Status()
+		if err != nil {
+			t.Fatalf("#%d: Status failed: %v", i, err)
+		}
+		if s.NumOfTasks != tt.n {
+			t.Errorf("#%d: number of tasks = %d, want %d", i, s.NumOfTasks, tt.n)
+		}
+		if !reflect.DeepEqual(s.Free, tt.free) {
+			t.Errorf("#%d: free tasks = %v, want %v", i, s.Free, tt.free)
+		}
+		for id := uint64(0); id < tt.n; id++ {
+			if _, err := etcdClient.Get(etcdutil.ParentMetaPath(c.name, id), false, false); err != nil {
+				t.Errorf("#%d: parent meta of task %d: %v", i, id, err)
+			}
+		}
+	}
+	if err := c.Resize(0); err != ErrResizeToZero {
+		t.Errorf("Resize(0) = %v, want %v", err, ErrResizeToZero)
+	}
+}
+
+// TestControllerResizeTopology checks that Resize turns down sizes the
+// topology of the job can't have, whether it's set or of the job spec.
+func TestControllerResizeTopology(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+	c := New("test-resize-topology", etcdClient, 2)
+	c.SetTopology(func() meritop.Topology { return example.NewHypercubeTopology(2) })
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer c.DestroyEtcdLayout()
+	if err := c.Resize(3); err == nil {
+		t.Errorf("Resize(3) of a hypercube succeeded")
+	}
+	if err := c.Resize(4); err != nil {
+		t.Errorf("Resize(4) failed: %v", err)
+	}
+
+	spec := &JobSpec{
+		Name:       "test-resize-spec",
+		NumOfTasks: 2,
+		Topology:   &TopologySpec{Type: "butterfly"},
+	}
+	sc, err := NewFromSpec(etcdClient, "", spec)
+	if err != nil {
+		t.Fatalf("NewFromSpec failed: %v", err)
+	}
+	if err := sc.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer sc.DestroyEtcdLayout()
+	// A controller of the job not set up by it gets the spec from etcd.
+	rc := New(sc.name, etcdClient, 2)
+	if err := rc.Resize(6); err == nil {
+		t.Errorf("Resize(6) of a butterfly succeeded")
+	}
+	n, _, err := etcdutil.GetNumOfTasks(etcdClient, rc.name)
+	if err != nil || n != 2 {
+		t.Errorf("number of tasks = %d, %v, want 2", n, err)
+	}
+}
+
+func TestControllerRegistry(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+
+	c := New("test-registry", etcdClient, 2)
+	c.SetOwner("alice")
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	// The same name under another root doesn't collide.
+	other := New("test-registry", etcdClient, 2)
+	other.SetRoot("team")
+	other.SetOwner("bob")
+	if err := other.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout under root failed: %v", err)
+	}
+	if _, err := etcdClient.Get("/team/test-registry/epoch", false, false); err != nil {
+		t.Errorf("epoch of job under root: %v", err)
+	}
+
+	dup := New("test-registry", etcdClient, 2)
+	if err := dup.InitEtcdLayout(); merrors.Kind(err) != merrors.ErrJobExists {
+		t.Errorf("InitEtcdLayout of job taken = %v, want %v", err, merrors.ErrJobExists)
+	}
+
+	for _, tt := range []struct {
+		root  string
+		owner string
+	}{
+		{"", "alice"},
+		{"team", "bob"},
+	} {
+		jobs, err := ListJobs(etcdClient, tt.root)
+		if err != nil {
+			t.Fatalf("ListJobs(%q) failed: %v", tt.root, err)
+		}
+		if len(jobs) != 1 || jobs[0].Name != "test-registry" || jobs[0].Owner != tt.owner || jobs[0].Created.IsZero() {
+			t.Errorf("jobs under %q = %+v, want test-registry of %s", tt.root, jobs, tt.owner)
+		}
+	}
+
+	if err := c.DestroyEtcdLayout(); err != nil {
+		t.Fatalf("DestroyEtcdLayout failed: %v", err)
+	}
+	if jobs, _ := ListJobs(etcdClient, ""); len(jobs) != 0 {
+		t.Errorf("jobs after destroy = %+v, want none", jobs)
+	}
+	if _, err := etcdClient.Get("/team/test-registry/epoch", false, false); err != nil {
+		t.Errorf("job under root destroyed with the other: %v", err)
+	}
+	other.DestroyEtcdLayout()
+}
+
+func TestJobSpec(t *testing.T) {
+	for _, bad := range []string{
+		`{"numOfTasks": 2}`,
+		`{"name": "j"}`,
+		`{"name": "j", "numOfTasks": 3, "topology": {"type": "hypercube"}}`,
+		`{"name": "j", "numOfTasks": 2, "topology": {"type": "ring"}}`,
+		`{"name": "j", "numOfTasks": 2, "checkpoint": {"everyEpochs": 0}}`,
+		`{"name": "j", "numOfTasks": 2, "ttl": "a day"}`,
+		`{"name": "j", "numOfTasks": 2`,
+	} {
+		if _, err := LoadJobSpec(strings.NewReader(bad)); err == nil {
+			t.Errorf("LoadJobSpec(%s) succeeded, want error", bad)
+		}
+	}
+
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+
+	spec, err := LoadJobSpec(strings.NewReader(`{
+		"name": "test-spec",
+		"numOfTasks": 3,
+		"topology": {"type": "tree", "params": {"fanout": 2}},
+		"config": {"rate": "0.1"},
+		"checkpoint": {"everyEpochs": 5}
+	}`))
+	if err != nil {
+		t.Fatalf("LoadJobSpec failed: %v", err)
+	}
+	c, err := NewFromSpec(etcdClient, "team", spec)
+	if err != nil {
+		t.Fatalf("NewFromSpec failed: %v", err)
+	}
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer c.DestroyEtcdLayout()
+
+	if cfg, err := etcdutil.GetConfig(etcdClient, c.name); err != nil || cfg["rate"] != "0.1" {
+		t.Errorf("config = %v, %v, want rate 0.1", cfg, err)
+	}
+	topo, ok, err := JobTopology(etcdClient, "team", "test-spec")
+	if err != nil || !ok {
+		t.Fatalf("JobTopology = %v, %v", ok, err)
+	}
+	topo.SetTaskID(0)
+	if children := topo.GetChildren(0); !reflect.DeepEqual(children, []uint64{1, 2}) {
+		t.Errorf("children of task 0 = %v, want [1 2]", children)
+	}
+	if _, ok, err := JobTopology(etcdClient, "", "test-spec"); err != nil || ok {
+		t.Errorf("JobTopology of job not there = %v, %v, want none", ok, err)
+	}
+}
+
+func TestControllerPaceEpochsEvery(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+
+	c := New("test-pace", etcdClient, 2)
+	c.PaceEpochsEvery(20 * time.Millisecond)
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+	if paced, err := etcdutil.IsControllerPaced(etcdClient, "test-pace"); err != nil || !paced {
+		t.Errorf("IsControllerPaced = %t, %v, want true", paced, err)
+	}
+	// Epochs advance without any task.
+	for {
+		epoch, err := c.Epoch()
+		if err != nil {
+			t.Fatalf("Epoch failed: %v", err)
+		}
+		if epoch >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControllerGC(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+
+	name := "test-gc"
+	c := New(name, etcdClient, 3)
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer c.DestroyEtcdLayout()
+
+	for _, kv := range []struct {
+		key   string
+		value string
+		ttl   uint64
+	}{
+		// Task 0 is being taken over.
+		{etcdutil.TaskHealthyPath(name, 0), "health", 10},
+		// Task 1 is running.
+		{etcdutil.TaskHealthyPath(name, 1), "health", 10},
+		{etcdutil.TaskMasterPath(name, 1), "localhost:1", 10},
+		// Task 2 has exited.
+		{etcdutil.TaskHealthyPath(name, 2), "health", 0},
+		{etcdutil.TaskStatusPath(name, 2), etcdutil.TaskExited, 0},
+		{etcdutil.ParentMetaPath(name, 2), "done", 0},
+		// Task 5 was removed.
+		{etcdutil.TaskHealthyPath(name, 5), "health", 0},
+		{etcdutil.FreeTaskPath(name, "5"), "", 0},
+		{etcdutil.ChildMetaPath(name, 5), "done", 0},
+		// Progress of epoch 3 is too old to keep at epoch 20.
+		{etcdutil.EpochPath(name), "20", 0},
+		{etcdutil.TaskProgressPath(name, 3, 1), "{}", 0},
+		{etcdutil.TaskProgressPath(name, 4, 1), "{}", 0},
+	} {
+		if _, err := etcdClient.Set(kv.key, kv.value, kv.ttl); err != nil {
+			t.Fatalf("Set %s failed: %v", kv.key, err)
+		}
+	}
+	want := &GCReport{
+		Healthy: []string{etcdutil.TaskHealthyPath(name, 2), etcdutil.TaskHealthyPath(name, 5)},
+		Free: []string{
+			etcdutil.FreeTaskPath(name, "1"),
+			etcdutil.FreeTaskPath(name, "2"),
+			etcdutil.FreeTaskPath(name, "5"),
+		},
+		Metas:    []string{etcdutil.ParentMetaPath(name, 2), etcdutil.ChildMetaPath(name, 5)},
+		Progress: []string{path.Join(etcdutil.ProgressPath(name), "3")},
+	}
+	for _, dryRun := range []bool{true, false} {
+		r, err := c.GC(dryRun)
+		if err != nil {
+			t.Fatalf("GC(%t) failed: %v", dryRun, err)
+		}
+		if !reflect.DeepEqual(r, want) {
+			t.Errorf("GC(%t) = %+v, want %+v", dryRun, r, want)
+		}
+	}
+	if r, err := c.GC(false); err != nil || len(r.Healthy)+len(r.Free)+len(r.Metas)+len(r.Progress) != 0 {
+		t.Errorf("GC after sweeping = %+v, %v, want nothing", r, err)
+	}
+	resp, err := etcdClient.Get(etcdutil.ParentMetaPath(name, 2), false, false)
+	if err != nil || resp.Node.Value != "" {
+		t.Errorf("meta of task exited = %v, %v, want cleared", resp, err)
+	}
+	if _, err := etcdClient.Get(etcdutil.FreeTaskPath(name, "0"), false, false); err != nil {
+		t.Errorf("free task 0 being taken over swept: %v", err)
+	}
+}
+
+func TestControllerLeaderElection(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+
+	name := "test-election"
+	c := New(name, etcdClient, 2)
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer c.DestroyEtcdLayout()
+
+	waitLeader := func(r *Controller) {
+		for i := 0; !r.IsLeader(); i++ {
+			if i == 200 {
+				t.Fatalf("replica %s isn't elected", r.replicaID)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	a := New(name, etcdClient, 2)
+	a.EnableLeaderElection("a")
+	if err := a.Start(); err != nil {
+		t.Fatalf("Start of replica a failed: %v", err)
+	}
+	waitLeader(a)
+	b := New(name, etcdClient, 2)
+	b.EnableLeaderElection("b")
+	if err := b.Start(); err != nil {
+		t.Fatalf("Start of replica b failed: %v", err)
+	}
+	defer b.Stop()
+
+	if err := b.SetEpoch(1); !merrors.Is(err, merrors.ErrNotLeader) {
+		t.Errorf("SetEpoch of follower = %v, want %v", err, merrors.ErrNotLeader)
+	}
+	if err := a.SetEpoch(1); err != nil {
+		t.Errorf("SetEpoch of leader failed: %v", err)
+	}
+	// The lead is given up as the leader stops, and the job is left.
+	a.Stop()
+	waitLeader(b)
+	if leader, err := etcdutil.GetLeader(etcdClient, name); err != nil || leader != "b" {
+		t.Errorf("leader = %q, %v, want b", leader, err)
+	}
+	if err := b.SetEpoch(2); err != nil {
+		t.Errorf("SetEpoch of new leader failed: %v", err)
+	}
+}
+
+func TestControllerEvents(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+
+	name := "test-events"
+	c := New(name, etcdClient, 2)
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer c.DestroyEtcdLayout()
+	if events, err := c.Events(); err != nil || len(events) != 0 {
+		t.Fatalf("Events of new job = %v, %v, want none", events, err)
+	}
+
+	if err := c.SetEpoch(2); err != nil {
+		t.Fatalf("SetEpoch failed: %v", err)
+	}
+	if err := c.RollbackEpoch(1); err != nil {
+		t.Fatalf("RollbackEpoch failed: %v", err)
+	}
+	// Task 1 is running.
+	etcdClient.Delete(etcdutil.FreeTaskPath(name, "1"), false)
+	if err := c.FailTask(1); err != nil {
+		t.Fatalf("FailTask failed: %v", err)
+	}
+	if err := c.ShutdownJob(); err != nil {
+		t.Fatalf("ShutdownJob failed: %v", err)
+	}
+
+	events, err := c.Events()
+	if err != nil {
+		t.Fatalf("Events failed: %v", err)
+	}
+	want := []etcdutil.Event{
+		{Kind: etcdutil.EventEpoch, Epoch: 2, Detail: "set"},
+		{Kind: etcdutil.EventEpoch, Epoch: 1, Detail: "rolled back"},
+		{Kind: etcdutil.EventFailure, TaskID: 1, Detail: "failed on request"},
+		{Kind: etcdutil.EventEpoch, Epoch: etcdutil.ExitEpoch, Detail: "set"},
+		{Kind: etcdutil.EventShutdown},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %d of them", events, len(want))
+	}
+	for i, e := range events {
+		if e.Actor != "controller" || e.Time.IsZero() {
+			t.Errorf("event %d by %q at %v, want by controller", i, e.Actor, e.Time)
+		}
+		e.Actor, e.Time = "", time.Time{}
+		if e != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, e, want[i])
+		}
+	}
+}
+
+func TestControllerValidateTopology(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+
+	name := "test-topology"
+	c := New(name, etcdClient, 4)
+	// The tree is of 5 tasks, task 1 having child 4 which the job lacks.
+	c.SetTopology(func() meritop.Topology { return example.NewTreeTopology(2, 5) })
+	if err := c.InitEtcdLayout(); err == nil || !strings.Contains(err.Error(), "child 4") {
+		c.DestroyEtcdLayout()
+		t.Fatalf("InitEtcdLayout error = %v, want bad topology", err)
+	}
+	if c.jobExists() {
+		t.Fatalf("job with bad topology set up")
+	}
+
+	c.SetTopology(func() meritop.Topology { return example.NewTreeTopology(2, 4) })
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	c.DestroyEtcdLayout()
+}
+
+func TestControllerMetrics(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+
+	name := "test-metrics"
+	c := New(name, etcdClient, 2)
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer c.DestroyEtcdLayout()
+
+	// Epoch 1 is completed, while skipping to epoch 3 doesn't complete any.
+	for _, epoch := range []uint64{1, 3} {
+		if err := c.SetEpoch(epoch); err != nil {
+			t.Fatalf("SetEpoch failed: %v", err)
+		}
+	}
+	// Task 1 is taken, fails, and is taken over.
+	etcdClient.Delete(etcdutil.FreeTaskPath(name, "1"), false)
+	if _, err := etcdutil.NextGeneration(etcdClient, name, 1); err != nil {
+		t.Fatalf("NextGeneration failed: %v", err)
+	}
+	if err := c.FailTask(1); err != nil {
+		t.Fatalf("FailTask failed: %v", err)
+	}
+	if _, err := etcdutil.NextGeneration(etcdClient, name, 1); err != nil {
+		t.Fatalf("NextGeneration failed: %v", err)
+	}
+
+	metrics, err := c.Metrics()
+	if err != nil {
+		t.Fatalf("Metrics failed: %v", err)
+	}
+	want := JobMetrics{Failures: 1, Reassignments: 1, EpochsCompleted: 1, FreeTasks: 2}
+	if *metrics != want {
+		t.Errorf("metrics = %+v, want %+v", *metrics, want)
+	}
+}
+
+func TestControllerTTL(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+
+	running := New("test-ttl-running", etcdClient, 1)
+	running.SetTTL(time.Second)
+	if err := running.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer running.Stop()
+	left := New("test-ttl-left", etcdClient, 1)
+	left.SetTTL(time.Second)
+	if err := left.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer left.DestroyEtcdLayout()
+	forever := New("test-ttl-forever", etcdClient, 1)
+	if err := forever.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer forever.DestroyEtcdLayout()
+	// A task of the job left heartbeats, but doesn't keep the lease.
+	etcdClient.Set(etcdutil.TaskHealthyPath(left.name, 0), "health", 0)
+
+	for i := 0; running.jobExists(); i++ {
+		if i == 50 {
+			t.Fatalf("job abandoned wasn't torn down by its controller")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if jobs, err := ListJobs(etcdClient, ""); err != nil || len(jobs) != 2 {
+		t.Errorf("jobs = %v, %v, want the job torn down unregistered", jobs, err)
+	}
+
+	if reaped, err := ReapAbandonedJobs(etcdClient, ""); err != nil || len(reaped) != 0 {
+		t.Fatalf("ReapAbandonedJobs = %v, %v, want none with task heartbeating", reaped, err)
+	}
+	etcdClient.Delete(etcdutil.TaskHealthyPath(left.name, 0), false)
+	reaped, err := ReapAbandonedJobs(etcdClient, "")
+	if err != nil || !reflect.DeepEqual(reaped, []string{"test-ttl-left"}) {
+		t.Fatalf("ReapAbandonedJobs = %v, %v, want [test-ttl-left]", reaped, err)
+	}
+	if left.jobExists() || !forever.jobExists() {
+		t.Errorf("job left exists: %t, job without TTL exists: %t, want false, true",
+			left.jobExists(), forever.jobExists())
+	}
+}
+
+func TestControllerProgress(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controller_test")
+	m.Launch()
+	defer m.Terminate(t)
+
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+	etcdClient := etcd.NewClient([]string{url})
+
+	name := "test-progress"
+	c := New(name, etcdClient, 2)
+	if err := c.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer c.DestroyEtcdLayout()
+	if eps, err := c.Progress(); err != nil || len(eps) != 0 {
+		t.Fatalf("Progress of new job = %v, %v, want none", eps, err)
+	}
+
+	for _, r := range []struct {
+		epoch, taskID uint64
+		p             meritop.Progress
+	}{
+		{1, 0, meritop.Progress{Items: 10, Loss: 0.5, Duration: time.Second}},
+		{1, 1, meritop.Progress{Items: 20, Loss: 0.3, Duration: 3 * time.Second}},
+		{0, 1, meritop.Progress{Items: 5, Loss: 0.9, Duration: time.Second}},
+	} {
+		if err := etcdutil.SetProgress(etcdClient, name, r.epoch, r.taskID, r.p); err != nil {
+			t.Fatalf("SetProgress failed: %v", err)
+		}
+	}
+	eps, err := c.Progress()
+	if err != nil {
+		t.Fatalf("Progress failed: %v", err)
+	}
+	if len(eps) != 2 || eps[0].Epoch != 0 || eps[1].Epoch != 1 {
+		t.Fatalf("progress = %+v, want of epochs 0 and 1", eps)
+	}
+	ep := eps[1]
+	if ep.Reported != 2 || ep.Items != 30 || ep.Loss < 0.399 || ep.Loss > 0.401 || ep.Duration != 3*time.Second {
+		t.Errorf("progress of epoch 1 = %+v, want 2 tasks, 30 items, loss 0.4 and 3s", ep)
+	}
+}
@@ -0,0 +1,71 @@
+package controllerhttp
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+
+	"github.com/go-distributed/meritop/controller"
+)
+
+// metric is a metric of jobs in the Prometheus text format.
+type metric struct {
+	name, typ, help string
+	value           func(m *controller.JobMetrics) uint64
+}
+
+var metrics = []metric{
+	{"meritop_task_failures_total", "counter", "Tasks of the job reported as failed.",
+		func(m *controller.JobMetrics) uint64 { return m.Failures }},
+	{"meritop_task_reassignments_total", "counter", "Tasks of the job taken by a node after another one had them.",
+		func(m *controller.JobMetrics) uint64 { return m.Reassignments }},
+	{"meritop_epochs_completed_total", "counter", "Epochs the job moved on from to the next one.",
+		func(m *controller.JobMetrics) uint64 { return m.EpochsCompleted }},
+	{"meritop_free_tasks", "gauge", "Tasks of the job waiting for a node to take them.",
+		func(m *controller.JobMetrics) uint64 { return m.FreeTasks }},
+}
+
+func (s *Server) jobMetrics(w http.ResponseWriter, job string) {
+	c := s.controller(job)
+	if _, err := c.Epoch(); err != nil {
+		writeErr(w, err)
+		return
+	}
+	m, err := c.Metrics()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// exportMetrics writes metrics of jobs registered under the root in the
+// Prometheus text format, labeled by job. Jobs which can't be read, e.g. as
+// they're deleted meanwhile, are left out.
+func (s *Server) exportMetrics(w http.ResponseWriter) {
+	jobs, err := controller.ListJobs(s.client, s.root)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	var names []string
+	var ms []*controller.JobMetrics
+	for _, j := range jobs {
+		m, err := s.controller(j.Name).Metrics()
+		if err != nil {
+			continue
+		}
+		names = append(names, j.Name)
+		ms = append(ms, m)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+	for _, mt := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", mt.name, mt.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", mt.name, mt.typ)
+		for i, m := range ms {
+			fmt.Fprintf(bw, "%s{job=%q} %d\n", mt.name, names[i], mt.value(m))
+		}
+	}
+	bw.Flush()
+}
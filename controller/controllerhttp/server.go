@@ -0,0 +1,349 @@
+// Package controllerhttp serves the controller of jobs over HTTP, so that
+// dashboards and schedulers can manage jobs without importing Go packages.
+// Requests and responses are JSON:
+//
+//	GET    /jobs                        jobs registered, see controller.ListJobs
+//	POST   /jobs                        set up a job from a controller.JobSpec
+//	GET    /jobs/{job}                  status of the job, see controller.JobStatus
+//	DELETE /jobs/{job}                  delete the job from etcd
+//	GET    /jobs/{job}/events           audit log of the job, see etcdutil.Event
+//	GET    /jobs/{job}/metrics          metrics of the job, see controller.JobMetrics
+//	GET    /jobs/{job}/progress         progress of epochs, see controller.EpochProgress
+//	GET    /jobs/{job}/epoch            current epoch, as {"epoch": n}
+//	PUT    /jobs/{job}/epoch            move the job to {"epoch": n}
+//	POST   /jobs/{job}/epoch            move the job to the next epoch
+//	POST   /jobs/{job}/tasks/{id}/fail  fail the task, see Controller.FailTask
+//	POST   /jobs/{job}/shutdown         shut the job down
+//
+// Errors are returned as {"error": "..."}. Metrics of all jobs are exported for
+// Prometheus to scrape as well:
+//
+//	GET    /metrics                     metrics of jobs, labeled by job
+package controllerhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop/controller"
+	merrors "github.com/go-distributed/meritop/errors"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+const (
+	JobsPrefix  = "/jobs"
+	MetricsPath = "/metrics"
+
+	ecodeKeyNotFound = 100
+)
+
+// Epoch is the body of requests and responses on the epoch of a job.
+type Epoch struct {
+	Epoch uint64 `json:"epoch"`
+}
+
+// Server serves jobs kept under an etcd root. Jobs set up by the server are
+// watched by their controllers, for failures of tasks, until they are deleted
+// or the server is closed. Jobs set up otherwise, e.g. by meritopctl, can be
+// managed all the same.
+type Server struct {
+	client etcdutil.Client
+	root   string
+
+	mu          sync.Mutex
+	controllers map[string]*controller.Controller
+}
+
+func NewServer(client etcdutil.Client, root string) *Server {
+	return &Server{
+		client:      client,
+		root:        root,
+		controllers: make(map[string]*controller.Controller),
+	}
+}
+
+// Close stops controllers of jobs set up by the server, which deletes the
+// jobs.
+func (s *Server) Close() {
+	s.mu.Lock()
+	cs := s.controllers
+	s.controllers = make(map[string]*controller.Controller)
+	s.mu.Unlock()
+	for _, c := range cs {
+		c.Stop()
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == MetricsPath {
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.exportMetrics(w)
+		return
+	}
+	if r.URL.Path != JobsPrefix && !strings.HasPrefix(r.URL.Path, JobsPrefix+"/") {
+		writeError(w, http.StatusNotFound, "no such resource")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, JobsPrefix), "/"), "/")
+	if parts[0] == "" {
+		switch r.Method {
+		case "GET":
+			s.listJobs(w)
+		case "POST":
+			s.createJob(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+	job, parts := parts[0], parts[1:]
+	route := r.Method + " " + strings.Join(parts, "/")
+	if len(parts) == 3 && parts[0] == "tasks" && parts[2] == "fail" {
+		route = r.Method + " tasks/{id}/fail"
+	}
+	switch route {
+	case "GET ":
+		s.jobStatus(w, job)
+	case "DELETE ":
+		s.deleteJob(w, job)
+	case "GET events":
+		s.jobEvents(w, job)
+	case "GET metrics":
+		s.jobMetrics(w, job)
+	case "GET progress":
+		s.jobProgress(w, job)
+	case "GET epoch":
+		s.getEpoch(w, job)
+	case "PUT epoch":
+		s.setEpoch(w, r, job)
+	case "POST epoch":
+		s.nextEpoch(w, job)
+	case "POST tasks/{id}/fail":
+		s.failTask(w, job, parts[1])
+	case "POST shutdown":
+		s.shutdown(w, job)
+	default:
+		writeError(w, http.StatusNotFound, "no such resource")
+	}
+}
+
+func (s *Server) listJobs(w http.ResponseWriter) {
+	jobs, err := controller.ListJobs(s.client, s.root)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) createJob(w http.ResponseWriter, r *http.Request) {
+	var spec controller.JobSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, "bad job spec: "+err.Error())
+		return
+	}
+	c, err := controller.NewFromSpec(s.client, s.root, &spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := c.Start(); err != nil {
+		writeErr(w, err)
+		return
+	}
+	s.mu.Lock()
+	s.controllers[spec.Name] = c
+	s.mu.Unlock()
+	st, err := c.Status()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, st)
+}
+
+func (s *Server) jobStatus(w http.ResponseWriter, job string) {
+	st, err := s.controller(job).Status()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (s *Server) jobEvents(w http.ResponseWriter, job string) {
+	c := s.controller(job)
+	if _, err := c.Epoch(); err != nil {
+		writeErr(w, err)
+		return
+	}
+	events, err := c.Events()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if events == nil {
+		events = []etcdutil.Event{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (s *Server) jobProgress(w http.ResponseWriter, job string) {
+	c := s.controller(job)
+	if _, err := c.Epoch(); err != nil {
+		writeErr(w, err)
+		return
+	}
+	eps, err := c.Progress()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if eps == nil {
+		eps = []controller.EpochProgress{}
+	}
+	writeJSON(w, http.StatusOK, eps)
+}
+
+func (s *Server) deleteJob(w http.ResponseWriter, job string) {
+	s.mu.Lock()
+	c, ok := s.controllers[job]
+	delete(s.controllers, job)
+	s.mu.Unlock()
+	if ok {
+		c.Stop()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c = s.controller(job)
+	if _, err := c.Epoch(); err != nil {
+		writeErr(w, err)
+		return
+	}
+	if err := c.DestroyEtcdLayout(); err != nil {
+		writeErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getEpoch(w http.ResponseWriter, job string) {
+	epoch, err := s.controller(job).Epoch()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Epoch{epoch})
+}
+
+func (s *Server) setEpoch(w http.ResponseWriter, r *http.Request, job string) {
+	var e Epoch
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		writeError(w, http.StatusBadRequest, "bad epoch: "+err.Error())
+		return
+	}
+	if err := s.controller(job).SetEpoch(e.Epoch); err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (s *Server) nextEpoch(w http.ResponseWriter, job string) {
+	c := s.controller(job)
+	epoch, err := c.Epoch()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if err := c.SetEpoch(epoch + 1); err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Epoch{epoch + 1})
+}
+
+func (s *Server) failTask(w http.ResponseWriter, job, idStr string) {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad task ID: "+idStr)
+		return
+	}
+	c := s.controller(job)
+	st, err := c.Status()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if id >= st.NumOfTasks {
+		writeError(w, http.StatusNotFound, "no such task")
+		return
+	}
+	if err := c.FailTask(id); err != nil {
+		writeErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) shutdown(w http.ResponseWriter, job string) {
+	c := s.controller(job)
+	if _, err := c.Epoch(); err != nil {
+		writeErr(w, err)
+		return
+	}
+	if err := c.ShutdownJob(); err != nil {
+		writeErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// controller returns the controller of the job, which is created if the job
+// hasn't been set up by the server.
+func (s *Server) controller(job string) *controller.Controller {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.controllers[job]; ok {
+		return c
+	}
+	c := controller.New(job, s.client, 0)
+	c.SetRoot(s.root)
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// writeErr tells the status of the error by its kind.
+func writeErr(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case isKeyNotFound(err):
+		code = http.StatusNotFound
+	case merrors.Is(err, merrors.ErrJobExists), merrors.Is(err, merrors.ErrEpochConflict):
+		code = http.StatusConflict
+	case merrors.Is(err, merrors.ErrEtcdUnavailable):
+		code = http.StatusServiceUnavailable
+	}
+	writeError(w, code, err.Error())
+}
+
+func isKeyNotFound(err error) bool {
+	e, ok := err.(*etcd.EtcdError)
+	return ok && e.ErrorCode == ecodeKeyNotFound
+}
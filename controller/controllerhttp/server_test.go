@@ -0,0 +1,134 @@
+package controllerhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop/controller"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+func TestServer(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controllerhttp_test")
+	m.Launch()
+	defer m.Terminate(t)
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+
+	s := NewServer(etcd.NewClient([]string{url}), "team")
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	spec := `{"name": "job", "numOfTasks": 2, "owner": "alice", "config": {"rate": "0.1"}}`
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		resp   interface{}
+		want   interface{}
+	}{
+		{"POST", "/jobs", `{"name": "job"}`, http.StatusBadRequest, nil, nil},
+		{"POST", "/jobs", spec, http.StatusCreated, &controller.JobStatus{}, nil},
+		{"POST", "/jobs", spec, http.StatusConflict, nil, nil},
+		{"GET", "/jobs", "", http.StatusOK, &[]etcdutil.JobInfo{}, nil},
+		{"GET", "/jobs/none", "", http.StatusNotFound, nil, nil},
+		{"GET", "/jobs/job/epoch", "", http.StatusOK, &Epoch{}, &Epoch{0}},
+		{"POST", "/jobs/job/epoch", "", http.StatusOK, &Epoch{}, &Epoch{1}},
+		{"PUT", "/jobs/job/epoch", `{"epoch": 5}`, http.StatusOK, &Epoch{}, &Epoch{5}},
+		{"GET", "/jobs/job/epoch", "", http.StatusOK, &Epoch{}, &Epoch{5}},
+		{"POST", "/jobs/job/tasks/1/fail", "", http.StatusNoContent, nil, nil},
+		{"POST", "/jobs/job/tasks/2/fail", "", http.StatusNotFound, nil, nil},
+		{"POST", "/jobs/job/shutdown", "", http.StatusNoContent, nil, nil},
+		{"GET", "/jobs/job/epoch", "", http.StatusOK, &Epoch{}, &Epoch{etcdutil.ExitEpoch}},
+		{"GET", "/jobs/job/events", "", http.StatusOK, &[]etcdutil.Event{}, nil},
+		{"GET", "/jobs/job/metrics", "", http.StatusOK, &controller.JobMetrics{}, &controller.JobMetrics{EpochsCompleted: 1, FreeTasks: 2}},
+		{"GET", "/jobs/none/metrics", "", http.StatusNotFound, nil, nil},
+		{"GET", "/jobs/job/progress", "", http.StatusOK, &[]controller.EpochProgress{}, &[]controller.EpochProgress{}},
+		{"DELETE", "/jobs/job", "", http.StatusNoContent, nil, nil},
+		{"GET", "/jobs/job", "", http.StatusNotFound, nil, nil},
+		{"GET", "/other", "", http.StatusNotFound, nil, nil},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest(tt.method, ts.URL+tt.path, bytes.NewBufferString(tt.body))
+		if err != nil {
+			t.Fatalf("#%d: NewRequest failed: %v", i, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("#%d: %s %s failed: %v", i, tt.method, tt.path, err)
+		}
+		if resp.StatusCode != tt.code {
+			t.Errorf("#%d: %s %s = %d, want %d", i, tt.method, tt.path, resp.StatusCode, tt.code)
+		}
+		if tt.resp != nil {
+			if err := json.NewDecoder(resp.Body).Decode(tt.resp); err != nil {
+				t.Errorf("#%d: decoding response failed: %v", i, err)
+			}
+		}
+		resp.Body.Close()
+		if tt.want != nil && fmt.Sprint(tt.resp) != fmt.Sprint(tt.want) {
+			t.Errorf("#%d: %s %s returned %v, want %v", i, tt.method, tt.path, tt.resp, tt.want)
+		}
+		switch r := tt.resp.(type) {
+		case *controller.JobStatus:
+			if r.Name != "job" || r.NumOfTasks != 2 || len(r.Free) != 2 {
+				t.Errorf("#%d: job created = %+v", i, r)
+			}
+		case *[]etcdutil.Event:
+			// Epochs set to 1, 5 and exit, and shut down.
+			if len(*r) != 4 || (*r)[3].Kind != etcdutil.EventShutdown {
+				t.Errorf("#%d: events = %+v", i, *r)
+			}
+		case *[]etcdutil.JobInfo:
+			if len(*r) != 1 || (*r)[0].Name != "job" || (*r)[0].Owner != "alice" {
+				t.Errorf("#%d: jobs = %+v", i, *r)
+			}
+		}
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	m := etcdutil.MustNewMember(t, "controllerhttp_test")
+	m.Launch()
+	defer m.Terminate(t)
+	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
+
+	s := NewServer(etcd.NewClient([]string{url}), "team")
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(`{"name": "job", "numOfTasks": 2}`))
+	if err != nil {
+		t.Fatalf("POST /jobs failed: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(ts.URL + MetricsPath)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", MetricsPath, err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("reading metrics failed: %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE meritop_task_failures_total counter\n",
+		`meritop_task_failures_total{job="job"} 0` + "\n",
+		"# TYPE meritop_free_tasks gauge\n",
+		`meritop_free_tasks{job="job"} 2` + "\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("metrics = %q, want %q in them", b, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	merrors "github.com/go-distributed/meritop/errors"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// leaderInterval is how often the leader refreshes its lead. Replicas take the
+// lead over a few intervals after the leader failed.
+var leaderInterval = time.Second
+
+// EnableLeaderElection makes the controller one of replicas of the controller
+// of the job, identified by id, e.g. to run it as a daemon without a single
+// point of failure. Replicas campaign to lead the job once started, and only
+// the leader watches the job and changes it. Others turn changes down with
+// merrors.ErrNotLeader, and stand by to take the lead over once the leader
+// fails or stops.
+//
+// The job has to be set up beforehand, e.g. with InitEtcdLayout, since
+// replicas don't set it up. Stop of a replica gives the lead up, and leaves the
+// job to others. It needs to be called before Start.
+func (c *Controller) EnableLeaderElection(id string) {
+	c.replicaID = id
+}
+
+// IsLeader tells whether the controller leads the job. Controllers which
+// aren't replicas always do.
+func (c *Controller) IsLeader() bool {
+	if c.replicaID == "" {
+		return true
+	}
+	c.leaderMu.Lock()
+	defer c.leaderMu.Unlock()
+	return c.leading
+}
+
+func (c *Controller) setLeading(leading bool) {
+	c.leaderMu.Lock()
+	defer c.leaderMu.Unlock()
+	c.leading = leading
+}
+
+// stopping tells whether Stop has been called.
+func (c *Controller) stopping() bool {
+	c.leaderMu.Lock()
+	defer c.leaderMu.Unlock()
+	return c.stopped
+}
+
+// checkLeader fails with merrors.ErrNotLeader if another replica leads the
+// job, so that changes are only made by the leader.
+func (c *Controller) checkLeader() error {
+	if c.IsLeader() {
+		return nil
+	}
+	return merrors.New(merrors.ErrNotLeader,
+		fmt.Sprintf("controller: replica %s doesn't lead job %s", c.replicaID, c.name))
+}
+
+// runElection campaigns to lead the job until the controller stops, or the job
+// is gone, e.g. cleaned up. Each term the replica leads, it watches the job as
+// Start does.
+func (c *Controller) runElection() {
+	defer close(c.electionDone)
+	for {
+		if !c.jobExists() {
+			c.logger.Infof("job %s is gone, replica %s stops campaigning", c.name, c.replicaID)
+			return
+		}
+		ok, err := etcdutil.Campaign(c.etcdclient, c.name, c.replicaID, leaderInterval, c.electionStop)
+		if err != nil {
+			c.logger.Errorf("replica %s campaigning for job %s failed: %v", c.replicaID, c.name, err)
+			select {
+			case <-time.After(leaderInterval):
+				continue
+			case <-c.electionStop:
+				return
+			}
+		}
+		if !ok {
+			return
+		}
+		if !c.jobExists() {
+			// The job was cleaned up as the lead was given up. The
+			// directory of the job was created again by the lead.
+			etcdutil.Resign(c.etcdclient, c.name, c.replicaID)
+			c.etcdclient.DeleteDir(path.Join("/", c.name))
+			continue
+		}
+		c.logger.Infof("replica %s leads job %s", c.replicaID, c.name)
+		c.setLeading(true)
+		c.startWatches()
+		err = etcdutil.KeepLeader(c.etcdclient, c.name, c.replicaID, leaderInterval, c.electionStop)
+		c.setLeading(false)
+		c.stopWatches()
+		select {
+		case <-c.electionStop:
+			return
+		default:
+		}
+		c.logger.Warnf("replica %s lost the lead of job %s: %v", c.replicaID, c.name, err)
+	}
+}
+
+// jobExists tells whether the job is set up in etcd. It's taken to be if etcd
+// can't tell.
+func (c *Controller) jobExists() bool {
+	_, err := c.etcdclient.Get(etcdutil.EpochPath(c.name), false, false)
+	return !isKeyNotFound(err)
+}
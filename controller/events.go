@@ -0,0 +1,29 @@
+package controller
+
+import (
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// Events returns the audit log of the job: changes of epoch, registrations
+// and failures of tasks, and shutdowns, in the order they happened.
+func (c *Controller) Events() ([]etcdutil.Event, error) {
+	events, err := etcdutil.ListEvents(c.etcdclient, c.name)
+	return events, etcdutil.WrapError(err)
+}
+
+// actor names the controller in the audit log of the job.
+func (c *Controller) actor() string {
+	if c.replicaID != "" {
+		return "controller " + c.replicaID
+	}
+	return "controller"
+}
+
+// recordEvent appends the event caused by the controller to the audit log of
+// the job. Failing to is only logged, since what happened has happened.
+func (c *Controller) recordEvent(e etcdutil.Event) {
+	e.Actor = c.actor()
+	if err := etcdutil.RecordEvent(c.etcdclient, c.name, e); err != nil {
+		c.logger.Warnf("recording %s event of job %s failed: %v", e.Kind, c.name, err)
+	}
+}
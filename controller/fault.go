@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// scrambledAddress is where ScrambleRegistration points tasks at. Names under
+// .invalid never resolve.
+const scrambledAddress = "scrambled.invalid:0"
+
+// Faults below are injected into the job on purpose, e.g. to test that it
+// recovers, and are recorded in the audit log as EventFault. They fail if the
+// task isn't registered in etcd.
+
+// ExpireHealth makes the task look as if its heartbeats were missed, see
+// etcdutil.ExpireHealth.
+func (c *Controller) ExpireHealth(taskID uint64) error {
+	return c.injectFault(taskID, "health expired", func() error {
+		return etcdutil.ExpireHealth(c.etcdclient, c.name, taskID)
+	})
+}
+
+// ScrambleRegistration garbles the address the task is registered at, see
+// etcdutil.ScrambleRegistration.
+func (c *Controller) ScrambleRegistration(taskID uint64) error {
+	return c.injectFault(taskID, "registration scrambled", func() error {
+		return etcdutil.ScrambleRegistration(c.etcdclient, c.name, taskID, scrambledAddress)
+	})
+}
+
+// RevokeTask takes the task from its node without freeing it, see
+// etcdutil.RevokeTask.
+func (c *Controller) RevokeTask(taskID uint64) error {
+	return c.injectFault(taskID, "task revoked", func() error {
+		return etcdutil.RevokeTask(c.etcdclient, c.name, taskID)
+	})
+}
+
+func (c *Controller) injectFault(taskID uint64, detail string, inject func() error) error {
+	if err := c.checkLeader(); err != nil {
+		return err
+	}
+	if err := inject(); err != nil {
+		return etcdutil.WrapError(err)
+	}
+	c.logger.Warnf("injected fault into task %d of job %s: %s", taskID, c.name, detail)
+	c.recordEvent(etcdutil.Event{Kind: etcdutil.EventFault, TaskID: taskID, Detail: detail})
+	return nil
+}
@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+const ecodeTestFailed = 101
+
+// GCReport lists stale entries GC found in the etcd layout of the job.
+type GCReport struct {
+	// Healthy are health keys which don't expire, of tasks which have exited,
+	// or which the job doesn't have. Those expiring are left, since a node
+	// taking a task over sets one before registering the task.
+	Healthy []string
+	// Free are entries of free tasks which are taken, have exited, are
+	// poisoned, or which the job doesn't have. Standbys waste time on them.
+	Free []string
+	// Metas are meta flags of tasks which have exited, or which the job
+	// doesn't have. Metas of tasks exited are cleared rather than deleted.
+	Metas []string
+	// Progress are directories of progress reported in epochs more than
+	// keepProgressEpochs behind the current one. They're kept once the job
+	// has been shut down.
+	Progress []string
+}
+
+// gcTask is what GC reads of a task.
+type gcTask struct {
+	registered bool
+	exited     bool
+	healthy    bool
+	poisoned   bool
+	metas      []*etcd.Node
+}
+
+// GC sweeps the etcd layout of the job for entries left over by tasks which
+// have exited, failed, or been removed by Resize, which long running jobs
+// pile up. With dryRun, they are only reported. Entries changed while GC runs
+// are left alone.
+func (c *Controller) GC(dryRun bool) (*GCReport, error) {
+	if !dryRun {
+		if err := c.checkLeader(); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.etcdclient.Get(path.Join("/", c.name), false, true)
+	if err != nil {
+		return nil, etcdutil.WrapError(err)
+	}
+	n := c.numOfTasks
+	epoch := uint64(etcdutil.ExitEpoch)
+	tasks := make(map[uint64]*gcTask)
+	task := func(id uint64) *gcTask {
+		t, ok := tasks[id]
+		if !ok {
+			t = &gcTask{}
+			tasks[id] = t
+		}
+		return t
+	}
+	var healthy, free, progress []*etcd.Node
+	for _, node := range resp.Node.Nodes {
+		switch path.Base(node.Key) {
+		case etcdutil.Epoch:
+			if v, err := strconv.ParseUint(node.Value, 10, 64); err == nil {
+				epoch = v
+			}
+		case etcdutil.ProgressDir:
+			progress = node.Nodes
+		case etcdutil.NumOfTasks:
+			if v, err := strconv.ParseUint(node.Value, 10, 64); err == nil {
+				n = v
+			}
+		case etcdutil.TasksDir:
+			for _, dir := range node.Nodes {
+				if id, ok := parseID(dir); ok {
+					readGCTask(task(id), dir)
+				}
+			}
+		case etcdutil.Healthy:
+			healthy = node.Nodes
+			for _, hn := range healthy {
+				if id, ok := parseID(hn); ok {
+					task(id).healthy = true
+				}
+			}
+		case etcdutil.FreeDir:
+			free = node.Nodes
+		case etcdutil.PoisonedDir:
+			for _, pn := range node.Nodes {
+				if id, ok := parseID(pn); ok {
+					task(id).poisoned = true
+				}
+			}
+		}
+	}
+
+	r := &GCReport{}
+	for _, hn := range healthy {
+		id, ok := parseID(hn)
+		if ok && id < n && !tasks[id].exited && hn.TTL > 0 {
+			continue
+		}
+		if swept, err := c.sweep(hn, false, dryRun); err != nil {
+			return r, etcdutil.WrapError(err)
+		} else if swept {
+			r.Healthy = append(r.Healthy, hn.Key)
+		}
+	}
+	for _, fn := range free {
+		id, ok := parseID(fn)
+		if ok && id < n {
+			t := task(id)
+			if !t.exited && !t.poisoned && !(t.healthy && t.registered) {
+				continue
+			}
+		}
+		if swept, err := c.sweep(fn, false, dryRun); err != nil {
+			return r, etcdutil.WrapError(err)
+		} else if swept {
+			r.Free = append(r.Free, fn.Key)
+		}
+	}
+	for id, t := range tasks {
+		if id < n && !t.exited {
+			continue
+		}
+		for _, mn := range t.metas {
+			// Metas of tasks the job has are kept in place for Repair.
+			clear := id < n
+			if clear && mn.Value == "" {
+				continue
+			}
+			if swept, err := c.sweep(mn, clear, dryRun); err != nil {
+				return r, etcdutil.WrapError(err)
+			} else if swept {
+				r.Metas = append(r.Metas, mn.Key)
+			}
+		}
+	}
+	for _, pn := range progress {
+		e, ok := parseID(pn)
+		if !ok || epoch == etcdutil.ExitEpoch || e+keepProgressEpochs >= epoch {
+			continue
+		}
+		if !dryRun {
+			if _, err := c.etcdclient.Delete(pn.Key, true); err != nil && !isKeyNotFound(err) {
+				return r, etcdutil.WrapError(err)
+			}
+		}
+		r.Progress = append(r.Progress, pn.Key)
+	}
+	sort.Strings(r.Healthy)
+	sort.Strings(r.Free)
+	sort.Strings(r.Metas)
+	sort.Strings(r.Progress)
+	c.logger.Infof("job %s swept (dry run: %t): health keys %v, free tasks %v, metas %v, progress %v",
+		c.name, dryRun, r.Healthy, r.Free, r.Metas, r.Progress)
+	return r, nil
+}
+
+// readGCTask fills t in with keys under the directory of the task.
+func readGCTask(t *gcTask, dir *etcd.Node) {
+	for _, n := range dir.Nodes {
+		switch path.Base(n.Key) {
+		case etcdutil.TaskMaster:
+			t.registered = true
+		case etcdutil.TaskStatus:
+			t.exited = n.Value == etcdutil.TaskExited
+		case etcdutil.TaskParentMeta, etcdutil.TaskChildMeta:
+			t.metas = append(t.metas, n)
+		case etcdutil.TaskLinkMeta:
+			t.metas = append(t.metas, n.Nodes...)
+		}
+	}
+}
+
+// sweep deletes node, or clears its value, unless it has changed since it was
+// read. It returns false if it has.
+func (c *Controller) sweep(node *etcd.Node, clear, dryRun bool) (bool, error) {
+	if dryRun {
+		return true, nil
+	}
+	var err error
+	if clear {
+		_, err = c.etcdclient.CompareAndSwap(node.Key, "", 0, "", node.ModifiedIndex)
+	} else {
+		_, err = c.etcdclient.CompareAndDelete(node.Key, "", node.ModifiedIndex)
+	}
+	if e, ok := err.(*etcd.EtcdError); ok && (e.ErrorCode == ecodeTestFailed || e.ErrorCode == ecodeKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
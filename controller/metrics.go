@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// JobMetrics are counters of the job since it was set up, and gauges of it
+// now, for operators to alert on unhealthy jobs, e.g. on failures piling up.
+type JobMetrics struct {
+	// Failures are tasks reported as failed.
+	Failures uint64 `json:"failures"`
+	// Reassignments are tasks taken by a node after another one had them.
+	Reassignments uint64 `json:"reassignments"`
+	// EpochsCompleted are epochs the job moved on from to the next one.
+	EpochsCompleted uint64 `json:"epochsCompleted"`
+	// FreeTasks are tasks waiting for a node to take them now.
+	FreeTasks uint64 `json:"freeTasks"`
+}
+
+// Metrics reads the metrics of the job, see etcdutil.IncCounter.
+func (c *Controller) Metrics() (*JobMetrics, error) {
+	counters, err := etcdutil.GetCounters(c.etcdclient, c.name)
+	if err != nil {
+		return nil, etcdutil.WrapError(err)
+	}
+	resp, err := c.etcdclient.Get(etcdutil.FreeTaskDir(c.name), false, false)
+	if err != nil {
+		return nil, etcdutil.WrapError(err)
+	}
+	return &JobMetrics{
+		Failures:        counters[etcdutil.CounterFailures],
+		Reassignments:   counters[etcdutil.CounterReassignments],
+		EpochsCompleted: counters[etcdutil.CounterEpochs],
+		FreeTasks:       uint64(len(resp.Node.Nodes)),
+	}, nil
+}
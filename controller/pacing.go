@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"time"
+
+	merrors "github.com/go-distributed/meritop/errors"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// PaceEpochsEvery makes the controller the only one to advance the epoch of
+// the job, once every interval, e.g. to sync a model periodically.
+// Context.IncEpoch of tasks does nothing then. It needs to be called before
+// Start.
+func (c *Controller) PaceEpochsEvery(interval time.Duration) {
+	c.paced = true
+	c.paceInterval = interval
+}
+
+// PaceEpochsOnReady makes the controller the only one to advance the epoch of
+// the job, once all tasks are done with it (see Context.EpochDone).
+// Context.IncEpoch of tasks does nothing then. It needs to be called before
+// Start.
+func (c *Controller) PaceEpochsOnReady() {
+	c.paced = true
+	c.paceInterval = 0
+}
+
+// paceEpochs advances the epoch of the job as it's paced, until the job has
+// been shut down or the controller stops.
+func (c *Controller) paceEpochs(paceStop chan bool) {
+	epochC := make(chan uint64, 1)
+	stop := make(chan bool)
+	defer close(stop)
+	epoch, err := etcdutil.GetAndWatchEpoch(c.etcdclient, c.name, epochC, stop)
+	if err != nil {
+		c.logger.Errorf("watching epoch of job %s for pacing failed: %v", c.name, err)
+		return
+	}
+	if c.paceInterval > 0 {
+		c.paceOnTimer(epoch, epochC, paceStop)
+	} else {
+		c.paceOnReady(epoch, epochC, paceStop)
+	}
+}
+
+func (c *Controller) paceOnTimer(epoch uint64, epochC chan uint64, paceStop chan bool) {
+	ticker := time.NewTicker(c.paceInterval)
+	defer ticker.Stop()
+	for epoch != etcdutil.ExitEpoch {
+		select {
+		case <-ticker.C:
+			c.advanceEpoch(epoch)
+		case epoch = <-epochC:
+		case <-paceStop:
+			return
+		}
+	}
+}
+
+// paceOnReady advances each epoch once all tasks have checked in at its
+// barrier. Epochs rolled back to are waited for anew.
+func (c *Controller) paceOnReady(epoch uint64, epochC chan uint64, paceStop chan bool) {
+	for epoch != etcdutil.ExitEpoch {
+		n, ok, err := etcdutil.GetNumOfTasks(c.etcdclient, c.name)
+		if err != nil {
+			c.logger.Errorf("reading number of tasks of job %s failed: %v", c.name, err)
+			return
+		}
+		if !ok {
+			n = c.numOfTasks
+		}
+		waitStop := make(chan bool)
+		ready := make(chan error, 1)
+		go func(epoch uint64) {
+			if ok, err := etcdutil.WaitEpochDone(c.etcdclient, c.name, epoch, int(n), waitStop); ok || err != nil {
+				ready <- err
+			}
+		}(epoch)
+		select {
+		case err := <-ready:
+			close(waitStop)
+			if err != nil {
+				c.logger.Errorf("waiting for tasks done with epoch %d failed: %v", epoch, err)
+				return
+			}
+			c.advanceEpoch(epoch)
+			select {
+			case epoch = <-epochC:
+			case <-paceStop:
+				return
+			}
+		case epoch = <-epochC:
+			close(waitStop)
+		case <-paceStop:
+			close(waitStop)
+			return
+		}
+	}
+}
+
+// advanceEpoch moves the job from epoch to the next one, unless the epoch has
+// changed since, e.g. rolled back.
+func (c *Controller) advanceEpoch(epoch uint64) {
+	err := etcdutil.CASEpoch(c.etcdclient, c.name, epoch, epoch+1)
+	if merrors.Kind(err) == merrors.ErrEpochConflict {
+		return
+	}
+	if err != nil {
+		c.logger.Errorf("advancing job %s to epoch %d failed: %v", c.name, epoch+1, err)
+		return
+	}
+	c.recordEvent(etcdutil.Event{Kind: etcdutil.EventEpoch, Epoch: epoch + 1, Detail: "paced"})
+	c.etcdclient.Delete(etcdutil.BarrierPath(c.name, epoch), true)
+}
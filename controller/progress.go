@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// keepProgressEpochs is how many epochs behind the current one progress is
+// kept for, before GC sweeps it.
+const keepProgressEpochs = 16
+
+// EpochProgress aggregates progress tasks reported in an epoch, see
+// meritop.ProgressReporter.
+type EpochProgress struct {
+	Epoch uint64 `json:"epoch"`
+	// Reported are how many tasks reported.
+	Reported int `json:"reported"`
+	// Items are processed by all tasks reporting.
+	Items uint64 `json:"items"`
+	// Loss is the mean of tasks reporting.
+	Loss float64 `json:"loss"`
+	// Duration is of the slowest task reporting, which the epoch takes at
+	// least.
+	Duration time.Duration `json:"duration"`
+	// Tasks are progress of each task reporting, by task ID.
+	Tasks map[uint64]meritop.Progress `json:"tasks"`
+}
+
+// Progress aggregates progress tasks reported in epochs of the job, for a
+// job level view of how it's going, sorted by epoch. Progress of epochs long
+// past is swept by GC.
+func (c *Controller) Progress() ([]EpochProgress, error) {
+	progress, err := etcdutil.ListProgress(c.etcdclient, c.name)
+	if err != nil {
+		return nil, etcdutil.WrapError(err)
+	}
+	var eps []EpochProgress
+	for epoch, tasks := range progress {
+		ep := EpochProgress{Epoch: epoch, Reported: len(tasks), Tasks: tasks}
+		for _, p := range tasks {
+			ep.Items += p.Items
+			ep.Loss += p.Loss
+			if p.Duration > ep.Duration {
+				ep.Duration = p.Duration
+			}
+		}
+		if ep.Reported > 0 {
+			ep.Loss /= float64(ep.Reported)
+		}
+		eps = append(eps, ep)
+	}
+	sort.Sort(byEpoch(eps))
+	return eps, nil
+}
+
+type byEpoch []EpochProgress
+
+func (s byEpoch) Len() int           { return len(s) }
+func (s byEpoch) Less(i, j int) bool { return s[i].Epoch < s[j].Epoch }
+func (s byEpoch) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
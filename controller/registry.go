@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	merrors "github.com/go-distributed/meritop/errors"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// ListJobs returns jobs registered under root in etcd, sorted by name.
+func ListJobs(client etcdutil.Client, root string) ([]etcdutil.JobInfo, error) {
+	jobs, err := etcdutil.ListJobs(client, root)
+	return jobs, etcdutil.WrapError(err)
+}
+
+// register adds the job to the registry. Jobs set up before the registry
+// aren't in it, so the job name is checked not to be taken in etcd as well.
+func (c *Controller) register() error {
+	info := etcdutil.JobInfo{
+		Name:    c.job,
+		Owner:   c.owner,
+		Created: time.Now(),
+		TTL:     c.ttl,
+	}
+	if info.Owner == "" {
+		info.Owner = defaultOwner()
+	}
+	if err := etcdutil.RegisterJob(c.etcdclient, c.root, info); err != nil {
+		return etcdutil.WrapError(err)
+	}
+	_, err := c.etcdclient.Get(etcdutil.EpochPath(c.name), false, false)
+	if isKeyNotFound(err) {
+		return nil
+	}
+	etcdutil.UnregisterJob(c.etcdclient, c.root, c.job)
+	if err != nil {
+		return etcdutil.WrapError(err)
+	}
+	return merrors.New(merrors.ErrJobExists, fmt.Sprintf("controller: job %s has been set up without registry", c.job))
+}
+
+func defaultOwner() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + host
+}
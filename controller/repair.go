@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+const ecodeKeyNotFound = 100
+
+// RepairReport lists what Repair found broken in the etcd layout of the job.
+type RepairReport struct {
+	// Rebuilt are keys which were missing or garbled, and have been set up
+	// again.
+	Rebuilt []string
+	// Freed are tasks nobody was working for, which have been made free for
+	// standbys to take.
+	Freed []uint64
+	// Unfreed are tasks alive, which were free to take nevertheless.
+	Unfreed []uint64
+}
+
+// Repair scans the etcd layout of the job, e.g. after etcd lost or garbled
+// some of it, and fixes what it can:
+//   - missing or garbled epoch is seeded again with the last checkpoint of
+//     the job, or 0 if there is none. Tasks roll back to it.
+//   - missing or garbled number of tasks is set to what controller was
+//     created with.
+//   - missing directories and metas of tasks are created again.
+//   - registrations of tasks are reconciled with their heartbeats, so that
+//     every task is either alive, or free to be taken over.
+//
+// It's best run while no task is being taken over, since a node halfway
+// through taking one looks like having lost it.
+func (c *Controller) Repair() (*RepairReport, error) {
+	if err := c.checkLeader(); err != nil {
+		return nil, err
+	}
+	r := &RepairReport{}
+	epoch, ok, err := etcdutil.LastCheckpoint(c.etcdclient, c.name)
+	if err != nil || !ok {
+		epoch = 0
+	}
+	if err := c.repairValue(r, etcdutil.EpochPath(c.name), isUint, strconv.FormatUint(epoch, 10)); err != nil {
+		return r, etcdutil.WrapError(err)
+	}
+	if err := c.repairValue(r, etcdutil.NumOfTasksPath(c.name), isUint, strconv.FormatUint(c.numOfTasks, 10)); err != nil {
+		return r, etcdutil.WrapError(err)
+	}
+	n, _, err := etcdutil.GetNumOfTasks(c.etcdclient, c.name)
+	if err != nil {
+		return r, etcdutil.WrapError(err)
+	}
+	if err := c.repairValue(r, etcdutil.JobStatusPath(c.name), nil, ""); err != nil {
+		return r, etcdutil.WrapError(err)
+	}
+	for _, dir := range []string{
+		etcdutil.TaskDirPath(c.name),
+		etcdutil.FreeTaskDir(c.name),
+		etcdutil.HealthyPath(c.name),
+	} {
+		if err := c.repairDir(r, dir); err != nil {
+			return r, etcdutil.WrapError(err)
+		}
+	}
+	for i := uint64(0); i < n; i++ {
+		for _, key := range []string{
+			etcdutil.ParentMetaPath(c.name, i),
+			etcdutil.ChildMetaPath(c.name, i),
+		} {
+			if err := c.repairValue(r, key, nil, ""); err != nil {
+				return r, etcdutil.WrapError(err)
+			}
+		}
+		if err := c.reconcileTask(r, i); err != nil {
+			return r, etcdutil.WrapError(err)
+		}
+	}
+	c.logger.Infof("job %s repaired: rebuilt %v, freed tasks %v, unfreed tasks %v",
+		c.name, r.Rebuilt, r.Freed, r.Unfreed)
+	return r, nil
+}
+
+// reconcileTask makes the task free to take if no node is working for it, and
+// not free if one is. Tasks exited by themselves are left alone.
+func (c *Controller) reconcileTask(r *RepairReport, taskID uint64) error {
+	idStr := strconv.FormatUint(taskID, 10)
+	healthy, err := c.exists(etcdutil.TaskHealthyPath(c.name, taskID))
+	if err != nil {
+		return err
+	}
+	registered, err := c.exists(etcdutil.TaskMasterPath(c.name, taskID))
+	if err != nil {
+		return err
+	}
+	free, err := c.exists(etcdutil.FreeTaskPath(c.name, idStr))
+	if err != nil {
+		return err
+	}
+	poisoned, err := c.exists(etcdutil.PoisonedTaskPath(c.name, taskID))
+	if err != nil {
+		return err
+	}
+	exited, err := etcdutil.IsTaskExited(c.etcdclient, c.name, taskID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case exited:
+		// The task exited by itself, and isn't to be taken over.
+	case healthy && !registered:
+		// Nobody can reach the node. Its heartbeat fails without the
+		// registration, and it stops.
+		if _, err := c.etcdclient.Delete(etcdutil.TaskHealthyPath(c.name, taskID), false); err != nil && !isKeyNotFound(err) {
+			return err
+		}
+		fallthrough
+	case !healthy && !free && !poisoned:
+		if _, err := c.etcdclient.Set(etcdutil.FreeTaskPath(c.name, idStr), "failed", 0); err != nil {
+			return err
+		}
+		r.Freed = append(r.Freed, taskID)
+	case healthy && free:
+		if _, err := c.etcdclient.Delete(etcdutil.FreeTaskPath(c.name, idStr), false); err != nil && !isKeyNotFound(err) {
+			return err
+		}
+		r.Unfreed = append(r.Unfreed, taskID)
+	}
+	return nil
+}
+
+// repairValue sets key to value if it's missing, or not valid.
+func (c *Controller) repairValue(r *RepairReport, key string, valid func(string) bool, value string) error {
+	resp, err := c.etcdclient.Get(key, false, false)
+	switch {
+	case isKeyNotFound(err):
+	case err != nil:
+		return err
+	case !resp.Node.Dir && (valid == nil || valid(resp.Node.Value)):
+		return nil
+	default:
+		c.logger.Warnf("registration on etcd has been corrupted! Key: %s", key)
+		if _, err := c.etcdclient.Delete(key, true); err != nil && !isKeyNotFound(err) {
+			return err
+		}
+	}
+	if _, err := c.etcdclient.Set(key, value, 0); err != nil {
+		return err
+	}
+	r.Rebuilt = append(r.Rebuilt, key)
+	return nil
+}
+
+// repairDir creates directory key if it's missing, or not a directory.
+func (c *Controller) repairDir(r *RepairReport, key string) error {
+	resp, err := c.etcdclient.Get(key, false, false)
+	switch {
+	case isKeyNotFound(err):
+	case err != nil:
+		return err
+	case resp.Node.Dir:
+		return nil
+	default:
+		c.logger.Warnf("registration on etcd has been corrupted! Key: %s", key)
+		if _, err := c.etcdclient.Delete(key, false); err != nil && !isKeyNotFound(err) {
+			return err
+		}
+	}
+	if _, err := c.etcdclient.CreateDir(key, 0); err != nil {
+		return err
+	}
+	r.Rebuilt = append(r.Rebuilt, key)
+	return nil
+}
+
+func (c *Controller) exists(key string) (bool, error) {
+	_, err := c.etcdclient.Get(key, false, false)
+	if isKeyNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func isKeyNotFound(err error) bool {
+	e, ok := err.(*etcd.EtcdError)
+	return ok && e.ErrorCode == ecodeKeyNotFound
+}
+
+func isUint(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
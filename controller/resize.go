@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// ErrResizeToZero is returned by Resize asked to remove all tasks. Shut the
+// job down instead.
+var ErrResizeToZero = errors.New("controller: can't resize job to no task")
+
+// Resize grows or shrinks the running job to n tasks, e.g. to scale a long
+// running job without tearing it down. Tasks added are set up for standbys to
+// take, and tasks removed are taken off free tasks. Running tasks pick the
+// change up at the start of the next epoch: topology is updated, tasks
+// implementing meritop.ResizeHandler are told, and tasks removed exit.
+// Topology of the job has to cope with its number of tasks changing. Sizes
+// it doesn't fit, e.g. other than a power of two for hypercubes, are turned
+// down.
+func (c *Controller) Resize(n uint64) error {
+	if err := c.checkLeader(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrResizeToZero
+	}
+	if err := c.loadTopology(); err != nil {
+		return err
+	}
+	if err := c.validateTopology(n); err != nil {
+		return err
+	}
+	cur, ok, err := etcdutil.GetNumOfTasks(c.etcdclient, c.name)
+	if err != nil {
+		return etcdutil.WrapError(err)
+	}
+	if !ok {
+		cur = c.numOfTasks
+	}
+	// Tasks added are set up before the job grows, so that they are there
+	// once running tasks look for them.
+	for id := cur; id < n; id++ {
+		if err := etcdutil.AddTaskSlot(c.etcdclient, c.name, id); err != nil {
+			return etcdutil.WrapError(err)
+		}
+	}
+	if err := etcdutil.SetNumOfTasks(c.etcdclient, c.name, n); err != nil {
+		return etcdutil.WrapError(err)
+	}
+	c.numOfTasks = n
+	for id := n; id < cur; id++ {
+		if err := etcdutil.RemoveTaskSlot(c.etcdclient, c.name, id); err != nil {
+			return etcdutil.WrapError(err)
+		}
+	}
+	c.logger.Infof("job %s resized from %d to %d tasks", c.name, cur, n)
+	return nil
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/example"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// JobSpec describes a job for the controller to set up, e.g. as posted to the
+// admin API (see controllerhttp), or kept in a file (see LoadJobSpec):
+//
+//	{
+//		"name": "regression",
+//		"numOfTasks": 7,
+//		"topology": {"type": "tree", "params": {"fanout": 2}},
+//		"config": {"learningRate": "0.1"},
+//		"checkpoint": {"everyEpochs": 10},
+//		"ttl": "24h"
+//	}
+type JobSpec struct {
+	Name       string `json:"name"`
+	NumOfTasks uint64 `json:"numOfTasks"`
+	// Owner is who the job is registered to, see SetOwner.
+	Owner string `json:"owner,omitempty"`
+	// Auth makes tasks authenticate data requests, see EnableAuth.
+	Auth bool `json:"auth,omitempty"`
+	// Config is the configuration of the job tasks start with.
+	Config map[string]string `json:"config,omitempty"`
+	// Topology is kept in etcd for tasks to set up, see JobTopology.
+	Topology *TopologySpec `json:"topology,omitempty"`
+	// Checkpoint makes the controller checkpoint the job periodically.
+	Checkpoint *CheckpointPolicy `json:"checkpoint,omitempty"`
+	// TTL is how long the job lives without tasks heartbeating, as taken by
+	// time.ParseDuration, see SetTTL.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// TopologySpec names the topology of a job, with its params, as taken by
+// example.NewTopology.
+type TopologySpec struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// CheckpointPolicy tells how often the controller checkpoints the job.
+type CheckpointPolicy struct {
+	EveryEpochs uint64 `json:"everyEpochs"`
+}
+
+// Validate checks the spec describes a job which can be set up.
+func (s *JobSpec) Validate() error {
+	if s.Name == "" {
+		return errors.New("controller: job spec has no name")
+	}
+	if s.NumOfTasks == 0 {
+		return errors.New("controller: job spec has no task")
+	}
+	if s.Topology != nil {
+		if _, err := example.NewTopology(s.Topology.Type, s.Topology.Params, s.NumOfTasks); err != nil {
+			return fmt.Errorf("controller: job spec has bad topology: %v", err)
+		}
+	}
+	if s.Checkpoint != nil && s.Checkpoint.EveryEpochs == 0 {
+		return errors.New("controller: job spec checkpoints every 0 epochs")
+	}
+	if s.TTL != "" {
+		if ttl, err := time.ParseDuration(s.TTL); err != nil || ttl <= 0 {
+			return fmt.Errorf("controller: job spec has bad TTL %q", s.TTL)
+		}
+	}
+	return nil
+}
+
+// LoadJobSpec reads a job spec in JSON from r, and validates it.
+func LoadJobSpec(r io.Reader) (*JobSpec, error) {
+	dec := json.NewDecoder(r)
+	var spec JobSpec
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("controller: bad job spec: %v", err)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// ReadJobSpecFile reads the job spec kept in the file, see LoadJobSpec.
+func ReadJobSpecFile(filename string) (*JobSpec, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadJobSpec(f)
+}
+
+// NewFromSpec creates the controller of the job specified, kept under root in
+// etcd. Start sets the job up.
+func NewFromSpec(client etcdutil.Client, root string, spec *JobSpec) (*Controller, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	c := New(spec.Name, client, spec.NumOfTasks)
+	c.SetRoot(root)
+	if spec.Owner != "" {
+		c.SetOwner(spec.Owner)
+	}
+	if spec.Auth {
+		c.EnableAuth()
+	}
+	c.initConfig = spec.Config
+	c.topology = spec.Topology
+	if spec.Checkpoint != nil {
+		c.checkpointEvery = spec.Checkpoint.EveryEpochs
+	}
+	if spec.TTL != "" {
+		ttl, _ := time.ParseDuration(spec.TTL)
+		c.SetTTL(ttl)
+	}
+	return c, nil
+}
+
+// JobTopology creates the topology the job has been set up with, for tasks of
+// the job to run on. It returns false if the job has been set up without one.
+// The topology is of the number of tasks the job has now.
+func JobTopology(client etcdutil.Client, root, job string) (meritop.Topology, bool, error) {
+	name := etcdutil.JobName(root, job)
+	value, ok, err := etcdutil.GetTopology(client, name)
+	if err != nil || !ok {
+		return nil, false, etcdutil.WrapError(err)
+	}
+	var ts TopologySpec
+	if err := json.Unmarshal([]byte(value), &ts); err != nil {
+		return nil, false, fmt.Errorf("controller: bad topology of job %s: %v", job, err)
+	}
+	n, ok, err := etcdutil.GetNumOfTasks(client, name)
+	if err != nil {
+		return nil, false, etcdutil.WrapError(err)
+	}
+	if !ok {
+		return nil, false, fmt.Errorf("controller: job %s has no number of tasks", job)
+	}
+	t, err := example.NewTopology(ts.Type, ts.Params, n)
+	if err != nil {
+		return nil, false, err
+	}
+	return t, true, nil
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// JobStatus is a snapshot of the job as registered in etcd.
+type JobStatus struct {
+	Name       string `json:"name"`
+	Epoch      uint64 `json:"epoch"`
+	NumOfTasks uint64 `json:"numOfTasks"`
+	// Done is true once the job has been shut down.
+	Done bool `json:"done"`
+	// LastCheckpoint is the last epoch all tasks checkpointed at, valid if
+	// Checkpointed.
+	LastCheckpoint uint64       `json:"lastCheckpoint"`
+	Checkpointed   bool         `json:"checkpointed"`
+	Tasks          []TaskStatus `json:"tasks"`
+	// Free are tasks waiting for a node to take them.
+	Free []uint64 `json:"free"`
+	// Poisoned are tasks failing too often, with reasons. Nobody takes them
+	// until they are unpoisoned.
+	Poisoned map[uint64]string `json:"poisoned"`
+}
+
+// TaskStatus is the state of a task of the job as registered in etcd.
+type TaskStatus struct {
+	ID uint64 `json:"id"`
+	// Address is where the node having the task serves data requests, empty if
+	// no node has it.
+	Address string `json:"address"`
+	// Healthy is true while the node having the task heartbeats.
+	Healthy bool `json:"healthy"`
+	// Exited is true once the task exited by itself.
+	Exited     bool   `json:"exited"`
+	Free       bool   `json:"free"`
+	Poisoned   bool   `json:"poisoned"`
+	Generation uint64 `json:"generation"`
+	Restarts   int    `json:"restarts"`
+	// EpochsDone are epochs the task checked in at the barrier of, which the
+	// job hasn't advanced from yet. A task missing the current epoch is the
+	// one the job waits for.
+	EpochsDone []uint64 `json:"epochsDone"`
+}
+
+// Status reads the etcd layout of the job, e.g. to tell whether a job is stuck
+// and on which task.
+func (c *Controller) Status() (*JobStatus, error) {
+	resp, err := c.etcdclient.Get(path.Join("/", c.name), false, true)
+	if err != nil {
+		return nil, etcdutil.WrapError(err)
+	}
+	s := &JobStatus{
+		Name:     c.job,
+		Poisoned: make(map[uint64]string),
+	}
+	tasks := make(map[uint64]*TaskStatus)
+	task := func(id uint64) *TaskStatus {
+		t, ok := tasks[id]
+		if !ok {
+			t = &TaskStatus{ID: id}
+			tasks[id] = t
+		}
+		return t
+	}
+	for _, n := range resp.Node.Nodes {
+		switch path.Base(n.Key) {
+		case etcdutil.Epoch:
+			s.Epoch, _ = strconv.ParseUint(n.Value, 10, 64)
+		case etcdutil.NumOfTasks:
+			s.NumOfTasks, _ = strconv.ParseUint(n.Value, 10, 64)
+		case etcdutil.Status:
+			s.Done = n.Value == "done"
+		case etcdutil.CheckpointDir:
+			for _, cn := range n.Nodes {
+				if path.Base(cn.Key) == etcdutil.CheckpointLast {
+					s.LastCheckpoint, err = strconv.ParseUint(cn.Value, 10, 64)
+					s.Checkpointed = err == nil
+				}
+			}
+		case etcdutil.TasksDir:
+			for _, dir := range n.Nodes {
+				if id, ok := parseID(dir); ok {
+					readTask(task(id), dir)
+				}
+			}
+		case etcdutil.Healthy:
+			for _, hn := range n.Nodes {
+				if id, ok := parseID(hn); ok {
+					task(id).Healthy = true
+				}
+			}
+		case etcdutil.FreeDir:
+			for _, fn := range n.Nodes {
+				if id, ok := parseID(fn); ok {
+					task(id).Free = true
+				}
+			}
+		case etcdutil.PoisonedDir:
+			for _, pn := range n.Nodes {
+				if id, ok := parseID(pn); ok {
+					task(id).Poisoned = true
+					s.Poisoned[id] = pn.Value
+				}
+			}
+		case etcdutil.BarrierDir:
+			for _, bn := range n.Nodes {
+				epoch, ok := parseID(bn)
+				if !ok {
+					continue
+				}
+				for _, dn := range bn.Nodes {
+					if id, ok := parseID(dn); ok {
+						t := task(id)
+						t.EpochsDone = append(t.EpochsDone, epoch)
+					}
+				}
+			}
+		}
+	}
+	for i := uint64(0); i < s.NumOfTasks; i++ {
+		task(i)
+	}
+	for _, t := range tasks {
+		sort.Sort(uint64s(t.EpochsDone))
+		if t.Free {
+			s.Free = append(s.Free, t.ID)
+		}
+		s.Tasks = append(s.Tasks, *t)
+	}
+	sort.Sort(byTaskID(s.Tasks))
+	sort.Sort(uint64s(s.Free))
+	return s, nil
+}
+
+// readTask fills t in with keys under the directory of the task.
+func readTask(t *TaskStatus, dir *etcd.Node) {
+	for _, n := range dir.Nodes {
+		switch path.Base(n.Key) {
+		case etcdutil.TaskMaster:
+			t.Address = n.Value
+		case etcdutil.TaskStatus:
+			t.Exited = n.Value == etcdutil.TaskExited
+		case etcdutil.TaskGeneration:
+			if g, err := etcdutil.ParseGeneration(n); err == nil {
+				t.Generation = g.Value
+			}
+		case etcdutil.TaskRestarts:
+			var r etcdutil.Restarts
+			if json.Unmarshal([]byte(n.Value), &r) == nil {
+				t.Restarts = r.Count
+			}
+		}
+	}
+}
+
+// parseID reads the ID of a task, or an epoch, n is named after.
+func parseID(n *etcd.Node) (uint64, bool) {
+	id, err := strconv.ParseUint(path.Base(n.Key), 10, 64)
+	return id, err == nil
+}
+
+type byTaskID []TaskStatus
+
+func (s byTaskID) Len() int           { return len(s) }
+func (s byTaskID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s byTaskID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type uint64s []uint64
+
+func (s uint64s) Len() int           { return len(s) }
+func (s uint64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/example"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+	"github.com/go-distributed/meritop/pkg/topoutil"
+)
+
+// validateEpochs is how many epochs from 0 InitEtcdLayout validates the
+// topology at. Topologies changing by epoch, e.g. a butterfly of up to 2^32
+// tasks, repeat within as many.
+var validateEpochs uint64 = 64
+
+// SetTopology makes InitEtcdLayout validate the topology tasks of the job run
+// on against the number of tasks, see topoutil.Validate, since tasks of a bad
+// one hang rather than fail. newTopology is called to get one for each task.
+// Topologies of job specs are validated without it. It needs to be called
+// before Start.
+func (c *Controller) SetTopology(newTopology func() meritop.Topology) {
+	c.newTopology = newTopology
+}
+
+// validateTopology validates the topology set, or else the one of the spec the
+// job is set up from, if any, at n tasks.
+func (c *Controller) validateTopology(n uint64) (err error) {
+	newTopology := c.newTopology
+	if newTopology == nil && c.topology != nil {
+		if _, err := example.NewTopology(c.topology.Type, c.topology.Params, n); err != nil {
+			return fmt.Errorf("controller: bad topology of job %s: %v", c.job, err)
+		}
+		newTopology = func() meritop.Topology {
+			t, _ := example.NewTopology(c.topology.Type, c.topology.Params, n)
+			return t
+		}
+	} else if newTopology != nil && n != c.numOfTasks {
+		// The topology set is of the number of tasks of the job. Tasks set
+		// the new one as they pick a resize up.
+		set := newTopology
+		newTopology = func() meritop.Topology {
+			t := set()
+			t.SetNumberOfTasks(n)
+			return t
+		}
+	}
+	if newTopology == nil {
+		return nil
+	}
+	// Topologies panic on numbers of tasks they can't have, e.g. hypercubes
+	// of other than a power of two.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("controller: bad topology of job %s: %v", c.job, r)
+		}
+	}()
+	if err := topoutil.Validate(newTopology, n, validateEpochs); err != nil {
+		return fmt.Errorf("controller: bad topology of job %s: %v", c.job, err)
+	}
+	return nil
+}
+
+// loadTopology gets the topology spec the job has been set up with from
+// etcd, if neither a topology nor a spec has been given, e.g. to a replica
+// taking the job over.
+func (c *Controller) loadTopology() error {
+	if c.newTopology != nil || c.topology != nil {
+		return nil
+	}
+	value, ok, err := etcdutil.GetTopology(c.etcdclient, c.name)
+	if err != nil || !ok {
+		return etcdutil.WrapError(err)
+	}
+	var ts TopologySpec
+	if err := json.Unmarshal([]byte(value), &ts); err != nil {
+		return fmt.Errorf("controller: bad topology of job %s: %v", c.job, err)
+	}
+	c.topology = &ts
+	return nil
+}
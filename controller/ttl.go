@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// SetTTL gives the job a lease of ttl, which tasks keep while heartbeating.
+// Once no task has for ttl, e.g. since whoever launched them lost their
+// machines, the job is abandoned: the controller, while running, tears it down
+// and frees the name, and otherwise ReapAbandonedJobs does. It needs to be
+// called before Start.
+func (c *Controller) SetTTL(ttl time.Duration) {
+	c.ttl = ttl
+}
+
+// teardownWhenAbandoned waits for the lease of the job to expire, and tears the
+// job down, until the controller stops.
+func (c *Controller) teardownWhenAbandoned(leaseStop chan bool) {
+	for {
+		// Watches of etcdutil are stopped by closing, once they return or
+		// the controller stops.
+		stop := make(chan bool)
+		returned := make(chan struct{})
+		go func() {
+			select {
+			case <-returned:
+			case <-leaseStop:
+			}
+			close(stop)
+		}()
+		ok, err := etcdutil.WaitLeaseExpired(c.etcdclient, c.name, stop)
+		close(returned)
+		if err != nil {
+			c.logger.Errorf("watching lease of job %s failed: %v", c.name, err)
+			return
+		}
+		if !ok {
+			return
+		}
+		abandoned, err := c.abandoned()
+		if err != nil {
+			c.logger.Errorf("checking tasks of job %s failed: %v", c.name, err)
+			return
+		}
+		if !abandoned {
+			// Tasks are still heartbeating, e.g. the lease expired while
+			// they couldn't reach etcd. It's given to them again.
+			if err := etcdutil.SetLease(c.etcdclient, c.name, c.ttl); err != nil {
+				c.logger.Errorf("renewing lease of job %s failed: %v", c.name, err)
+				return
+			}
+			continue
+		}
+		c.logger.Warnf("job %s has been abandoned for %v, tearing down etcd layout", c.name, c.ttl)
+		c.stopWatches()
+		if err := c.DestroyEtcdLayout(); err != nil {
+			c.logger.Errorf("tearing down job %s failed: %v", c.name, err)
+		}
+		return
+	}
+}
+
+// abandoned tells whether no task of the job heartbeats.
+func (c *Controller) abandoned() (bool, error) {
+	resp, err := c.etcdclient.Get(etcdutil.HealthyPath(c.name), false, false)
+	if isKeyNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, etcdutil.WrapError(err)
+	}
+	return len(resp.Node.Nodes) == 0, nil
+}
+
+// ReapAbandonedJobs tears down jobs registered under root in etcd, set up with
+// a TTL, whose lease has expired while no task heartbeats, and returns their
+// names. It's for jobs whose controllers have gone along with their tasks,
+// e.g. run periodically by meritopctl reap. Jobs set up less than their TTL
+// ago aren't judged yet.
+func ReapAbandonedJobs(client etcdutil.Client, root string) ([]string, error) {
+	jobs, err := ListJobs(client, root)
+	if err != nil {
+		return nil, err
+	}
+	var reaped []string
+	for _, j := range jobs {
+		if j.TTL <= 0 || time.Since(j.Created) < j.TTL {
+			continue
+		}
+		c := New(j.Name, client, 0)
+		c.SetRoot(root)
+		if _, ok, err := etcdutil.GetLease(client, c.name); err != nil {
+			return reaped, etcdutil.WrapError(err)
+		} else if ok {
+			continue
+		}
+		abandoned, err := c.abandoned()
+		if err != nil {
+			return reaped, err
+		}
+		if !abandoned {
+			continue
+		}
+		c.logger.Warnf("job %s has been abandoned for %v, tearing down etcd layout", c.name, j.TTL)
+		if err := c.DestroyEtcdLayout(); err != nil {
+			return reaped, etcdutil.WrapError(err)
+		}
+		reaped = append(reaped, j.Name)
+	}
+	return reaped, nil
+}
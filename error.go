@@ -0,0 +1,42 @@
+package meritop
+
+import "fmt"
+
+// Severity tells how bad an error framework ran into is.
+type Severity int
+
+const (
+	// SeverityRecoverable means the operation failed and has been dropped,
+	// e.g. a meta couldn't be flagged because etcd was unreachable. Framework
+	// carries on, and the task could retry the operation or exit.
+	SeverityRecoverable Severity = iota
+	// SeverityFatal means framework can't carry on running the task, e.g. it
+	// can't serve data requests anymore. The task is stopped once told.
+	SeverityFatal
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityRecoverable:
+		return "recoverable"
+	case SeverityFatal:
+		return "fatal"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// FrameworkError is an error framework ran into while doing Op for the task.
+// It's passed to FrameworkErrorHandler.
+type FrameworkError struct {
+	Severity Severity
+	Op       string
+	Err      error
+}
+
+func (e *FrameworkError) Error() string {
+	return fmt.Sprintf("%s error in %s: %v", e.Severity, e.Op, e.Err)
+}
+
+// Cause returns Err, so that the kind of it can be told with meritop/errors.
+func (e *FrameworkError) Cause() error { return e.Err }
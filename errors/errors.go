@@ -0,0 +1,98 @@
+// Package errors defines kinds of failures meritop runs into, so that tasks and
+// controllers can branch on them instead of matching error messages. Errors
+// returned by framework, controller and the packages under them are, where the
+// kind is known, of type *Error, or caused by one:
+//
+//	if errors.Is(err, errors.ErrNeighborUnreachable) {
+//		// ask another task for the data
+//	}
+package errors
+
+import "errors"
+
+// Kinds of failures.
+var (
+	// ErrNoFreeTask means a standby found no task to take, e.g. it timed out
+	// waiting for one to fail.
+	ErrNoFreeTask = errors.New("no free task")
+	// ErrNeighborUnreachable means another task couldn't be talked to, after
+	// retrying.
+	ErrNeighborUnreachable = errors.New("neighbor unreachable")
+	// ErrEpochConflict means the epoch changed under an operation, e.g. a data
+	// request of a past epoch, or two tasks moving the job to the next epoch
+	// at once.
+	ErrEpochConflict = errors.New("epoch conflict")
+	// ErrEtcdUnavailable means etcd couldn't be reached.
+	ErrEtcdUnavailable = errors.New("etcd unavailable")
+	// ErrTaskFenced means another node has taken the task of this one.
+	ErrTaskFenced = errors.New("task fenced")
+	// ErrUnauthorized means a request was turned down for a bad token.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotReady means a task can't serve a data request yet, e.g. since it
+	// hasn't computed the data. The request is retried later.
+	ErrNotReady = errors.New("not ready")
+	// ErrBadRequest means a task won't ever serve a data request, e.g. since
+	// it doesn't know the key. The request isn't retried.
+	ErrBadRequest = errors.New("bad request")
+	// ErrJobExists means a job couldn't be set up, since another one of the
+	// same name runs against the etcd cluster.
+	ErrJobExists = errors.New("job exists")
+	// ErrNotLeader means a replica of the controller of a job turned down a
+	// change, since another replica leads the job.
+	ErrNotLeader = errors.New("not leader")
+)
+
+// Error is an error of a known kind.
+type Error struct {
+	// Kind is one of the kinds of failures above.
+	Kind error
+	// Err is the failure itself.
+	Err error
+}
+
+// New returns an error of kind with the given text.
+func New(kind error, text string) error {
+	return &Error{Kind: kind, Err: errors.New(text)}
+}
+
+// Wrap returns err as an error of kind, or nil if err is nil. Errors already of
+// a kind are returned as they are.
+func Wrap(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	if Kind(err) != nil {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+// Cause returns the failure of the error.
+func (e *Error) Cause() error { return e.Err }
+
+type causer interface {
+	Cause() error
+}
+
+// Kind returns the kind of err, or of the error causing it, or nil if it's
+// not known.
+func Kind(err error) error {
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			return e.Kind
+		}
+		c, ok := err.(causer)
+		if !ok {
+			return nil
+		}
+		err = c.Cause()
+	}
+	return nil
+}
+
+// Is reports whether err is of kind.
+func Is(err, kind error) bool {
+	return kind != nil && (err == kind || Kind(err) == kind)
+}
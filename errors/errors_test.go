@@ -0,0 +1,48 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type causeError struct{ err error }
+
+func (e *causeError) Error() string { return fmt.Sprintf("caused by %v", e.err) }
+func (e *causeError) Cause() error  { return e.err }
+
+func TestKind(t *testing.T) {
+	failure := errors.New("failure")
+	unreachable := Wrap(ErrNeighborUnreachable, failure)
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{nil, nil},
+		{failure, nil},
+		{ErrEpochConflict, nil},
+		{unreachable, ErrNeighborUnreachable},
+		{New(ErrNoFreeTask, "no task"), ErrNoFreeTask},
+		// Errors already of a kind keep it.
+		{Wrap(ErrEtcdUnavailable, unreachable), ErrNeighborUnreachable},
+		{&causeError{unreachable}, ErrNeighborUnreachable},
+		{&causeError{failure}, nil},
+	}
+	for i, tt := range tests {
+		if k := Kind(tt.err); k != tt.kind {
+			t.Errorf("#%d: kind want = %v, get = %v", i, tt.kind, k)
+		}
+	}
+	if !Is(ErrEpochConflict, ErrEpochConflict) {
+		t.Errorf("kind isn't taken as of itself")
+	}
+	if Is(failure, nil) {
+		t.Errorf("error is taken as of nil kind")
+	}
+	if unreachable.Error() != failure.Error() {
+		t.Errorf("message want = %s, get = %s", failure, unreachable)
+	}
+	if Wrap(ErrTaskFenced, nil) != nil {
+		t.Errorf("nil error isn't wrapped as nil")
+	}
+}
@@ -0,0 +1,93 @@
+package example
+
+// ButterflyTopology pairs tasks for allreduce by recursive halving and
+// doubling over 2^k tasks. With data split into n blocks, one per task, a round
+// takes 2k epochs: k stages of reduce-scatter, after which each task has its
+// block reduced, and k stages of allgather, after which every task has all
+// blocks. Each task sends only half of what it has at each stage, which makes
+// it bandwidth optimal, unlike exchanging whole data on a hypercube.
+// Of the two tasks in a pair, the one with smaller ID is the parent.
+type ButterflyTopology struct {
+	numOfTasks uint64
+	dims       uint64
+	taskID     uint64
+}
+
+// BlockRange is the range [Start, End) of data blocks.
+type BlockRange struct {
+	Start, End uint64
+}
+
+func (t *ButterflyTopology) SetTaskID(taskID uint64) { t.taskID = taskID }
+
+func (t *ButterflyTopology) GetParents(epoch uint64) []uint64 {
+	if p, ok := t.partner(epoch); ok && p < t.taskID {
+		return []uint64{p}
+	}
+	return []uint64{}
+}
+
+func (t *ButterflyTopology) GetChildren(epoch uint64) []uint64 {
+	if p, ok := t.partner(epoch); ok && p > t.taskID {
+		return []uint64{p}
+	}
+	return []uint64{}
+}
+
+func (t *ButterflyTopology) SetNumberOfTasks(nt uint64) {
+	t.numOfTasks = nt
+	t.dims = hypercubeDims(nt)
+}
+
+// Stages returns the number of epochs a round of allreduce takes.
+func (t *ButterflyTopology) Stages() uint64 { return 2 * t.dims }
+
+// Stage returns the stage of a round at given epoch, and whether it is of
+// reduce-scatter or allgather.
+func (t *ButterflyTopology) Stage(epoch uint64) (stage uint64, reduceScatter bool) {
+	stage = epoch % t.Stages()
+	return stage, stage < t.dims
+}
+
+// Blocks returns the blocks exchanged at given epoch. In reduce-scatter, the
+// task sends partner's blocks, and reduces its own blocks with what it gets.
+// In allgather, it sends its own blocks, and gets partner's blocks.
+func (t *ButterflyTopology) Blocks(epoch uint64) (own, partner BlockRange) {
+	p, ok := t.partner(epoch)
+	if !ok {
+		return BlockRange{0, 1}, BlockRange{0, 1}
+	}
+	stage, rs := t.Stage(epoch)
+	var size uint64
+	if rs {
+		size = t.numOfTasks >> (stage + 1)
+	} else {
+		size = 1 << (stage - t.dims)
+	}
+	return alignedRange(t.taskID, size), alignedRange(p, size)
+}
+
+// alignedRange returns the blocks of given size that block id is in.
+func alignedRange(id, size uint64) BlockRange {
+	start := id / size * size
+	return BlockRange{start, start + size}
+}
+
+// partner returns the task paired with this one at the given epoch. Reduce-
+// scatter goes from the highest dimension down, and allgather back up.
+func (t *ButterflyTopology) partner(epoch uint64) (uint64, bool) {
+	if t.dims == 0 {
+		return 0, false
+	}
+	stage, rs := t.Stage(epoch)
+	dim := stage - t.dims
+	if rs {
+		dim = t.dims - 1 - stage
+	}
+	return t.taskID ^ (1 << dim), true
+}
+
+// Creates a new butterfly topology of n tasks. n must be a power of two.
+func NewButterflyTopology(n uint64) *ButterflyTopology {
+	return &ButterflyTopology{numOfTasks: n, dims: hypercubeDims(n)}
+}
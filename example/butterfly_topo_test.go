@@ -0,0 +1,66 @@
+package example
+
+import "testing"
+
+// TestButterflyAllreduce runs a round of allreduce on the topology, with each
+// block holding the set of tasks reduced into it.
+func TestButterflyAllreduce(t *testing.T) {
+	const n = 8
+	topos := make([]*ButterflyTopology, n)
+	blocks := make([][]map[uint64]bool, n)
+	for i := range topos {
+		topos[i] = NewButterflyTopology(n)
+		topos[i].SetTaskID(uint64(i))
+		blocks[i] = make([]map[uint64]bool, n)
+		for b := range blocks[i] {
+			blocks[i][b] = map[uint64]bool{uint64(i): true}
+		}
+	}
+	if s := topos[0].Stages(); s != 6 {
+		t.Fatalf("stages want = 6, get = %d", s)
+	}
+	for epoch := uint64(0); epoch < 6; epoch++ {
+		next := make([][]map[uint64]bool, n)
+		for i, topo := range topos {
+			next[i] = append([]map[uint64]bool(nil), blocks[i]...)
+			linked := append(topo.GetParents(epoch), topo.GetChildren(epoch)...)
+			if len(linked) != 1 {
+				t.Fatalf("epoch %d: task %d linked to %v", epoch, i, linked)
+			}
+			p := linked[0]
+			own, partner := topo.Blocks(epoch)
+			if pown, _ := topos[p].Blocks(epoch); pown != partner {
+				t.Fatalf("epoch %d: task %d and %d disagree on blocks", epoch, i, p)
+			}
+			_, rs := topo.Stage(epoch)
+			if rs {
+				for b := own.Start; b < own.End; b++ {
+					next[i][b] = union(blocks[i][b], blocks[p][b])
+				}
+			} else {
+				for b := partner.Start; b < partner.End; b++ {
+					next[i][b] = blocks[p][b]
+				}
+			}
+		}
+		blocks = next
+	}
+	for i := range blocks {
+		for b, reduced := range blocks[i] {
+			if len(reduced) != n {
+				t.Errorf("task %d block %d reduced from %d tasks, want %d", i, b, len(reduced), n)
+			}
+		}
+	}
+}
+
+func union(a, b map[uint64]bool) map[uint64]bool {
+	u := make(map[uint64]bool)
+	for k := range a {
+		u[k] = true
+	}
+	for k := range b {
+		u[k] = true
+	}
+	return u
+}
@@ -0,0 +1,62 @@
+package example
+
+// HypercubeTopology arranges tasks on the corners of a hypercube. At each
+// epoch, tasks are paired along one dimension of the cube, taking dimensions
+// in turn. Of the two tasks in a pair, the one with smaller ID is the parent.
+// Exchanging data with the partner over log2(n) epochs gives recursive doubling
+// allreduce, where all tasks end up with the reduced data.
+type HypercubeTopology struct {
+	numOfTasks uint64
+	dims       uint64
+	taskID     uint64
+}
+
+func (t *HypercubeTopology) SetTaskID(taskID uint64) { t.taskID = taskID }
+
+func (t *HypercubeTopology) GetParents(epoch uint64) []uint64 {
+	if p, ok := t.partner(epoch); ok && p < t.taskID {
+		return []uint64{p}
+	}
+	return []uint64{}
+}
+
+func (t *HypercubeTopology) GetChildren(epoch uint64) []uint64 {
+	if p, ok := t.partner(epoch); ok && p > t.taskID {
+		return []uint64{p}
+	}
+	return []uint64{}
+}
+
+// Dimensions returns the number of dimensions of the cube, which is also the
+// number of epochs a round of allreduce takes.
+func (t *HypercubeTopology) Dimensions() uint64 { return t.dims }
+
+func (t *HypercubeTopology) SetNumberOfTasks(nt uint64) {
+	t.numOfTasks = nt
+	t.dims = hypercubeDims(nt)
+}
+
+// partner returns the task paired with this one at the given epoch.
+func (t *HypercubeTopology) partner(epoch uint64) (uint64, bool) {
+	if t.dims == 0 {
+		return 0, false
+	}
+	return t.taskID ^ (1 << (epoch % t.dims)), true
+}
+
+func hypercubeDims(n uint64) uint64 {
+	if n == 0 || n&(n-1) != 0 {
+		panic("number of tasks of a hypercube topology must be a power of two")
+	}
+	dims := uint64(0)
+	for n > 1 {
+		n >>= 1
+		dims++
+	}
+	return dims
+}
+
+// Creates a new hypercube topology of n tasks. n must be a power of two.
+func NewHypercubeTopology(n uint64) *HypercubeTopology {
+	return &HypercubeTopology{numOfTasks: n, dims: hypercubeDims(n)}
+}
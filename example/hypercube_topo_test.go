@@ -0,0 +1,44 @@
+package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 8 tasks are paired along dimensions 0, 1, 2 and then 0 again.
+func TestHypercubeTopology8(t *testing.T) {
+	tests := []struct {
+		id, epoch         uint64
+		parents, children []uint64
+	}{
+		{0, 0, []uint64{}, []uint64{1}},
+		{0, 1, []uint64{}, []uint64{2}},
+		{0, 2, []uint64{}, []uint64{4}},
+		{0, 3, []uint64{}, []uint64{1}},
+		{5, 0, []uint64{4}, []uint64{}},
+		{5, 1, []uint64{}, []uint64{7}},
+		{5, 2, []uint64{1}, []uint64{}},
+		{6, 1, []uint64{4}, []uint64{}},
+	}
+	for i, tt := range tests {
+		topo := NewHypercubeTopology(8)
+		topo.SetTaskID(tt.id)
+		if p := topo.GetParents(tt.epoch); !reflect.DeepEqual(p, tt.parents) {
+			t.Errorf("#%d: parents want = %v, get = %v", i, tt.parents, p)
+		}
+		if c := topo.GetChildren(tt.epoch); !reflect.DeepEqual(c, tt.children) {
+			t.Errorf("#%d: children want = %v, get = %v", i, tt.children, c)
+		}
+	}
+	if d := NewHypercubeTopology(8).Dimensions(); d != 3 {
+		t.Errorf("dimensions want = 3, get = %d", d)
+	}
+}
+
+func TestHypercubeTopologySingleTask(t *testing.T) {
+	topo := NewHypercubeTopology(1)
+	topo.SetTaskID(0)
+	if len(topo.GetParents(0)) != 0 || len(topo.GetChildren(0)) != 0 {
+		t.Errorf("single task has neighbors: %v, %v", topo.GetParents(0), topo.GetChildren(0))
+	}
+}
@@ -0,0 +1,85 @@
+package example
+
+import "sort"
+
+// LocalityTreeTopology is a tree which keeps tasks of the same locality, e.g.
+// rack or zone, in one subtree. Tasks of a locality form a tree of the given
+// fanout under the one with smallest ID, and roots of the localities form such
+// a tree under task 0. So only roots of localities talk across them.
+// Tasks without a locality label are put together as one locality.
+type LocalityTreeTopology struct {
+	fanout     uint64
+	localities map[uint64]string
+	numOfTasks uint64
+	// parentOf has the parent of every task but root.
+	parentOf          map[uint64]uint64
+	parents, children []uint64
+}
+
+func (t *LocalityTreeTopology) SetTaskID(taskID uint64) {
+	t.parents = make([]uint64, 0, 1)
+	t.children = make([]uint64, 0, t.fanout)
+	if p, ok := t.parentOf[taskID]; ok {
+		t.parents = append(t.parents, p)
+	}
+	for id := uint64(0); id < t.numOfTasks; id++ {
+		if p, ok := t.parentOf[id]; ok && p == taskID {
+			t.children = append(t.children, id)
+		}
+	}
+}
+
+func (t *LocalityTreeTopology) GetParents(epoch uint64) []uint64 { return t.parents }
+
+func (t *LocalityTreeTopology) GetChildren(epoch uint64) []uint64 { return t.children }
+
+func (t *LocalityTreeTopology) SetNumberOfTasks(nt uint64) {
+	t.numOfTasks = nt
+	t.build()
+}
+
+func (t *LocalityTreeTopology) build() {
+	groups := make(map[string][]uint64)
+	for id := uint64(0); id < t.numOfTasks; id++ {
+		l := t.localities[id]
+		groups[l] = append(groups[l], id)
+	}
+	// Roots of localities are sorted by ID, which puts locality of task 0
+	// first and makes task 0 the root of the tree.
+	roots := make([]uint64, 0, len(groups))
+	t.parentOf = make(map[uint64]uint64, t.numOfTasks)
+	for _, ids := range groups {
+		t.linkTree(ids)
+		roots = append(roots, ids[0])
+	}
+	sort.Sort(uint64Slice(roots))
+	t.linkTree(roots)
+}
+
+// linkTree links tasks, sorted by ID, in a tree of the fanout.
+func (t *LocalityTreeTopology) linkTree(ids []uint64) {
+	for k := 1; k < len(ids); k++ {
+		t.parentOf[ids[k]] = ids[(uint64(k)-1)/t.fanout]
+	}
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Creates a new locality aware tree topology of nTasks tasks, with locality
+// labels of tasks, e.g. read by etcdutil.GetNodeLocalities.
+func NewLocalityTreeTopology(fanout uint64, localities map[uint64]string, nTasks uint64) *LocalityTreeTopology {
+	if fanout == 0 {
+		panic("fanout of tree topology must be positive")
+	}
+	t := &LocalityTreeTopology{
+		fanout:     fanout,
+		localities: localities,
+		numOfTasks: nTasks,
+	}
+	t.build()
+	return t
+}
@@ -0,0 +1,50 @@
+package example
+
+import (
+	"reflect"
+	"testing"
+)
+
+// rack a: 0 2 4 5, rack b: 1 3 6, no label: 7
+//
+//       0
+//    2  4  1  7
+//  5      3 6
+func TestLocalityTreeTopology(t *testing.T) {
+	localities := map[uint64]string{
+		0: "a", 2: "a", 4: "a", 5: "a",
+		1: "b", 3: "b", 6: "b",
+	}
+	tests := []treeTopoTest{
+		{
+			uint64(0),
+			[]uint64{}, []uint64{1, 2, 4, 7},
+		},
+		{
+			uint64(1),
+			[]uint64{0}, []uint64{3, 6},
+		},
+		{
+			uint64(2),
+			[]uint64{0}, []uint64{5},
+		},
+		{
+			uint64(6),
+			[]uint64{1}, []uint64{},
+		},
+		{
+			uint64(7),
+			[]uint64{0}, []uint64{},
+		},
+	}
+	for _, tt := range tests {
+		topo := NewLocalityTreeTopology(2, localities, 8)
+		topo.SetTaskID(tt.id)
+		if p := topo.GetParents(0); !reflect.DeepEqual(p, tt.parents) {
+			t.Errorf("task %d: parents want = %v, get = %v", tt.id, tt.parents, p)
+		}
+		if c := topo.GetChildren(0); !reflect.DeepEqual(c, tt.children) {
+			t.Errorf("task %d: children want = %v, get = %v", tt.id, tt.children, c)
+		}
+	}
+}
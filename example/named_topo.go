@@ -0,0 +1,69 @@
+package example
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+)
+
+// TreeParams are the params of "tree" topologies. Fanouts, if given, has the
+// fanout of each level, see NewLevelTreeTopology.
+type TreeParams struct {
+	Fanout  uint64   `json:"fanout"`
+	Fanouts []uint64 `json:"fanouts"`
+}
+
+// NewTopology creates a topology of numOfTasks tasks by type, with params in
+// JSON, e.g. as named in a job spec:
+//
+//	"tree"       TreeParams, e.g. {"fanout": 2}
+//	"hypercube"  no params, numOfTasks a power of two
+//	"butterfly"  no params, numOfTasks a power of two
+//	"spec"       a TopologySpec
+func NewTopology(typ string, params json.RawMessage, numOfTasks uint64) (meritop.Topology, error) {
+	switch typ {
+	case "tree":
+		var p TreeParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, fmt.Errorf("tree topology: %v", err)
+		}
+		fanouts := p.Fanouts
+		if len(fanouts) == 0 {
+			fanouts = []uint64{p.Fanout}
+		}
+		for _, fanout := range fanouts {
+			if fanout == 0 {
+				return nil, fmt.Errorf("tree topology: fanout must be positive")
+			}
+		}
+		return NewLevelTreeTopology(fanouts, numOfTasks), nil
+	case "hypercube", "butterfly":
+		if numOfTasks == 0 || numOfTasks&(numOfTasks-1) != 0 {
+			return nil, fmt.Errorf("%s topology: %d tasks isn't a power of two", typ, numOfTasks)
+		}
+		if typ == "hypercube" {
+			return NewHypercubeTopology(numOfTasks), nil
+		}
+		return NewButterflyTopology(numOfTasks), nil
+	case "spec":
+		t, err := NewTopologyFromSpec(bytes.NewReader(params))
+		if err != nil {
+			return nil, err
+		}
+		if t.spec.NumOfTasks != 0 && t.spec.NumOfTasks != numOfTasks {
+			return nil, fmt.Errorf("topology spec: %d tasks, job has %d", t.spec.NumOfTasks, numOfTasks)
+		}
+		t.SetNumberOfTasks(numOfTasks)
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown topology type %q", typ)
+}
+
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	return json.Unmarshal(params, v)
+}
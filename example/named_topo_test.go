@@ -0,0 +1,42 @@
+package example
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNewTopology(t *testing.T) {
+	tests := []struct {
+		typ        string
+		params     string
+		numOfTasks uint64
+		ok         bool
+		// children of task 0 at epoch 0
+		children []uint64
+	}{
+		{"tree", `{"fanout": 2}`, 7, true, []uint64{1, 2}},
+		{"tree", `{"fanouts": [3, 1]}`, 7, true, []uint64{1, 2, 3}},
+		{"tree", `{}`, 7, false, nil},
+		{"hypercube", "", 4, true, []uint64{1}},
+		{"hypercube", "", 6, false, nil},
+		{"butterfly", "", 4, true, []uint64{2}},
+		{"spec", `{"epochs": [{"edges": [[0, 2]]}]}`, 3, true, []uint64{2}},
+		{"spec", `{"numOfTasks": 2, "epochs": [{"edges": [[0, 1]]}]}`, 3, false, nil},
+		{"ring", "", 3, false, nil},
+	}
+	for i, tt := range tests {
+		topo, err := NewTopology(tt.typ, json.RawMessage(tt.params), tt.numOfTasks)
+		if (err == nil) != tt.ok {
+			t.Errorf("#%d: NewTopology(%s, %s) error = %v, want ok %t", i, tt.typ, tt.params, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		topo.SetTaskID(0)
+		if children := topo.GetChildren(0); !reflect.DeepEqual(children, tt.children) {
+			t.Errorf("#%d: children = %v, want %v", i, children, tt.children)
+		}
+	}
+}
@@ -26,8 +26,10 @@ func main() {
 	case "c":
 		log.Printf("controller")
 		controller := controller.New(*job, etcd.NewClient(etcdURLs), ntask)
+		controller.EnableAutoCleanup(nil)
 		controller.Start()
 		controller.WaitForJobDone()
+		controller.WaitForCleanup()
 	case "t":
 		log.Printf("task")
 		bootstrap := framework.NewBootStrap(*job, etcdURLs, createListener(), nil)
@@ -0,0 +1,85 @@
+package example
+
+import (
+	"math/rand"
+
+	"github.com/go-distributed/meritop"
+)
+
+// ShuffleLink is the link type of ShuffleTopology linking tasks which exchange
+// data in a shuffle.
+const ShuffleLink = "shuffle"
+
+// ShuffleTopology adds shuffles of data to a topology. At designated epochs,
+// each task sends its data to another task picked by a random permutation of
+// all tasks, seeded by epoch so that every task gets the same permutation.
+// The task it sends to and the one it gets from are its neighbors of link type
+// ShuffleLink. Parents and children are those of the base topology.
+type ShuffleTopology struct {
+	meritop.Topology
+	numOfTasks   uint64
+	taskID       uint64
+	shuffleEpoch func(epoch uint64) bool
+}
+
+func (t *ShuffleTopology) SetTaskID(taskID uint64) {
+	t.taskID = taskID
+	t.Topology.SetTaskID(taskID)
+}
+
+func (t *ShuffleTopology) SetNumberOfTasks(nt uint64) {
+	t.numOfTasks = nt
+	t.Topology.SetNumberOfTasks(nt)
+}
+
+func (t *ShuffleTopology) GetLinkTypes() []string { return []string{ShuffleLink} }
+
+func (t *ShuffleTopology) GetNeighbors(linkType string, epoch uint64) []uint64 {
+	switch linkType {
+	case "parent":
+		return t.GetParents(epoch)
+	case "child":
+		return t.GetChildren(epoch)
+	case ShuffleLink:
+	default:
+		return []uint64{}
+	}
+	ids := []uint64{}
+	if !t.shuffleEpoch(epoch) {
+		return ids
+	}
+	to, from := t.ShufflePeers(epoch)
+	if to != t.taskID {
+		ids = append(ids, to)
+	}
+	if from != t.taskID && from != to {
+		ids = append(ids, from)
+	}
+	return ids
+}
+
+// ShufflePeers returns the task which this task sends data to in the shuffle
+// at given epoch, and the one it gets data from. Either might be the task
+// itself, which keeps its data then.
+func (t *ShuffleTopology) ShufflePeers(epoch uint64) (to, from uint64) {
+	perm := rand.New(rand.NewSource(int64(epoch))).Perm(int(t.numOfTasks))
+	for i, p := range perm {
+		if uint64(i) == t.taskID {
+			to = uint64(p)
+		}
+		if uint64(p) == t.taskID {
+			from = uint64(i)
+		}
+	}
+	return to, from
+}
+
+// Creates a new shuffle topology of n tasks on base topology. Data is shuffled
+// at epochs for which shuffleEpoch returns true.
+func NewShuffleTopology(base meritop.Topology, n uint64, shuffleEpoch func(epoch uint64) bool) *ShuffleTopology {
+	return &ShuffleTopology{
+		Topology:     base,
+		numOfTasks:   n,
+		shuffleEpoch: shuffleEpoch,
+	}
+}
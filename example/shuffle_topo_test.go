@@ -0,0 +1,35 @@
+package example
+
+import "testing"
+
+func TestShuffleTopology(t *testing.T) {
+	const n = 8
+	everyThird := func(epoch uint64) bool { return epoch%3 == 2 }
+	topos := make([]*ShuffleTopology, n)
+	for i := range topos {
+		topos[i] = NewShuffleTopology(NewTreeTopology(2, n), n, everyThird)
+		topos[i].SetTaskID(uint64(i))
+	}
+	for epoch := uint64(0); epoch < 6; epoch++ {
+		// Tasks agree on the permutation: whom a task sends to gets from it.
+		got := make(map[uint64]bool)
+		for i, topo := range topos {
+			to, from := topo.ShufflePeers(epoch)
+			if pto, _ := topos[from].ShufflePeers(epoch); pto != uint64(i) {
+				t.Errorf("epoch %d: task %d gets from task %d, which sends to %d", epoch, i, from, pto)
+			}
+			got[to] = true
+			neighbors := topo.GetNeighbors(ShuffleLink, epoch)
+			if !everyThird(epoch) && len(neighbors) != 0 {
+				t.Errorf("epoch %d: task %d has shuffle neighbors %v out of shuffle", epoch, i, neighbors)
+			}
+		}
+		if len(got) != n {
+			t.Errorf("epoch %d: not a permutation: %v", epoch, got)
+		}
+	}
+	// parents and children come from base topology
+	if c := topos[0].GetChildren(2); len(c) != 2 || c[0] != 1 || c[1] != 2 {
+		t.Errorf("children of task 0 want = [1 2], get = %v", c)
+	}
+}
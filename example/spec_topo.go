@@ -0,0 +1,130 @@
+package example
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// TopologySpec describes a topology by its edges at each epoch. Epochs are
+// cycled through, so epoch n uses Epochs[n % len(Epochs)]. In JSON:
+//
+//	{
+//	  "numOfTasks": 3,
+//	  "epochs": [
+//	    {"edges": [[0, 1], [0, 2]]},
+//	    {"edges": [[1, 0], [1, 2]], "links": {"ring": [[0, 1], [1, 2], [2, 0]]}}
+//	  ]
+//	}
+type TopologySpec struct {
+	NumOfTasks uint64      `json:"numOfTasks"`
+	Epochs     []EpochSpec `json:"epochs"`
+}
+
+// EpochSpec has the edges of one epoch. Each edge in Edges is a pair of
+// parent and child IDs. Links has edges of other link types, which link both
+// tasks as neighbors of each other.
+type EpochSpec struct {
+	Edges [][2]uint64            `json:"edges"`
+	Links map[string][][2]uint64 `json:"links,omitempty"`
+}
+
+// SpecTopology is a topology read from a TopologySpec.
+type SpecTopology struct {
+	spec      TopologySpec
+	linkTypes []string
+	taskID    uint64
+}
+
+// NewTopologyFromSpec reads a topology from the JSON TopologySpec in r.
+func NewTopologyFromSpec(r io.Reader) (*SpecTopology, error) {
+	var spec TopologySpec
+	if err := json.NewDecoder(r).Decode(&spec); err != nil {
+		return nil, fmt.Errorf("topology spec: %v", err)
+	}
+	if len(spec.Epochs) == 0 {
+		return nil, fmt.Errorf("topology spec: no epochs")
+	}
+	t := &SpecTopology{spec: spec}
+	seen := make(map[string]bool)
+	for i, e := range spec.Epochs {
+		if err := t.checkEdges(e.Edges); err != nil {
+			return nil, fmt.Errorf("topology spec: epoch %d: %v", i, err)
+		}
+		for linkType, edges := range e.Links {
+			if linkType == "parent" || linkType == "child" {
+				return nil, fmt.Errorf("topology spec: epoch %d: reserved link type %q", i, linkType)
+			}
+			if err := t.checkEdges(edges); err != nil {
+				return nil, fmt.Errorf("topology spec: epoch %d: link %q: %v", i, linkType, err)
+			}
+			if !seen[linkType] {
+				seen[linkType] = true
+				t.linkTypes = append(t.linkTypes, linkType)
+			}
+		}
+	}
+	return t, nil
+}
+
+func (t *SpecTopology) checkEdges(edges [][2]uint64) error {
+	for _, e := range edges {
+		if e[0] == e[1] {
+			return fmt.Errorf("task %d linked to itself", e[0])
+		}
+		if t.spec.NumOfTasks > 0 && (e[0] >= t.spec.NumOfTasks || e[1] >= t.spec.NumOfTasks) {
+			return fmt.Errorf("edge %v out of %d tasks", e, t.spec.NumOfTasks)
+		}
+	}
+	return nil
+}
+
+func (t *SpecTopology) SetTaskID(taskID uint64) { t.taskID = taskID }
+
+func (t *SpecTopology) GetParents(epoch uint64) []uint64 {
+	ids := []uint64{}
+	for _, e := range t.epoch(epoch).Edges {
+		if e[1] == t.taskID {
+			ids = append(ids, e[0])
+		}
+	}
+	return ids
+}
+
+func (t *SpecTopology) GetChildren(epoch uint64) []uint64 {
+	ids := []uint64{}
+	for _, e := range t.epoch(epoch).Edges {
+		if e[0] == t.taskID {
+			ids = append(ids, e[1])
+		}
+	}
+	return ids
+}
+
+// GetLinkTypes returns link types used at any epoch of the spec.
+func (t *SpecTopology) GetLinkTypes() []string { return t.linkTypes }
+
+func (t *SpecTopology) GetNeighbors(linkType string, epoch uint64) []uint64 {
+	switch linkType {
+	case "parent":
+		return t.GetParents(epoch)
+	case "child":
+		return t.GetChildren(epoch)
+	}
+	ids := []uint64{}
+	for _, e := range t.epoch(epoch).Links[linkType] {
+		switch t.taskID {
+		case e[0]:
+			ids = append(ids, e[1])
+		case e[1]:
+			ids = append(ids, e[0])
+		}
+	}
+	return ids
+}
+
+func (t *SpecTopology) SetNumberOfTasks(nt uint64) { t.spec.NumOfTasks = nt }
+
+func (t *SpecTopology) epoch(epoch uint64) EpochSpec {
+	return t.spec.Epochs[epoch%uint64(len(t.spec.Epochs))]
+}
@@ -0,0 +1,63 @@
+package example
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTopologyFromSpec(t *testing.T) {
+	spec := `{
+		"numOfTasks": 3,
+		"epochs": [
+			{"edges": [[0, 1], [0, 2]]},
+			{"edges": [[1, 0], [1, 2]], "links": {"ring": [[0, 1], [1, 2], [2, 0]]}}
+		]
+	}`
+	tests := []struct {
+		id, epoch         uint64
+		parents, children []uint64
+		ring              []uint64
+	}{
+		{0, 0, []uint64{}, []uint64{1, 2}, []uint64{}},
+		{2, 0, []uint64{0}, []uint64{}, []uint64{}},
+		{0, 1, []uint64{1}, []uint64{}, []uint64{1, 2}},
+		{1, 1, []uint64{}, []uint64{0, 2}, []uint64{0, 2}},
+		// epochs are cycled through
+		{0, 2, []uint64{}, []uint64{1, 2}, []uint64{}},
+	}
+	for i, tt := range tests {
+		topo, err := NewTopologyFromSpec(strings.NewReader(spec))
+		if err != nil {
+			t.Fatalf("NewTopologyFromSpec failed: %v", err)
+		}
+		topo.SetTaskID(tt.id)
+		if p := topo.GetParents(tt.epoch); !reflect.DeepEqual(p, tt.parents) {
+			t.Errorf("#%d: parents want = %v, get = %v", i, tt.parents, p)
+		}
+		if c := topo.GetChildren(tt.epoch); !reflect.DeepEqual(c, tt.children) {
+			t.Errorf("#%d: children want = %v, get = %v", i, tt.children, c)
+		}
+		if r := topo.GetNeighbors("ring", tt.epoch); !reflect.DeepEqual(r, tt.ring) {
+			t.Errorf("#%d: ring neighbors want = %v, get = %v", i, tt.ring, r)
+		}
+		if lts := topo.GetLinkTypes(); !reflect.DeepEqual(lts, []string{"ring"}) {
+			t.Errorf("#%d: link types want = [ring], get = %v", i, lts)
+		}
+	}
+}
+
+func TestTopologyFromBadSpec(t *testing.T) {
+	specs := []string{
+		`{"numOfTasks": 2`,
+		`{"numOfTasks": 2, "epochs": []}`,
+		`{"numOfTasks": 2, "epochs": [{"edges": [[0, 2]]}]}`,
+		`{"epochs": [{"edges": [[1, 1]]}]}`,
+		`{"epochs": [{"links": {"parent": [[0, 1]]}}]}`,
+	}
+	for i, spec := range specs {
+		if _, err := NewTopologyFromSpec(strings.NewReader(spec)); err == nil {
+			t.Errorf("#%d: bad spec accepted: %s", i, spec)
+		}
+	}
+}
@@ -2,10 +2,16 @@ package example
 
 //The tree structure is basically assume that all the task forms a tree.
 //Also the tree structure stays the same between epochs.
+//Tasks are numbered level by level, starting from root 0.
 type TreeTopology struct {
-	fanout, numOfTasks uint64
-	taskID             uint64
-	parents, children  []uint64
+	// fanouts has the fanout of each level, from root down. Levels deeper
+	// than given have the fanout of the last one.
+	fanouts           []uint64
+	numOfTasks        uint64
+	taskID            uint64
+	parents, children []uint64
+	// peers are the other children of parent.
+	peers []uint64
 }
 
 func (t *TreeTopology) SetTaskID(taskID uint64) {
@@ -13,10 +19,10 @@ func (t *TreeTopology) SetTaskID(taskID uint64) {
 	// Not the most efficient way to create parents and children, but
 	// since this is not on critical path, we are ok.
 	t.parents = make([]uint64, 0, 1)
-	t.children = make([]uint64, 0, t.fanout)
+	t.children = make([]uint64, 0, t.fanouts[0])
 
 	for index := uint64(1); index < t.numOfTasks; index++ {
-		parentID := (index - 1) / t.fanout
+		parentID := t.parentOf(index)
 		if index == taskID {
 			t.parents = append(t.parents, parentID)
 			if len(t.parents) > 1 {
@@ -27,19 +33,60 @@ func (t *TreeTopology) SetTaskID(taskID uint64) {
 			t.children = append(t.children, index)
 		}
 	}
+	t.peers = make([]uint64, 0, t.fanouts[0])
+	for index := uint64(1); index < t.numOfTasks && len(t.parents) == 1; index++ {
+		if index != taskID && t.parentOf(index) == t.parents[0] {
+			t.peers = append(t.peers, index)
+		}
+	}
+}
+
+// parentOf returns the parent of a non-root task.
+func (t *TreeTopology) parentOf(index uint64) uint64 {
+	start, size := uint64(0), uint64(1)
+	for level := 0; ; level++ {
+		fanout := t.fanouts[len(t.fanouts)-1]
+		if level < len(t.fanouts) {
+			fanout = t.fanouts[level]
+		}
+		next := start + size
+		if index < next+size*fanout {
+			return start + (index-next)/fanout
+		}
+		start, size = next, size*fanout
+	}
 }
 
 func (t *TreeTopology) GetParents(epoch uint64) []uint64 { return t.parents }
 
 func (t *TreeTopology) GetChildren(epoch uint64) []uint64 { return t.children }
 
+// GetPeers returns the siblings of this task.
+func (t *TreeTopology) GetPeers(epoch uint64) []uint64 { return t.peers }
+
 func (t *TreeTopology) SetNumberOfTasks(nt uint64) { t.numOfTasks = nt }
 
 // Creates a new tree topology with given fanout and number of tasks.
 // This will be called during the task graph configuration.
 func NewTreeTopology(fanout, nTasks uint64) *TreeTopology {
+	return NewLevelTreeTopology([]uint64{fanout}, nTasks)
+}
+
+// Creates a new tree topology with a fanout per level, e.g. [8, 4, 2] has 8
+// tasks under root, 4 under each of those, and 2 under each task below. A
+// large fanout near root keeps the tree shallow with hundreds of leaves,
+// without root serving all of them.
+func NewLevelTreeTopology(fanouts []uint64, nTasks uint64) *TreeTopology {
+	if len(fanouts) == 0 {
+		panic("tree topology needs fanout of at least one level")
+	}
+	for _, fanout := range fanouts {
+		if fanout == 0 {
+			panic("fanout of tree topology must be positive")
+		}
+	}
 	m := &TreeTopology{
-		fanout:     fanout,
+		fanouts:    fanouts,
 		numOfTasks: nTasks,
 	}
 	return m
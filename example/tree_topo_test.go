@@ -1,6 +1,9 @@
 package example
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 type treeTopoTest struct {
 	id                uint64
@@ -83,3 +86,61 @@ func testTreeTopology(fanout, number uint64, tests []treeTopoTest, t *testing.T)
 		}
 	}
 }
+
+//          0
+//    1     2     3
+//  4 5   6 7   8 9
+// 10
+func TestLevelTreeTopology(t *testing.T) {
+	tests := []treeTopoTest{
+		{
+			uint64(0),
+			[]uint64{}, []uint64{1, 2, 3},
+		},
+		{
+			uint64(2),
+			[]uint64{0}, []uint64{6, 7},
+		},
+		{
+			uint64(3),
+			[]uint64{0}, []uint64{8, 9},
+		},
+		{
+			uint64(4),
+			[]uint64{1}, []uint64{10},
+		},
+		{
+			uint64(9),
+			[]uint64{3}, []uint64{},
+		},
+	}
+	for _, tt := range tests {
+		topo := NewLevelTreeTopology([]uint64{3, 2, 1}, 11)
+		topo.SetTaskID(tt.id)
+		if p := topo.GetParents(0); !reflect.DeepEqual(p, tt.parents) {
+			t.Errorf("task %d: parents want = %v, get = %v", tt.id, tt.parents, p)
+		}
+		if c := topo.GetChildren(0); !reflect.DeepEqual(c, tt.children) {
+			t.Errorf("task %d: children want = %v, get = %v", tt.id, tt.children, c)
+		}
+	}
+}
+
+func TestTreeTopologyPeers(t *testing.T) {
+	tests := []struct {
+		id    uint64
+		peers []uint64
+	}{
+		{0, []uint64{}},
+		{1, []uint64{2}},
+		{4, []uint64{3}},
+		{7, []uint64{8}},
+	}
+	for _, tt := range tests {
+		topo := NewTreeTopology(2, 9)
+		topo.SetTaskID(tt.id)
+		if p := topo.GetPeers(0); !reflect.DeepEqual(p, tt.peers) {
+			t.Errorf("task %d: peers want = %v, get = %v", tt.id, tt.peers, p)
+		}
+	}
+}
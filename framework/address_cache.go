@@ -0,0 +1,168 @@
+package framework
+
+import (
+	"sync"
+
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// addressCache keeps the addresses of tasks so that data requests don't need
+// to go to the coordinator every time. An entry is dropped when a request to
+// the address fails, and refreshed when the coordinator reports that the task
+// has been taken over by
+// another node. Generations of tasks are kept along, so that requests and
+// metas of nodes having lost their task can be told, and so are protocol
+// versions they talk.
+type addressCache struct {
+	coord etcdutil.Coordinator
+	name  string
+	stop  chan bool
+
+	mu    sync.Mutex
+	addrs map[uint64]string
+	gens  map[uint64]etcdutil.Generation
+	vers  map[uint64]int
+}
+
+func newAddressCache(coord etcdutil.Coordinator, name string) *addressCache {
+	c := &addressCache{
+		coord: coord,
+		name:  name,
+		stop:  make(chan bool, 1),
+		addrs: make(map[uint64]string),
+		gens:  make(map[uint64]etcdutil.Generation),
+		vers:  make(map[uint64]int),
+	}
+	coord.WatchTasks(name, c.stop, c.handleChange)
+	return c
+}
+
+// get returns the address of the node serving the task.
+func (c *addressCache) get(taskID uint64) (string, error) {
+	c.mu.Lock()
+	addr, ok := c.addrs[taskID]
+	c.mu.Unlock()
+	if ok {
+		return addr, nil
+	}
+	addr, err := c.coord.Address(c.name, taskID)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.addrs[taskID] = addr
+	c.mu.Unlock()
+	return addr, nil
+}
+
+// invalidate drops the cached address of the task if it is still addr.
+func (c *addressCache) invalidate(taskID uint64, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.addrs[taskID] == addr {
+		delete(c.addrs, taskID)
+	}
+}
+
+// generation returns the current generation of the task.
+func (c *addressCache) generation(taskID uint64) (etcdutil.Generation, error) {
+	c.mu.Lock()
+	gen, ok := c.gens[taskID]
+	c.mu.Unlock()
+	if ok {
+		return gen, nil
+	}
+	gen, err := c.coord.Generation(c.name, taskID)
+	if err != nil {
+		return gen, err
+	}
+	c.setGeneration(taskID, gen)
+	return gen, nil
+}
+
+// staleGeneration tells if the generation of the task is older than the
+// current one. If the current one can't be found, it's not taken to be.
+func (c *addressCache) staleGeneration(taskID, generation uint64) bool {
+	gen, err := c.generation(taskID)
+	return err == nil && generation < gen.Value
+}
+
+// staleWrite tells if a write at index is done by a node of the task that had
+// lost it by then. Ones done before are of the node working for it at the time.
+// Writes are few, so the generation is looked up again, in case the watch
+// hasn't told the latest one yet.
+func (c *addressCache) staleWrite(taskID, generation, index uint64) bool {
+	if generation == 0 {
+		return false
+	}
+	gen, err := c.coord.Generation(c.name, taskID)
+	if err != nil {
+		return false
+	}
+	c.setGeneration(taskID, gen)
+	return generation < gen.Value && index > gen.Index
+}
+
+// setGeneration keeps gen unless a newer one has been seen.
+func (c *addressCache) setGeneration(taskID uint64, gen etcdutil.Generation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen.Value > c.gens[taskID].Value {
+		c.gens[taskID] = gen
+	}
+}
+
+// version returns the protocol version the task talks. Tasks of releases
+// which don't register it talk frameworkhttp.ProtocolV1.
+func (c *addressCache) version(taskID uint64) int {
+	c.mu.Lock()
+	v, ok := c.vers[taskID]
+	c.mu.Unlock()
+	if ok {
+		return v
+	}
+	v, err := c.coord.Version(c.name, taskID)
+	if err != nil {
+		return frameworkhttp.ProtocolV1
+	}
+	if v == 0 {
+		v = frameworkhttp.ProtocolV1
+	}
+	c.mu.Lock()
+	c.vers[taskID] = v
+	c.mu.Unlock()
+	return v
+}
+
+func (c *addressCache) handleChange(change etcdutil.TaskChange) {
+	switch change.Kind {
+	case etcdutil.TaskChangeAddress:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.addrs[change.TaskID]; !ok {
+			return
+		}
+		if change.Deleted {
+			delete(c.addrs, change.TaskID)
+		} else {
+			c.addrs[change.TaskID] = change.Address
+		}
+	case etcdutil.TaskChangeGeneration:
+		if !change.Deleted {
+			c.setGeneration(change.TaskID, change.Generation)
+		}
+	case etcdutil.TaskChangeVersion:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if change.Deleted {
+			delete(c.vers, change.TaskID)
+		} else {
+			c.vers[change.TaskID] = change.Version
+		}
+	}
+}
+
+func (c *addressCache) stopWatch() {
+	c.stop <- true
+}
@@ -0,0 +1,59 @@
+package framework
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+func TestAddressCache(t *testing.T) {
+	job := "TestAddressCache"
+	m := etcdutil.StartNewEtcdServer(t, job)
+	defer m.Terminate(t)
+	client := etcd.NewClient([]string{m.URL()})
+	masterPath := etcdutil.TaskMasterPath(job, 1)
+
+	client.Set(masterPath, "addr1", 0)
+	c := newAddressCache(etcdutil.NewCoordinator(client), job)
+	defer c.stopWatch()
+	if addr, err := c.get(1); err != nil || addr != "addr1" {
+		t.Fatalf("get() = (%s, %v), want (addr1, nil)", addr, err)
+	}
+
+	// The cached address isn't looked up again.
+	c.mu.Lock()
+	c.addrs[1] = "cached"
+	c.mu.Unlock()
+	if addr, _ := c.get(1); addr != "cached" {
+		t.Errorf("address want = cached, get = %s", addr)
+	}
+
+	// Task 1 is taken over by another node.
+	client.Set(masterPath, "addr2", 0)
+	deadline := time.Now().Add(time.Second)
+	for {
+		addr, _ := c.get(1)
+		if addr == "addr2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("address want = addr2, get = %s", addr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Failure of a stale address doesn't drop the new one.
+	c.invalidate(1, "addr1")
+	if addr, _ := c.get(1); addr != "addr2" {
+		t.Errorf("address want = addr2, get = %s", addr)
+	}
+	c.mu.Lock()
+	c.addrs[1] = "bad"
+	c.mu.Unlock()
+	c.invalidate(1, "bad")
+	if addr, _ := c.get(1); addr != "addr2" {
+		t.Errorf("address want = addr2, get = %s", addr)
+	}
+}
@@ -0,0 +1,201 @@
+package framework
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"golang.org/x/net/context"
+)
+
+// Data of AllReduce is pushed with reqs of this prefix, which framework takes
+// for itself instead of passing them to the task.
+const allReducePrefix = "_allreduce/"
+
+var errAllReduceNotTree = errors.New("framework: AllReduce needs each task to have one parent at most")
+
+// allReduceBox keeps data pushed for AllReduce calls of the epoch until they
+// take it. Calls are matched across tasks by their order in the epoch.
+type allReduceBox struct {
+	mu    sync.Mutex
+	epoch uint64
+	seq   uint64
+	slots map[string]chan []byte
+}
+
+// next returns the sequence number of a new call in the epoch.
+func (b *allReduceBox) next(epoch uint64) (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.reset(epoch) {
+		return 0, false
+	}
+	b.seq++
+	return b.seq, true
+}
+
+// slot returns where data of key goes in the epoch, or nil if the epoch has
+// passed.
+func (b *allReduceBox) slot(epoch uint64, key string) chan []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.reset(epoch) {
+		return nil
+	}
+	c, ok := b.slots[key]
+	if !ok {
+		c = make(chan []byte, 1)
+		b.slots[key] = c
+	}
+	return c
+}
+
+// reset drops data of past epochs. It returns false if epoch has passed.
+func (b *allReduceBox) reset(epoch uint64) bool {
+	if b.slots != nil && epoch < b.epoch {
+		return false
+	}
+	if b.slots == nil || epoch > b.epoch {
+		b.epoch = epoch
+		b.seq = 0
+		b.slots = make(map[string]chan []byte)
+	}
+	return true
+}
+
+func allReduceKey(dir string, seq, fromID uint64) string {
+	return fmt.Sprintf("%s%s/%d/%d", allReducePrefix, dir, seq, fromID)
+}
+
+// AllReduce reduces data of all tasks over the tree of the topology in the
+// current epoch: each task reduces its data with what its children reduced,
+// and passes it to its parent. The root then passes the result back down.
+func (f *framework) AllReduce(data []byte, op meritop.ReduceOp) ([]byte, error) {
+	epoch, ctx := f.epoch, f.epochCtx
+	if ctx == nil || ctx.Err() != nil {
+		return nil, f.canceledErr()
+	}
+	parents := f.parents(epoch)
+	if len(parents) > 1 {
+		return nil, errAllReduceNotTree
+	}
+	seq, ok := f.allReduce.next(epoch)
+	if !ok {
+		return nil, errEpochChanged
+	}
+	children := append([]uint64(nil), f.children(epoch)...)
+	sort.Sort(uint64s(children))
+
+	acc := data
+	for _, id := range children {
+		item, err := f.awaitAllReduce(ctx, epoch, allReduceKey("up", seq, id))
+		if err != nil {
+			return nil, err
+		}
+		acc = op(acc, item)
+	}
+	result := acc
+	if len(parents) == 1 {
+		if err := f.pushAllReduce(ctx, parents[0], allReduceKey("up", seq, f.taskID), epoch, acc); err != nil {
+			return nil, err
+		}
+		var err error
+		result, err = f.awaitAllReduce(ctx, epoch, allReduceKey("down", seq, parents[0]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	errs := make(chan error, len(children))
+	for _, id := range children {
+		go func(id uint64) {
+			errs <- f.pushAllReduce(ctx, id, allReduceKey("down", seq, f.taskID), epoch, result)
+		}(id)
+	}
+	for range children {
+		if err := <-errs; err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (f *framework) awaitAllReduce(ctx context.Context, epoch uint64, key string) ([]byte, error) {
+	c := f.allReduce.slot(epoch, key)
+	if c == nil {
+		return nil, errEpochChanged
+	}
+	select {
+	case data := <-c:
+		return data, nil
+	case <-ctx.Done():
+		return nil, f.canceledErr()
+	}
+}
+
+// pushAllReduce pushes data for AllReduce of the task. Tasks may move on to
+// the epoch at different times, so pushes turned down for epoch mismatch are
+// tried again until this epoch is over.
+func (f *framework) pushAllReduce(ctx context.Context, toID uint64, req string, epoch uint64, data []byte) error {
+	pt, ok := f.transport.(PushTransport)
+	if !ok || !f.peerTalks(toID, frameworkhttp.ProtocolV2) {
+		return errPushNotSupported
+	}
+	for {
+		if !f.sendLimiter.acquire(f.httpStop) {
+			return frameworkhttp.ErrServerClosed
+		}
+		err := f.retry(ctx, "data push", toID, req, func() error {
+			addr, err := f.addrCache.get(toID)
+			if err != nil {
+				return err
+			}
+			err = pt.Push(ctx, addr, req, f.taskID, toID, epoch, data)
+			if addressStale(ctx, err) {
+				f.addrCache.invalidate(toID, addr)
+			}
+			return err
+		})
+		f.sendLimiter.release()
+		if err != frameworkhttp.ErrReqEpochMismatch {
+			if ctx.Err() != nil {
+				return f.canceledErr()
+			}
+			return err
+		}
+		select {
+		case <-time.After(dataRequestBackoff):
+		case <-ctx.Done():
+			return f.canceledErr()
+		}
+	}
+}
+
+// handleAllReducePush passes data pushed for AllReduce on to the call waiting
+// for it. It returns false if data isn't for AllReduce.
+func (f *framework) handleAllReducePush(p *dataPush) bool {
+	if !strings.HasPrefix(p.req, allReducePrefix) {
+		return false
+	}
+	c := f.allReduce.slot(p.epoch, p.req)
+	if c == nil {
+		return true
+	}
+	select {
+	case c <- p.data:
+	default:
+		// Pushed again after a retry.
+	}
+	return true
+}
+
+type uint64s []uint64
+
+func (s uint64s) Len() int           { return len(s) }
+func (s uint64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
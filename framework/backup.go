@@ -0,0 +1,147 @@
+package framework
+
+import (
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+)
+
+// Update appends data to the update log of the task, which backups of the
+// task apply. The primary is expected to have applied it itself. It returns 0
+// if the log couldn't be shipped.
+func (f *framework) Update(taskID uint64, data []byte) uint64 {
+	f.updateMu.Lock()
+	defer f.updateMu.Unlock()
+	if f.lastUpdateIDs == nil {
+		f.lastUpdateIDs = make(map[uint64]uint64)
+	}
+	last, ok := f.lastUpdateIDs[taskID]
+	if !ok {
+		// Pick up the log where previous primary of the task left it.
+		var err error
+		last, err = f.coord.LastUpdateLogID(f.name, taskID)
+		if err != nil {
+			f.reportError(meritop.SeverityRecoverable, fmt.Sprintf("getting update log of task %d", taskID), err)
+			return 0
+		}
+	}
+	ul := meritop.UpdateLog{ID: last + 1, Data: data}
+	if err := f.coord.ShipUpdateLog(f.name, taskID, ul); err != nil {
+		f.reportError(meritop.SeverityRecoverable, fmt.Sprintf("shipping update log %d of task %d", ul.ID, taskID), err)
+		return 0
+	}
+	f.lastUpdateIDs[taskID] = ul.ID
+	return ul.ID
+}
+
+// backup makes the framework a backup copy of some task if backups are
+// enabled and no task is free. It returns true once the backup has been
+// promoted to primary of the task.
+func (f *framework) backup() (bool, error) {
+	if f.maxBackups <= 0 {
+		return false, nil
+	}
+	free, err := f.coord.FreeTasks(f.name)
+	if err != nil {
+		return false, err
+	}
+	if len(free) > 0 {
+		return false, nil
+	}
+	n, ok, err := f.coord.NumOfTasks(f.name)
+	if err != nil || !ok {
+		return false, err
+	}
+	addr := frameworkhttp.ListenerAddr(f.ln)
+	for taskID := uint64(0); taskID < n; taskID++ {
+		for replicaID := uint64(1); replicaID <= uint64(f.maxBackups); replicaID++ {
+			if f.coord.OccupyReplica(f.name, taskID, replicaID, addr) {
+				return f.runAsBackup(taskID, replicaID)
+			}
+		}
+	}
+	return false, nil
+}
+
+// runAsBackup applies update logs shipped by the primary of the task until it
+// fails, and then tries to take the task over.
+func (f *framework) runAsBackup(taskID, replicaID uint64) (bool, error) {
+	defer f.coord.ReleaseReplica(f.name, taskID, replicaID)
+	f.updateNumOfTasks()
+	f.topology.SetTaskID(taskID)
+	task := f.buildTask(taskID)
+	b, ok := task.(meritop.Backupable)
+	if !ok {
+		f.log.Warnf("task %d isn't Backupable. Stand by instead.", taskID)
+		f.maxBackups = 0
+		return false, nil
+	}
+
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		err := f.coord.HeartbeatReplica(f.name, taskID, replicaID,
+			frameworkhttp.ListenerAddr(f.ln), f.heartbeatInterval(), stop)
+		if err != nil {
+			f.log.Warnf("HeartbeatReplica stops with error: %v\n", err)
+		}
+	}()
+
+	// Logs kept, and those shipped while the task is being set up, are
+	// applied after.
+	logs := make(chan meritop.UpdateLog, 1)
+	logStop := make(chan bool, 1)
+	f.coord.WatchUpdateLog(f.name, taskID, 0, logs, logStop)
+
+	f.log.Infof("backup %d of task %d starts", replicaID, taskID)
+	f.taskID = taskID
+	f.task = task
+	if err := f.callInit(); err != nil {
+		logStop <- true
+		f.task = nil
+		return false, err
+	}
+	f.restoreState()
+	b.BecameBackup()
+	failed := make(chan error, 1)
+	go func() { failed <- f.coord.WaitTaskFailure(f.name, taskID) }()
+	for {
+		select {
+		case ul, ok := <-logs:
+			if !ok {
+				f.log.Infof("backup %d of task %d stopped getting update logs", replicaID, taskID)
+				f.task = nil
+				return false, nil
+			}
+			b.Update(ul)
+		case err := <-failed:
+			// Apply what the primary has shipped before taking over.
+			logStop <- true
+			for ul := range logs {
+				b.Update(ul)
+			}
+			if err != nil {
+				return false, err
+			}
+			if f.jobFinished() {
+				return false, errJobFinished
+			}
+			if !f.coord.OccupyTask(f.name, taskID, frameworkhttp.ListenerAddr(f.ln)) {
+				f.log.Warnf("backup %d of task %d failed to take the task over", replicaID, taskID)
+				f.task = nil
+				return false, nil
+			}
+			f.log.Infof("backup %d of task %d becomes primary", replicaID, taskID)
+			b.BecamePrimary()
+			return true, nil
+		case <-f.quitChan():
+			logStop <- true
+			for range logs {
+			}
+			f.log.Infof("backup %d of task %d stopped", replicaID, taskID)
+			f.task = nil
+			return false, errStopped
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package framework
+
+import (
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+)
+
+// incEpochAtBarrier asks for the epoch to advance once enough tasks are done
+// with it, counting this one.
+func (f *framework) incEpochAtBarrier(epoch uint64) {
+	if err := f.coord.MarkEpochAdvance(f.name, epoch); err != nil {
+		f.reportError(meritop.SeverityRecoverable, fmt.Sprintf("asking epoch %d to advance", epoch), err)
+		return
+	}
+	f.epochDone(epoch)
+}
+
+func (f *framework) epochDone(epoch uint64) {
+	if !f.barrier && !f.controllerPaced {
+		return
+	}
+	if err := f.coord.MarkEpochDone(f.name, epoch, f.taskID); err != nil {
+		f.reportError(meritop.SeverityRecoverable, fmt.Sprintf("checking in at barrier of epoch %d", epoch), err)
+		return
+	}
+	if f.controllerPaced {
+		// The controller advances the epoch once it's ready.
+		return
+	}
+	if _, err := f.coord.TryPassBarrier(f.name, epoch, f.quorumOfBarrier()); err != nil {
+		f.reportError(meritop.SeverityRecoverable, fmt.Sprintf("passing barrier of epoch %d", epoch), err)
+	}
+}
+
+// setupPacing finds out whether the controller paces epochs of the job.
+func (f *framework) setupPacing() error {
+	paced, err := f.coord.ControllerPaced(f.name)
+	if err != nil {
+		return err
+	}
+	f.controllerPaced = paced
+	return nil
+}
+
+// quorumOfBarrier returns the number of tasks to be done with an epoch
+// before it advances. It's all tasks by default.
+func (f *framework) quorumOfBarrier() int {
+	if f.barrierQuorum > 0 {
+		return f.barrierQuorum
+	}
+	return int(f.numTasks())
+}
+
+// Barrier blocks until all tasks have entered the named barrier in the current
+// epoch, or the epoch changes.
+func (f *framework) Barrier(name string) error {
+	epoch, ctx := f.epoch, f.epochCtx
+	if ctx == nil || ctx.Err() != nil {
+		return f.canceledErr()
+	}
+	if err := f.coord.EnterBarrier(f.name, epoch, name, f.taskID); err != nil {
+		return err
+	}
+	stop := make(chan bool)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		close(stop)
+	}()
+	passed, err := f.coord.WaitBarrier(f.name, epoch, name, int(f.numTasks()), stop)
+	if err != nil {
+		return err
+	}
+	if !passed {
+		return f.canceledErr()
+	}
+	return nil
+}
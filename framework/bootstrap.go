@@ -1,34 +1,50 @@
 package framework
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"os"
-	"strconv"
-	"strings"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
 	"github.com/go-distributed/meritop"
 	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"github.com/go-distributed/meritop/pkg/codec"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
+	"github.com/go-distributed/meritop/pkg/topoutil"
+	"golang.org/x/net/context"
 )
 
+// errJobFinished is returned by occupyTask if the job finished while standing by.
+var errJobFinished = errors.New("job has finished")
+
 type taskRole int
 
 const (
 	roleNone taskRole = iota
 	roleParent
 	roleChild
+	roleNeighbor
 )
 
 // One need to pass in at least these two for framework to start.
-func NewBootStrap(jobName string, etcdURLs []string, ln net.Listener, logger *log.Logger) meritop.Bootstrap {
-	return &framework{
+// Optional behavior can be configured via opts. logger, if not nil, is logged
+// to at LevelInfo. See WithLogger to plug in another meritop.Logger.
+func NewBootStrap(jobName string, etcdURLs []string, ln net.Listener, logger *log.Logger, opts ...Option) meritop.Bootstrap {
+	f := &framework{
 		name:     jobName,
+		jobName:  jobName,
 		etcdURLs: etcdURLs,
 		ln:       ln,
-		log:      logger,
 	}
+	if logger != nil {
+		f.logger = meritop.NewStdLogger(logger, meritop.LevelInfo)
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
 }
 
 func (f *framework) SetTaskBuilder(taskBuilder meritop.TaskBuilder) { f.taskBuilder = taskBuilder }
@@ -36,74 +52,274 @@ func (f *framework) SetTaskBuilder(taskBuilder meritop.TaskBuilder) { f.taskBuil
 func (f *framework) SetTopology(topology meritop.Topology) { f.topology = topology }
 
 func (f *framework) Start() {
-	var err error
+	if f.logger == nil {
+		f.logger = meritop.NewStdLogger(log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate), meritop.LevelInfo)
+	}
+	f.log = &taskLogger{f: f}
+	if f.codec == nil {
+		f.codec = codec.NewJSON()
+	}
+	if f.transport == nil {
+		var t *frameworkhttp.Transport
+		if f.tlsConfig != nil {
+			t = frameworkhttp.NewTLSTransport(newStdLog(f.log), f.tlsConfig)
+		} else {
+			t = frameworkhttp.NewTransport(newStdLog(f.log))
+		}
+		if f.rateLimit != nil {
+			t.SetRateLimit(*f.rateLimit)
+		}
+		f.transport = t
+	}
 
-	if f.log == nil {
-		f.log = log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate)
+	defer close(f.started())
+	defer f.setState(meritop.StateStopped)
+	// etcd is only needed by the default coordinator.
+	if f.coord == nil {
+		if f.etcdClient == nil {
+			client, err := etcdutil.NewClient(f.etcdURLs, f.etcdInfo)
+			if err != nil {
+				f.log.Warnf("creating etcd client failed: %v", err)
+				return
+			}
+			f.etcdClient = client
+		}
+		f.coord = etcdutil.NewCoordinator(f.etcdClient)
+	}
+	if f.stateStore == nil {
+		f.stateStore = &coordStateStore{coord: f.coord, name: f.name}
+	}
+	for {
+		f.runTask()
+		if f.stopped() {
+			f.releaseStopped()
+			return
+		}
+		if f.drained {
+			f.releaseTask()
+			return
+		}
+		if f.exited {
+			f.unregisterTask()
+			return
+		}
+		// Supervised, the node takes a task again once its task panicked.
+		if f.taskPanic == nil || !f.restartTask() {
+			return
+		}
 	}
+}
+
+// runTask takes a task and runs it until the node stops working for it.
+func (f *framework) runTask() {
+	var err error
+
+	f.setState(meritop.StateStarting)
+	f.runCtx, f.cancelRun = context.WithCancel(context.Background())
+	defer f.cancelRun()
 
-	f.etcdClient = etcd.NewClient(f.etcdURLs)
+	f.addrCache = newAddressCache(f.coord, f.name)
+	// Errors before getting a task can't be told to any, so framework gives
+	// up starting. Another node could take over the task.
+	if err = f.setupAuth(); err != nil {
+		f.log.Warnf("setupAuth() failed: %v", err)
+		f.addrCache.stopWatch()
+		return
+	}
+	if err = f.setupConfig(); err != nil {
+		f.log.Warnf("setupConfig() failed: %v", err)
+		f.addrCache.stopWatch()
+		return
+	}
+	if err = f.setupPacing(); err != nil {
+		f.log.Warnf("setupPacing() failed: %v", err)
+		f.addrCache.stopWatch()
+		return
+	}
+	if err = f.setupLease(); err != nil {
+		f.log.Warnf("setupLease() failed: %v", err)
+		f.addrCache.stopWatch()
+		return
+	}
 
 	if err = f.occupyTask(); err != nil {
-		f.log.Fatalf("occupyTask() failed: %v", err)
+		if err == errJobFinished {
+			f.log.Infof("standby found that job has finished\n")
+		} else if err == errStopped {
+			f.log.Infof("standby stopped")
+		} else {
+			f.log.Warnf("occupyTask() failed: %v", err)
+		}
+		f.addrCache.stopWatch()
+		return
+	}
+	f.recordEvent(etcdutil.Event{
+		Kind:   etcdutil.EventRegister,
+		TaskID: f.taskID,
+		Detail: frameworkhttp.ListenerAddr(f.ln),
+	})
+	if err = f.setupFencing(); err != nil {
+		f.log.Warnf("setupFencing() failed: %v", err)
+		f.addrCache.stopWatch()
+		return
+	}
+	if err = f.setupVersion(); err != nil {
+		f.log.Warnf("setupVersion() failed: %v", err)
+		f.addrCache.stopWatch()
+		return
 	}
 
 	f.epochChan = make(chan uint64, 1) // grab epoch from etcd
 	f.epochStop = make(chan bool, 1)   // stop etcd watch
 	// meta will have epoch prepended so we must get epoch before any watch on meta
-	f.epoch, err = etcdutil.GetAndWatchEpoch(f.etcdClient, f.name, f.epochChan, f.epochStop)
+	f.epoch, err = f.coord.WatchEpoch(f.name, f.epochChan, f.epochStop)
 	if err != nil {
-		f.log.Fatalf("WatchEpoch failed: %v", err)
+		f.log.Warnf("WatchEpoch failed: %v", err)
+		f.addrCache.stopWatch()
+		return
 	}
 	if f.epoch == exitEpoch {
-		f.log.Printf("task %d found that job has finished\n", f.taskID)
+		f.log.Infof("task %d found that job has finished\n", f.taskID)
 		f.epochStop <- true
+		f.addrCache.stopWatch()
 		return
 	}
-	f.log.Printf("task %d starting at epoch %d\n", f.taskID, f.epoch)
+	f.log.Infof("task %d starting at epoch %d\n", f.taskID, f.epoch)
 
 	// task builder and topology are defined by applications.
 	// Both should be initialized at this point.
 	// Get the task implementation and topology for this node (indentified by taskID)
-	f.task = f.taskBuilder.GetTask(f.taskID)
+	// Backups promoted to primary have set up the task already.
 	f.topology.SetTaskID(f.taskID)
+	// The job might have been resized since topology was created, and this
+	// task removed after it was taken.
+	if !f.updateNumOfTasks() {
+		f.exitRemoved()
+		f.epochStop <- true
+		f.addrCache.stopWatch()
+		return
+	}
+	promoted := f.task != nil
+	if !promoted {
+		f.task = f.buildTask(f.taskID)
+	}
 
+	f.setupLimiters()
+	f.setupChannels()
+	go f.stopOnQuit(f.stopChan)
 	go f.startHTTP()
 
 	f.heartbeat()
-	f.setupChannels()
-	f.task.Init(f.taskID, f)
+	f.detectFailure()
+	f.watchHealth()
+	f.openWAL()
+	if !promoted {
+		f.initTask()
+	}
+	f.watchCheckpoint()
+	f.watchConfig()
 	f.run()
 	f.releaseResource()
 }
 
+// buildTask gets the task implementation for taskID from the task builder.
+func (f *framework) buildTask(taskID uint64) meritop.Task {
+	b, ok := f.taskBuilder.(meritop.TaskBuilderV2)
+	if !ok {
+		return f.taskBuilder.GetTask(taskID)
+	}
+	return b.BuildTask(meritop.TaskInfo{
+		TaskID:     taskID,
+		NumOfTasks: f.numOfTasks,
+		Config:     f.Config(),
+		Topology:   lockedTopology{f},
+	})
+}
+
+// setupAuth passes the auth token of the job to transport.
+func (f *framework) setupAuth() error {
+	token := f.authToken
+	if token == "" {
+		var err error
+		if token, err = f.coord.AuthToken(f.name); err != nil {
+			return err
+		}
+	}
+	if token == "" {
+		return nil
+	}
+	at, ok := f.transport.(AuthTransport)
+	if !ok {
+		return fmt.Errorf("transport %T doesn't support auth", f.transport)
+	}
+	at.SetAuthToken(token)
+	return nil
+}
+
+// setupConfig loads the configuration of the job, and checks it has the keys
+// required.
+func (f *framework) setupConfig() error {
+	cfg, err := f.coord.Config(f.name)
+	if err != nil {
+		return err
+	}
+	f.setConfig(meritop.Config(cfg))
+	return f.Config().Require(f.requiredConfig...)
+}
+
 func (f *framework) setupChannels() {
 	f.httpStop = make(chan struct{})
+	f.httpDone = make(chan struct{})
+	f.stopChan = make(chan struct{})
 	f.metaChan = make(chan *metaChange, 100)
 	f.dataReqtoSendChan = make(chan *dataRequest, 100)
 	f.dataReqChan = make(chan *dataRequest, 100)
 	f.dataRespToSendChan = make(chan *dataResponse, 100)
 	f.dataRespChan = make(chan *frameworkhttp.DataResponse, 100)
+	f.dataBatchToSendChan = make(chan *dataBatch, 100)
+	f.dataBatchRespChan = make(chan *dataBatchResponse, 100)
+	f.dataPushToSendChan = make(chan *dataPush, 100)
+	f.dataPushChan = make(chan *dataPush, 100)
+	f.dataCallbackChan = make(chan *dataCallback, 100)
+	f.gateChan = make(chan struct{}, 1)
+	f.dataReqFailChan = make(chan *dataRequestFailure, 100)
+	f.healthChan = make(chan *healthChange, 100)
+	f.checkpointChan = make(chan uint64, 10)
+	f.configChan = make(chan meritop.Config, 10)
+	f.stallChan = make(chan uint64, 1)
 }
 
 func (f *framework) run() {
-	f.log.Printf("framework of task %d starts to run", f.taskID)
-	defer f.log.Printf("framework of task %d stops running.", f.taskID)
-	f.setEpochStarted()
+	f.log.Infof("framework of task %d starts to run", f.taskID)
+	defer f.log.Infof("framework of task %d stops running.", f.taskID)
+	defer f.recoverTask()
+	select {
+	case <-f.stopChan:
+		// The task failed to initialize.
+		return
+	default:
+	}
+	f.gating = false
+	f.setEpochStarted(false)
+	f.setState(meritop.StateRunning)
 	for {
 		select {
-		case nextEpoch, ok := <-f.epochChan:
+		case <-f.stopChan: // single task exit
 			f.releaseEpochResource()
-			if !ok { // single task exit
-				nextEpoch = exitEpoch
+			f.fireEpochEnd()
+			return
+		case nextEpoch := <-f.epochChan:
+			f.setState(meritop.StateEpochTransition)
+			if !f.epochGateOpen(nextEpoch) {
+				break
+			}
+			if !f.switchEpoch(nextEpoch) {
 				return
 			}
-			f.epoch = nextEpoch
-			if f.epoch == exitEpoch {
+		case <-f.gateChan:
+			if !f.switchEpoch(f.gateReleased()) {
 				return
 			}
-			// start the next epoch's work
-			f.setEpochStarted()
 		case meta := <-f.metaChan:
 			if meta.epoch != f.epoch {
 				break
@@ -112,25 +328,36 @@ func (f *framework) run() {
 			// the epoch that was meant for this event. This context will be passed
 			// to user event handler functions and used to ask framework to do work later
 			// with previous information.
-			go f.handleMetaChange(f.createContext(), meta.who, meta.from, meta.meta)
+			// Metas are handed to task one at a time, in order they were
+			// flagged.
+			ctx := f.createContext()
+			if meta.who == roleNeighbor {
+				f.metaQueue.push(func() { f.handleNeighborMeta(ctx, meta.linkType, meta.from, meta.meta) })
+				break
+			}
+			f.metaQueue.push(func() { f.handleMetaChange(ctx, meta.who, meta.from, meta.meta) })
 		case req := <-f.dataReqtoSendChan:
 			if req.epoch != f.epoch {
-				f.log.Printf("epoch mismatch: task %d, req-to-send epoch: %d, current epoch: %d",
+				f.log.Debugf("epoch mismatch: task %d, req-to-send epoch: %d, current epoch: %d",
 					f.taskID, req.epoch, f.epoch)
+				f.sendLimiter.release()
 				break
 			}
-			go f.sendRequest(req)
+			go f.sendRequest(f.requestContext(), req)
 		case req := <-f.dataReqChan:
 			if req.epoch != f.epoch {
-				f.log.Printf("epoch mismatch: task %d, request epoch: %d, current epoch: %d",
+				f.log.Debugf("epoch mismatch: task %d, request epoch: %d, current epoch: %d",
 					f.taskID, req.epoch, f.epoch)
 				req.notifyEpochMismatch()
 				break
 			}
-			go f.handleDataReq(req)
+			go f.handleDataReq(f.epochCtx, req)
 		case resp := <-f.dataRespToSendChan:
+			// Data served once the task has moved on from the epoch
+			// requested is turned down here, so responses handed back
+			// are always of the epoch they're stamped with.
 			if resp.epoch != f.epoch {
-				f.log.Printf("epoch mismatch: task %d, resp-to-send epoch: %d, current epoch: %d",
+				f.log.Debugf("epoch mismatch: task %d, resp-to-send epoch: %d, current epoch: %d",
 					f.taskID, resp.epoch, f.epoch)
 				resp.notifyEpochMismatch()
 				break
@@ -138,59 +365,253 @@ func (f *framework) run() {
 			go f.sendResponse(resp)
 		case resp := <-f.dataRespChan:
 			if resp.Epoch != f.epoch {
-				f.log.Printf("epoch mismatch: task %d, response epoch: %d, current epoch: %d",
+				f.log.Debugf("epoch mismatch: task %d, response epoch: %d, current epoch: %d",
 					f.taskID, resp.Epoch, f.epoch)
+				if h, ok := f.task.(meritop.StaleDataHandler); ok {
+					go f.handleStaleData(h, resp)
+					break
+				}
+				if resp.Body != nil {
+					resp.Body.Close()
+				}
+				f.handleLimiter.release()
+				break
+			}
+			go f.handleReceived(f.createContext(), resp)
+		case fail := <-f.dataReqFailChan:
+			if fail.epoch != f.epoch {
+				break
+			}
+			go f.handleDataReqFailure(f.createContext(), fail)
+		case c := <-f.dataCallbackChan:
+			if c.epoch != f.epoch {
+				f.log.Debugf("epoch mismatch: task %d, response epoch: %d, current epoch: %d",
+					f.taskID, c.epoch, f.epoch)
+				break
+			}
+			go f.handleDataCallback(c)
+		case b := <-f.dataBatchToSendChan:
+			if b.epoch != f.epoch {
+				f.log.Debugf("epoch mismatch: task %d, batch-to-send epoch: %d, current epoch: %d",
+					f.taskID, b.epoch, f.epoch)
+				break
+			}
+			go f.sendBatch(f.requestContext(), b)
+		case b := <-f.dataBatchRespChan:
+			if b.epoch != f.epoch {
+				f.log.Debugf("epoch mismatch: task %d, batch response epoch: %d, current epoch: %d",
+					f.taskID, b.epoch, f.epoch)
+				if h, ok := f.task.(meritop.StaleDataHandler); ok {
+					for _, resp := range b.resps {
+						go f.handleStaleData(h, resp)
+					}
+				}
+				break
+			}
+			go f.handleBatchResp(f.createContext(), b)
+		case p := <-f.dataPushToSendChan:
+			if p.epoch != f.epoch {
+				f.log.Debugf("epoch mismatch: task %d, push-to-send epoch: %d, current epoch: %d",
+					f.taskID, p.epoch, f.epoch)
+				f.sendLimiter.release()
+				break
+			}
+			go f.sendPush(f.requestContext(), p)
+		case p := <-f.dataPushChan:
+			if p.epoch != f.epoch {
+				f.log.Debugf("epoch mismatch: task %d, pushed data epoch: %d, current epoch: %d",
+					f.taskID, p.epoch, f.epoch)
+				p.errChan <- frameworkhttp.ErrReqEpochMismatch
+				break
+			}
+			p.errChan <- nil
+			go f.handleDataPush(f.createContext(), p)
+		case h := <-f.healthChan:
+			go f.handleHealthChange(f.createContext(), h)
+		case epoch := <-f.checkpointChan:
+			f.handleCheckpointRequest(epoch)
+		case cfg := <-f.configChan:
+			f.handleConfigUpdate(f.createContext(), cfg)
+		case epoch := <-f.stallChan:
+			if epoch != f.epoch {
 				break
 			}
-			go f.handleDataResp(f.createContext(), resp)
+			f.handleEpochStalled(epoch)
 		}
 	}
 }
 
-func (f *framework) setEpochStarted() {
-	f.task.SetEpoch(f.createContext(), f.epoch)
+// switchEpoch ends the current epoch and starts nextEpoch. It returns false
+// if the task is to stop instead.
+func (f *framework) switchEpoch(nextEpoch uint64) bool {
+	f.releaseEpochResource()
+	f.fireEpochEnd()
+	// Controller sets epoch back to roll the job back.
+	rollback := nextEpoch <= f.epoch
+	f.epoch = nextEpoch
+	if f.epoch == exitEpoch {
+		// The controller cleans the job up once all tasks are gone.
+		f.markExited()
+		return false
+	}
+	n := f.numOfTasks
+	if !f.updateNumOfTasks() {
+		f.exitRemoved()
+		return false
+	}
+	f.resized = f.numOfTasks != n
+	// start the next epoch's work
+	f.setEpochStarted(rollback)
+	f.setState(meritop.StateRunning)
+	return true
+}
+
+func (f *framework) setEpochStarted(rollback bool) {
+	f.epochCtx, f.cancelEpoch = context.WithCancel(f.runCtx)
+	f.epochStarted = time.Now()
+	f.startChildrenReady(f.createContext())
+	f.startEpochDeadline()
+	if rollback {
+		f.forgetFlagged()
+	}
+	if f.checkpointPending && f.checkpointAt == f.epoch {
+		f.checkpoint()
+	}
+	f.fireEpochStart()
+	if f.resized {
+		f.resized = false
+		f.handleResize(f.createContext())
+	}
+	if r, ok := f.task.(meritop.EpochRollbacker); ok && rollback {
+		r.RollbackEpoch(f.createContext(), f.epoch)
+	} else {
+		f.setEpoch(f.createContext())
+	}
 
 	// setup etcd watches
 	// - create self's parent and child meta flag
 	// - watch parents' child meta flag
 	// - watch children's parent meta flag
-	f.watchMeta(roleParent, f.topology.GetParents(f.epoch))
-	f.watchMeta(roleChild, f.topology.GetChildren(f.epoch))
+	f.watchMeta(roleParent, "", f.parents(f.epoch))
+	f.watchMeta(roleChild, "", f.children(f.epoch))
+	// - watch peers' and neighbors' meta flag of each link type
+	if pt, ok := f.topology.(meritop.PeerTopology); ok {
+		f.watchMeta(roleNeighbor, topoutil.LinkPeer, pt.GetPeers(f.epoch))
+	}
+	if lt, ok := f.topology.(meritop.LinkTopology); ok {
+		for _, linkType := range lt.GetLinkTypes() {
+			f.watchMeta(roleNeighbor, linkType, lt.GetNeighbors(linkType, f.epoch))
+		}
+	}
 }
 
 func (f *framework) releaseEpochResource() {
+	if f.cancelEpoch != nil {
+		f.cancelEpoch()
+	}
+	f.CancelAllRequests()
+	f.stopChildrenReady()
+	f.stopEpochDeadline()
 	for _, c := range f.metaStops {
 		c <- true
 	}
 	f.metaStops = nil
 }
 
-// release resources: heartbeat, epoch watch.
+// release resources: watches first, then the server once requests being
+// answered are done, and heartbeat last so that the task isn't taken over
+// meanwhile. The task exits after all.
 func (f *framework) releaseResource() {
-	f.log.Printf("framework of task %d is releasing resources...\n", f.taskID)
+	f.setState(meritop.StateStopping)
+	f.log.Infof("framework of task %d is releasing resources...\n", f.taskID)
+	f.cancelRun()
 	f.epochStop <- true
-	close(f.heartbeatStop)
+	f.addrCache.stopWatch()
+	f.failureStop <- true
+	f.healthStop <- true
+	f.checkpointStop <- true
+	f.configStop <- true
 	f.stopHTTP()
+	close(f.heartbeatStop)
+	f.exitOnce.Do(func() {
+		defer f.recoverTask()
+		f.task.Exit()
+	})
+	f.closeWAL()
+	f.fireShutdown()
+}
+
+// setupFencing takes the next generation of the task, so that nodes which
+// worked for it before are fenced off.
+func (f *framework) setupFencing() error {
+	gen, err := f.coord.NextGeneration(f.name, f.taskID)
+	if err != nil {
+		return err
+	}
+	f.generation = gen
+	if ft, ok := f.transport.(FencedTransport); ok {
+		ft.SetFencing(gen.Value, f.addrCache.staleGeneration)
+	}
+	return nil
 }
 
 // occupyTask will grab the first unassigned task and register itself on etcd.
+// If all tasks are taken, it stands by, or runs as backup of a task, until one
+// of them fails, or the job has finished.
 func (f *framework) occupyTask() error {
+	if f.stopped() {
+		return errStopped
+	}
+	// A node restarted on the same address takes its task back.
+	if taskID, ok := f.coord.ReclaimTask(f.name, frameworkhttp.ListenerAddr(f.ln)); ok {
+		f.log.Infof("reclaimed task %d", taskID)
+		f.taskID = taskID
+		return nil
+	}
 	for {
-		freeTask, err := etcdutil.WaitFreeTask(f.etcdClient, f.name, f.log)
+		promoted, err := f.backup()
+		if err != nil {
+			return err
+		}
+		if promoted {
+			return nil
+		}
+		freeTask, err := f.coord.WaitFreeTask(f.name, f.log, f.quitChan())
+		if err == etcdutil.ErrWaitFreeTaskStopped {
+			return errStopped
+		}
+		if err == etcdutil.ErrWaitFreeTaskTimeout {
+			if f.jobFinished() {
+				return errJobFinished
+			}
+			continue
+		}
 		if err != nil {
 			return err
 		}
-		f.log.Printf("standby got failure at task %d", freeTask)
-		ok := etcdutil.TryOccupyTask(f.etcdClient, f.name, freeTask, f.ln.Addr().String())
+		f.log.Infof("standby got failure at task %d", freeTask)
+		if !f.waitRestartBackoff(freeTask) {
+			continue
+		}
+		ok := f.coord.OccupyTask(f.name, freeTask, frameworkhttp.ListenerAddr(f.ln))
 		if ok {
 			f.taskID = freeTask
 			return nil
 		}
-		f.log.Printf("standby tried task %d failed. Wait free task again.", freeTask)
+		f.log.Warnf("standby tried task %d failed. Wait free task again.", freeTask)
+	}
+}
+
+func (f *framework) jobFinished() bool {
+	epoch, err := f.coord.Epoch(f.name)
+	if err != nil {
+		f.log.Warnf("standby getting epoch failed: %v", err)
+		return false
 	}
+	return epoch == exitEpoch
 }
 
-func (f *framework) watchMeta(who taskRole, taskIDs []uint64) {
+func (f *framework) watchMeta(who taskRole, linkType string, taskIDs []uint64) {
 	stops := make([]chan bool, len(taskIDs))
 
 	for i, taskID := range taskIDs {
@@ -205,48 +626,80 @@ func (f *framework) watchMeta(who taskRole, taskIDs []uint64) {
 		case roleChild:
 			// Watch child's parent-meta.
 			watchPath = etcdutil.ParentMetaPath(f.name, taskID)
+		case roleNeighbor:
+			// Watch neighbor's meta of the link type.
+			watchPath = etcdutil.LinkMetaPath(f.name, taskID, linkType)
 		default:
-			f.log.Panic("unexpected role")
+			panic("unexpected role")
 		}
 
 		// When a node working for a task crashed, a new node will take over
 		// the task and continue what's left. It assumes that progress is stalled
 		// until the new node comes (i.e. epoch won't change).
 
-		responseHandler := func(resp *etcd.Response, taskID uint64) {
-			if resp.Action != "set" && resp.Action != "get" {
-				return
-			}
-			// epoch is prepended to meta. When a new one starts and replaces
+		// Metas are kept together with those flagged before in the epoch.
+		// Ones already passed on are skipped, so each is handled once.
+		var lastSeq uint64
+		taskID := taskID
+		handler := func(value string, rev uint64) {
+			// epoch is stored with meta. When a new one starts and replaces
 			// the old one, it doesn't need to handle previous things, whose
 			// epoch is smaller than current one.
-			values := strings.SplitN(resp.Node.Value, "-", 2)
-			ep, err := strconv.ParseUint(values[0], 10, 64)
+			metas, err := decodeMetas(value)
 			if err != nil {
-				f.log.Panicf("WARN: not a unit64 prepended to meta: %s", values[0])
+				f.reportError(meritop.SeverityRecoverable, "decoding meta "+value, err)
+				return
+			}
+			// The value is written by the node flagging the last meta. If it
+			// has lost the task since, it's ignored.
+			if n := len(metas); n > 0 && f.addrCache.staleWrite(taskID, metas[n-1].Generation, rev) {
+				f.log.Infof("task %d ignored metas of task %d from stale generation %d",
+					f.taskID, taskID, metas[n-1].Generation)
+				return
 			}
-			f.metaChan <- &metaChange{
-				from:  taskID,
-				who:   who,
-				epoch: ep,
-				meta:  values[1],
+			for _, meta := range metas {
+				if meta.Seq != 0 && meta.Seq <= lastSeq {
+					continue
+				}
+				lastSeq = meta.Seq
+				f.metaChan <- &metaChange{
+					from:     taskID,
+					who:      who,
+					epoch:    meta.Epoch,
+					meta:     meta,
+					linkType: linkType,
+				}
 			}
 		}
 
-		// Need to pass in taskID to make it work. Didn't know why.
-		err := etcdutil.WatchMeta(f.etcdClient, taskID, watchPath, stop, responseHandler)
+		err := f.coord.WatchMeta(watchPath, stop, handler)
 		if err != nil {
-			f.log.Panicf("WatchMeta failed. path: %s, err: %v", watchPath, err)
+			f.reportError(meritop.SeverityFatal, "watching meta "+watchPath, err)
 		}
 	}
 	f.metaStops = append(f.metaStops, stops...)
 }
 
-func (f *framework) handleMetaChange(ctx meritop.Context, who taskRole, taskID uint64, meta string) {
+func (f *framework) handleMetaChange(ctx meritop.Context, who taskRole, taskID uint64, meta *meritop.Meta) {
+	defer f.recoverTask()
+	if meta.Broadcast && who == roleParent {
+		// Data of the meta is fetched for the task.
+		ctx.DataRequest(taskID, meta.Kind)
+		return
+	}
+	if r, ok := f.task.(meritop.TypedMetaReceiver); ok {
+		switch who {
+		case roleParent:
+			r.ParentTypedMetaReady(ctx, taskID, meta)
+		case roleChild:
+			r.ChildTypedMetaReady(ctx, taskID, meta)
+		}
+		return
+	}
 	switch who {
 	case roleParent:
-		f.task.ParentMetaReady(ctx, taskID, meta)
+		f.task.ParentMetaReady(ctx, taskID, meta.Kind)
 	case roleChild:
-		f.task.ChildMetaReady(ctx, taskID, meta)
+		f.task.ChildMetaReady(ctx, taskID, meta.Kind)
 	}
 }
@@ -0,0 +1,29 @@
+package framework
+
+import "github.com/go-distributed/meritop"
+
+// broadcastToChildren stages data for children to fetch in the epoch, and
+// tells them with meta.
+func (f *framework) broadcastToChildren(meta string, data []byte, epoch uint64) {
+	f.broadcastMu.Lock()
+	if f.broadcasts == nil || f.broadcastEpoch != epoch {
+		// Data of past epochs is never served again.
+		f.broadcasts = make(map[string][]byte)
+		f.broadcastEpoch = epoch
+	}
+	f.broadcasts[meta] = data
+	f.broadcastMu.Unlock()
+	f.flagMetaToChild(&meritop.Meta{Kind: meta, Epoch: epoch, Broadcast: true})
+}
+
+// broadcastData returns data broadcast to children with req as meta in the
+// epoch. It returns false if there is none.
+func (f *framework) broadcastData(epoch uint64, req string) ([]byte, bool) {
+	f.broadcastMu.Lock()
+	defer f.broadcastMu.Unlock()
+	if f.broadcastEpoch != epoch {
+		return nil, false
+	}
+	data, ok := f.broadcasts[req]
+	return data, ok
+}
@@ -0,0 +1,59 @@
+package framework
+
+import (
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+)
+
+// watchCheckpoint passes epochs the controller asks all tasks to checkpoint at
+// to event loop.
+func (f *framework) watchCheckpoint() {
+	f.checkpointStop = make(chan bool, 1)
+	err := f.coord.WatchCheckpointRequest(f.name, f.checkpointStop, func(epoch uint64) {
+		f.checkpointChan <- epoch
+	})
+	if err != nil {
+		f.reportError(meritop.SeverityRecoverable, "watching checkpoint requests", err)
+	}
+}
+
+// handleCheckpointRequest checkpoints the task if it's at the start of the
+// epoch asked, or keeps the request until it is.
+func (f *framework) handleCheckpointRequest(epoch uint64) {
+	switch {
+	case epoch > f.epoch:
+		f.checkpointAt, f.checkpointPending = epoch, true
+	case epoch == f.epoch:
+		f.checkpoint()
+	}
+}
+
+// checkpoint saves the state the task returns from Checkpoint to the state
+// store, and checks in as checkpointed at the current epoch. Tasks not
+// implementing meritop.Checkpointer have nothing to save.
+func (f *framework) checkpoint() {
+	f.checkpointPending = false
+	if !f.saveCheckpoint() {
+		return
+	}
+	if err := f.coord.MarkCheckpointDone(f.name, f.epoch, f.taskID); err != nil {
+		f.reportError(meritop.SeverityRecoverable, fmt.Sprintf("checking in checkpoint of epoch %d", f.epoch), err)
+	}
+}
+
+// saveCheckpoint saves the checkpoint of tasks implementing meritop.Checkpointer
+// as their state. It returns false if saving failed.
+func (f *framework) saveCheckpoint() bool {
+	c, ok := f.task.(meritop.Checkpointer)
+	if !ok {
+		return true
+	}
+	data := c.Checkpoint(f.epoch)
+	if err := f.stateStore.Save(f.taskID, f.epoch, data); err != nil {
+		f.reportError(meritop.SeverityRecoverable, fmt.Sprintf("checkpointing at epoch %d", f.epoch), err)
+		return false
+	}
+	f.truncateWAL()
+	return true
+}
@@ -0,0 +1,95 @@
+package framework
+
+import (
+	"math"
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+// childrenReady keeps track of children whose data has been delivered in an
+// epoch, for meritop.ChildrenReadyHandler.
+type childrenReady struct {
+	epoch     uint64
+	ctx       meritop.Context
+	total     int
+	need      int
+	responded map[uint64]bool
+	fired     bool
+	timer     *time.Timer
+}
+
+// startChildrenReady starts tracking children of the epoch started.
+func (f *framework) startChildrenReady(ctx *epochContext) {
+	f.stopChildrenReady()
+	if _, ok := f.task.(meritop.ChildrenReadyHandler); !ok {
+		return
+	}
+	if f.childrenFraction <= 0 && f.childrenTimeout <= 0 {
+		return
+	}
+	total := len(f.children(ctx.epoch))
+	if total == 0 {
+		return
+	}
+	need := total
+	if f.childrenFraction > 0 && f.childrenFraction < 1 {
+		need = int(math.Ceil(f.childrenFraction * float64(total)))
+	}
+	r := &childrenReady{
+		epoch:     ctx.epoch,
+		ctx:       ctx,
+		total:     total,
+		need:      need,
+		responded: make(map[uint64]bool),
+	}
+	f.readyMu.Lock()
+	defer f.readyMu.Unlock()
+	f.ready = r
+	if f.childrenTimeout > 0 {
+		r.timer = time.AfterFunc(f.childrenTimeout, func() { f.fireChildrenReady(r) })
+	}
+}
+
+// stopChildrenReady stops tracking children once the epoch is over.
+func (f *framework) stopChildrenReady() {
+	f.readyMu.Lock()
+	defer f.readyMu.Unlock()
+	if f.ready != nil && f.ready.timer != nil {
+		f.ready.timer.Stop()
+	}
+	f.ready = nil
+}
+
+// childResponded counts the child whose data has been delivered.
+func (f *framework) childResponded(epoch, childID uint64) {
+	f.readyMu.Lock()
+	r := f.ready
+	if r == nil || r.epoch != epoch || r.fired {
+		f.readyMu.Unlock()
+		return
+	}
+	r.responded[childID] = true
+	n := len(r.responded)
+	f.readyMu.Unlock()
+	if n >= r.need {
+		f.fireChildrenReady(r)
+	}
+}
+
+// fireChildrenReady tells the task once per epoch, unless the epoch is over.
+func (f *framework) fireChildrenReady(r *childrenReady) {
+	defer f.recoverTask()
+	f.readyMu.Lock()
+	if f.ready != r || r.fired {
+		f.readyMu.Unlock()
+		return
+	}
+	r.fired = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	partial := len(r.responded) < r.total
+	f.readyMu.Unlock()
+	f.task.(meritop.ChildrenReadyHandler).ChildrenReady(r.ctx, partial)
+}
@@ -0,0 +1,41 @@
+package framework
+
+import (
+	"reflect"
+
+	"github.com/go-distributed/meritop"
+)
+
+func (f *framework) setConfig(cfg meritop.Config) {
+	f.configMu.Lock()
+	defer f.configMu.Unlock()
+	f.config = cfg
+}
+
+// watchConfig passes the configuration of the job to event loop each time the
+// controller changes it.
+func (f *framework) watchConfig() {
+	f.configStop = make(chan bool, 1)
+	err := f.coord.WatchConfig(f.name, f.configStop, func(cfg map[string]string) {
+		f.configChan <- meritop.Config(cfg)
+	})
+	if err != nil {
+		f.reportError(meritop.SeverityRecoverable, "watching config", err)
+	}
+}
+
+// handleConfigUpdate takes the configuration changed, and tells tasks
+// implementing meritop.ConfigWatcher. Updates are handled in order, one at a
+// time.
+func (f *framework) handleConfigUpdate(ctx meritop.Context, cfg meritop.Config) {
+	if reflect.DeepEqual(cfg, f.Config()) {
+		return
+	}
+	f.setConfig(cfg)
+	w, ok := f.task.(meritop.ConfigWatcher)
+	if !ok {
+		return
+	}
+	defer f.recoverTask()
+	w.ConfigUpdated(ctx, cfg)
+}
@@ -1,29 +1,101 @@
 package framework
 
-type context struct {
+import (
+	"time"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/topoutil"
+	"golang.org/x/net/context"
+)
+
+// epochContext implements meritop.Context. It keeps the epoch the task was
+// in when the context was created.
+type epochContext struct {
 	epoch uint64
-	f     *framework
+	// started is when the task started the epoch.
+	started time.Time
+	f       *framework
 }
 
-func (f *framework) createContext() *context {
-	return &context{
-		epoch: f.epoch,
-		f:     f,
+func (f *framework) createContext() *epochContext {
+	return &epochContext{
+		epoch:   f.epoch,
+		started: f.epochStarted,
+		f:       f,
 	}
 }
 
-func (c *context) FlagMetaToParent(meta string) {
-	c.f.flagMetaToParent(meta, c.epoch)
+func (c *epochContext) FlagMetaToParent(meta string) {
+	c.f.flagMetaToParent(&meritop.Meta{Kind: meta, Epoch: c.epoch})
+}
+
+func (c *epochContext) FlagMetaToChild(meta string) {
+	c.f.flagMetaToChild(&meritop.Meta{Kind: meta, Epoch: c.epoch})
+}
+
+func (c *epochContext) FlagTypedMetaToParent(kind string, payload []byte) {
+	c.f.flagMetaToParent(&meritop.Meta{Kind: kind, Epoch: c.epoch, Payload: payload})
+}
+
+func (c *epochContext) FlagTypedMetaToChild(kind string, payload []byte) {
+	c.f.flagMetaToChild(&meritop.Meta{Kind: kind, Epoch: c.epoch, Payload: payload})
 }
 
-func (c *context) FlagMetaToChild(meta string) {
-	c.f.flagMetaToChild(meta, c.epoch)
+func (c *epochContext) FlagMetaToPeer(meta string) {
+	c.f.flagMetaToNeighbors(topoutil.LinkPeer, &meritop.Meta{Kind: meta, Epoch: c.epoch})
 }
 
-func (c *context) IncEpoch() {
+func (c *epochContext) FlagMetaToNeighbors(linkType string, meta string) {
+	c.f.flagMetaToNeighbors(linkType, &meritop.Meta{Kind: meta, Epoch: c.epoch})
+}
+
+func (c *epochContext) IncEpoch() {
 	c.f.incEpoch(c.epoch)
 }
 
-func (c *context) DataRequest(toID uint64, req string) {
+func (c *epochContext) EpochDone() {
+	c.f.epochDone(c.epoch)
+}
+
+func (c *epochContext) DataRequest(toID uint64, req string) {
 	c.f.dataRequest(toID, req, c.epoch)
 }
+
+func (c *epochContext) DataRequestContext(ctx context.Context, toID uint64, req string) {
+	c.f.dataRequestContext(ctx, toID, req, c.epoch)
+}
+
+func (c *epochContext) DataRequestFunc(toID uint64, req string, fn func(data []byte, err error)) {
+	c.f.dataRequestFunc(toID, req, fn, c.epoch)
+}
+
+func (c *epochContext) DataRequestMulti(toID uint64, reqs []string) {
+	c.f.dataRequestMulti(toID, reqs, c.epoch)
+}
+
+func (c *epochContext) DataRequestAll(toIDs []uint64, req string) {
+	c.f.dataRequestAll(toIDs, req, 0, c.epoch)
+}
+
+func (c *epochContext) DataRequestQuorum(toIDs []uint64, req string, quorum int) {
+	c.f.dataRequestAll(toIDs, req, quorum, c.epoch)
+}
+
+func (c *epochContext) BroadcastToChildren(meta string, data []byte) {
+	c.f.broadcastToChildren(meta, data, c.epoch)
+}
+
+func (c *epochContext) GatherFromChildren(req string, reduce func(acc, item []byte) []byte, done func(result []byte)) {
+	c.f.gatherFromChildren(req, reduce, done, c.epoch)
+}
+
+func (c *epochContext) ReportProgress(p meritop.Progress) {
+	if p.Duration == 0 && !c.started.IsZero() {
+		p.Duration = time.Since(c.started)
+	}
+	c.f.reportProgress(c.epoch, p)
+}
+
+func (c *epochContext) DataPush(toID uint64, req string, data []byte) {
+	c.f.dataPush(toID, req, data, c.epoch)
+}
@@ -0,0 +1,46 @@
+package framework
+
+import (
+	"golang.org/x/net/context"
+)
+
+func (f *framework) dataRequestFunc(toID uint64, req string, fn func(data []byte, err error), epoch uint64) {
+	if !f.sendLimiter.acquire(f.httpStop) {
+		return
+	}
+	f.dataReqtoSendChan <- &dataRequest{
+		taskID:   toID,
+		epoch:    epoch,
+		req:      req,
+		callback: fn,
+	}
+}
+
+// sendCallback gets the whole data of a request with a callback, and passes
+// it to event loop along with the callback. Requests canceled don't call it.
+func (f *framework) sendCallback(ctx context.Context, dr *dataRequest) {
+	var data []byte
+	d, err := f.fetchData(ctx, dr, false)
+	if err == nil {
+		data, err = f.wholeData(d)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	f.dataCallbackChan <- &dataCallback{
+		taskID:   dr.taskID,
+		epoch:    dr.epoch,
+		req:      dr.req,
+		data:     data,
+		err:      err,
+		callback: dr.callback,
+	}
+}
+
+func (f *framework) handleDataCallback(c *dataCallback) {
+	defer f.recoverTask()
+	c.callback(c.data, c.err)
+	if c.err == nil && f.isChild(c.epoch, c.taskID) {
+		f.childResponded(c.epoch, c.taskID)
+	}
+}
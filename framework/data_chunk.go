@@ -0,0 +1,133 @@
+package framework
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+)
+
+// Data served by a meritop.StreamingReducer is framed into segments, each a
+// uvarint length followed by as many bytes. An empty segment ends the data,
+// so that a transfer broken between segments isn't taken as complete.
+
+// newChunkReader returns the framed data of segments sent on ch.
+func newChunkReader(ch <-chan []byte) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeChunks(pw, ch))
+		// The request might be gone. Don't leave task blocked on sending.
+		for range ch {
+		}
+	}()
+	return pr
+}
+
+func writeChunks(w io.Writer, ch <-chan []byte) error {
+	var hdr [binary.MaxVarintLen64]byte
+	for chunk := range ch {
+		if len(chunk) == 0 {
+			continue
+		}
+		n := binary.PutUvarint(hdr[:], uint64(len(chunk)))
+		if _, err := w.Write(hdr[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0})
+	return err
+}
+
+// readChunks passes segments of framed data to deliver as they are read. The
+// rest of r is read before the last call, so that errors found at the end of
+// data, like checksum mismatch, are returned instead.
+func readChunks(r io.Reader, deliver func(chunk []byte, last bool)) error {
+	br := bufio.NewReader(r)
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if n == 0 {
+			if _, err := io.Copy(ioutil.Discard, br); err != nil {
+				return err
+			}
+			deliver(nil, true)
+			return nil
+		}
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return unexpectedEOF(err)
+		}
+		deliver(chunk, false)
+	}
+}
+
+// unexpectedEOF turns EOF into io.ErrUnexpectedEOF, as data isn't supposed to
+// end before the terminating segment.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// isChunked tells whether data from the task is served in segments.
+func (f *framework) isChunked(epoch, taskID uint64) bool {
+	_, ok := f.task.(meritop.StreamingReducer)
+	return ok && f.isChild(epoch, taskID)
+}
+
+// handleDataChunks passes data from child to task segment by segment. Segments
+// might have been delivered when the transfer breaks, so it isn't retried but
+// reported as a failed request.
+func (f *framework) handleDataChunks(ctx meritop.Context, r meritop.StreamingReducer, resp *frameworkhttp.DataResponse) {
+	body := resp.Body
+	if body == nil {
+		// The transport doesn't stream. Data has been received as a whole.
+		body = ioutil.NopCloser(bytes.NewReader(resp.Data))
+	}
+	defer body.Close()
+	err := readChunks(body, func(chunk []byte, last bool) {
+		r.ChildDataChunk(ctx, resp.TaskID, resp.Req, chunk, last)
+	})
+	if err != nil {
+		f.log.Warnf("task %d reading data chunks from task %d failed: %v", f.taskID, resp.TaskID, err)
+		f.handleDataReqFailure(ctx, &dataRequestFailure{
+			taskID: resp.TaskID,
+			epoch:  resp.Epoch,
+			req:    resp.Req,
+			err:    err,
+		})
+		return
+	}
+	f.childResponded(resp.Epoch, resp.TaskID)
+}
+
+// wholeData returns all data of the response, with segments joined if it is
+// served in segments.
+func (f *framework) wholeData(resp *frameworkhttp.DataResponse) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(resp.Data)
+	if resp.Body != nil {
+		defer resp.Body.Close()
+		r = resp.Body
+	}
+	if !f.isChunked(resp.Epoch, resp.TaskID) {
+		if resp.Body == nil {
+			return resp.Data, nil
+		}
+		return ioutil.ReadAll(r)
+	}
+	var data []byte
+	err := readChunks(r, func(chunk []byte, last bool) {
+		data = append(data, chunk...)
+	})
+	return data, err
+}
@@ -0,0 +1,52 @@
+package framework
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDataChunks(t *testing.T) {
+	chunks := [][]byte{{1}, {}, {2, 3}, bytes.Repeat([]byte{4}, 1000)}
+	ch := make(chan []byte, len(chunks))
+	for _, c := range chunks {
+		ch <- c
+	}
+	close(ch)
+	var buf bytes.Buffer
+	if err := writeChunks(&buf, ch); err != nil {
+		t.Fatalf("writeChunks failed: %v", err)
+	}
+	framed := buf.Bytes()
+
+	var got [][]byte
+	last := false
+	err := readChunks(bytes.NewReader(framed), func(chunk []byte, l bool) {
+		if l {
+			last = true
+			return
+		}
+		got = append(got, chunk)
+	})
+	if err != nil || !last {
+		t.Fatalf("readChunks failed: %v, last = %v", err, last)
+	}
+	// empty segments are skipped
+	want := [][]byte{chunks[0], chunks[2], chunks[3]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks want = %v, get = %v", want, got)
+	}
+
+	// Data cut short is never taken as complete.
+	for _, n := range []int{0, 1, 3, len(framed) - 1} {
+		err := readChunks(bytes.NewReader(framed[:n]), func(chunk []byte, l bool) {
+			if l {
+				t.Errorf("data cut at %d taken as complete", n)
+			}
+		})
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("data cut at %d: error want = %v, get = %v", n, io.ErrUnexpectedEOF, err)
+		}
+	}
+}
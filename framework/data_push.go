@@ -0,0 +1,82 @@
+package framework
+
+import (
+	"errors"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"golang.org/x/net/context"
+)
+
+var errPushNotSupported = errors.New("transport, or the task pushed to, doesn't support pushing data")
+
+func (f *framework) dataPush(toID uint64, req string, data []byte, epoch uint64) {
+	// Pushes are in flight like data requests, so they share the limit.
+	if !f.sendLimiter.acquire(f.httpStop) {
+		return
+	}
+	f.dataPushToSendChan <- &dataPush{
+		taskID: toID,
+		epoch:  epoch,
+		req:    req,
+		data:   data,
+	}
+}
+
+// sendPush pushes data to the task, retrying on failure like data requests.
+// It releases the slot taken by dataPush.
+func (f *framework) sendPush(ctx context.Context, p *dataPush) {
+	defer f.sendLimiter.release()
+	pt, ok := f.transport.(PushTransport)
+	if !ok || !f.peerTalks(p.taskID, frameworkhttp.ProtocolV2) {
+		f.log.Warnf("task %d data push (%s) to task %d failed: %v", f.taskID, p.req, p.taskID, errPushNotSupported)
+		return
+	}
+	f.retry(ctx, "data push", p.taskID, p.req, func() error {
+		addr, err := f.addrCache.get(p.taskID)
+		if err != nil {
+			return err
+		}
+		err = pt.Push(ctx, addr, p.req, f.taskID, p.taskID, p.epoch, p.data)
+		if addressStale(ctx, err) {
+			f.addrCache.invalidate(p.taskID, addr)
+		}
+		return err
+	})
+}
+
+// PushTaskData passes data pushed by another task to event loop, which checks
+// its epoch before handing it to the task.
+func (f *framework) PushTaskData(fromID, epoch uint64, req string, data []byte) error {
+	errChan := make(chan error, 1)
+	select {
+	case f.dataPushChan <- &dataPush{
+		taskID:  fromID,
+		epoch:   epoch,
+		req:     req,
+		data:    data,
+		errChan: errChan,
+	}:
+	case <-f.httpStop:
+		return frameworkhttp.ErrServerClosed
+	}
+	select {
+	case err := <-errChan:
+		return err
+	case <-f.httpStop:
+		return frameworkhttp.ErrServerClosed
+	}
+}
+
+func (f *framework) handleDataPush(ctx meritop.Context, p *dataPush) {
+	if f.handleAllReducePush(p) {
+		return
+	}
+	defer f.recoverTask()
+	r, ok := f.task.(meritop.DataPushReceiver)
+	if !ok {
+		f.log.Warnf("task %d dropped data (%s) pushed by task %d", f.taskID, p.req, p.taskID)
+		return
+	}
+	r.DataPushed(ctx, p.taskID, p.req, p.data)
+}
@@ -1,40 +1,311 @@
 package framework
 
 import (
-	"net/http"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-distributed/meritop"
+	merrors "github.com/go-distributed/meritop/errors"
 	"github.com/go-distributed/meritop/framework/frameworkhttp"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
-	"github.com/go-distributed/meritop/pkg/topoutil"
+	"golang.org/x/net/context"
 )
 
-func (f *framework) sendRequest(dr *dataRequest) {
-	addr, err := etcdutil.GetAddress(f.etcdClient, f.name, dr.taskID)
+// Data requests failed by e.g. network faults are retried with backoff.
+const defaultMaxDataRequestAttempts = 5
+
+// Data requests throttled by the serving task are retried up to this many
+// times the attempts of failed ones.
+const throttledAttemptsFactor = 4
+
+var (
+	dataRequestBackoff    = 100 * time.Millisecond
+	maxDataRequestBackoff = 5 * time.Second
+)
+
+// sendRequest sends the data request and passes the responses to event loop.
+// It releases the slot taken by dataRequest before waiting for slots to handle
+// the responses, as tasks handling responses may request more data meanwhile.
+func (f *framework) sendRequest(ctx context.Context, dr *dataRequest) {
+	if dr.ctx != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = mergeContext(ctx, dr.ctx)
+		defer cancel()
+	}
+	ds := f.send(ctx, dr)
+	f.sendLimiter.release()
+	for _, d := range ds {
+		f.passResponse(d)
+	}
+}
+
+// send sends the data request, and returns the responses to pass to event
+// loop.
+func (f *framework) send(ctx context.Context, dr *dataRequest) []*frameworkhttp.DataResponse {
+	if dr.reqs != nil {
+		return f.sendMulti(ctx, dr)
+	}
+	if dr.callback != nil {
+		f.sendCallback(ctx, dr)
+		return nil
+	}
+	_, stream := f.task.(meritop.DataStreamReceiver)
+	d, err := f.fetchData(ctx, dr, stream || f.isChunked(dr.epoch, dr.taskID))
 	if err != nil {
-		// TODO: We should handle network faults later by retrying
-		f.log.Fatalf("getAddress(%d) failed: %v", dr.taskID, err)
+		f.reportFailure(ctx, dr, err)
+		return nil
+	}
+	return []*frameworkhttp.DataResponse{d}
+}
+
+// passResponse passes the response to event loop once a slot to handle it is
+// free, see handleReceived. It's dropped if framework stops meanwhile.
+func (f *framework) passResponse(d *frameworkhttp.DataResponse) {
+	if !f.handleLimiter.acquire(f.httpStop) {
+		if d.Body != nil {
+			d.Body.Close()
+		}
 		return
 	}
-	d, err := frameworkhttp.RequestData(addr, dr.req, f.taskID, dr.taskID, dr.epoch, f.log)
+	f.dataRespChan <- d
+}
+
+// sendMulti asks for the data of several keys. Transports not supporting it
+// get the keys one by one. Data of each key is a separate response.
+func (f *framework) sendMulti(ctx context.Context, dr *dataRequest) []*frameworkhttp.DataResponse {
+	ds, err := f.fetchMulti(ctx, dr)
 	if err != nil {
-		if err == frameworkhttp.ErrReqEpochMismatch {
-			f.log.Printf("task %d got epoch mismatch error from server", f.taskID)
-			return
+		for _, req := range dr.reqs {
+			f.reportFailure(ctx, &dataRequest{taskID: dr.taskID, epoch: dr.epoch, req: req}, err)
+		}
+		return nil
+	}
+	return ds
+}
+
+func (f *framework) fetchMulti(ctx context.Context, dr *dataRequest) ([]*frameworkhttp.DataResponse, error) {
+	mt, ok := f.transport.(MultiTransport)
+	if !ok || !f.peerTalks(dr.taskID, frameworkhttp.ProtocolV2) {
+		ds := make([]*frameworkhttp.DataResponse, len(dr.reqs))
+		for i, req := range dr.reqs {
+			d, err := f.fetchData(ctx, &dataRequest{taskID: dr.taskID, epoch: dr.epoch, req: req}, false)
+			if err != nil {
+				return nil, err
+			}
+			ds[i] = d
 		}
-		f.log.Printf("task %d RequestData failed: %v", f.taskID, err)
+		return ds, nil
+	}
+	var ds []*frameworkhttp.DataResponse
+	err := f.retry(ctx, "data request", dr.taskID, strings.Join(dr.reqs, ","), func() error {
+		addr, err := f.addrCache.get(dr.taskID)
+		if err != nil {
+			return err
+		}
+		ds, err = mt.SendMulti(ctx, addr, dr.reqs, f.taskID, dr.taskID, dr.epoch)
+		if addressStale(ctx, err) {
+			f.addrCache.invalidate(dr.taskID, addr)
+		}
+		return err
+	})
+	return ds, err
+}
+
+// reportFailure passes a request given up to event loop, unless it has been
+// canceled on purpose.
+func (f *framework) reportFailure(ctx context.Context, dr *dataRequest, err error) {
+	if ctx.Err() != nil {
 		return
 	}
-	f.dataRespChan <- d
+	f.dataReqFailChan <- &dataRequestFailure{
+		taskID: dr.taskID,
+		epoch:  dr.epoch,
+		req:    dr.req,
+		err:    err,
+	}
+}
+
+// handleDataReqFailure tells tasks implementing meritop.DataRequestFailureHandler
+// that the request failed. Others just miss the data.
+func (f *framework) handleDataReqFailure(ctx meritop.Context, fail *dataRequestFailure) {
+	defer f.recoverTask()
+	if h, ok := f.task.(meritop.DataRequestFailureHandler); ok {
+		h.DataRequestFailed(ctx, fail.taskID, fail.req, fail.err)
+	}
+}
+
+// fetchData gets the data of the request from the serving task.
+func (f *framework) fetchData(ctx context.Context, dr *dataRequest, stream bool) (*frameworkhttp.DataResponse, error) {
+	var d *frameworkhttp.DataResponse
+	// Data received by failed attempts. Retries only ask for what follows.
+	var partial []byte
+	err := f.retry(ctx, "data request", dr.taskID, dr.req, func() (err error) {
+		d, err = f.trySendRequest(ctx, dr, stream, partial)
+		if e, ok := err.(*frameworkhttp.PartialDataError); ok {
+			partial = e.Data
+		} else if err != nil {
+			partial = nil
+		}
+		return err
+	})
+	return d, err
+}
+
+// retry runs the given attempt to talk to a task until it succeeds. Failed
+// attempts are retried with exponential backoff. The address of the task is
+// dropped from cache on failure, and looked up again on next attempt since the
+// task might have been taken over by another node.
+func (f *framework) retry(ctx context.Context, what string, toID uint64, req string, attempt func() error) error {
+	backoff := dataRequestBackoff
+	l := f.log.with("req", req).with("to", toID)
+	throttled := 0
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			l.Debugf("%s canceled", what)
+			return ctx.Err()
+		}
+		if err == frameworkhttp.ErrReqEpochMismatch {
+			l.Debugf("%s got epoch mismatch error from server", what)
+			return err
+		}
+		if err == frameworkhttp.ErrUnauthorized {
+			l.Warnf("%s not authorized", what)
+			return err
+		}
+		if err == frameworkhttp.ErrBadRequest {
+			l.Warnf("%s turned down", what)
+			return err
+		}
+		if err == frameworkhttp.ErrStaleGeneration {
+			// Another node has taken the task of this one.
+			f.reportError(meritop.SeverityFatal, what, etcdutil.ErrTaskFenced)
+			return err
+		}
+		wait := backoff
+		if e, ok := err.(*frameworkhttp.TooManyRequestsError); ok {
+			// The task is alive, but busy. It's not counted as a failure, but
+			// against a larger limit, so that requests to a task throttling
+			// them for good still fail in the end.
+			n--
+			throttled++
+			if throttled >= throttledAttemptsFactor*f.maxDataRequestAttempts() {
+				l.Warnf("%s throttled %d times: %v", what, throttled, err)
+				return merrors.Wrap(merrors.ErrNeighborUnreachable, err)
+			}
+			if e.RetryAfter > wait {
+				wait = e.RetryAfter
+			}
+		} else if n >= f.maxDataRequestAttempts() {
+			l.Warnf("%s failed after %d attempts: %v", what, n, err)
+			if err == frameworkhttp.ErrServeFailed {
+				return err
+			}
+			return merrors.Wrap(merrors.ErrNeighborUnreachable, err)
+		}
+		l.Warnf("%s failed: %v. Retry in %v", what, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-f.httpStop:
+			return frameworkhttp.ErrServerClosed
+		}
+		backoff *= 2
+		if backoff > maxDataRequestBackoff {
+			backoff = maxDataRequestBackoff
+		}
+	}
+}
+
+func (f *framework) trySendRequest(ctx context.Context, dr *dataRequest, stream bool, partial []byte) (*frameworkhttp.DataResponse, error) {
+	addr, err := f.addrCache.get(dr.taskID)
+	if err != nil {
+		return nil, err
+	}
+	var d *frameworkhttp.DataResponse
+	rt, resumable := f.transport.(ResumeTransport)
+	resumable = resumable && f.peerTalks(dr.taskID, frameworkhttp.ProtocolV2)
+	if st, ok := f.transport.(StreamTransport); ok && stream {
+		d, err = st.SendStream(ctx, addr, dr.req, f.taskID, dr.taskID, dr.epoch)
+	} else if resumable && len(partial) > 0 {
+		d, err = rt.Resume(ctx, addr, dr.req, f.taskID, dr.taskID, dr.epoch, partial)
+	} else {
+		d, err = f.transport.Send(ctx, addr, dr.req, f.taskID, dr.taskID, dr.epoch)
+	}
+	if addressStale(ctx, err) {
+		f.addrCache.invalidate(dr.taskID, addr)
+	}
+	return d, err
+}
+
+// addressStale tells whether the failure could be caused by the task having
+// moved to another node.
+func addressStale(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	switch err.(type) {
+	case *frameworkhttp.TooManyRequestsError:
+		return false
+	}
+	return err != frameworkhttp.ErrReqEpochMismatch && err != frameworkhttp.ErrStaleGeneration
+}
+
+func (f *framework) maxDataRequestAttempts() int {
+	if f.maxDataReqAttempts <= 0 {
+		return defaultMaxDataRequestAttempts
+	}
+	return f.maxDataReqAttempts
 }
 
 func (f *framework) GetTaskData(taskID, epoch uint64, req string) ([]byte, error) {
-	dataChan := make(chan []byte, 1)
-	f.dataReqChan <- &dataRequest{
+	r, err := f.GetTaskDataStream(taskID, epoch, req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// GetTaskDataStream is like GetTaskData, but hands the data back as a stream
+// so that large data served by a meritop.DataStreamer doesn't need to be held
+// in memory.
+func (f *framework) GetTaskDataStream(taskID, epoch uint64, req string) (io.ReadCloser, error) {
+	// Requests beyond the limit wait here before reaching the task. The slot
+	// is held until the data has been copied to requester, and the stream
+	// closed.
+	if !f.serveLimiter.acquire(f.httpStop) {
+		return nil, frameworkhttp.ErrServerClosed
+	}
+	r, err := f.getTaskData(taskID, epoch, req)
+	if err != nil {
+		f.serveLimiter.release()
+		return nil, err
+	}
+	return &releasingReader{ReadCloser: r, release: f.serveLimiter.release}, nil
+}
+
+func (f *framework) getTaskData(taskID, epoch uint64, req string) (io.ReadCloser, error) {
+	dataChan := make(chan io.ReadCloser, 1)
+	errChan := make(chan error, 1)
+	select {
+	case f.dataReqChan <- &dataRequest{
 		taskID:   taskID,
 		epoch:    epoch,
 		req:      req,
 		dataChan: dataChan,
+		errChan:  errChan,
+	}:
+	case <-f.httpStop:
+		return nil, frameworkhttp.ErrServerClosed
 	}
 
 	select {
@@ -44,56 +315,85 @@ func (f *framework) GetTaskData(taskID, epoch uint64, req string) ([]byte, error
 			return nil, frameworkhttp.ErrReqEpochMismatch
 		}
 		return d, nil
+	case err := <-errChan:
+		return nil, err
 	case <-f.httpStop:
 		// If a node stopped running and there is remaining requests, we need to
 		// respond error message back. It is used to let client routines stop blocking --
-		// especially helpful in test cases.
-
-		// This is used to drain the channel queue and get the rest notified.
-		<-f.dataReqChan
+		// especially helpful in test cases. Requests left in event loop are
+		// never handled.
 		return nil, frameworkhttp.ErrServerClosed
 	}
 }
 
-// Framework http server for data request.
-// Each request will be in the format: "/datareq?taskID=XXX&req=XXX".
+// releasingReader releases the slot of a request served once closed.
+type releasingReader struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releasingReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// Framework server for data request. It serves via the configured transport,
+// which is HTTP by default.
+// For HTTP, each request will be in the format: "/datareq?taskID=XXX&req=XXX".
 // "taskID" indicates the requesting task. "req" is the meta data for this request.
 // On success, it should respond with requested data in http body.
 func (f *framework) startHTTP() {
-	f.log.Printf("task %d serving http on %s\n", f.taskID, f.ln.Addr())
-	// TODO: http server graceful shutdown
-	handler := frameworkhttp.NewDataRequestHandler(f.log, f)
-	err := http.Serve(f.ln, handler)
+	defer close(f.httpDone)
+	f.log.Infof("task %d serving data requests on %s\n", f.taskID, f.ln.Addr())
+	err := f.transport.Serve(f.ln, f)
 	select {
 	case <-f.httpStop:
-		f.log.Printf("task %d http stops serving", f.taskID)
+		f.log.Infof("task %d http stops serving", f.taskID)
 	default:
 		if err != nil {
-			f.log.Fatalf("task %d http.Serve() returns error: %v\n", f.taskID, err)
+			f.reportError(meritop.SeverityFatal, "serving data requests", err)
 		}
 	}
 }
 
 // Close listener, stop HTTP server;
-// Write error message back to under-serving responses.
+// Write error message back to under-serving responses, and wait for the
+// server to finish responding.
 func (f *framework) stopHTTP() {
 	close(f.httpStop)
 	f.ln.Close()
+	<-f.httpDone
 }
 
 func (f *framework) sendResponse(dr *dataResponse) {
 	dr.dataChan <- dr.data
 }
 
-func (f *framework) handleDataReq(dr *dataRequest) {
-	var data []byte
+func (f *framework) handleDataReq(ctx context.Context, dr *dataRequest) {
+	defer f.recoverTask()
+	var data io.ReadCloser
+	var err error
 	switch {
-	case topoutil.IsParent(f.topology, dr.epoch, dr.taskID):
-		data = f.task.ServeAsChild(dr.taskID, dr.req)
-	case topoutil.IsChild(f.topology, dr.epoch, dr.taskID):
-		data = f.task.ServeAsParent(dr.taskID, dr.req)
+	case f.isParent(dr.epoch, dr.taskID):
+		data, err = f.serveAsChild(ctx, dr.taskID, dr.req)
+	case f.isChild(dr.epoch, dr.taskID):
+		if b, ok := f.broadcastData(dr.epoch, dr.req); ok {
+			data = ioutil.NopCloser(bytes.NewReader(b))
+			break
+		}
+		data, err = f.serveAsParent(ctx, dr.taskID, dr.req)
 	default:
-		f.log.Panic("unexpected")
+		var ok bool
+		if data, ok = f.serveAsNeighbor(dr.epoch, dr.taskID, dr.req); !ok {
+			panic("unexpected")
+		}
+	}
+	if err != nil {
+		f.log.with("req", dr.req).with("from", dr.taskID).Warnf("serving data request failed: %v", err)
+		dr.errChan <- err
+		return
 	}
 	// Getting the data from task could take a long time. We need to let
 	// the response-to-send go through event loop to check epoch.
@@ -106,13 +406,114 @@ func (f *framework) handleDataReq(dr *dataRequest) {
 	}
 }
 
+// serveAsChild gets data for a request from parent. Task implementing
+// meritop.DataStreamer serves it as a stream, and meritop.StreamingReducer
+// in segments. Values of meritop.TypedTask are marshaled with the codec. Only
+// a meritop.TaskV2 can fail, or a TypedTask if its value can't be marshaled.
+func (f *framework) serveAsChild(ctx context.Context, fromID uint64, req string) (io.ReadCloser, error) {
+	if s, ok := f.task.(meritop.StreamingReducer); ok {
+		return newChunkReader(s.ServeAsChildChunks(fromID, req)), nil
+	}
+	if s, ok := f.task.(meritop.DataStreamer); ok {
+		return s.ServeAsChildStream(fromID, req), nil
+	}
+	if t, ok := f.task.(meritop.TypedTask); ok {
+		return readCloser(f.marshalTyped(req, t.ServeAsChildTyped(fromID, req)))
+	}
+	if t, ok := f.task.(meritop.TaskV2); ok {
+		return readCloser(t.TryServeAsChild(fromID, req))
+	}
+	if t, ok := f.task.(meritop.ContextTask); ok {
+		return ioutil.NopCloser(bytes.NewReader(t.ServeAsChildContext(ctx, fromID, req))), nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.task.ServeAsChild(fromID, req))), nil
+}
+
+func (f *framework) serveAsParent(ctx context.Context, fromID uint64, req string) (io.ReadCloser, error) {
+	if s, ok := f.task.(meritop.DataStreamer); ok {
+		return s.ServeAsParentStream(fromID, req), nil
+	}
+	if t, ok := f.task.(meritop.TypedTask); ok {
+		return readCloser(f.marshalTyped(req, t.ServeAsParentTyped(fromID, req)))
+	}
+	if t, ok := f.task.(meritop.TaskV2); ok {
+		return readCloser(t.TryServeAsParent(fromID, req))
+	}
+	if t, ok := f.task.(meritop.ContextTask); ok {
+		return ioutil.NopCloser(bytes.NewReader(t.ServeAsParentContext(ctx, fromID, req))), nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.task.ServeAsParent(fromID, req))), nil
+}
+
+func readCloser(data []byte, err error) (io.ReadCloser, error) {
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// handleReceived handles a response passed to event loop, and releases the
+// slot taken to pass it.
+func (f *framework) handleReceived(ctx meritop.Context, resp *frameworkhttp.DataResponse) {
+	defer f.handleLimiter.release()
+	f.handleDataResp(ctx, resp)
+}
+
 func (f *framework) handleDataResp(ctx meritop.Context, resp *frameworkhttp.DataResponse) {
+	defer f.recoverTask()
+	if !f.isParent(resp.Epoch, resp.TaskID) &&
+		!f.isChild(resp.Epoch, resp.TaskID) &&
+		f.handleNeighborData(ctx, resp) {
+		return
+	}
+	if r, ok := f.task.(meritop.StreamingReducer); ok && f.isChunked(resp.Epoch, resp.TaskID) {
+		f.handleDataChunks(ctx, r, resp)
+		return
+	}
+	if r, ok := f.task.(meritop.DataStreamReceiver); ok {
+		f.handleDataStream(ctx, r, resp)
+		return
+	}
+	if t, ok := f.task.(meritop.TypedTask); ok {
+		f.handleTypedData(ctx, t, resp)
+		return
+	}
 	switch {
-	case topoutil.IsParent(f.topology, resp.Epoch, resp.TaskID):
+	case f.isParent(resp.Epoch, resp.TaskID):
 		f.task.ParentDataReady(ctx, resp.TaskID, resp.Req, resp.Data)
-	case topoutil.IsChild(f.topology, resp.Epoch, resp.TaskID):
+	case f.isChild(resp.Epoch, resp.TaskID):
 		f.task.ChildDataReady(ctx, resp.TaskID, resp.Req, resp.Data)
+		f.childResponded(resp.Epoch, resp.TaskID)
+	default:
+		panic("unexpected")
+	}
+}
+
+func (f *framework) handleStaleData(h meritop.StaleDataHandler, resp *frameworkhttp.DataResponse) {
+	defer f.handleLimiter.release()
+	defer f.recoverTask()
+	data, err := f.wholeData(resp)
+	if err != nil {
+		f.log.Warnf("task %d reading stale data from task %d failed: %v", f.taskID, resp.TaskID, err)
+		return
+	}
+	h.StaleDataReady(resp.Epoch, resp.TaskID, resp.Req, data)
+}
+
+func (f *framework) handleDataStream(ctx meritop.Context, r meritop.DataStreamReceiver, resp *frameworkhttp.DataResponse) {
+	body := resp.Body
+	if body == nil {
+		// The transport doesn't stream. Data has been received as a whole.
+		body = ioutil.NopCloser(bytes.NewReader(resp.Data))
+	}
+	defer body.Close()
+	switch {
+	case f.isParent(resp.Epoch, resp.TaskID):
+		r.ParentDataStream(ctx, resp.TaskID, resp.Req, body)
+	case f.isChild(resp.Epoch, resp.TaskID):
+		r.ChildDataStream(ctx, resp.TaskID, resp.Req, body)
+		f.childResponded(resp.Epoch, resp.TaskID)
 	default:
-		f.log.Panic("unexpected")
+		panic("unexpected")
 	}
 }
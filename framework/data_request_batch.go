@@ -0,0 +1,133 @@
+package framework
+
+import (
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"golang.org/x/net/context"
+)
+
+// Requests of a batch are sent by a bounded number of workers, so that
+// requesting data from many children won't open as many connections at once.
+const defaultDataRequestWorkers = 8
+
+func (f *framework) dataRequestAll(toIDs []uint64, req string, quorum int, epoch uint64) {
+	f.dataBatchToSendChan <- &dataBatch{
+		taskIDs: toIDs,
+		epoch:   epoch,
+		req:     req,
+		quorum:  quorum,
+	}
+}
+
+// sendBatch sends the requests of a batch concurrently and passes responses to
+// event loop together once quorum of them arrived. Requests still in flight
+// by then are canceled.
+func (f *framework) sendBatch(ctx context.Context, b *dataBatch) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ids := make(chan uint64, len(b.taskIDs))
+	for _, id := range f.sortByWeight(b.epoch, b.taskIDs) {
+		ids <- id
+	}
+	close(ids)
+	// Buffered so that workers can finish after quorum has been reached.
+	results := make(chan *batchResult, len(b.taskIDs))
+	workers := f.dataRequestWorkers()
+	if workers > len(b.taskIDs) {
+		workers = len(b.taskIDs)
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for id := range ids {
+				dr := &dataRequest{taskID: id, epoch: b.epoch, req: b.req}
+				if !f.sendLimiter.acquire(f.httpStop) {
+					results <- &batchResult{dr: dr, err: frameworkhttp.ErrServerClosed}
+					continue
+				}
+				// Responses are delivered together, so they are always read
+				// as a whole.
+				d, err := f.fetchData(ctx, dr, false)
+				f.sendLimiter.release()
+				results <- &batchResult{dr: dr, resp: d, err: err}
+			}
+		}()
+	}
+
+	quorum := b.quorum
+	if quorum <= 0 || quorum > len(b.taskIDs) {
+		quorum = len(b.taskIDs)
+	}
+	resps := make([]*frameworkhttp.DataResponse, 0, quorum)
+	var failed []*batchResult
+	for i := 0; i < len(b.taskIDs) && len(resps) < quorum; i++ {
+		r := <-results
+		if r.err != nil {
+			failed = append(failed, r)
+			continue
+		}
+		resps = append(resps, r.resp)
+	}
+	if len(resps) < quorum {
+		if ctx.Err() == nil {
+			f.log.Warnf("task %d data request (%s) to %d tasks failed: %d responses, quorum %d",
+				f.taskID, b.req, len(b.taskIDs), len(resps), quorum)
+		}
+		for _, r := range failed {
+			f.reportFailure(ctx, r.dr, r.err)
+		}
+		return
+	}
+	f.dataBatchRespChan <- &dataBatchResponse{
+		epoch:  b.epoch,
+		req:    b.req,
+		resps:  resps,
+		gather: b.gather,
+	}
+}
+
+type batchResult struct {
+	dr   *dataRequest
+	resp *frameworkhttp.DataResponse
+	err  error
+}
+
+func (f *framework) dataRequestWorkers() int {
+	if f.dataReqWorkers <= 0 {
+		return defaultDataRequestWorkers
+	}
+	return f.dataReqWorkers
+}
+
+// handleBatchResp delivers responses of a batch in one callback to tasks
+// implementing meritop.BatchDataReceiver. Others get them one by one as
+// usual.
+func (f *framework) handleBatchResp(ctx meritop.Context, b *dataBatchResponse) {
+	defer f.recoverTask()
+	if b.gather != nil {
+		f.handleGather(ctx, b)
+		return
+	}
+	r, ok := f.task.(meritop.BatchDataReceiver)
+	if !ok {
+		for _, resp := range b.resps {
+			f.handleDataResp(ctx, resp)
+		}
+		return
+	}
+	resps := make(map[uint64][]byte, len(b.resps))
+	for _, resp := range b.resps {
+		data, err := f.wholeData(resp)
+		if err != nil {
+			f.log.Warnf("task %d reading data from task %d failed: %v", f.taskID, resp.TaskID, err)
+			continue
+		}
+		resps[resp.TaskID] = data
+	}
+	r.DataAllReady(ctx, b.req, resps)
+	for id := range resps {
+		if f.isChild(b.epoch, id) {
+			f.childResponded(b.epoch, id)
+		}
+	}
+}
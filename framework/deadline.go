@@ -0,0 +1,44 @@
+package framework
+
+import (
+	"time"
+
+	"github.com/go-distributed/meritop"
+)
+
+// startEpochDeadline arms the deadline of the epoch started, if the task is
+// the one to handle stalls.
+func (f *framework) startEpochDeadline() {
+	if f.epochDeadline <= 0 || f.taskID != f.deadlineTaskID {
+		return
+	}
+	if _, ok := f.task.(meritop.EpochStallHandler); !ok {
+		return
+	}
+	epoch := f.epoch
+	f.deadlineTimer = time.AfterFunc(f.epochDeadline, func() {
+		select {
+		case f.stallChan <- epoch:
+		case <-f.stopChan:
+		}
+	})
+}
+
+func (f *framework) stopEpochDeadline() {
+	if f.deadlineTimer != nil {
+		f.deadlineTimer.Stop()
+		f.deadlineTimer = nil
+	}
+}
+
+// handleEpochStalled tells the task the epoch hasn't advanced in time, and
+// rearms the deadline in case it still doesn't.
+func (f *framework) handleEpochStalled(epoch uint64) {
+	f.log.Warnf("task %d: epoch %d has stalled for %v", f.taskID, epoch, f.epochDeadline)
+	ctx := f.createContext()
+	go func() {
+		defer f.recoverTask()
+		f.task.(meritop.EpochStallHandler).EpochStalled(ctx, epoch)
+	}()
+	f.startEpochDeadline()
+}
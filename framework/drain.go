@@ -0,0 +1,117 @@
+package framework
+
+import (
+	"errors"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+)
+
+// Drain checkpoints the task and stops the framework. The task is freed once
+// resources have been released, see releaseTask.
+func (f *framework) Drain() {
+	f.log.Infof("task %d draining at epoch %d", f.taskID, f.epoch)
+	f.saveCheckpoint()
+	f.drained = true
+	f.stop()
+}
+
+// releaseTask frees the task drained for standbys to take over.
+func (f *framework) releaseTask() {
+	err := f.coord.ReleaseTask(f.name, f.taskID, frameworkhttp.ListenerAddr(f.ln))
+	if err != nil {
+		f.log.Warnf("releasing task %d failed: %v", f.taskID, err)
+		return
+	}
+	f.log.Infof("task %d released", f.taskID)
+}
+
+// Exit stops the task alone, leaving the rest of the job running. It's
+// reported exited first, so that failure detectors don't free it. The task
+// is unregistered once resources have been released, see unregisterTask.
+func (f *framework) Exit() {
+	f.log.Infof("task %d exiting at epoch %d", f.taskID, f.epoch)
+	f.markExited()
+	f.stop()
+}
+
+// markExited reports the task exited, so that it's unregistered instead of
+// freed once it stops.
+func (f *framework) markExited() {
+	if err := f.coord.MarkTaskExited(f.name, f.taskID); err != nil {
+		f.reportError(meritop.SeverityRecoverable, "reporting exit of task", err)
+	}
+	f.exited = true
+}
+
+// unregisterTask gives up the task exited without freeing it.
+func (f *framework) unregisterTask() {
+	err := f.coord.ExitTask(f.name, f.taskID, frameworkhttp.ListenerAddr(f.ln))
+	if err != nil {
+		f.log.Warnf("unregistering task %d failed: %v", f.taskID, err)
+		return
+	}
+	f.log.Infof("task %d exited", f.taskID)
+}
+
+// errStopped is returned by occupyTask if the node was stopped while standing
+// by.
+var errStopped = errors.New("framework: stopped")
+
+// Stop stops the framework on this node, whether it's running a task, a
+// backup of one, or standing by, and waits for Start to return. The task is
+// then freed as if drained, but without a checkpoint.
+func (f *framework) Stop() {
+	f.quitOnce.Do(func() { close(f.quitChan()) })
+	f.quitMu.Lock()
+	done := f.done
+	f.quitMu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func (f *framework) quitChan() chan bool {
+	f.quitMu.Lock()
+	defer f.quitMu.Unlock()
+	if f.quit == nil {
+		f.quit = make(chan bool)
+	}
+	return f.quit
+}
+
+// started returns the channel to be closed once Start returns.
+func (f *framework) started() chan struct{} {
+	f.quitMu.Lock()
+	defer f.quitMu.Unlock()
+	f.done = make(chan struct{})
+	return f.done
+}
+
+func (f *framework) stopped() bool {
+	select {
+	case <-f.quitChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// stopOnQuit stops the task running once Stop is called, until stopChan is
+// closed.
+func (f *framework) stopOnQuit(stopChan chan struct{}) {
+	select {
+	case <-f.quitChan():
+		f.stop()
+	case <-stopChan:
+	}
+}
+
+// releaseStopped frees the task of the node stopped, unless it was standing
+// by, or the job has finished.
+func (f *framework) releaseStopped() {
+	if f.task == nil || f.jobFinished() {
+		return
+	}
+	f.releaseTask()
+}
@@ -0,0 +1,120 @@
+package framework
+
+import (
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/topoutil"
+)
+
+// updateNumOfTasks picks up the number of tasks changed, so that the
+// job can grow or shrink while it runs. The change takes effect from the epoch
+// started: topology is updated before watches of the epoch are set up.
+// It returns false if this task isn't part of the job anymore.
+func (f *framework) updateNumOfTasks() bool {
+	n, ok, err := f.coord.NumOfTasks(f.name)
+	if err != nil {
+		f.log.Warnf("task %d getting number of tasks failed: %v", f.taskID, err)
+		return true
+	}
+	if !ok || n == f.numOfTasks {
+		return true
+	}
+	if f.numOfTasks != 0 {
+		f.log.Infof("task %d: number of tasks changed from %d to %d at epoch %d",
+			f.taskID, f.numOfTasks, n, f.epoch)
+	}
+	f.topoMu.Lock()
+	defer f.topoMu.Unlock()
+	f.numOfTasks = n
+	if f.taskID >= n {
+		return false
+	}
+	f.topology.SetNumberOfTasks(n)
+	f.topology.SetTaskID(f.taskID)
+	return true
+}
+
+// numTasks returns the number of tasks of the job. It, and the topology read
+// by helpers below, are changed by the event loop as the job is resized,
+// while data requests are being served and tasks run.
+func (f *framework) numTasks() uint64 {
+	f.topoMu.RLock()
+	defer f.topoMu.RUnlock()
+	return f.numOfTasks
+}
+
+func (f *framework) parents(epoch uint64) []uint64 {
+	f.topoMu.RLock()
+	defer f.topoMu.RUnlock()
+	return append([]uint64(nil), f.topology.GetParents(epoch)...)
+}
+
+func (f *framework) children(epoch uint64) []uint64 {
+	f.topoMu.RLock()
+	defer f.topoMu.RUnlock()
+	return append([]uint64(nil), f.topology.GetChildren(epoch)...)
+}
+
+func (f *framework) isParent(epoch, taskID uint64) bool {
+	f.topoMu.RLock()
+	defer f.topoMu.RUnlock()
+	return topoutil.IsParent(f.topology, epoch, taskID)
+}
+
+func (f *framework) isChild(epoch, taskID uint64) bool {
+	f.topoMu.RLock()
+	defer f.topoMu.RUnlock()
+	return topoutil.IsChild(f.topology, epoch, taskID)
+}
+
+func (f *framework) linkTypeOf(epoch, taskID uint64) (string, bool) {
+	f.topoMu.RLock()
+	defer f.topoMu.RUnlock()
+	return topoutil.LinkTypeOf(f.topology, epoch, taskID)
+}
+
+func (f *framework) sortByWeight(epoch uint64, ids []uint64) []uint64 {
+	f.topoMu.RLock()
+	defer f.topoMu.RUnlock()
+	return topoutil.SortByWeight(f.topology, epoch, ids)
+}
+
+// lockedTopology is the topology handed to tasks. Tasks read it from handlers
+// and data callbacks run along with the event loop resizing it, so it's only
+// got at under topoMu, as helpers above do.
+type lockedTopology struct {
+	f *framework
+}
+
+func (t lockedTopology) SetTaskID(taskID uint64) {
+	t.f.topoMu.Lock()
+	defer t.f.topoMu.Unlock()
+	t.f.topology.SetTaskID(taskID)
+}
+
+func (t lockedTopology) GetParents(epoch uint64) []uint64 { return t.f.parents(epoch) }
+
+func (t lockedTopology) GetChildren(epoch uint64) []uint64 { return t.f.children(epoch) }
+
+func (t lockedTopology) SetNumberOfTasks(numOfTasks uint64) {
+	t.f.topoMu.Lock()
+	defer t.f.topoMu.Unlock()
+	t.f.topology.SetNumberOfTasks(numOfTasks)
+}
+
+// handleResize tells tasks implementing meritop.ResizeHandler the number of
+// tasks changed.
+func (f *framework) handleResize(ctx meritop.Context) {
+	h, ok := f.task.(meritop.ResizeHandler)
+	if !ok {
+		return
+	}
+	defer f.recoverTask()
+	h.NumOfTasksChanged(ctx, f.numTasks())
+}
+
+// exitRemoved stops the task the job has been shrunk off. It exits like
+// Exit, so that it isn't taken for failed and freed.
+func (f *framework) exitRemoved() {
+	f.log.Infof("task %d has been removed from job at epoch %d", f.taskID, f.epoch)
+	f.markExited()
+}
@@ -0,0 +1,35 @@
+package framework
+
+import "github.com/go-distributed/meritop"
+
+// epochGateOpen tells whether to move on to next epoch now. Tasks implementing
+// meritop.EpochGate which aren't ready defer it until WaitReady returns, and
+// the latest epoch changed to meanwhile is switched to then.
+func (f *framework) epochGateOpen(next uint64) bool {
+	if next == exitEpoch {
+		return true
+	}
+	if f.gating {
+		f.gatedEpoch = next
+		return false
+	}
+	g, ok := f.task.(meritop.EpochGate)
+	if !ok || g.ReadyForEpoch(next) {
+		return true
+	}
+	f.log.Infof("task %d not ready for epoch %d yet", f.taskID, next)
+	f.gating = true
+	f.gatedEpoch = next
+	go func() {
+		defer f.recoverTask()
+		g.WaitReady()
+		f.gateChan <- struct{}{}
+	}()
+	return false
+}
+
+// gateReleased is called once the task is ready for the gated epoch.
+func (f *framework) gateReleased() uint64 {
+	f.gating = false
+	return f.gatedEpoch
+}
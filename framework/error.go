@@ -0,0 +1,38 @@
+package framework
+
+import (
+	"github.com/go-distributed/meritop"
+	merrors "github.com/go-distributed/meritop/errors"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// reportError tells the task about an error framework ran into while doing
+// op, if the task implements meritop.FrameworkErrorHandler. The task is
+// stopped after a fatal one. Errors of etcd being unreachable are told apart
+// with meritop/errors.
+func (f *framework) reportError(severity meritop.Severity, op string, err error) {
+	fe := &meritop.FrameworkError{Severity: severity, Op: op, Err: etcdutil.WrapError(err)}
+	if severity == meritop.SeverityFatal {
+		f.log.Errorf("task %d: %v", f.taskID, fe)
+	} else {
+		f.log.Warnf("task %d: %v", f.taskID, fe)
+	}
+	if h, ok := f.task.(meritop.FrameworkErrorHandler); ok {
+		h.OnFrameworkError(fe)
+	}
+	if severity == meritop.SeverityFatal {
+		f.stop()
+	}
+}
+
+// errEpochChanged is returned by blocking calls of the task, e.g. AllReduce,
+// which are canceled as the epoch changes.
+var errEpochChanged = merrors.New(merrors.ErrEpochConflict, "framework: epoch changed meanwhile")
+
+// canceledErr tells why a blocking call of the task has been canceled.
+func (f *framework) canceledErr() error {
+	if err := f.runCtx.Err(); err != nil {
+		return err
+	}
+	return errEpochChanged
+}
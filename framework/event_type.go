@@ -1,17 +1,87 @@
 package framework
 
+import (
+	"io"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"golang.org/x/net/context"
+)
+
 type metaChange struct {
 	from  uint64
 	who   taskRole
 	epoch uint64
-	meta  string
+	meta  *meritop.Meta
+	// linkType is set for metas from neighbors.
+	linkType string
+}
+
+// healthChange is a parent or child of the task failing or being taken over.
+type healthChange struct {
+	taskID  uint64
+	healthy bool
+}
+
+// dataBatch is a data request sent to several tasks by DataRequestAll.
+type dataBatch struct {
+	taskIDs []uint64
+	epoch   uint64
+	req     string
+	quorum  int
+	// gather, if set, reduces responses instead of passing them to task.
+	gather *gather
+}
+
+type dataBatchResponse struct {
+	epoch  uint64
+	req    string
+	resps  []*frameworkhttp.DataResponse
+	gather *gather
+}
+
+// dataPush is data pushed to, or by, the task. errChan tells the pushing task
+// whether it's accepted.
+type dataPush struct {
+	taskID  uint64
+	epoch   uint64
+	req     string
+	data    []byte
+	errChan chan error
+}
+
+// dataRequestFailure is a data request framework has given up on.
+type dataRequestFailure struct {
+	taskID uint64
+	epoch  uint64
+	req    string
+	err    error
 }
 
 type dataRequest struct {
+	// ctx is set by requests the task can cancel itself.
+	ctx    context.Context
+	taskID uint64
+	epoch  uint64
+	req    string
+	// reqs is set instead of req by multi-key requests.
+	reqs     []string
+	dataChan chan io.ReadCloser
+	// errChan gets the error of the task failing to serve the request.
+	errChan chan error
+	// callback, if set, gets the response instead of the task.
+	callback func(data []byte, err error)
+}
+
+// dataCallback is the response to a request with a callback, or the error it
+// failed with.
+type dataCallback struct {
 	taskID   uint64
 	epoch    uint64
 	req      string
-	dataChan chan []byte
+	data     []byte
+	err      error
+	callback func(data []byte, err error)
 }
 
 func (dr *dataRequest) notifyEpochMismatch() {
@@ -22,10 +92,11 @@ type dataResponse struct {
 	taskID   uint64
 	epoch    uint64
 	req      string
-	data     []byte
-	dataChan chan []byte
+	data     io.ReadCloser
+	dataChan chan io.ReadCloser
 }
 
 func (dr *dataResponse) notifyEpochMismatch() {
+	dr.data.Close()
 	close(dr.dataChan)
 }
@@ -0,0 +1,14 @@
+package framework
+
+import (
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// recordEvent appends the event caused by the task to the audit log of the
+// job. Failing to is only logged, since what happened has happened.
+func (f *framework) recordEvent(e etcdutil.Event) {
+	e.Actor = etcdutil.TaskActor(f.taskID)
+	if err := f.coord.RecordEvent(f.name, e); err != nil {
+		f.log.Warnf("recording %s event failed: %v", e.Kind, err)
+	}
+}
@@ -1,24 +1,30 @@
 package framework
 
 import (
+	"crypto/tls"
 	"fmt"
-	"log"
-	"math"
 	"net"
+	"sync"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
 	"github.com/go-distributed/meritop"
 	"github.com/go-distributed/meritop/framework/frameworkhttp"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
+	"github.com/go-distributed/meritop/pkg/wal"
+	"golang.org/x/net/context"
 )
 
-const exitEpoch = math.MaxUint64
+const exitEpoch = etcdutil.ExitEpoch
 
 type framework struct {
 	// These should be passed by outside world
+	// name is the job kept under the etcd root, see etcdutil.JobName.
 	name     string
+	jobName  string
 	etcdURLs []string
-	log      *log.Logger
+	logger   meritop.Logger
+	// log annotates messages with the task and epoch, and logs to logger.
+	log *taskLogger
 
 	// user defined interfaces
 	taskBuilder meritop.TaskBuilder
@@ -27,52 +33,263 @@ type framework struct {
 	task       meritop.Task
 	taskID     uint64
 	epoch      uint64
-	etcdClient *etcd.Client
+	etcdClient etcdutil.Client
+	// etcdInfo is what the etcd client of the framework connects with.
+	etcdInfo etcdutil.ClientInfo
+	// coord is what the task coordinates with the rest of the job through.
+	// It's on etcdClient unless given, see WithCoordinator.
+	coord      etcdutil.Coordinator
+	addrCache  *addressCache
 	ln         net.Listener
+	transport  Transport
+	tlsConfig  *tls.Config
+	rateLimit  *frameworkhttp.RateLimit
+	authToken  string
+	codec      meritop.Codec
+	stateStore meritop.StateStore
+	// config of the job is loaded from coord, and has to have requiredConfig.
+	// It's replaced by event loop as it changes.
+	configMu       sync.Mutex
+	config         meritop.Config
+	requiredConfig []string
+	// hooks are called around epochs of the task.
+	hooks []Hooks
+	// wal, if walDir is set, logs updates to the state on the local node.
+	walDir string
+	wal    *wal.WAL
+	// numOfTasks is the number of tasks of the job, as last found.
+	// It and topology are changed under topoMu, see numTasks.
+	numOfTasks uint64
+	topoMu     sync.RWMutex
+	// resized is set once numOfTasks changed at an epoch switch, until the
+	// task has been told at the start of the epoch.
+	resized bool
+	// generation is bumped every time a node takes the task. Peers reject
+	// data requests and metas of nodes which have lost the task.
+	generation etcdutil.Generation
+
+	maxDataReqAttempts int
+	dataReqWorkers     int
+	maxInFlightReqs    int
+	// heartbeatEvery is the heartbeat interval, or the default if not positive.
+	heartbeatEvery time.Duration
+	// Standbys back off from tasks failing again and again, and poison them
+	// once they failed more than maxRestarts times.
+	restartBackoff    time.Duration
+	maxRestartBackoff time.Duration
+	maxRestarts       int
+	// maxBackups is the number of backup copies kept for each task.
+	maxBackups int
+	// ChildrenReady is called once childrenFraction of children have had
+	// their data delivered, or childrenTimeout has elapsed in an epoch.
+	childrenFraction float64
+	childrenTimeout  time.Duration
+	readyMu          sync.Mutex
+	ready            *childrenReady
+	// The epoch is taken to have stalled once it stays for epochDeadline, and
+	// task deadlineTaskID is told.
+	epochDeadline  time.Duration
+	deadlineTaskID uint64
+	deadlineTimer  *time.Timer
+	// The controller asked for a checkpoint at the start of checkpointAt.
+	checkpointPending bool
+	checkpointAt      uint64
+	// With barrier, epoch advances once barrierQuorum tasks are done with it.
+	barrier       bool
+	barrierQuorum int
+	// If the controller paces epochs, only it advances the epoch, and tasks
+	// just check in at the barrier.
+	controllerPaced bool
+	// leaseTTL is the lease of the job the task keeps while heartbeating, 0
+	// if the job has none.
+	leaseTTL time.Duration
+	// flagged keeps metas flagged to each path in the latest epoch.
+	metaMu  sync.Mutex
+	flagged map[string][]*meritop.Meta
+	// metaQueue hands metas received to task in order.
+	metaQueue serialQueue
+	// broadcasts keeps data broadcast to children in broadcastEpoch.
+	broadcastMu    sync.Mutex
+	broadcastEpoch uint64
+	broadcasts     map[string][]byte
+	// allReduce keeps data pushed for AllReduce calls.
+	allReduce allReduceBox
+	// Switching to gatedEpoch is deferred while gating, until EpochGate of the
+	// task tells on gateChan that it's ready.
+	gating     bool
+	gatedEpoch uint64
+	// state is where framework is in its lifecycle, told to stateSubs as it
+	// changes.
+	stateMu   sync.Mutex
+	state     meritop.FrameworkState
+	stateSubs []chan meritop.FrameworkState
+	// drained is set once the task has been asked to hand itself over.
+	drained bool
+	// exited is set once the task has exited by itself.
+	exited bool
+	// quit is closed by Stop, and done once Start has returned.
+	quitMu   sync.Mutex
+	quit     chan bool
+	quitOnce sync.Once
+	done     chan struct{}
+	// Supervised, the node recovers from panics of the task, and takes a task
+	// again. taskPanic is the first panic of the task running.
+	supervise bool
+	panicMu   sync.Mutex
+	taskPanic interface{}
+	// lastUpdateIDs keeps IDs of the last update logs shipped for tasks.
+	updateMu      sync.Mutex
+	lastUpdateIDs map[uint64]uint64
+
+	// sendLimiter bounds data requests being sent, serveLimiter the ones
+	// being served by the task, and handleLimiter responses being handled.
+	sendLimiter   limiter
+	serveLimiter  limiter
+	handleLimiter limiter
+
+	// runCtx is canceled once framework stops, and epochCtx also once the
+	// epoch changes.
+	runCtx      context.Context
+	cancelRun   context.CancelFunc
+	epochCtx    context.Context
+	cancelEpoch context.CancelFunc
+	// epochStarted is when the task started the epoch.
+	epochStarted time.Time
+
+	// in-flight data requests are sent with reqCtx, and canceled together.
+	reqMu      sync.Mutex
+	reqCtx     context.Context
+	cancelReqs context.CancelFunc
 
 	// etcd stops
 	metaStops []chan bool
 	epochStop chan bool
 
-	httpStop      chan struct{}
-	heartbeatStop chan struct{}
+	httpStop       chan struct{}
+	httpDone       chan struct{}
+	heartbeatStop  chan bool
+	failureStop    chan bool
+	healthStop     chan bool
+	checkpointStop chan bool
+	configStop     chan bool
+	stopOnce       sync.Once
+	exitOnce       sync.Once
 
 	// event loop
+	stopChan           chan struct{}
 	epochChan          chan uint64
 	metaChan           chan *metaChange
 	dataReqtoSendChan  chan *dataRequest
 	dataReqChan        chan *dataRequest
 	dataRespToSendChan chan *dataResponse
 	dataRespChan       chan *frameworkhttp.DataResponse
+
+	dataBatchToSendChan chan *dataBatch
+	dataBatchRespChan   chan *dataBatchResponse
+	dataPushToSendChan  chan *dataPush
+	dataPushChan        chan *dataPush
+	dataReqFailChan     chan *dataRequestFailure
+	dataCallbackChan    chan *dataCallback
+	healthChan          chan *healthChange
+	checkpointChan      chan uint64
+	configChan          chan meritop.Config
+	stallChan           chan uint64
+	gateChan            chan struct{}
+}
+
+func (f *framework) flagMetaToParent(meta *meritop.Meta) {
+	f.flagMeta(etcdutil.ParentMetaPath(f.name, f.GetTaskID()), meta)
 }
 
-func (f *framework) flagMetaToParent(meta string, epoch uint64) {
-	value := fmt.Sprintf("%d-%s", epoch, meta)
-	_, err := f.etcdClient.Set(etcdutil.ParentMetaPath(f.name, f.GetTaskID()), value, 0)
+func (f *framework) flagMetaToChild(meta *meritop.Meta) {
+	f.flagMeta(etcdutil.ChildMetaPath(f.name, f.GetTaskID()), meta)
+}
+
+func (f *framework) flagMetaToNeighbors(linkType string, meta *meritop.Meta) {
+	f.flagMeta(etcdutil.LinkMetaPath(f.name, f.GetTaskID(), linkType), meta)
+}
+
+// flagMeta numbers the meta and keeps it at path along with others flagged
+// there in the epoch, so that watchers get each of them once and in order.
+func (f *framework) flagMeta(path string, meta *meritop.Meta) {
+	f.metaMu.Lock()
+	defer f.metaMu.Unlock()
+	if f.flagged == nil {
+		f.flagged = make(map[string][]*meritop.Meta)
+	}
+	flagged, ok := f.flagged[path]
+	if !ok {
+		// Carry on from metas flagged before, e.g. by the node which worked
+		// for the task.
+		flagged = f.loadFlagged(path)
+	}
+	var seq uint64
+	if len(flagged) > 0 {
+		seq = flagged[len(flagged)-1].Seq
+	}
+	// Watchers handle metas of their current epoch only.
+	var metas []*meritop.Meta
+	for _, m := range flagged {
+		if m.Epoch >= meta.Epoch {
+			metas = append(metas, m)
+		}
+	}
+	m := *meta
+	m.Seq = seq + 1
+	m.Generation = f.generation.Value
+	metas = append(metas, &m)
+	value, err := encodeMetas(metas)
+	if err != nil {
+		f.reportError(meritop.SeverityRecoverable, "encoding meta "+meta.Kind, err)
+		return
+	}
+	err = f.coord.SetMeta(path, value)
 	if err != nil {
-		f.log.Fatalf("etcdClient.Set failed; key: %s, value: %s, error: %v",
-			etcdutil.ParentMetaPath(f.name, f.GetTaskID()), value, err)
+		f.reportError(meritop.SeverityRecoverable, "flagging meta to "+path, err)
+		return
 	}
+	f.flagged[path] = metas
 }
 
-func (f *framework) flagMetaToChild(meta string, epoch uint64) {
-	value := fmt.Sprintf("%d-%s", epoch, meta)
-	_, err := f.etcdClient.Set(etcdutil.ChildMetaPath(f.name, f.GetTaskID()), value, 0)
+func (f *framework) loadFlagged(path string) []*meritop.Meta {
+	value, ok, err := f.coord.Meta(path)
+	if err != nil || !ok {
+		return nil
+	}
+	metas, err := decodeMetas(value)
 	if err != nil {
-		f.log.Fatalf("etcdClient.Set failed; key: %s, value: %s, error: %v",
-			etcdutil.ChildMetaPath(f.name, f.GetTaskID()), value, err)
+		return nil
 	}
+	return metas
+}
+
+// forgetFlagged drops metas flagged, which have been cleared from etcd when
+// the job rolled back.
+func (f *framework) forgetFlagged() {
+	f.metaMu.Lock()
+	defer f.metaMu.Unlock()
+	f.flagged = nil
 }
 
 // When app code invoke this method on framework, we simply
 // update the etcd epoch to next uint64. All nodes should watch
 // for epoch and update their local epoch correspondingly.
 func (f *framework) incEpoch(epoch uint64) {
-	err := etcdutil.CASEpoch(f.etcdClient, f.name, epoch, epoch+1)
+	if f.controllerPaced {
+		f.log.Debugf("IncEpoch in epoch %d ignored, the controller paces epochs", epoch)
+		return
+	}
+	if f.barrier {
+		f.incEpochAtBarrier(epoch)
+		return
+	}
+	err := f.coord.CASEpoch(f.name, epoch, epoch+1)
 	if err != nil {
-		f.log.Fatalf("task %d Epoch CompareAndSwap(%d, %d) failed: %v",
-			f.taskID, f.epoch+1, epoch, err)
+		f.reportError(meritop.SeverityRecoverable,
+			fmt.Sprintf("epoch CompareAndSwap(%d, %d)", epoch, epoch+1), err)
+		return
 	}
+	f.recordEvent(etcdutil.Event{Kind: etcdutil.EventEpoch, Epoch: epoch + 1, Detail: "advanced"})
 }
 
 func (f *framework) dataRequest(toID uint64, req string, epoch uint64) {
@@ -80,33 +297,105 @@ func (f *framework) dataRequest(toID uint64, req string, epoch uint64) {
 	// Event driven task will call this in a synchronous way so that
 	// the epoch won't change at the time task sending this request.
 	// Epoch may change, however, before the request is actually being sent.
+	// It blocks while too many requests are in flight. The slot is released
+	// once the request is done or dropped.
+	if !f.sendLimiter.acquire(f.httpStop) {
+		return
+	}
+	f.dataReqtoSendChan <- &dataRequest{
+		taskID: toID,
+		epoch:  epoch,
+		req:    req,
+	}
+}
+
+// dataRequestContext is like dataRequest, but the request is also canceled
+// once ctx is done.
+func (f *framework) dataRequestContext(ctx context.Context, toID uint64, req string, epoch uint64) {
+	if !f.sendLimiter.acquire(f.httpStop) {
+		return
+	}
 	f.dataReqtoSendChan <- &dataRequest{
+		ctx:    ctx,
 		taskID: toID,
 		epoch:  epoch,
 		req:    req,
 	}
 }
 
-func (f *framework) GetTopology() meritop.Topology { return f.topology }
+// dataRequestMulti requests data of several keys from the task at once.
+func (f *framework) dataRequestMulti(toID uint64, reqs []string, epoch uint64) {
+	if !f.sendLimiter.acquire(f.httpStop) {
+		return
+	}
+	f.dataReqtoSendChan <- &dataRequest{
+		taskID: toID,
+		epoch:  epoch,
+		reqs:   reqs,
+	}
+}
+
+// requestContext returns the context for sending data requests.
+func (f *framework) requestContext() context.Context {
+	f.reqMu.Lock()
+	defer f.reqMu.Unlock()
+	if f.reqCtx == nil {
+		f.reqCtx, f.cancelReqs = context.WithCancel(context.Background())
+	}
+	return f.reqCtx
+}
+
+func (f *framework) CancelAllRequests() {
+	f.reqMu.Lock()
+	defer f.reqMu.Unlock()
+	if f.cancelReqs != nil {
+		f.cancelReqs()
+	}
+	f.reqCtx, f.cancelReqs = context.WithCancel(context.Background())
+}
+
+func (f *framework) GetTopology() meritop.Topology { return lockedTopology{f} }
 
 // this will shutdown local node instead of global job.
+// Event loop stops, and resources are released once it's out.
 func (f *framework) stop() {
-	close(f.epochChan)
+	f.stopOnce.Do(func() {
+		close(f.stopChan)
+		f.cancelRun()
+	})
 }
 
 // When node call this on framework, it simply set epoch to exitEpoch,
 // All nodes will be notified of the epoch change and exit themselves.
 func (f *framework) ShutdownJob() {
-	if err := etcdutil.CASEpoch(f.etcdClient, f.name, f.epoch, exitEpoch); err != nil {
-		panic("TODO: we should do a set instead of CAS here.")
+	// TODO: we should do a set instead of CAS here.
+	if err := f.coord.CASEpoch(f.name, f.epoch, exitEpoch); err != nil {
+		f.reportError(meritop.SeverityRecoverable, "shutting down job", err)
+		return
 	}
-	if err := etcdutil.SetJobStatus(f.etcdClient, f.name, 0); err != nil {
-		panic("SetJobStatus")
+	if err := f.coord.MarkJobDone(f.name); err != nil {
+		f.reportError(meritop.SeverityRecoverable, "setting job status", err)
+		return
 	}
+	f.recordEvent(etcdutil.Event{Kind: etcdutil.EventShutdown, Epoch: f.epoch})
 }
 
-func (f *framework) GetLogger() *log.Logger { return f.log }
+func (f *framework) GetLogger() meritop.Logger { return f.log }
 
 func (f *framework) GetTaskID() uint64 { return f.taskID }
 
+func (f *framework) GetNumTasks() uint64 { return f.numTasks() }
+
+func (f *framework) GetJobName() string { return f.jobName }
+
+func (f *framework) GetCodec() meritop.Codec { return f.codec }
+
 func (f *framework) GetEpoch() uint64 { return f.epoch }
+
+func (f *framework) Context() context.Context { return f.runCtx }
+
+func (f *framework) Config() meritop.Config {
+	f.configMu.Lock()
+	defer f.configMu.Unlock()
+	return f.config
+}
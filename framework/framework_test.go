@@ -2,17 +2,30 @@ package framework
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/coreos/etcd/integration"
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/go-distributed/meritop"
 	"github.com/go-distributed/meritop/controller"
+	merrors "github.com/go-distributed/meritop/errors"
 	"github.com/go-distributed/meritop/example"
 	"github.com/go-distributed/meritop/framework/frameworkhttp"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
+	"golang.org/x/net/context"
 )
 
 // TestRequestDataEpochMismatch creates a scenario where data request happened
@@ -46,7 +59,7 @@ func TestRequestDataEpochMismatch(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetAddress failed: %v", err)
 	}
-	_, err = frameworkhttp.RequestData(addr, "req", 0, fw.GetTaskID(), 10, fw.GetLogger())
+	_, err = frameworkhttp.RequestData(addr, "req", 0, fw.GetTaskID(), 10, newStdLog(fw.GetLogger()))
 	// if err.Error() != "epoch mismatch" {
 	if err != frameworkhttp.ErrReqEpochMismatch {
 		t.Fatalf("error want = (epoch mismatch), but get = (%s)", err.Error())
@@ -61,18 +74,10 @@ func TestRequestDataEpochMismatch(t *testing.T) {
 // it's passed from framework correctly and unmodified.
 func TestFrameworkFlagMetaReady(t *testing.T) {
 	appName := "framework_test_flagmetaready"
-	// launch testing etcd server
-	m := etcdutil.MustNewMember(t, appName)
-	m.Launch()
-	defer m.Terminate(t)
-	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
-
-	// launch controller to setup etcd layout
-	ctl := controller.New(appName, etcd.NewClient([]string{url}), 2)
-	if err := ctl.InitEtcdLayout(); err != nil {
-		t.Fatalf("initEtcdLayout failed: %v", err)
-	}
-	defer ctl.DestroyEtcdLayout()
+	// launch testing etcd server, and controller to setup etcd layout
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+	url := job.url
 
 	pDataChan := make(chan *tDataBundle, 1)
 	cDataChan := make(chan *tDataBundle, 1)
@@ -122,7 +127,7 @@ func TestFrameworkFlagMetaReady(t *testing.T) {
 
 	for i, tt := range tests {
 		// 0: F#FlagChildMetaReady -> 1: T#ParentMetaReady
-		f0.flagMetaToChild(tt.cMeta, 0)
+		f0.flagMetaToChild(&meritop.Meta{Kind: tt.cMeta})
 		// from child(1)'s view
 		data := <-pDataChan
 		expected := &tDataBundle{0, tt.cMeta, "", nil}
@@ -131,7 +136,7 @@ func TestFrameworkFlagMetaReady(t *testing.T) {
 		}
 
 		// 1: F#FlagParentMetaReady -> 0: T#ChildMetaReady
-		f1.flagMetaToParent(tt.pMeta, 0)
+		f1.flagMetaToParent(&meritop.Meta{Kind: tt.pMeta})
 		// from parent(0)'s view
 		data = <-cDataChan
 		expected = &tDataBundle{1, tt.pMeta, "", nil}
@@ -143,18 +148,10 @@ func TestFrameworkFlagMetaReady(t *testing.T) {
 
 func TestFrameworkDataRequest(t *testing.T) {
 	appName := "framework_test_flagmetaready"
-	// launch testing etcd server
-	m := etcdutil.MustNewMember(t, appName)
-	m.Launch()
-	defer m.Terminate(t)
-	url := fmt.Sprintf("http://%s", m.ClientListeners[0].Addr().String())
-
-	// launch controller to setup etcd layout
-	ctl := controller.New(appName, etcd.NewClient([]string{url}), 2)
-	if err := ctl.InitEtcdLayout(); err != nil {
-		t.Fatalf("initEtcdLayout failed: %v", err)
-	}
-	defer ctl.DestroyEtcdLayout()
+	// launch testing etcd server, and controller to setup etcd layout
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+	url := job.url
 
 	tests := []struct {
 		req  string
@@ -252,16 +249,36 @@ type testableTaskBuilder struct {
 	cDataChan  chan *tDataBundle
 	pDataChan  chan *tDataBundle
 	setupLatch *sync.WaitGroup
+	batchChan  chan map[uint64][]byte
+	failChan   chan *tDataBundle
+	// topology creates the topology of tasks, instead of the tree default.
+	topology func() meritop.Topology
+	exitChan chan uint64
+	// panicOnce, if set, makes tasks panic the first time they get meta
+	// "panic".
+	panicOnce *sync.Once
+	// wrap, if set, makes tasks of what it returns for the testableTask, e.g.
+	// one implementing optional interfaces of meritop for the test.
+	wrap func(*testableTask) meritop.Task
 }
 
 func (b *testableTaskBuilder) GetTask(taskID uint64) meritop.Task {
+	if b.wrap != nil {
+		return b.wrap(b.getTask(taskID).(*testableTask))
+	}
+	return b.getTask(taskID)
+}
+
+func (b *testableTaskBuilder) getTask(taskID uint64) meritop.Task {
 	switch taskID {
 	case 0:
 		return &testableTask{dataMap: b.dataMap, dataChan: b.cDataChan,
-			setupLatch: b.setupLatch}
+			setupLatch: b.setupLatch, batchChan: b.batchChan, failChan: b.failChan,
+			exitChan: b.exitChan, panicOnce: b.panicOnce}
 	case 1:
 		return &testableTask{dataMap: b.dataMap, dataChan: b.pDataChan,
-			setupLatch: b.setupLatch}
+			setupLatch: b.setupLatch, batchChan: b.batchChan, failChan: b.failChan,
+			exitChan: b.exitChan, panicOnce: b.panicOnce}
 	default:
 		panic("unimplemented")
 	}
@@ -280,6 +297,13 @@ type testableTask struct {
 	// The basic idea is that there are only two nodes -- one parent and one child.
 	// When this channel is for parent, it passes information from child.
 	dataChan chan *tDataBundle
+	// batchChan conveys responses of batched data requests.
+	batchChan chan map[uint64][]byte
+	// failChan conveys data requests failed, with the error as resp.
+	failChan chan *tDataBundle
+	// exitChan conveys IDs of tasks exiting.
+	exitChan  chan uint64
+	panicOnce *sync.Once
 }
 
 func (t *testableTask) Init(taskID uint64, framework meritop.Framework) {
@@ -289,10 +313,17 @@ func (t *testableTask) Init(taskID uint64, framework meritop.Framework) {
 		t.setupLatch.Done()
 	}
 }
-func (t *testableTask) Exit()                                      {}
+func (t *testableTask) Exit() {
+	if t.exitChan != nil {
+		t.exitChan <- t.id
+	}
+}
 func (t *testableTask) SetEpoch(ctx meritop.Context, epoch uint64) {}
 
 func (t *testableTask) ParentMetaReady(ctx meritop.Context, fromID uint64, meta string) {
+	if meta == "panic" && t.panicOnce != nil {
+		t.panicOnce.Do(func() { panic("task panicked") })
+	}
 	if t.dataChan != nil {
 		t.dataChan <- &tDataBundle{fromID, meta, "", nil}
 	}
@@ -321,6 +352,22 @@ func (t *testableTask) ChildDataReady(ctx meritop.Context, fromID uint64, req st
 	t.ParentDataReady(ctx, fromID, req, resp)
 }
 
+func (t *testableTask) DataPushed(ctx meritop.Context, fromID uint64, req string, data []byte) {
+	t.ParentDataReady(ctx, fromID, req, data)
+}
+
+func (t *testableTask) DataRequestFailed(ctx meritop.Context, toID uint64, req string, err error) {
+	if t.failChan != nil {
+		t.failChan <- &tDataBundle{toID, "", req, []byte(err.Error())}
+	}
+}
+
+func (t *testableTask) DataAllReady(ctx meritop.Context, req string, resps map[uint64][]byte) {
+	if t.batchChan != nil {
+		t.batchChan <- resps
+	}
+}
+
 func createListener(t *testing.T) net.Listener {
 	l, err := net.Listen("tcp4", "127.0.0.1:0")
 	if err != nil {
@@ -328,3 +375,2874 @@ func createListener(t *testing.T) net.Listener {
 	}
 	return l
 }
+
+// TestFrameworkTransport checks that data requests go through the transport
+// plugged in by user instead of the default one.
+func TestFrameworkTransport(t *testing.T) {
+	appName := "framework_test_transport"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	pDataChan := make(chan *tDataBundle, 1)
+	cDataChan := make(chan *tDataBundle, 1)
+	tr := &countingTransport{Transport: frameworkhttp.NewTransport(nil)}
+	var wg sync.WaitGroup
+	taskBuilder := &testableTaskBuilder{
+		dataMap:    map[string][]byte{"req": []byte("resp")},
+		cDataChan:  cDataChan,
+		pDataChan:  pDataChan,
+		setupLatch: &wg,
+	}
+	fs := make([]*framework, 2)
+	for i := range fs {
+		fs[i] = NewBootStrap(appName, []string{job.url}, createListener(t), nil, WithTransport(tr)).(*framework)
+		fs[i].SetTaskBuilder(taskBuilder)
+		fs[i].SetTopology(example.NewTreeTopology(2, 2))
+	}
+	wg.Add(2)
+	go fs[0].Start()
+	go fs[1].Start()
+	wg.Wait()
+	f0 := fs[0]
+	if f0.GetTaskID() != 0 {
+		f0 = fs[1]
+	}
+	defer f0.ShutdownJob()
+
+	f0.dataRequest(1, "req", 0)
+	<-pDataChan // served by child
+	data := <-cDataChan
+	expected := &tDataBundle{1, "", "req", []byte("resp")}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, data)
+	}
+	if n := tr.sent(); n != 1 {
+		t.Errorf("number of sent requests want = 1, get = %d", n)
+	}
+}
+
+type countingTransport struct {
+	*frameworkhttp.Transport
+	sync.Mutex
+	count int
+}
+
+func (c *countingTransport) Send(ctx context.Context, addr, req string, from, to, epoch uint64) (*frameworkhttp.DataResponse, error) {
+	c.Lock()
+	c.count++
+	c.Unlock()
+	return c.Transport.Send(ctx, addr, req, from, to, epoch)
+}
+
+func (c *countingTransport) sent() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.count
+}
+
+// TestDataRequestRetry checks that a failed data request is retried, and the
+// address of serving task is looked up again.
+func TestDataRequestRetry(t *testing.T) {
+	appName := "framework_test_datarequestretry"
+	pDataChan := make(chan *tDataBundle, 1)
+	cDataChan := make(chan *tDataBundle, 1)
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"req": []byte("resp")},
+		cDataChan: cDataChan,
+		pDataChan: pDataChan,
+	}
+	f0, f1, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	// Make task 1 unreachable for a while.
+	masterPath := etcdutil.TaskMasterPath(appName, 1)
+	if _, err := f0.etcdClient.Set(masterPath, "127.0.0.1:1", 0); err != nil {
+		t.Fatalf("etcd Set failed: %v", err)
+	}
+	go func() {
+		time.Sleep(3 * dataRequestBackoff)
+		f0.etcdClient.Set(masterPath, f1.ln.Addr().String(), 0)
+	}()
+
+	f0.dataRequest(1, "req", 0)
+	<-pDataChan // served by child
+	data := <-cDataChan
+	expected := &tDataBundle{1, "", "req", []byte("resp")}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, data)
+	}
+}
+
+// TestDataRequestAll checks that responses of a batch are delivered together,
+// and a quorum of them is enough even if other tasks can't be reached.
+func TestDataRequestAll(t *testing.T) {
+	appName := "framework_test_datarequestall"
+	batchChan := make(chan map[uint64][]byte, 1)
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"req": []byte("resp")},
+		batchChan: batchChan,
+	}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	expected := map[uint64][]byte{1: []byte("resp")}
+	f0.dataRequestAll([]uint64{1}, "req", 0, 0)
+	if resps := <-batchChan; !reflect.DeepEqual(resps, expected) {
+		t.Errorf("responses want = %v, get = %v", expected, resps)
+	}
+
+	// Task 5 doesn't exist.
+	f0.dataRequestAll([]uint64{5, 1}, "req", 1, 0)
+	select {
+	case resps := <-batchChan:
+		if !reflect.DeepEqual(resps, expected) {
+			t.Errorf("responses want = %v, get = %v", expected, resps)
+		}
+	case <-time.After(dataRequestBackoff):
+		t.Errorf("quorum responses not delivered before retry of failed request")
+	}
+}
+
+// TestDataRequestLimit checks that DataRequest blocks while too many requests
+// are in flight.
+func TestDataRequestLimit(t *testing.T) {
+	appName := "framework_test_datarequestlimit"
+	pDataChan := make(chan *tDataBundle, 2)
+	cDataChan := make(chan *tDataBundle, 2)
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"req": []byte("resp")},
+		cDataChan: cDataChan,
+		pDataChan: pDataChan,
+	}
+	f0, f1, cleanup := startJob(t, appName, taskBuilder, nil, WithMaxInFlightRequests(1))
+	defer cleanup()
+
+	// The first request keeps retrying while task 1 is unreachable.
+	masterPath := etcdutil.TaskMasterPath(appName, 1)
+	if _, err := f0.etcdClient.Set(masterPath, "127.0.0.1:1", 0); err != nil {
+		t.Fatalf("etcd Set failed: %v", err)
+	}
+	f0.dataRequest(1, "req", 0)
+	sent := make(chan struct{})
+	go func() {
+		f0.dataRequest(1, "req", 0)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatalf("second request should wait for the first one")
+	case <-time.After(dataRequestBackoff):
+	}
+
+	f0.etcdClient.Set(masterPath, f1.ln.Addr().String(), 0)
+	<-sent
+	for i := 0; i < 2; i++ {
+		<-pDataChan // served by child
+		data := <-cDataChan
+		expected := &tDataBundle{1, "", "req", []byte("resp")}
+		if !reflect.DeepEqual(data, expected) {
+			t.Errorf("#%d: data bundle want = %v, get = %v", i, expected, data)
+		}
+	}
+}
+
+// chainedTask requests more keys from the child once data of key "req" is
+// ready.
+type chainedTask struct {
+	*testableTask
+	more []string
+}
+
+func (t *chainedTask) ChildDataReady(ctx meritop.Context, fromID uint64, req string, resp []byte) {
+	t.testableTask.ChildDataReady(ctx, fromID, req, resp)
+	if req != "req" {
+		return
+	}
+	for _, r := range t.more {
+		ctx.DataRequest(fromID, r)
+	}
+}
+
+// TestDataRequestLimitFromHandler checks that tasks handling responses can
+// request more data than the limit of requests in flight.
+func TestDataRequestLimitFromHandler(t *testing.T) {
+	appName := "framework_test_datarequestlimithandler"
+	dataMap := map[string][]byte{"req": []byte("resp"), "more1": {1}, "more2": {2}}
+	cDataChan := make(chan *tDataBundle, 3)
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   dataMap,
+		cDataChan: cDataChan,
+		pDataChan: make(chan *tDataBundle, 3),
+		wrap: func(t *testableTask) meritop.Task {
+			return &chainedTask{t, []string{"more1", "more2"}}
+		},
+	}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil, WithMaxInFlightRequests(1))
+	defer cleanup()
+
+	f0.dataRequest(1, "req", 0)
+	for i := 0; i < 3; i++ {
+		select {
+		case data := <-cDataChan:
+			expected := &tDataBundle{1, "", data.req, dataMap[data.req]}
+			if !reflect.DeepEqual(data, expected) {
+				t.Errorf("#%d: data bundle want = %v, get = %v", i, expected, data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("#%d: data requested while handling a response not received", i)
+		}
+	}
+}
+
+// TestDataServeHeldUntilClosed checks that data served as a stream holds its
+// slot until the stream is closed, not only until it's handed back.
+func TestDataServeHeldUntilClosed(t *testing.T) {
+	appName := "framework_test_dataserveheld"
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"req": []byte("resp")},
+		cDataChan: make(chan *tDataBundle, 2),
+		pDataChan: make(chan *tDataBundle, 2),
+	}
+	_, f1, cleanup := startJob(t, appName, taskBuilder, nil, WithMaxInFlightRequests(1))
+	defer cleanup()
+
+	r, err := f1.GetTaskDataStream(0, 0, "req")
+	if err != nil {
+		t.Fatalf("GetTaskDataStream failed: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		r, err := f1.GetTaskDataStream(0, 0, "req")
+		if err == nil {
+			r.Close()
+		}
+		served <- err
+	}()
+	select {
+	case <-served:
+		t.Fatalf("second request should wait for the first stream to be closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != "resp" {
+		t.Fatalf("data = %q, %v, want %q", b, err, "resp")
+	}
+	r.Close()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("GetTaskDataStream failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("second request not served after the first stream closed")
+	}
+}
+
+func TestDataPush(t *testing.T) {
+	appName := "framework_test_datapush"
+	pDataChan := make(chan *tDataBundle, 1)
+	cDataChan := make(chan *tDataBundle, 1)
+	taskBuilder := &testableTaskBuilder{
+		cDataChan: cDataChan,
+		pDataChan: pDataChan,
+	}
+	f0, f1, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	// 0: F#DataPush -> 1: T#DataPushed
+	f0.dataPush(1, "parameters", []byte{1, 2, 3}, 0)
+	data := <-pDataChan
+	expected := &tDataBundle{0, "", "parameters", []byte{1, 2, 3}}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, data)
+	}
+
+	// 1: F#DataPush -> 0: T#DataPushed
+	f1.dataPush(0, "gradient", []byte{4, 5, 6}, 0)
+	data = <-cDataChan
+	expected = &tDataBundle{1, "", "gradient", []byte{4, 5, 6}}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, data)
+	}
+}
+
+func TestDataRequestFailed(t *testing.T) {
+	appName := "framework_test_datarequestfailed"
+	failChan := make(chan *tDataBundle, 1)
+	taskBuilder := &testableTaskBuilder{failChan: failChan}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil, WithMaxDataRequestAttempts(1))
+	defer cleanup()
+
+	// Task 1 can't be reached.
+	masterPath := etcdutil.TaskMasterPath(appName, 1)
+	if _, err := f0.etcdClient.Set(masterPath, "127.0.0.1:1", 0); err != nil {
+		t.Fatalf("etcd Set failed: %v", err)
+	}
+	f0.dataRequest(1, "req", 0)
+	data := <-failChan
+	if data.id != 1 || data.req != "req" || len(data.resp) == 0 {
+		t.Errorf("failure want = (1, req, error), get = (%d, %s, %s)", data.id, data.req, data.resp)
+	}
+}
+
+// TestDataRequestThrottled checks that requests to a task throttling them for
+// good fail in the end.
+func TestDataRequestThrottled(t *testing.T) {
+	appName := "framework_test_datarequestthrottled"
+	failChan := make(chan *tDataBundle, 1)
+	taskBuilder := &testableTaskBuilder{failChan: failChan}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil, WithMaxDataRequestAttempts(1))
+	defer cleanup()
+
+	var attempts int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "too many requests", 429)
+	}))
+	defer s.Close()
+	masterPath := etcdutil.TaskMasterPath(appName, 1)
+	if _, err := f0.etcdClient.Set(masterPath, s.Listener.Addr().String(), 0); err != nil {
+		t.Fatalf("etcd Set failed: %v", err)
+	}
+	f0.dataRequest(1, "req", 0)
+	select {
+	case data := <-failChan:
+		if data.id != 1 || data.req != "req" || !strings.Contains(string(data.resp), "too many requests") {
+			t.Errorf("failure want = (1, req, too many requests), get = (%d, %s, %s)", data.id, data.req, data.resp)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("throttled request didn't fail")
+	}
+	if n := atomic.LoadInt32(&attempts); n != throttledAttemptsFactor {
+		t.Errorf("attempts = %d, want %d", n, throttledAttemptsFactor)
+	}
+}
+
+// TestDataRequestAuth checks that tasks of a job with auth enabled can talk
+// to each other, while others can't.
+func TestDataRequestAuth(t *testing.T) {
+	appName := "framework_test_datarequestauth"
+	pDataChan := make(chan *tDataBundle, 1)
+	cDataChan := make(chan *tDataBundle, 1)
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"req": []byte("resp")},
+		cDataChan: cDataChan,
+		pDataChan: pDataChan,
+	}
+	f0, f1, cleanup := startJob(t, appName, taskBuilder, (*controller.Controller).EnableAuth)
+	defer cleanup()
+
+	f0.dataRequest(1, "req", 0)
+	<-pDataChan // served by child
+	data := <-cDataChan
+	expected := &tDataBundle{1, "", "req", []byte("resp")}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, data)
+	}
+
+	_, err := frameworkhttp.RequestData(f1.ln.Addr().String(), "req", 0, 1, 0, nil)
+	if err != frameworkhttp.ErrUnauthorized {
+		t.Errorf("error want = %v, get = %v", frameworkhttp.ErrUnauthorized, err)
+	}
+}
+
+func TestUnixSocketTasks(t *testing.T) {
+	appName := "framework_test_unixsockettasks"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "meritop")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	var lns [2]net.Listener
+	for i := range lns {
+		if lns[i], err = net.Listen("unix", filepath.Join(dir, fmt.Sprintf("task%d.sock", i))); err != nil {
+			t.Fatalf("net.Listen(\"unix\") failed: %v", err)
+		}
+	}
+
+	pDataChan := make(chan *tDataBundle, 1)
+	cDataChan := make(chan *tDataBundle, 1)
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"req": []byte("resp")},
+		cDataChan: cDataChan,
+		pDataChan: pDataChan,
+	}
+	f0, _ := startFrameworksOn(t, appName, job.url, taskBuilder, lns)
+	defer f0.ShutdownJob()
+
+	addr, err := etcdutil.GetAddress(f0.etcdClient, appName, 1)
+	if err != nil {
+		t.Fatalf("GetAddress failed: %v", err)
+	}
+	if _, ok := frameworkhttp.UnixSocketPath(addr); !ok {
+		t.Errorf("address want = unix://..., get = %s", addr)
+	}
+
+	f0.dataRequest(1, "req", 0)
+	<-pDataChan // served by child
+	data := <-cDataChan
+	expected := &tDataBundle{1, "", "req", []byte("resp")}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, data)
+	}
+}
+
+func TestDataRequestMulti(t *testing.T) {
+	appName := "framework_test_datarequestmulti"
+	pDataChan := make(chan *tDataBundle, 2)
+	cDataChan := make(chan *tDataBundle, 2)
+	dataMap := map[string][]byte{"parameters": {1, 2, 3}, "gradient": {4, 5, 6}}
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   dataMap,
+		cDataChan: cDataChan,
+		pDataChan: pDataChan,
+	}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	f0.dataRequestMulti(1, []string{"parameters", "gradient"}, 0)
+	for i := 0; i < 2; i++ {
+		<-pDataChan // served by child
+		data := <-cDataChan
+		expected := &tDataBundle{1, "", data.req, dataMap[data.req]}
+		if data.resp == nil || !reflect.DeepEqual(data, expected) {
+			t.Errorf("#%d: data bundle want = %v, get = %v", i, expected, data)
+		}
+	}
+}
+
+// startFrameworks starts a parent (task 0) and a child (task 1) framework for
+// the given job, and returns them in this order once both tasks are set up.
+func startFrameworks(t *testing.T, appName, url string, taskBuilder *testableTaskBuilder, opts ...Option) (*framework, *framework) {
+	return startFrameworksOn(t, appName, url, taskBuilder, [2]net.Listener{createListener(t), createListener(t)}, opts...)
+}
+
+// testJob is a job of two tasks set up on etcd started for a test.
+type testJob struct {
+	url    string
+	client *etcd.Client
+	ctl    *controller.Controller
+}
+
+// setupJob starts etcd, and sets up a job of two tasks on it as the controller
+// does. setup, if given, is applied to the controller before the job is set
+// up, e.g. to enable auth. cleanup destroys the job and stops etcd.
+func setupJob(t *testing.T, appName string, setup func(*controller.Controller)) (*testJob, func()) {
+	m := etcdutil.StartNewEtcdServer(t, appName)
+	job := &testJob{url: m.URL()}
+	job.client = etcd.NewClient([]string{job.url})
+	job.ctl = controller.New(appName, job.client, 2)
+	if setup != nil {
+		setup(job.ctl)
+	}
+	if err := job.ctl.InitEtcdLayout(); err != nil {
+		m.Terminate(t)
+		t.Fatalf("initEtcdLayout failed: %v", err)
+	}
+	return job, func() {
+		job.ctl.DestroyEtcdLayout()
+		m.Terminate(t)
+	}
+}
+
+// startJob sets up the job as setupJob does, and starts its frameworks as
+// startFrameworks does. cleanup shuts the job down as well.
+func startJob(t *testing.T, appName string, taskBuilder *testableTaskBuilder, setup func(*controller.Controller), opts ...Option) (f0, f1 *framework, cleanup func()) {
+	job, destroy := setupJob(t, appName, setup)
+	f0, f1 = startFrameworks(t, appName, job.url, taskBuilder, opts...)
+	return f0, f1, func() {
+		f0.ShutdownJob()
+		destroy()
+	}
+}
+
+// startFrameworksOn is like startFrameworks, but tasks listen on given
+// listeners.
+func startFrameworksOn(t *testing.T, appName, url string, taskBuilder *testableTaskBuilder, lns [2]net.Listener, opts ...Option) (*framework, *framework) {
+	var wg sync.WaitGroup
+	taskBuilder.setupLatch = &wg
+	fs := make([]*framework, 2)
+	for i := range fs {
+		fs[i] = NewBootStrap(appName, []string{url}, lns[i], nil, opts...).(*framework)
+		fs[i].SetTaskBuilder(taskBuilder)
+		if taskBuilder.topology != nil {
+			fs[i].SetTopology(taskBuilder.topology())
+		} else {
+			fs[i].SetTopology(example.NewTreeTopology(2, 2))
+		}
+	}
+	wg.Add(2)
+	go fs[0].Start()
+	go fs[1].Start()
+	wg.Wait()
+	if fs[0].GetTaskID() != 0 {
+		return fs[1], fs[0]
+	}
+	return fs[0], fs[1]
+}
+
+func TestStreamingReduce(t *testing.T) {
+	appName := "framework_test_streamingreduce"
+	chunkChan := make(chan *tDataBundle, 10)
+	dataMap := map[string][]byte{"gradient": {1, 2, 3, 4, 5}}
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   dataMap,
+		cDataChan: make(chan *tDataBundle, 1),
+		pDataChan: make(chan *tDataBundle, 1),
+		wrap:      func(t *testableTask) meritop.Task { return &chunkedTask{t, chunkChan} },
+	}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	f0.dataRequest(1, "gradient", 0)
+	var data []byte
+	for n := 0; ; n++ {
+		chunk := <-chunkChan
+		if chunk.id != 1 || chunk.req != "gradient" {
+			t.Fatalf("unexpected chunk: %v", chunk)
+		}
+		if chunk.meta == "last" {
+			if n != 3 {
+				t.Errorf("number of chunks want = 3, get = %d", n)
+			}
+			break
+		}
+		data = append(data, chunk.resp...)
+	}
+	if !reflect.DeepEqual(data, dataMap["gradient"]) {
+		t.Errorf("data want = %v, get = %v", dataMap["gradient"], data)
+	}
+}
+
+// chunkedTask serves data from dataMap in segments of two bytes, and passes
+// segments received on chunkChan, with meta set to "last" for the last call.
+type chunkedTask struct {
+	*testableTask
+	chunkChan chan *tDataBundle
+}
+
+func (t *chunkedTask) ServeAsChildChunks(fromID uint64, req string) <-chan []byte {
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		data := t.dataMap[req]
+		for len(data) > 2 {
+			ch <- data[:2]
+			data = data[2:]
+		}
+		ch <- data
+	}()
+	return ch
+}
+
+func (t *chunkedTask) ChildDataChunk(ctx meritop.Context, fromID uint64, req string, chunk []byte, last bool) {
+	b := &tDataBundle{id: fromID, req: req, resp: chunk}
+	if last {
+		b.meta = "last"
+	}
+	t.chunkChan <- b
+}
+
+// TestNeighbors checks that tasks linked by a link type other than parent and
+// child get each other's metas and data.
+func TestNeighbors(t *testing.T) {
+	appName := "framework_test_neighbors"
+	linkChan := make(chan *tDataBundle, 2)
+	dataMap := map[string][]byte{"parameters": {1, 2, 3}}
+	taskBuilder := &testableTaskBuilder{
+		dataMap:  dataMap,
+		wrap:     func(t *testableTask) meritop.Task { return &linkedTask{t, linkChan} },
+		topology: func() meritop.Topology { return &ringTopology{} },
+	}
+	f0, f1, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	f0.flagMetaToNeighbors("ring", &meritop.Meta{Kind: "ready", Epoch: 0})
+	b := <-linkChan
+	expected := &tDataBundle{1, "ready", "ring", nil}
+	if !reflect.DeepEqual(b, expected) {
+		t.Errorf("meta bundle want = %v, get = %v", expected, b)
+	}
+
+	f1.dataRequest(0, "parameters", 0)
+	b = <-linkChan
+	expected = &tDataBundle{1, "", "ring:parameters", dataMap["parameters"]}
+	if !reflect.DeepEqual(b, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, b)
+	}
+}
+
+// ringTopology links two tasks as neighbors of link type "ring" only.
+type ringTopology struct{ taskID uint64 }
+
+func (t *ringTopology) SetTaskID(taskID uint64)            { t.taskID = taskID }
+func (t *ringTopology) GetParents(epoch uint64) []uint64   { return nil }
+func (t *ringTopology) GetChildren(epoch uint64) []uint64  { return nil }
+func (t *ringTopology) SetNumberOfTasks(numOfTasks uint64) {}
+func (t *ringTopology) GetLinkTypes() []string             { return []string{"ring"} }
+func (t *ringTopology) GetNeighbors(linkType string, epoch uint64) []uint64 {
+	return []uint64{1 - t.taskID}
+}
+
+// TestPeers checks that peers get each other's metas and data.
+func TestPeers(t *testing.T) {
+	appName := "framework_test_peers"
+	linkChan := make(chan *tDataBundle, 2)
+	dataMap := map[string][]byte{"model": {1, 2, 3}}
+	taskBuilder := &testableTaskBuilder{
+		dataMap:  dataMap,
+		wrap:     func(t *testableTask) meritop.Task { return &linkedTask{t, linkChan} },
+		topology: func() meritop.Topology { return &peerTopology{} },
+	}
+	f0, f1, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	f1.createContext().FlagMetaToPeer("averaged")
+	b := <-linkChan
+	expected := &tDataBundle{0, "averaged", "peer", nil}
+	if !reflect.DeepEqual(b, expected) {
+		t.Errorf("meta bundle want = %v, get = %v", expected, b)
+	}
+
+	f0.dataRequest(1, "model", 0)
+	b = <-linkChan
+	expected = &tDataBundle{0, "", "peer:model", dataMap["model"]}
+	if !reflect.DeepEqual(b, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, b)
+	}
+}
+
+// peerTopology makes two tasks peers of each other.
+type peerTopology struct{ ringTopology }
+
+func (t *peerTopology) GetLinkTypes() []string { return nil }
+func (t *peerTopology) GetPeers(epoch uint64) []uint64 {
+	return []uint64{1 - t.taskID}
+}
+
+// linkedTask passes metas and data from neighbors and peers on linkChan, as
+// bundles with the receiving task in id.
+type linkedTask struct {
+	*testableTask
+	linkChan chan *tDataBundle
+}
+
+func (t *linkedTask) NeighborMetaReady(ctx meritop.Context, linkType string, fromID uint64, meta string) {
+	t.linkChan <- &tDataBundle{id: t.id, meta: meta, req: linkType}
+}
+
+func (t *linkedTask) ServeAsNeighbor(linkType string, fromID uint64, req string) []byte {
+	return t.dataMap[req]
+}
+
+func (t *linkedTask) NeighborDataReady(ctx meritop.Context, linkType string, fromID uint64, req string, resp []byte) {
+	t.linkChan <- &tDataBundle{id: t.id, req: linkType + ":" + req, resp: resp}
+}
+
+// TestResizeJob checks that tasks pick up the number of tasks changed in etcd
+// at the next epoch.
+func TestResizeJob(t *testing.T) {
+	appName := "framework_test_resizejob"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	resized := make(chan []uint64, 2)
+	taskBuilder := &testableTaskBuilder{
+		topology: func() meritop.Topology {
+			return &resizedTopology{TreeTopology: example.NewTreeTopology(2, 2), resized: resized}
+		},
+	}
+	f0, _ := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+	if n := f0.GetNumTasks(); n != 2 {
+		t.Fatalf("GetNumTasks want = 2, get = %d", n)
+	}
+	if name := f0.GetJobName(); name != appName {
+		t.Errorf("GetJobName want = %s, get = %s", appName, name)
+	}
+
+	if err := etcdutil.SetNumOfTasks(job.client, appName, 3); err != nil {
+		t.Fatalf("SetNumOfTasks failed: %v", err)
+	}
+	// Tasks take the number in etcd at start too.
+	if children := <-resized; !reflect.DeepEqual(children, []uint64{1}) {
+		t.Fatalf("children of task 0 want = [1], get = %v", children)
+	}
+	f0.incEpoch(0)
+	if children := <-resized; !reflect.DeepEqual(children, []uint64{1, 2}) {
+		t.Errorf("children of task 0 want = [1 2], get = %v", children)
+	}
+	if n := f0.GetNumTasks(); n != 3 {
+		t.Errorf("GetNumTasks after resizing want = 3, get = %d", n)
+	}
+}
+
+// resizedTopology passes children of task 0 on resized, once the number of
+// tasks has been changed.
+type resizedTopology struct {
+	*example.TreeTopology
+	resized chan []uint64
+	changed bool
+}
+
+func (t *resizedTopology) SetNumberOfTasks(nt uint64) {
+	t.changed = true
+	t.TreeTopology.SetNumberOfTasks(nt)
+}
+
+func (t *resizedTopology) SetTaskID(taskID uint64) {
+	t.TreeTopology.SetTaskID(taskID)
+	if t.changed && taskID == 0 {
+		t.resized <- t.GetChildren(0)
+	}
+}
+
+func (t *linkedTask) PeerMetaReady(ctx meritop.Context, fromID uint64, meta string) {
+	t.linkChan <- &tDataBundle{id: t.id, meta: meta, req: "peer"}
+}
+
+func (t *linkedTask) ServeAsPeer(fromID uint64, req string) []byte {
+	return t.dataMap[req]
+}
+
+func (t *linkedTask) PeerDataReady(ctx meritop.Context, fromID uint64, req string, resp []byte) {
+	t.linkChan <- &tDataBundle{id: t.id, req: "peer:" + req, resp: resp}
+}
+
+// TestTaskFailover checks that, without a controller, a failed task is
+// reported by the others and taken over by a standby.
+func TestTaskFailover(t *testing.T) {
+	appName := "framework_test_taskfailover"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	taskBuilder := &testableTaskBuilder{}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	standby := NewBootStrap(appName, []string{job.url}, createListener(t), nil).(*framework)
+	standby.SetTaskBuilder(taskBuilder)
+	standby.SetTopology(example.NewTreeTopology(2, 2))
+	taskBuilder.setupLatch.Add(1)
+	go standby.Start()
+
+	f1.stop()
+	taskBuilder.setupLatch.Wait()
+	if id := standby.GetTaskID(); id != 1 {
+		t.Errorf("standby taskID want = 1, get = %d", id)
+	}
+}
+
+// tCoordinator records tasks occupied through it.
+type tCoordinator struct {
+	etcdutil.Coordinator
+	mu       sync.Mutex
+	occupied []uint64
+}
+
+func (c *tCoordinator) OccupyTask(name string, taskID uint64, addr string) bool {
+	ok := c.Coordinator.OccupyTask(name, taskID, addr)
+	if ok {
+		c.mu.Lock()
+		c.occupied = append(c.occupied, taskID)
+		c.mu.Unlock()
+	}
+	return ok
+}
+
+// TestMemClient runs a job on an in-memory client instead of etcd, down to a
+// task failing over to a standby, which coordinates through a Coordinator of
+// its own.
+func TestMemClient(t *testing.T) {
+	appName := "framework_test_memclient"
+	client := etcdutil.NewMemClient()
+	defer client.Close()
+
+	ctl := controller.New(appName, client, 2)
+	if err := ctl.InitEtcdLayout(); err != nil {
+		t.Fatalf("initEtcdLayout failed: %v", err)
+	}
+	defer ctl.DestroyEtcdLayout()
+
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"request": []byte("response")},
+		cDataChan: make(chan *tDataBundle, 1),
+		pDataChan: make(chan *tDataBundle, 1),
+	}
+	f0, f1 := startFrameworks(t, appName, "", taskBuilder, WithEtcdClient(client))
+	defer f0.ShutdownJob()
+
+	f0.dataRequest(1, "request", 0)
+	<-taskBuilder.pDataChan
+	if data := <-taskBuilder.cDataChan; !reflect.DeepEqual(data.resp, []byte("response")) {
+		t.Errorf("response want = %q, get = %q", "response", data.resp)
+	}
+
+	coord := &tCoordinator{Coordinator: etcdutil.NewCoordinator(client)}
+	standby := NewBootStrap(appName, nil, createListener(t), nil,
+		WithEtcdClient(client), WithCoordinator(coord)).(*framework)
+	standby.SetTaskBuilder(taskBuilder)
+	standby.SetTopology(example.NewTreeTopology(2, 2))
+	taskBuilder.setupLatch.Add(1)
+	go standby.Start()
+
+	f1.stop()
+	taskBuilder.setupLatch.Wait()
+	if id := standby.GetTaskID(); id != 1 {
+		t.Errorf("standby taskID want = 1, get = %d", id)
+	}
+	coord.mu.Lock()
+	defer coord.mu.Unlock()
+	if !reflect.DeepEqual(coord.occupied, []uint64{1}) {
+		t.Errorf("tasks occupied through coordinator = %v, want [1]", coord.occupied)
+	}
+}
+
+// TestMemCoordinator runs a job coordinated in memory, with etcd unreachable,
+// from exchanging data to shutting the job down.
+func TestMemCoordinator(t *testing.T) {
+	appName := "framework_test_memcoordinator"
+	coord := etcdutil.NewMemCoordinator()
+	defer coord.Close()
+	if err := coord.SetupJob(appName, 2); err != nil {
+		t.Fatalf("SetupJob failed: %v", err)
+	}
+	testCoordinator(t, appName, coord, func() ([]etcdutil.Event, error) {
+		return coord.Events(appName), nil
+	})
+}
+
+func TestV3Coordinator(t *testing.T) {
+	appName := "framework_test_v3coordinator"
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+	coord := etcdutil.NewV3Coordinator(clus.RandClient())
+	if err := coord.SetupJob(appName, 2); err != nil {
+		t.Fatalf("SetupJob failed: %v", err)
+	}
+	testCoordinator(t, appName, coord, func() ([]etcdutil.Event, error) {
+		return coord.Events(appName)
+	})
+}
+
+// testCoordinator runs a job of two tasks set up by coord, without etcd v2,
+// until it's shut down. events returns the audit log of the job.
+func testCoordinator(t *testing.T, appName string, coord etcdutil.Coordinator, events func() ([]etcdutil.Event, error)) {
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"request": []byte("response")},
+		cDataChan: make(chan *tDataBundle, 1),
+		pDataChan: make(chan *tDataBundle, 1),
+	}
+	f0, f1 := startFrameworks(t, appName, "http://127.0.0.1:1", taskBuilder,
+		WithCoordinator(coord), WithEpochBarrier(0))
+	if f0.etcdClient != nil || f1.etcdClient != nil {
+		t.Errorf("etcd client created along with a coordinator given")
+	}
+	states := f0.StateChanges()
+
+	f0.dataRequest(1, "request", 0)
+	<-taskBuilder.pDataChan
+	if data := <-taskBuilder.cDataChan; !reflect.DeepEqual(data.resp, []byte("response")) {
+		t.Errorf("response want = %q, get = %q", "response", data.resp)
+	}
+
+	f0.createContext().IncEpoch()
+	f1.createContext().EpochDone()
+	if epoch, err := coord.Epoch(appName); epoch != 1 || err != nil {
+		t.Fatalf("Epoch = (%d, %v), want (1, nil)", epoch, err)
+	}
+	for f0.GetEpoch() != 1 || f1.GetEpoch() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	f0.ShutdownJob()
+	for s := range states {
+		if s == meritop.StateStopped {
+			break
+		}
+	}
+	if epoch, err := coord.Epoch(appName); epoch != exitEpoch || err != nil {
+		t.Errorf("Epoch = (%d, %v), want (%d, nil)", epoch, err, uint64(exitEpoch))
+	}
+	recorded, err := events()
+	if err != nil {
+		t.Fatalf("events failed: %v", err)
+	}
+	var kinds []string
+	for _, e := range recorded {
+		kinds = append(kinds, e.Kind)
+	}
+	want := []string{etcdutil.EventRegister, etcdutil.EventRegister, etcdutil.EventEpoch, etcdutil.EventShutdown}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("events = %v, want %v", kinds, want)
+	}
+}
+
+func TestEtcdClientInfo(t *testing.T) {
+	appName := "framework_test_etcdclientinfo"
+	dir, err := ioutil.TempDir("", appName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certs := etcdutil.WriteTestCerts(t, dir)
+	m := etcdutil.StartNewTLSEtcdServer(t, appName, certs)
+	defer m.Terminate(t)
+	url := m.URL()
+
+	client, err := etcdutil.NewClient([]string{url}, certs)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	ctl := controller.New(appName, client, 2)
+	if err := ctl.InitEtcdLayout(); err != nil {
+		t.Fatalf("initEtcdLayout failed: %v", err)
+	}
+	defer ctl.DestroyEtcdLayout()
+
+	// Frameworks which can't create the client give up starting.
+	bad := NewBootStrap(appName, []string{url}, createListener(t), nil,
+		WithEtcdClientInfo(etcdutil.ClientInfo{CertFile: "no-such.crt", KeyFile: "no-such.key"}))
+	done := make(chan struct{})
+	go func() {
+		bad.Start()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("framework with a missing client certificate didn't give up starting")
+	}
+
+	// etcd requiring mutual TLS, without auth enabled, takes requests with
+	// the certificate and credentials.
+	info := certs
+	info.Username, info.Password = "root", "secret"
+	f0, _ := startFrameworks(t, appName, url, &testableTaskBuilder{}, WithEtcdClientInfo(info))
+	defer f0.ShutdownJob()
+
+	// So does the client frameworks hosted by a runner share.
+	r := NewRunner(appName, []string{url}, createListener(t), WithEtcdClientInfo(info))
+	defer r.Stop()
+	if _, err := r.client.Get(etcdutil.EpochPath(appName), false, false); err != nil {
+		t.Errorf("Get through runner client failed: %v", err)
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	appName := "framework_test_savestate"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	restoreChan := make(chan *tDataBundle, 1)
+	taskBuilder := &testableTaskBuilder{wrap: func(t *testableTask) meritop.Task { return &restorableTask{t, restoreChan} }}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	if epoch, data := f1.LoadLatestState(); data != nil {
+		t.Fatalf("state want = none, get = (%d, %s)", epoch, data)
+	}
+	f1.SaveState(1, []byte("old"))
+	f1.SaveState(2, []byte("state"))
+	if epoch, data := f1.LoadLatestState(); epoch != 2 || string(data) != "state" {
+		t.Errorf("state want = (2, state), get = (%d, %s)", epoch, data)
+	}
+
+	// The standby taking over task 1 restores its state.
+	standby := NewBootStrap(appName, []string{job.url}, createListener(t), nil).(*framework)
+	standby.SetTaskBuilder(taskBuilder)
+	standby.SetTopology(example.NewTreeTopology(2, 2))
+	taskBuilder.setupLatch.Add(1)
+	go standby.Start()
+	f1.stop()
+
+	r := <-restoreChan
+	if r.id != 1 || r.meta != "2" || string(r.resp) != "state" {
+		t.Errorf("restore want = (1, 2, state), get = (%d, %s, %s)", r.id, r.meta, r.resp)
+	}
+}
+
+// restorableTask passes the checkpoint it restores from on restoreChan, with
+// the epoch as meta.
+type restorableTask struct {
+	*testableTask
+	restoreChan chan *tDataBundle
+}
+
+func (t *restorableTask) Restore(epoch uint64, data []byte) {
+	t.restoreChan <- &tDataBundle{id: t.id, meta: fmt.Sprint(epoch), resp: data}
+}
+
+func TestBackupTakeover(t *testing.T) {
+	appName := "framework_test_backuptakeover"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	backupChan := make(chan string, 1)
+	taskBuilder := &testableTaskBuilder{wrap: func(t *testableTask) meritop.Task { return &backupTask{t, backupChan} }}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f1.ShutdownJob()
+	// Backups replay logs shipped before they start.
+	if id := f0.Update(0, []byte("a")); id != 1 {
+		t.Fatalf("update log ID want = 1, get = %d", id)
+	}
+
+	backup := NewBootStrap(appName, []string{job.url}, createListener(t), nil, WithBackups(1)).(*framework)
+	backup.SetTaskBuilder(taskBuilder)
+	backup.SetTopology(example.NewTreeTopology(2, 2))
+	taskBuilder.setupLatch.Add(1)
+	go backup.Start()
+
+	tests := []struct {
+		update func()
+		want   string
+	}{
+		{nil, "backup"},
+		{nil, "update 1: a"},
+		{func() { f0.Update(0, []byte("b")) }, "update 2: b"},
+		{func() { f0.Update(0, []byte("c")) }, "update 3: c"},
+		{f0.stop, "primary"},
+	}
+	for i, tt := range tests {
+		if tt.update != nil {
+			tt.update()
+		}
+		if get := <-backupChan; get != tt.want {
+			t.Errorf("#%d: want = %s, get = %s", i, tt.want, get)
+		}
+	}
+	if id := backup.GetTaskID(); id != 0 {
+		t.Errorf("backup taskID want = 0, get = %d", id)
+	}
+	// The new primary continues the log.
+	if id := backup.Update(0, []byte("d")); id != 4 {
+		t.Errorf("update log ID want = 4, get = %d", id)
+	}
+}
+
+// backupTask passes what happens to it as a backup on backupChan.
+type backupTask struct {
+	*testableTask
+	backupChan chan string
+}
+
+func (t *backupTask) BecamePrimary() { t.backupChan <- "primary" }
+func (t *backupTask) BecameBackup()  { t.backupChan <- "backup" }
+
+func (t *backupTask) Update(log meritop.UpdateLog) {
+	t.backupChan <- fmt.Sprintf("update %d: %s", log.ID, log.Data)
+}
+
+func TestFrameworkStop(t *testing.T) {
+	appName := "framework_test_stop"
+	taskBuilder := &testableTaskBuilder{exitChan: make(chan uint64, 2)}
+	_, f1, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	addr := f1.ln.Addr().String()
+	f1.stop()
+	f1.stop()
+	if id := <-taskBuilder.exitChan; id != 1 {
+		t.Fatalf("exit task want = 1, get = %d", id)
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Errorf("task 1 still listens on %s", addr)
+	}
+	select {
+	case id := <-taskBuilder.exitChan:
+		t.Errorf("task %d exits again", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFrameworkError(t *testing.T) {
+	appName := "framework_test_error"
+	errChan := make(chan error, 1)
+	taskBuilder := &testableTaskBuilder{
+		wrap:     func(t *testableTask) meritop.Task { return &errorTask{t, errChan} },
+		exitChan: make(chan uint64, 2),
+	}
+	_, f1, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	// Epoch isn't 5, so it can't be increased from there. The task carries on.
+	f1.incEpoch(5)
+	err := <-errChan
+	if fe, ok := err.(*meritop.FrameworkError); !ok || fe.Severity != meritop.SeverityRecoverable {
+		t.Errorf("error want = recoverable FrameworkError, get = %v", err)
+	}
+	if !merrors.Is(err, merrors.ErrEpochConflict) {
+		t.Errorf("error kind want = %v, get = %v", merrors.ErrEpochConflict, merrors.Kind(err))
+	}
+
+	f1.reportError(meritop.SeverityFatal, "testing", fmt.Errorf("fatal"))
+	err = <-errChan
+	if fe, ok := err.(*meritop.FrameworkError); !ok || fe.Severity != meritop.SeverityFatal {
+		t.Errorf("error want = fatal FrameworkError, get = %v", err)
+	}
+	if id := <-taskBuilder.exitChan; id != 1 {
+		t.Errorf("exit task want = 1, get = %d", id)
+	}
+}
+
+// errorTask passes errors framework ran into on errChan.
+type errorTask struct {
+	*testableTask
+	errChan chan error
+}
+
+func (t *errorTask) OnFrameworkError(err error) { t.errChan <- err }
+
+func TestChildDieAndRestart(t *testing.T) {
+	appName := "framework_test_childdie"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	healthChan := make(chan string, 2)
+	taskBuilder := &testableTaskBuilder{wrap: func(t *testableTask) meritop.Task { return &statefulTask{t, healthChan} }}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	f1.stop()
+	if get := <-healthChan; get != "0: ChildDie 1" {
+		t.Errorf("want = 0: ChildDie 1, get = %s", get)
+	}
+
+	standby := NewBootStrap(appName, []string{job.url}, createListener(t), nil).(*framework)
+	standby.SetTaskBuilder(taskBuilder)
+	standby.SetTopology(example.NewTreeTopology(2, 2))
+	taskBuilder.setupLatch.Add(1)
+	go standby.Start()
+	if get := <-healthChan; get != "0: ChildRestart 1" {
+		t.Errorf("want = 0: ChildRestart 1, get = %s", get)
+	}
+}
+
+// statefulTask passes parents and children dying and restarting on
+// healthChan.
+type statefulTask struct {
+	*testableTask
+	healthChan chan string
+}
+
+func (t *statefulTask) ParentDie(ctx meritop.Context, parentID uint64) {
+	t.healthChan <- fmt.Sprintf("%d: ParentDie %d", t.id, parentID)
+}
+
+func (t *statefulTask) ChildDie(ctx meritop.Context, childID uint64) {
+	t.healthChan <- fmt.Sprintf("%d: ChildDie %d", t.id, childID)
+}
+
+func (t *statefulTask) ParentRestart(ctx meritop.Context, parentID uint64) {
+	t.healthChan <- fmt.Sprintf("%d: ParentRestart %d", t.id, parentID)
+}
+
+func (t *statefulTask) ChildRestart(ctx meritop.Context, childID uint64) {
+	t.healthChan <- fmt.Sprintf("%d: ChildRestart %d", t.id, childID)
+}
+
+func TestReclaimTask(t *testing.T) {
+	appName := "framework_test_reclaimtask"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	taskBuilder := &testableTaskBuilder{exitChan: make(chan uint64, 3)}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	resp, err := job.client.Get(etcdutil.TaskMasterPath(appName, 1), false, false)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if resp.Node.TTL == 0 {
+		t.Errorf("task 1 is registered without TTL")
+	}
+
+	// Restart task 1 on the same address before it expires.
+	addr := f1.ln.Addr().String()
+	f1.stop()
+	<-taskBuilder.exitChan
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	restarted := NewBootStrap(appName, []string{job.url}, ln, nil).(*framework)
+	restarted.SetTaskBuilder(taskBuilder)
+	restarted.SetTopology(example.NewTreeTopology(2, 2))
+	taskBuilder.setupLatch.Add(1)
+	start := time.Now()
+	go restarted.Start()
+	taskBuilder.setupLatch.Wait()
+	if id := restarted.GetTaskID(); id != 1 {
+		t.Errorf("restarted taskID want = 1, get = %d", id)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("reclaiming task took %v", d)
+	}
+}
+
+func TestEpochBarrier(t *testing.T) {
+	appName := "framework_test_epochbarrier"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	f0, f1 := startFrameworks(t, appName, job.url, &testableTaskBuilder{}, WithEpochBarrier(0))
+	defer f0.ShutdownJob()
+
+	epoch := func() string {
+		resp, err := job.client.Get(etcdutil.EpochPath(appName), false, false)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		return resp.Node.Value
+	}
+	// Task 1 isn't done yet.
+	f0.createContext().IncEpoch()
+	if e := epoch(); e != "0" {
+		t.Fatalf("epoch want = 0, get = %s", e)
+	}
+	f1.createContext().EpochDone()
+	if e := epoch(); e != "1" {
+		t.Fatalf("epoch want = 1, get = %s", e)
+	}
+	// ShutdownJob swaps the epoch task knows of.
+	for f0.GetEpoch() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControllerPacedEpochs(t *testing.T) {
+	appName := "framework_test_controllerpaced"
+	m := etcdutil.StartNewEtcdServer(t, appName)
+	defer m.Terminate(t)
+	url := m.URL()
+
+	client := etcd.NewClient([]string{url})
+	ctl := controller.New(appName, client, 2)
+	ctl.PaceEpochsOnReady()
+	if err := ctl.Start(); err != nil {
+		t.Fatalf("controller start failed: %v", err)
+	}
+	defer ctl.Stop()
+
+	f0, f1 := startFrameworks(t, appName, url, &testableTaskBuilder{})
+	defer f0.ShutdownJob()
+
+	epoch := func() string {
+		resp, err := client.Get(etcdutil.EpochPath(appName), false, false)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		return resp.Node.Value
+	}
+	// Only the controller advances the epoch.
+	f0.createContext().IncEpoch()
+	f0.createContext().EpochDone()
+	time.Sleep(100 * time.Millisecond)
+	if e := epoch(); e != "0" {
+		t.Fatalf("epoch want = 0, get = %s", e)
+	}
+	f1.createContext().EpochDone()
+	for f0.GetEpoch() != 1 || f1.GetEpoch() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if e := epoch(); e != "1" {
+		t.Fatalf("epoch want = 1, get = %s", e)
+	}
+}
+
+func TestChildrenReady(t *testing.T) {
+	appName := "framework_test_childrenready"
+	readyChan := make(chan bool, 1)
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"gradient": {1, 2, 3}},
+		cDataChan: make(chan *tDataBundle, 1),
+		wrap:      func(t *testableTask) meritop.Task { return &childrenReadyTask{t, readyChan} },
+	}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil, WithChildrenReady(1, 500*time.Millisecond))
+	defer cleanup()
+
+	// No data is requested from child in epoch 0, so it times out.
+	if partial := <-readyChan; !partial {
+		t.Errorf("epoch 0: partial want = true, get = false")
+	}
+
+	f0.createContext().IncEpoch()
+	for f0.GetEpoch() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	f0.createContext().DataRequest(1, "gradient")
+	<-taskBuilder.cDataChan
+	if partial := <-readyChan; partial {
+		t.Errorf("epoch 1: partial want = false, get = true")
+	}
+}
+
+// childrenReadyTask passes whether children are partially ready on readyChan.
+type childrenReadyTask struct {
+	*testableTask
+	readyChan chan bool
+}
+
+func (t *childrenReadyTask) ChildrenReady(ctx meritop.Context, partial bool) {
+	t.readyChan <- partial
+}
+
+func TestEpochRollback(t *testing.T) {
+	appName := "framework_test_epochrollback"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	rollbackChan := make(chan string, 2)
+	taskBuilder := &testableTaskBuilder{wrap: func(t *testableTask) meritop.Task { return &rollbackTask{t, rollbackChan} }}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	f0.createContext().IncEpoch()
+	for f0.GetEpoch() != 1 || f1.GetEpoch() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := job.ctl.RollbackEpoch(2); err != etcdutil.ErrRollbackForward {
+		t.Errorf("RollbackEpoch(2) error want = %v, get = %v", etcdutil.ErrRollbackForward, err)
+	}
+	if err := job.ctl.RollbackEpoch(1); err != nil {
+		t.Fatalf("RollbackEpoch(1) failed: %v", err)
+	}
+	get := []string{<-rollbackChan, <-rollbackChan}
+	sort.Strings(get)
+	if want := []string{"0: RollbackEpoch 1", "1: RollbackEpoch 1"}; !reflect.DeepEqual(get, want) {
+		t.Errorf("want = %v, get = %v", want, get)
+	}
+}
+
+// rollbackTask passes epochs rolled back to on rollbackChan.
+type rollbackTask struct {
+	*testableTask
+	rollbackChan chan string
+}
+
+func (t *rollbackTask) RollbackEpoch(ctx meritop.Context, epoch uint64) {
+	t.rollbackChan <- fmt.Sprintf("%d: RollbackEpoch %d", t.id, epoch)
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	appName := "framework_test_heartbeatinterval"
+	f0, _, cleanup := startJob(t, appName, &testableTaskBuilder{}, nil, WithHeartbeatInterval(2*time.Second))
+	defer cleanup()
+
+	// Keys live for about three intervals, beyond the 3 seconds by default.
+	// Heartbeat might not have refreshed them yet.
+	for _, key := range []string{etcdutil.TaskHealthyPath(appName, 0), etcdutil.TaskMasterPath(appName, 0)} {
+		var ttl int64
+		for i := 0; i < 100 && ttl <= 3; i++ {
+			resp, err := f0.etcdClient.Get(key, false, false)
+			if err != nil {
+				t.Fatalf("Get %s failed: %v", key, err)
+			}
+			ttl = resp.Node.TTL
+			time.Sleep(10 * time.Millisecond)
+		}
+		if ttl <= 3 {
+			t.Errorf("%s TTL want > 3, get = %d", key, ttl)
+		}
+	}
+}
+
+// TestMetaInOrder checks that metas flagged one after another are each
+// delivered once, in order.
+func TestMetaInOrder(t *testing.T) {
+	appName := "framework_test_metainorder"
+	taskBuilder := &testableTaskBuilder{pDataChan: make(chan *tDataBundle, 10)}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	kinds := []string{"ParamReady", "GradientReady", "ParamReady"}
+	for _, kind := range kinds {
+		f0.flagMetaToChild(&meritop.Meta{Kind: kind})
+	}
+	for i, kind := range kinds {
+		if data := <-taskBuilder.pDataChan; data.meta != kind {
+			t.Errorf("#%d: meta want = %s, get = %s", i, kind, data.meta)
+		}
+	}
+	select {
+	case data := <-taskBuilder.pDataChan:
+		t.Errorf("meta %s delivered again", data.meta)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestRestartPoisoned checks that a task failing too often is poisoned rather
+// than taken over, until it is unpoisoned.
+func TestRestartPoisoned(t *testing.T) {
+	appName := "framework_test_restartpoisoned"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	taskBuilder := &testableTaskBuilder{}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	// Task 1 has failed twice already.
+	for i := 0; i < 2; i++ {
+		if err := etcdutil.RecordRestart(job.client, appName, 1); err != nil {
+			t.Fatalf("RecordRestart failed: %v", err)
+		}
+	}
+	standby := NewBootStrap(appName, []string{job.url}, createListener(t), nil,
+		WithRestartBackoff(10*time.Millisecond, 100*time.Millisecond, 2)).(*framework)
+	standby.SetTaskBuilder(taskBuilder)
+	standby.SetTopology(example.NewTreeTopology(2, 2))
+	taskBuilder.setupLatch.Add(1)
+	go standby.Start()
+
+	f1.stop()
+	var poisoned map[uint64]string
+	for i := 0; i < 100 && len(poisoned) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		var err error
+		if poisoned, err = job.ctl.PoisonedTasks(); err != nil {
+			t.Fatalf("PoisonedTasks failed: %v", err)
+		}
+	}
+	if _, ok := poisoned[1]; !ok {
+		t.Fatalf("poisoned tasks = %v, want task 1", poisoned)
+	}
+
+	if err := job.ctl.UnpoisonTask(1); err != nil {
+		t.Fatalf("UnpoisonTask failed: %v", err)
+	}
+	taskBuilder.setupLatch.Wait()
+	if id := standby.GetTaskID(); id != 1 {
+		t.Errorf("standby taskID want = 1, get = %d", id)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	appName := "framework_test_checkpoint"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	checkpointChan := make(chan string, 2)
+	taskBuilder := &testableTaskBuilder{wrap: func(t *testableTask) meritop.Task { return &checkpointTask{t, checkpointChan} }}
+	f0, _ := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	if _, err := job.ctl.ResumeFromCheckpoint(); err != controller.ErrNoCheckpoint {
+		t.Errorf("ResumeFromCheckpoint error want = %v, get = %v", controller.ErrNoCheckpoint, err)
+	}
+	done := make(chan uint64)
+	go func() {
+		epoch, err := job.ctl.Checkpoint()
+		if err != nil {
+			t.Errorf("Checkpoint failed: %v", err)
+		}
+		done <- epoch
+	}()
+	// Wait for tasks to get the request before moving on.
+	for {
+		if _, err := job.client.Get(etcdutil.CheckpointRequestPath(appName), false, false); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	f0.createContext().IncEpoch()
+
+	get := []string{<-checkpointChan, <-checkpointChan}
+	sort.Strings(get)
+	if want := []string{"0: Checkpoint 1", "1: Checkpoint 1"}; !reflect.DeepEqual(get, want) {
+		t.Errorf("want = %v, get = %v", want, get)
+	}
+	if epoch := <-done; epoch != 1 {
+		t.Errorf("checkpoint epoch want = 1, get = %d", epoch)
+	}
+	if epoch, ok, err := job.ctl.LastCheckpoint(); err != nil || !ok || epoch != 1 {
+		t.Errorf("LastCheckpoint = (%d, %v, %v), want (1, true, nil)", epoch, ok, err)
+	}
+	epoch, data, ok, err := etcdutil.LoadTaskState(job.client, appName, 1)
+	if err != nil || !ok || epoch != 1 || string(data) != "1: Checkpoint 1" {
+		t.Errorf("state = (%d, %q, %v, %v), want (1, \"1: Checkpoint 1\", true, nil)", epoch, data, ok, err)
+	}
+}
+
+// checkpointTask passes epochs it checkpoints at on checkpointChan, and
+// returns the same as state.
+type checkpointTask struct {
+	*testableTask
+	checkpointChan chan string
+}
+
+func (t *checkpointTask) Checkpoint(epoch uint64) []byte {
+	s := fmt.Sprintf("%d: Checkpoint %d", t.id, epoch)
+	t.checkpointChan <- s
+	return []byte(s)
+}
+
+func TestEpochDeadline(t *testing.T) {
+	appName := "framework_test_epochdeadline"
+	stallChan := make(chan string, 10)
+	taskBuilder := &testableTaskBuilder{wrap: func(t *testableTask) meritop.Task { return &stallTask{t, stallChan} }}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil, WithEpochDeadline(100*time.Millisecond, 0))
+	defer cleanup()
+
+	// Only task 0 is told, again while the epoch stays.
+	for i := 0; i < 2; i++ {
+		if get := <-stallChan; get != "0: EpochStalled 0" {
+			t.Errorf("#%d: want = 0: EpochStalled 0, get = %s", i, get)
+		}
+	}
+	f0.createContext().IncEpoch()
+	for get := <-stallChan; get != "0: EpochStalled 1"; get = <-stallChan {
+		if get != "0: EpochStalled 0" {
+			t.Fatalf("want = 0: EpochStalled 1, get = %s", get)
+		}
+	}
+}
+
+// stallTask passes epochs stalled on stallChan.
+type stallTask struct {
+	*testableTask
+	stallChan chan string
+}
+
+func (t *stallTask) EpochStalled(ctx meritop.Context, epoch uint64) {
+	t.stallChan <- fmt.Sprintf("%d: EpochStalled %d", t.id, epoch)
+}
+
+// TestMetaReplayAfterFailover checks that a task taken over mid-epoch gets
+// metas flagged to it before it started.
+func TestMetaReplayAfterFailover(t *testing.T) {
+	appName := "framework_test_metareplay"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	taskBuilder := &testableTaskBuilder{pDataChan: make(chan *tDataBundle, 1)}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	f0.flagMetaToChild(&meritop.Meta{Kind: "ParamReady"})
+	if data := <-taskBuilder.pDataChan; data.meta != "ParamReady" {
+		t.Fatalf("meta want = ParamReady, get = %s", data.meta)
+	}
+
+	standby := NewBootStrap(appName, []string{job.url}, createListener(t), nil).(*framework)
+	standby.SetTaskBuilder(taskBuilder)
+	standby.SetTopology(example.NewTreeTopology(2, 2))
+	taskBuilder.setupLatch.Add(1)
+	go standby.Start()
+	f1.stop()
+
+	if data := <-taskBuilder.pDataChan; data.id != 0 || data.meta != "ParamReady" {
+		t.Errorf("replayed meta want = ParamReady from 0, get = %s from %d", data.meta, data.id)
+	}
+}
+
+// TestFencing checks that once task 1 is taken by another node, metas and data
+// requests of the node which had it are rejected, and the node stops.
+func TestFencing(t *testing.T) {
+	appName := "framework_test_fencing"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	errChan := make(chan error, 1)
+	taskBuilder := &testableTaskBuilder{
+		cDataChan: make(chan *tDataBundle, 1),
+		wrap:      func(t *testableTask) meritop.Task { return &errorTask{t, errChan} },
+		exitChan:  make(chan uint64, 2),
+	}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	f1.flagMetaToParent(&meritop.Meta{Kind: "before"})
+	if data := <-taskBuilder.cDataChan; data.meta != "before" {
+		t.Fatalf("meta want = before, get = %s", data.meta)
+	}
+
+	if _, err := etcdutil.NextGeneration(job.client, appName, 1); err != nil {
+		t.Fatalf("NextGeneration failed: %v", err)
+	}
+	f1.flagMetaToParent(&meritop.Meta{Kind: "after"})
+	select {
+	case data := <-taskBuilder.cDataChan:
+		t.Errorf("meta %s of stale generation delivered", data.meta)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	f1.dataRequest(0, "req", f1.GetEpoch())
+	err := <-errChan
+	if fe, ok := err.(*meritop.FrameworkError); !ok || fe.Severity != meritop.SeverityFatal || fe.Err != etcdutil.ErrTaskFenced {
+		t.Errorf("error want = fatal %v, get = %v", etcdutil.ErrTaskFenced, err)
+	}
+	if id := <-taskBuilder.exitChan; id != 1 {
+		t.Errorf("exit task want = 1, get = %d", id)
+	}
+}
+
+// TestSupervisor checks that a supervised node recovers from its task
+// panicking, and takes the task again once it's reported failed.
+func TestSupervisor(t *testing.T) {
+	appName := "framework_test_supervisor"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	taskBuilder := &testableTaskBuilder{
+		pDataChan: make(chan *tDataBundle, 10),
+		exitChan:  make(chan uint64, 2),
+		panicOnce: new(sync.Once),
+	}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder, WithSupervisor())
+	defer f0.ShutdownJob()
+
+	taskBuilder.setupLatch.Add(1)
+	f0.flagMetaToChild(&meritop.Meta{Kind: "panic"})
+	if id := <-taskBuilder.exitChan; id != 1 {
+		t.Fatalf("exit task want = 1, get = %d", id)
+	}
+	// The node takes the task again.
+	taskBuilder.setupLatch.Wait()
+	if id := f1.GetTaskID(); id != 1 {
+		t.Errorf("task taken again want = 1, get = %d", id)
+	}
+	r, err := etcdutil.GetRestarts(job.client, appName, 1)
+	if err != nil {
+		t.Fatalf("GetRestarts failed: %v", err)
+	}
+	if r.Count != 1 {
+		t.Errorf("restarts want = 1, get = %d", r.Count)
+	}
+
+	f0.flagMetaToChild(&meritop.Meta{Kind: "after"})
+	for {
+		select {
+		case data := <-taskBuilder.pDataChan:
+			if data.meta == "after" {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("meta after restart not delivered")
+		}
+	}
+}
+
+// TestDrain checks that a task drained is checkpointed and handed over to a
+// standby without being counted as failed.
+func TestDrain(t *testing.T) {
+	appName := "framework_test_drain"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	checkpointChan := make(chan string, 2)
+	taskBuilder := &testableTaskBuilder{
+		wrap:     func(t *testableTask) meritop.Task { return &checkpointTask{t, checkpointChan} },
+		exitChan: make(chan uint64, 2),
+	}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	standby := NewBootStrap(appName, []string{job.url}, createListener(t), nil).(*framework)
+	standby.SetTaskBuilder(taskBuilder)
+	standby.SetTopology(example.NewTreeTopology(2, 2))
+	taskBuilder.setupLatch.Add(1)
+	go standby.Start()
+
+	f1.Drain()
+	if s := <-checkpointChan; s != "1: Checkpoint 0" {
+		t.Errorf("checkpoint want = %q, get = %q", "1: Checkpoint 0", s)
+	}
+	if id := <-taskBuilder.exitChan; id != 1 {
+		t.Errorf("exit task want = 1, get = %d", id)
+	}
+	taskBuilder.setupLatch.Wait()
+	if id := standby.GetTaskID(); id != 1 {
+		t.Errorf("task taken over want = 1, get = %d", id)
+	}
+	if _, data := standby.LoadLatestState(); string(data) != "1: Checkpoint 0" {
+		t.Errorf("state want = %q, get = %q", "1: Checkpoint 0", data)
+	}
+	r, err := etcdutil.GetRestarts(job.client, appName, 1)
+	if err != nil {
+		t.Fatalf("GetRestarts failed: %v", err)
+	}
+	if r.Count != 0 {
+		t.Errorf("restarts want = 0, get = %d", r.Count)
+	}
+}
+
+// TestContextTask checks that contexts tasks are called with are canceled once
+// the epoch changes, and framework stops.
+func TestContextTask(t *testing.T) {
+	appName := "framework_test_contexttask"
+	contextChan := make(chan string, 20)
+	taskBuilder := &testableTaskBuilder{wrap: func(t *testableTask) meritop.Task { return &contextTask{t, contextChan} }}
+	f0, f1, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	// wait reads from contextChan until all of want have been passed.
+	wait := func(want ...string) {
+		left := make(map[string]bool)
+		for _, w := range want {
+			left[w] = true
+		}
+		for len(left) > 0 {
+			select {
+			case s := <-contextChan:
+				delete(left, s)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%v not passed", left)
+			}
+		}
+	}
+	wait("0: Init", "1: Init", "0: SetEpoch 0", "1: SetEpoch 0")
+
+	f0.incEpoch(0)
+	wait("0: epoch 0 canceled", "1: epoch 0 canceled", "0: SetEpoch 1", "1: SetEpoch 1")
+	for f0.GetEpoch() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	f0.dataRequest(1, "req", 1)
+	wait("1: ServeAsChild req")
+
+	f1.stop()
+	wait("1: Init canceled")
+}
+
+// contextTask passes what it's called with, and contexts canceled, on
+// contextChan.
+type contextTask struct {
+	*testableTask
+	contextChan chan string
+}
+
+func (t *contextTask) InitContext(ctx context.Context, taskID uint64, framework meritop.Framework) {
+	t.Init(taskID, framework)
+	t.contextChan <- fmt.Sprintf("%d: Init", taskID)
+	go func() {
+		<-ctx.Done()
+		t.contextChan <- fmt.Sprintf("%d: Init canceled", taskID)
+	}()
+}
+
+func (t *contextTask) SetEpochContext(ctx context.Context, epochCtx meritop.Context, epoch uint64) {
+	t.contextChan <- fmt.Sprintf("%d: SetEpoch %d", t.id, epoch)
+	go func() {
+		<-ctx.Done()
+		t.contextChan <- fmt.Sprintf("%d: epoch %d canceled", t.id, epoch)
+	}()
+}
+
+func (t *contextTask) ServeAsParentContext(ctx context.Context, fromID uint64, req string) []byte {
+	t.contextChan <- fmt.Sprintf("%d: ServeAsParent %s", t.id, req)
+	return t.ServeAsParent(fromID, req)
+}
+
+func (t *contextTask) ServeAsChildContext(ctx context.Context, fromID uint64, req string) []byte {
+	t.contextChan <- fmt.Sprintf("%d: ServeAsChild %s", t.id, req)
+	return t.ServeAsChild(fromID, req)
+}
+
+func TestLocalWAL(t *testing.T) {
+	appName := "framework_test_localwal"
+	dir, err := ioutil.TempDir("", "framework_test_localwal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f0, _, cleanup := startJob(t, appName, &testableTaskBuilder{}, nil, WithLocalWAL(dir))
+	defer cleanup()
+
+	var lf meritop.LoggedFramework = f0
+	replay := func() []string {
+		var logs []string
+		if err := lf.ReplayLog(func(data []byte) error {
+			logs = append(logs, string(data))
+			return nil
+		}); err != nil {
+			t.Fatalf("ReplayLog failed: %v", err)
+		}
+		return logs
+	}
+	for _, data := range []string{"a", "b"} {
+		if err := lf.AppendLog([]byte(data)); err != nil {
+			t.Fatalf("AppendLog failed: %v", err)
+		}
+	}
+	if get := replay(); !reflect.DeepEqual(get, []string{"a", "b"}) {
+		t.Errorf("logs want = [a b], get = %v", get)
+	}
+	// Saving state truncates the log.
+	f0.SaveState(0, []byte("state"))
+	if get := replay(); len(get) != 0 {
+		t.Errorf("logs after SaveState = %v, want none", get)
+	}
+}
+
+// TestConfig checks that tasks get the configuration set by controller, and
+// nodes don't take tasks without the keys required.
+func TestConfig(t *testing.T) {
+	appName := "framework_test_config"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+	if err := job.ctl.SetConfig(map[string]string{"iterations": "10", "timeout": "1m"}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+
+	taskBuilder := &testableTaskBuilder{}
+	f := NewBootStrap(appName, []string{job.url}, createListener(t), nil, WithRequiredConfig("iterations", "rate")).(*framework)
+	f.SetTaskBuilder(taskBuilder)
+	f.SetTopology(example.NewTreeTopology(2, 2))
+	done := make(chan struct{})
+	go func() {
+		f.Start()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("framework without required config keeps running")
+	}
+	if f.task != nil {
+		t.Errorf("framework without required config has taken task %d", f.taskID)
+	}
+
+	f0, _ := startFrameworks(t, appName, job.url, taskBuilder, WithRequiredConfig("iterations"))
+	defer f0.ShutdownJob()
+	cfg := f0.Config()
+	if n, err := cfg.Int("iterations", 0); err != nil || n != 10 {
+		t.Errorf("iterations want = 10, get = %d, %v", n, err)
+	}
+	if d, err := cfg.Duration("timeout", 0); err != nil || d != time.Minute {
+		t.Errorf("timeout want = %v, get = %v, %v", time.Minute, d, err)
+	}
+	if b, err := cfg.Bool("verbose", true); err != nil || !b {
+		t.Errorf("verbose want = true, get = %v, %v", b, err)
+	}
+	if _, err := cfg.Bool("timeout", false); err == nil {
+		t.Errorf("malformed bool isn't reported")
+	}
+}
+
+// TestHooks checks that hooks are called as tasks go through epochs, and shut
+// down.
+func TestHooks(t *testing.T) {
+	appName := "framework_test_hooks"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	events := make(chan string, 20)
+	hooks := Hooks{
+		OnEpochStart: func(taskID, epoch uint64) { events <- fmt.Sprintf("%d: start %d", taskID, epoch) },
+		OnEpochEnd:   func(taskID, epoch uint64) { events <- fmt.Sprintf("%d: end %d", taskID, epoch) },
+		OnShutdown:   func(taskID uint64) { events <- fmt.Sprintf("%d: shutdown", taskID) },
+	}
+	f0, _ := startFrameworks(t, appName, job.url, &testableTaskBuilder{}, WithHooks(hooks))
+	f0.incEpoch(0)
+	waitEvents(t, events, "0: start 0", "1: start 0", "0: end 0", "1: end 0", "0: start 1", "1: start 1")
+	f0.epoch = 1
+	f0.ShutdownJob()
+	waitEvents(t, events, "0: end 1", "1: end 1", "0: shutdown", "1: shutdown")
+}
+
+// waitEvents waits for events want, in any order.
+func waitEvents(t *testing.T, events chan string, want ...string) {
+	var get []string
+	for range want {
+		select {
+		case e := <-events:
+			get = append(get, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events want = %v, get = %v", want, get)
+		}
+	}
+	sort.Strings(want)
+	sort.Strings(get)
+	if !reflect.DeepEqual(get, want) {
+		t.Errorf("events want = %v, get = %v", want, get)
+	}
+}
+
+// TestTaskV2 checks that failures of tasks serving data are passed to
+// requesters, and tasks failing to set epoch are stopped.
+func TestTaskV2(t *testing.T) {
+	appName := "framework_test_taskv2"
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"notready": []byte("ready")},
+		cDataChan: make(chan *tDataBundle, 1),
+		failChan:  make(chan *tDataBundle, 1),
+		exitChan:  make(chan uint64, 2),
+		wrap:      func(t *testableTask) meritop.Task { return &taskV2{testableTask: t} },
+	}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	// The request is retried until the task is ready.
+	f0.dataRequest(1, "notready", 0)
+	if d := <-taskBuilder.cDataChan; string(d.resp) != "ready" {
+		t.Errorf("data want = ready, get = %s", d.resp)
+	}
+	f0.dataRequest(1, "bad", 0)
+	if d := <-taskBuilder.failChan; string(d.resp) != frameworkhttp.ErrBadRequest.Error() {
+		t.Errorf("error want = %v, get = %s", frameworkhttp.ErrBadRequest, d.resp)
+	}
+
+	f0.incEpoch(0)
+	if id := <-taskBuilder.exitChan; id != 1 {
+		t.Errorf("exit task want = 1, get = %d", id)
+	}
+	for f0.GetEpoch() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type taskV2 struct {
+	*testableTask
+	notReady sync.Once
+}
+
+func (t *taskV2) TryInit(taskID uint64, framework meritop.Framework) error {
+	t.Init(taskID, framework)
+	return nil
+}
+
+func (t *taskV2) TrySetEpoch(ctx meritop.Context, epoch uint64) error {
+	if t.id == 1 && epoch == 1 {
+		return fmt.Errorf("can't set epoch %d", epoch)
+	}
+	return nil
+}
+
+func (t *taskV2) TryServeAsParent(fromID uint64, req string) ([]byte, error) {
+	ready := true
+	switch req {
+	case "notready":
+		t.notReady.Do(func() { ready = false })
+	case "bad":
+		return nil, merrors.ErrBadRequest
+	}
+	if !ready {
+		return nil, merrors.ErrNotReady
+	}
+	return t.ServeAsParent(fromID, req), nil
+}
+
+func (t *taskV2) TryServeAsChild(fromID uint64, req string) ([]byte, error) {
+	return t.TryServeAsParent(fromID, req)
+}
+
+// TestStore checks the key-value store of tasks.
+func TestStore(t *testing.T) {
+	appName := "framework_test_store"
+	f0, f1, cleanup := startJob(t, appName, &testableTaskBuilder{}, nil)
+	defer cleanup()
+
+	s := f0.Store()
+	stop := make(chan bool)
+	defer close(stop)
+	values := s.Watch("offset", stop)
+	if _, ok, err := s.Get("offset"); ok || err != nil {
+		t.Fatalf("Get of key unset = %v, %v", ok, err)
+	}
+	if ok, err := s.CompareAndSwap("offset", nil, []byte("1")); !ok || err != nil {
+		t.Fatalf("CompareAndSwap of key unset = %v, %v", ok, err)
+	}
+	if ok, err := s.CompareAndSwap("offset", []byte("0"), []byte("2")); ok || err != nil {
+		t.Fatalf("CompareAndSwap of other value = %v, %v", ok, err)
+	}
+	if ok, err := s.CompareAndSwap("offset", []byte("1"), []byte("2")); !ok || err != nil {
+		t.Fatalf("CompareAndSwap = %v, %v", ok, err)
+	}
+	if v, ok, err := s.Get("offset"); string(v) != "2" || !ok || err != nil {
+		t.Fatalf("Get = %s, %v, %v", v, ok, err)
+	}
+	// Stores of tasks are apart.
+	if _, ok, _ := f1.Store().Get("offset"); ok {
+		t.Errorf("key of task 0 is set for task 1")
+	}
+	if err := s.Delete("offset"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	for _, want := range []string{"1", "2", ""} {
+		select {
+		case v := <-values:
+			if string(v) != want || (want == "") != (v == nil) {
+				t.Errorf("value watched want = %q, get = %q", want, v)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("value %q not watched", want)
+		}
+	}
+}
+
+// TestBroadcastToChildren checks that children get data broadcast by parent
+// without asking for it.
+func TestBroadcastToChildren(t *testing.T) {
+	appName := "framework_test_broadcast"
+	taskBuilder := &testableTaskBuilder{
+		cDataChan: make(chan *tDataBundle, 1),
+		pDataChan: make(chan *tDataBundle, 1),
+	}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	f0.createContext().BroadcastToChildren("params", []byte("weights"))
+	d := <-taskBuilder.pDataChan
+	if d.id != 0 || d.meta != "" || d.req != "params" || string(d.resp) != "weights" {
+		t.Errorf("child got %v, want data of params from parent", d)
+	}
+	select {
+	case d := <-taskBuilder.cDataChan:
+		t.Errorf("parent served broadcast itself: %v", d)
+	default:
+	}
+}
+
+// TestGatherFromChildren checks that data of children is reduced and passed
+// to done.
+func TestGatherFromChildren(t *testing.T) {
+	appName := "framework_test_gather"
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"gradient": []byte("g")},
+		cDataChan: make(chan *tDataBundle, 1),
+	}
+	f0, f1, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	reduce := func(acc, item []byte) []byte {
+		if acc == nil {
+			acc = []byte("sum:")
+		}
+		return append(acc, item...)
+	}
+	results := make(chan []byte, 2)
+	done := func(result []byte) { results <- result }
+	f0.createContext().GatherFromChildren("gradient", reduce, done)
+	if r := <-results; string(r) != "sum:g" {
+		t.Errorf("result want = sum:g, get = %s", r)
+	}
+	select {
+	case d := <-taskBuilder.cDataChan:
+		t.Errorf("data gathered passed to task: %v", d)
+	default:
+	}
+	// Task 1 has no children.
+	f1.createContext().GatherFromChildren("gradient", reduce, done)
+	if r := <-results; r != nil {
+		t.Errorf("result of no children want = nil, get = %s", r)
+	}
+}
+
+// TestAllReduce checks that every task gets data of all tasks reduced.
+func TestAllReduce(t *testing.T) {
+	appName := "framework_test_allreduce"
+	f0, f1, cleanup := startJob(t, appName, &testableTaskBuilder{}, nil)
+	defer cleanup()
+
+	sum := func(a, b []byte) []byte {
+		x, _ := strconv.Atoi(string(a))
+		y, _ := strconv.Atoi(string(b))
+		return []byte(strconv.Itoa(x + y))
+	}
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make(chan result, 2)
+	for i, f := range []*framework{f0, f1} {
+		go func(data []byte, f *framework) {
+			// Calls are matched by order.
+			for _, d := range [][]byte{data, append(data, '0')} {
+				r, err := f.AllReduce(d, sum)
+				results <- result{r, err}
+			}
+		}([]byte(strconv.Itoa(i+1)), f)
+	}
+	for _, want := range []string{"3", "3", "30", "30"} {
+		select {
+		case r := <-results:
+			if r.err != nil || string(r.data) != want {
+				t.Errorf("AllReduce = %s, %v, want %s", r.data, r.err, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("AllReduce didn't return")
+		}
+	}
+}
+
+// TestBarrier checks that tasks pass a barrier once all of them entered it,
+// and that waiting is canceled as the epoch changes.
+func TestBarrier(t *testing.T) {
+	appName := "framework_test_barrier"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	f0, f1 := startFrameworks(t, appName, job.url, &testableTaskBuilder{})
+	defer f0.ShutdownJob()
+
+	errs := make(chan error, 1)
+	go func() { errs <- f0.Barrier("load") }()
+	select {
+	case err := <-errs:
+		t.Fatalf("Barrier returned before task 1 entered: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := f1.Barrier("load"); err != nil {
+		t.Fatalf("Barrier failed: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Barrier failed: %v", err)
+	}
+
+	go func() { errs <- f0.Barrier("train") }()
+	for {
+		if _, err := job.client.Get(etcdutil.PhaseBarrierPath(appName, 0, "train"), false, false); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	f1.createContext().IncEpoch()
+	select {
+	case err := <-errs:
+		if !merrors.Is(err, merrors.ErrEpochConflict) {
+			t.Errorf("Barrier of epoch changed = %v, want ErrEpochConflict", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Barrier wasn't canceled")
+	}
+	for f0.GetEpoch() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestExit checks that a task exiting by itself is neither freed nor counted
+// as failed, while the rest of the job goes on.
+func TestExit(t *testing.T) {
+	appName := "framework_test_exit"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	taskBuilder := &testableTaskBuilder{exitChan: make(chan uint64, 2)}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	f1.Exit()
+	if id := <-taskBuilder.exitChan; id != 1 {
+		t.Errorf("exit task want = 1, get = %d", id)
+	}
+	for {
+		if _, err := job.client.Get(etcdutil.TaskMasterPath(appName, 1), false, false); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exited, err := etcdutil.IsTaskExited(job.client, appName, 1); !exited || err != nil {
+		t.Errorf("IsTaskExited = %v, %v", exited, err)
+	}
+	// Give failure detector of task 0 time to tell.
+	time.Sleep(100 * time.Millisecond)
+	if _, err := job.client.Get(etcdutil.FreeTaskPath(appName, "1"), false, false); err == nil {
+		t.Errorf("task exited has been freed")
+	}
+	select {
+	case id := <-taskBuilder.exitChan:
+		t.Errorf("task %d exited with task 1", id)
+	default:
+	}
+}
+
+// infoTaskBuilder passes what it's told of tasks built on infos.
+type infoTaskBuilder struct {
+	*testableTaskBuilder
+	infos chan meritop.TaskInfo
+}
+
+func (b *infoTaskBuilder) BuildTask(info meritop.TaskInfo) meritop.Task {
+	b.infos <- info
+	return b.GetTask(info.TaskID)
+}
+
+// TestTaskBuilderV2 checks that builders are told of the job the task is
+// part of.
+func TestTaskBuilderV2(t *testing.T) {
+	appName := "framework_test_taskbuilderv2"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+	if err := job.ctl.SetConfig(map[string]string{"masters": "0"}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	builder := &infoTaskBuilder{
+		testableTaskBuilder: &testableTaskBuilder{setupLatch: &wg},
+		infos:               make(chan meritop.TaskInfo, 2),
+	}
+	fs := make([]*framework, 2)
+	for i := range fs {
+		fs[i] = NewBootStrap(appName, []string{job.url}, createListener(t), nil).(*framework)
+		fs[i].SetTaskBuilder(builder)
+		fs[i].SetTopology(example.NewTreeTopology(2, 2))
+	}
+	wg.Add(2)
+	go fs[0].Start()
+	go fs[1].Start()
+	wg.Wait()
+	defer fs[0].ShutdownJob()
+
+	for i := 0; i < 2; i++ {
+		info := <-builder.infos
+		if info.NumOfTasks != 2 {
+			t.Errorf("task %d: NumOfTasks want = 2, get = %d", info.TaskID, info.NumOfTasks)
+		}
+		if v := info.Config.String("masters", ""); v != "0" {
+			t.Errorf("task %d: config of masters want = 0, get = %q", info.TaskID, v)
+		}
+		if p := info.Topology.GetParents(0); (info.TaskID == 0) != (len(p) == 0) {
+			t.Errorf("task %d: topology isn't set up for the task, parents = %v", info.TaskID, p)
+		}
+	}
+}
+
+// TestDataRequestFunc checks that responses, and failures, of requests with
+// a callback are passed to it rather than to the task.
+func TestDataRequestFunc(t *testing.T) {
+	appName := "framework_test_datarequestfunc"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	taskBuilder := &testableTaskBuilder{
+		dataMap:   map[string][]byte{"gradient": []byte("g")},
+		cDataChan: make(chan *tDataBundle, 1),
+	}
+	f0, _ := startFrameworks(t, appName, job.url, taskBuilder, WithMaxDataRequestAttempts(1))
+	defer f0.ShutdownJob()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make(chan result, 1)
+	fn := func(data []byte, err error) { results <- result{data, err} }
+	f0.createContext().DataRequestFunc(1, "gradient", fn)
+	if r := <-results; string(r.data) != "g" || r.err != nil {
+		t.Errorf("response = %s, %v, want g", r.data, r.err)
+	}
+	select {
+	case d := <-taskBuilder.cDataChan:
+		t.Errorf("response passed to task: %v", d)
+	default:
+	}
+	// Task 2 isn't part of the job.
+	f0.createContext().DataRequestFunc(2, "gradient", fn)
+	if r := <-results; r.err == nil {
+		t.Errorf("request to task 2 succeeded: %s", r.data)
+	}
+}
+
+type gatedTask struct {
+	*testableTask
+	gateChan chan struct{}
+}
+
+func (t *gatedTask) ReadyForEpoch(next uint64) bool {
+	select {
+	case <-t.gateChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *gatedTask) WaitReady() { <-t.gateChan }
+
+// TestEpochGate checks that tasks not ready for the next epoch hold their
+// switch to it until they are.
+func TestEpochGate(t *testing.T) {
+	appName := "framework_test_epochgate"
+	gateChan := make(chan struct{})
+	taskBuilder := &testableTaskBuilder{wrap: func(t *testableTask) meritop.Task { return &gatedTask{t, gateChan} }}
+	f0, _, cleanup := startJob(t, appName, taskBuilder, nil)
+	defer cleanup()
+
+	f0.incEpoch(0)
+	time.Sleep(100 * time.Millisecond)
+	if e := f0.GetEpoch(); e != 0 {
+		t.Fatalf("epoch of task not ready want = 0, get = %d", e)
+	}
+	close(gateChan)
+	for f0.GetEpoch() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// recordLogger keeps messages logged at each level.
+type recordLogger struct {
+	mu   sync.Mutex
+	logs map[string][]string
+}
+
+func (l *recordLogger) logf(level, format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs[level] = append(l.logs[level], fmt.Sprintf(format, v...))
+}
+
+func (l *recordLogger) Debugf(format string, v ...interface{}) { l.logf("debug", format, v...) }
+func (l *recordLogger) Infof(format string, v ...interface{})  { l.logf("info", format, v...) }
+func (l *recordLogger) Warnf(format string, v ...interface{})  { l.logf("warn", format, v...) }
+func (l *recordLogger) Errorf(format string, v ...interface{}) { l.logf("error", format, v...) }
+
+// TestWithLogger checks that framework, and tasks with GetLogger, log to the
+// logger given, annotated with the job, task and epoch.
+func TestWithLogger(t *testing.T) {
+	appName := "framework_test_withlogger"
+	l := &recordLogger{logs: make(map[string][]string)}
+	f0, _, cleanup := startJob(t, appName, &testableTaskBuilder{}, nil, WithLogger(l))
+	defer cleanup()
+
+	f0.GetLogger().Warnf("from task")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.logs["info"]) == 0 {
+		t.Errorf("nothing logged at info level")
+	}
+	want := "job=framework_test_withlogger task=0 epoch=0: from task"
+	if w := l.logs["warn"]; len(w) == 0 || w[len(w)-1] != want {
+		t.Errorf("warnings want = %q last, get = %q", want, w)
+	}
+}
+
+// TestStateChanges checks that framework tells where it is in its lifecycle.
+func TestStateChanges(t *testing.T) {
+	appName := "framework_test_statechanges"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	f0, _ := startFrameworks(t, appName, job.url, &testableTaskBuilder{})
+	states := f0.StateChanges()
+	waitState := func(want meritop.FrameworkState) {
+		for s := range states {
+			if s == want {
+				return
+			}
+		}
+		t.Fatalf("state %v not reached", want)
+	}
+	waitState(meritop.StateRunning)
+	f0.incEpoch(0)
+	waitState(meritop.StateEpochTransition)
+	waitState(meritop.StateRunning)
+	if s := f0.State(); s != meritop.StateRunning {
+		t.Errorf("State want = Running, get = %v", s)
+	}
+	f0.ShutdownJob()
+	waitState(meritop.StateStopping)
+	waitState(meritop.StateStopped)
+	if _, ok := <-states; ok {
+		t.Errorf("channel of state changes isn't closed once stopped")
+	}
+}
+
+func TestStop(t *testing.T) {
+	appName := "framework_test_stop"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	taskBuilder := &testableTaskBuilder{}
+	f0, f1 := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	// A standby stops while waiting for a free task.
+	standby := NewBootStrap(appName, []string{job.url}, createListener(t), nil).(*framework)
+	standby.SetTaskBuilder(taskBuilder)
+	standby.SetTopology(example.NewTreeTopology(2, 2))
+	states := standby.StateChanges()
+	returned := make(chan struct{})
+	go func() {
+		standby.Start()
+		close(returned)
+	}()
+	for s := range states {
+		if s == meritop.StateStarting {
+			break
+		}
+	}
+	standby.Stop()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Start of standby doesn't return after Stop")
+	}
+
+	f1.Stop()
+	if s := f1.State(); s != meritop.StateStopped {
+		t.Errorf("State want = Stopped, get = %v", s)
+	}
+	if _, err := f0.etcdClient.Get(etcdutil.FreeTaskPath(appName, "1"), false, false); err != nil {
+		t.Errorf("task 1 isn't freed after Stop: %v", err)
+	}
+}
+
+func TestRunner(t *testing.T) {
+	appName := "framework_test_runner"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	pDataChan := make(chan *tDataBundle, 1)
+	cDataChan := make(chan *tDataBundle, 1)
+	var wg sync.WaitGroup
+	taskBuilder := &testableTaskBuilder{
+		dataMap:    map[string][]byte{"req": []byte("resp")},
+		cDataChan:  cDataChan,
+		pDataChan:  pDataChan,
+		setupLatch: &wg,
+	}
+	r := NewRunner(appName, []string{job.url}, createListener(t))
+	defer r.Stop()
+	fs := make([]*framework, 2)
+	for i := range fs {
+		fs[i] = r.NewBootStrap().(*framework)
+		fs[i].SetTaskBuilder(taskBuilder)
+		fs[i].SetTopology(example.NewTreeTopology(2, 2))
+	}
+	wg.Add(2)
+	go r.Start()
+	wg.Wait()
+	f0 := fs[0]
+	if f0.GetTaskID() != 0 {
+		f0 = fs[1]
+	}
+	defer f0.ShutdownJob()
+
+	if fs[0].etcdClient != fs[1].etcdClient {
+		t.Errorf("frameworks hosted don't share etcd client")
+	}
+	addr, err := etcdutil.GetAddress(f0.etcdClient, appName, 1)
+	if err != nil {
+		t.Fatalf("GetAddress failed: %v", err)
+	}
+	if _, _, ok := frameworkhttp.SplitMuxAddr(addr); !ok {
+		t.Errorf("address want = host:port#slot, get = %s", addr)
+	}
+
+	f0.dataRequest(1, "req", 0)
+	<-pDataChan // served by child
+	data := <-cDataChan
+	expected := &tDataBundle{1, "", "req", []byte("resp")}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, data)
+	}
+}
+
+type typedPoint struct {
+	X, Y int
+}
+
+type typedTask struct {
+	*testableTask
+	typedChan chan interface{}
+}
+
+func (t *typedTask) Init(taskID uint64, framework meritop.Framework) {
+	framework.GetCodec().Register(typedPoint{})
+	t.testableTask.Init(taskID, framework)
+}
+
+func (t *typedTask) ServeAsParentTyped(fromID uint64, req string) interface{} {
+	return typedPoint{X: int(t.id), Y: len(req)}
+}
+
+func (t *typedTask) ServeAsChildTyped(fromID uint64, req string) interface{} {
+	return typedPoint{X: int(t.id), Y: len(req)}
+}
+
+func (t *typedTask) ParentTypedDataReady(ctx meritop.Context, parentID uint64, req string, v interface{}) {
+	t.typedChan <- v
+}
+
+func (t *typedTask) ChildTypedDataReady(ctx meritop.Context, childID uint64, req string, v interface{}) {
+	t.typedChan <- v
+}
+
+// TestTypedTask checks that values served by a TypedTask arrive at the
+// requester as values of the registered type.
+func TestTypedTask(t *testing.T) {
+	appName := "framework_test_typedtask"
+	typedChan := make(chan interface{}, 1)
+	f0, f1, cleanup := startJob(t, appName, &testableTaskBuilder{wrap: func(t *testableTask) meritop.Task { return &typedTask{t, typedChan} }}, nil)
+	defer cleanup()
+
+	f0.dataRequest(1, "point", 0)
+	if v, w := <-typedChan, (typedPoint{X: 1, Y: 5}); v != w {
+		t.Errorf("value from child want = %v, get = %v", w, v)
+	}
+	f1.dataRequest(0, "point", 0)
+	if v, w := <-typedChan, (typedPoint{X: 0, Y: 5}); v != w {
+		t.Errorf("value from parent want = %v, get = %v", w, v)
+	}
+}
+
+type configTask struct {
+	*testableTask
+	configChan chan meritop.Config
+}
+
+func (t *configTask) ConfigUpdated(ctx meritop.Context, config meritop.Config) {
+	if t.id == 0 {
+		t.configChan <- config
+	}
+}
+
+// TestConfigUpdated checks that changes of the config are told to running
+// tasks.
+func TestConfigUpdated(t *testing.T) {
+	appName := "framework_test_configupdated"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+	if err := job.ctl.SetConfig(map[string]string{"rate": "0.1"}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+
+	configChan := make(chan meritop.Config, 10)
+	f0, _ := startFrameworks(t, appName, job.url, &testableTaskBuilder{wrap: func(t *testableTask) meritop.Task { return &configTask{t, configChan} }})
+	defer f0.ShutdownJob()
+
+	if err := job.ctl.SetConfig(map[string]string{"rate": "0.2"}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	want := meritop.Config{"rate": "0.2"}
+	select {
+	case cfg := <-configChan:
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("config updated want = %v, get = %v", want, cfg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("config update isn't told to task")
+	}
+	if cfg := f0.Config(); !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Config want = %v, get = %v", want, cfg)
+	}
+}
+
+// TestPeerProtocolVersion checks that tasks register the protocol version they
+// talk, and features are kept from tasks of older versions.
+func TestPeerProtocolVersion(t *testing.T) {
+	appName := "framework_test_peerprotocolversion"
+	f0, _, cleanup := startJob(t, appName, &testableTaskBuilder{}, nil)
+	defer cleanup()
+
+	v, err := etcdutil.GetProtocolVersion(f0.etcdClient, appName, 1)
+	if err != nil {
+		t.Fatalf("GetProtocolVersion failed: %v", err)
+	}
+	if v != frameworkhttp.ProtocolVersion {
+		t.Errorf("version of task 1 want = %d, get = %d", frameworkhttp.ProtocolVersion, v)
+	}
+	if !f0.peerTalks(1, frameworkhttp.ProtocolV2) {
+		t.Errorf("task 1 is taken to talk a version before %d", frameworkhttp.ProtocolV2)
+	}
+
+	// Task 1 is taken over by a node of an older release.
+	if err := etcdutil.SetProtocolVersion(f0.etcdClient, appName, 1, frameworkhttp.ProtocolV1); err != nil {
+		t.Fatalf("SetProtocolVersion failed: %v", err)
+	}
+	for i := 0; f0.peerTalks(1, frameworkhttp.ProtocolV2); i++ {
+		if i == 50 {
+			t.Fatalf("task 1 is still taken to talk %d", frameworkhttp.ProtocolV2)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+type resizedTask struct {
+	*testableTask
+	resizeChan chan uint64
+}
+
+func (t *resizedTask) NumOfTasksChanged(ctx meritop.Context, numOfTasks uint64) {
+	t.resizeChan <- numOfTasks
+}
+
+// TestShrinkJob checks that tasks removed by the controller exit at the next
+// epoch, and aren't taken for failed.
+func TestShrinkJob(t *testing.T) {
+	appName := "framework_test_shrinkjob"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	resizeChan := make(chan uint64, 2)
+	taskBuilder := &testableTaskBuilder{
+		exitChan: make(chan uint64, 2),
+		wrap:     func(t *testableTask) meritop.Task { return &resizedTask{t, resizeChan} },
+	}
+	f0, _ := startFrameworks(t, appName, job.url, taskBuilder)
+	defer f0.ShutdownJob()
+
+	if err := job.ctl.Resize(1); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	f0.incEpoch(0)
+	if id := <-taskBuilder.exitChan; id != 1 {
+		t.Errorf("exit task want = 1, get = %d", id)
+	}
+	if n := <-resizeChan; n != 1 {
+		t.Errorf("NumOfTasksChanged want = 1, get = %d", n)
+	}
+	for {
+		if _, err := job.client.Get(etcdutil.TaskMasterPath(appName, 1), false, false); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give failure detector of task 0 time to tell.
+	time.Sleep(100 * time.Millisecond)
+	if _, err := job.client.Get(etcdutil.FreeTaskPath(appName, "1"), false, false); err == nil {
+		t.Errorf("task removed has been freed")
+	}
+}
+
+// TestStartRemoved checks that a task removed after it was taken, but before
+// it started, exits instead of running.
+func TestStartRemoved(t *testing.T) {
+	appName := "framework_test_startremoved"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	built := make(chan uint64, 2)
+	taskBuilder := &testableTaskBuilder{
+		wrap: func(t *testableTask) meritop.Task {
+			built <- t.id
+			return t
+		},
+	}
+	var wg sync.WaitGroup
+	taskBuilder.setupLatch = &wg
+	wg.Add(1)
+	// Free tasks are taken at random. Only task 0 is left for the first one.
+	free1 := etcdutil.FreeTaskPath(appName, "1")
+	if _, err := job.client.Delete(free1, false); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	f0 := NewBootStrap(appName, []string{job.url}, createListener(t), nil).(*framework)
+	f0.SetTaskBuilder(taskBuilder)
+	f0.SetTopology(example.NewTreeTopology(2, 2))
+	go f0.Start()
+	wg.Wait()
+	defer f0.ShutdownJob()
+
+	// The job shrinks as if resized while task 1 is being taken.
+	if err := etcdutil.SetNumOfTasks(job.client, appName, 1); err != nil {
+		t.Fatalf("SetNumOfTasks failed: %v", err)
+	}
+	if _, err := job.client.Set(free1, "", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	f1 := NewBootStrap(appName, []string{job.url}, createListener(t), nil).(*framework)
+	f1.SetTaskBuilder(taskBuilder)
+	f1.SetTopology(example.NewTreeTopology(2, 2))
+	f1.Start()
+
+	if id := f1.GetTaskID(); id != 1 {
+		t.Fatalf("task taken want = 1, get = %d", id)
+	}
+	if exited, err := etcdutil.IsTaskExited(job.client, appName, 1); !exited || err != nil {
+		t.Errorf("task 1 hasn't exited: %v", err)
+	}
+	if id := <-built; id != 0 {
+		t.Errorf("task built want = 0, get = %d", id)
+	}
+	select {
+	case id := <-built:
+		t.Errorf("task %d removed has been built", id)
+	default:
+	}
+}
+
+// TestEtcdRoot checks that jobs kept under a root run as others do.
+func TestEtcdRoot(t *testing.T) {
+	appName := "framework_test_etcdroot"
+	job, cleanup := setupJob(t, appName, func(ctl *controller.Controller) { ctl.SetRoot("team") })
+	defer cleanup()
+
+	f0, f1 := startFrameworks(t, appName, job.url, &testableTaskBuilder{}, WithEtcdRoot("team"))
+	defer f0.ShutdownJob()
+
+	if name := f1.GetJobName(); name != appName {
+		t.Errorf("GetJobName want = %s, get = %s", appName, name)
+	}
+	if _, err := job.client.Get(etcdutil.TaskMasterPath("team/"+appName, 1), false, false); err != nil {
+		t.Errorf("task 1 isn't registered under root: %v", err)
+	}
+	f0.incEpoch(0)
+	for {
+		epoch, err := job.ctl.Epoch()
+		if err != nil {
+			t.Fatalf("Epoch failed: %v", err)
+		}
+		if epoch == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestAutoCleanup checks that the controller cleans the job up once it has
+// been shut down, and all tasks have exited.
+func TestAutoCleanup(t *testing.T) {
+	appName := "framework_test_autocleanup"
+	m := etcdutil.StartNewEtcdServer(t, appName)
+	defer m.Terminate(t)
+	url := m.URL()
+
+	client := etcd.NewClient([]string{url})
+	ctl := controller.New(appName, client, 2)
+	archived := make(chan *controller.JobStatus, 1)
+	ctl.EnableAutoCleanup(func(s *controller.JobStatus) error {
+		archived <- s
+		return nil
+	})
+	if err := ctl.Start(); err != nil {
+		t.Fatalf("controller Start failed: %v", err)
+	}
+	defer ctl.Stop()
+
+	taskBuilder := &testableTaskBuilder{exitChan: make(chan uint64, 2)}
+	f0, _ := startFrameworks(t, appName, url, taskBuilder)
+	f0.ShutdownJob()
+
+	done := make(chan error, 1)
+	go func() { done <- ctl.WaitForCleanup() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitForCleanup failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("job isn't cleaned up")
+	}
+	s := <-archived
+	if !s.Done || s.Epoch != etcdutil.ExitEpoch {
+		t.Errorf("job archived = done %t at epoch %d, want done at exit epoch", s.Done, s.Epoch)
+	}
+	for _, task := range s.Tasks {
+		if !task.Exited || task.Free {
+			t.Errorf("task archived = %+v, want exited, and not free", task)
+		}
+	}
+	if _, err := client.Get("/"+appName, false, false); err == nil {
+		t.Errorf("etcd layout of job is left")
+	}
+	if jobs, _ := controller.ListJobs(client, ""); len(jobs) != 0 {
+		t.Errorf("jobs registered after cleanup = %+v, want none", jobs)
+	}
+}
+
+func TestTasksKeepLease(t *testing.T) {
+	appName := "framework_test_keeplease"
+	job, cleanup := setupJob(t, appName, func(ctl *controller.Controller) { ctl.SetTTL(time.Second) })
+	defer cleanup()
+
+	f0, _ := startFrameworks(t, appName, job.url, &testableTaskBuilder{})
+	defer f0.ShutdownJob()
+
+	// Only tasks keep the lease, without a controller running.
+	time.Sleep(2500 * time.Millisecond)
+	if _, ok, err := etcdutil.GetLease(job.client, appName); err != nil || !ok {
+		t.Fatalf("lease of job = %t, %v, want kept", ok, err)
+	}
+}
+
+func TestReportProgress(t *testing.T) {
+	appName := "framework_test_reportprogress"
+	job, cleanup := setupJob(t, appName, nil)
+	defer cleanup()
+
+	f0, f1 := startFrameworks(t, appName, job.url, &testableTaskBuilder{})
+	defer f0.ShutdownJob()
+
+	var ctx meritop.Context = f0.createContext()
+	ctx.(meritop.ProgressReporter).ReportProgress(meritop.Progress{Items: 10, Loss: 0.5})
+	ctx = f1.createContext()
+	ctx.(meritop.ProgressReporter).ReportProgress(meritop.Progress{Items: 20, Loss: 0.3, Duration: time.Hour})
+
+	eps, err := job.ctl.Progress()
+	if err != nil {
+		t.Fatalf("Progress failed: %v", err)
+	}
+	if len(eps) != 1 || eps[0].Epoch != 0 || eps[0].Items != 30 || eps[0].Duration != time.Hour {
+		t.Fatalf("progress = %+v, want 30 items in an hour at epoch 0", eps)
+	}
+	// Duration left out is since the task started the epoch.
+	if d := eps[0].Tasks[0].Duration; d <= 0 || d >= time.Hour {
+		t.Errorf("duration of task 0 = %v, want since epoch started", d)
+	}
+}
+
+func TestInjectedFaults(t *testing.T) {
+	appName := "framework_test_injectedfaults"
+	m := etcdutil.StartNewEtcdServer(t, appName)
+	defer m.Terminate(t)
+	url := m.URL()
+
+	client := etcd.NewClient([]string{url})
+	ctl := controller.New(appName, client, 2)
+	if err := ctl.Start(); err != nil {
+		t.Fatalf("controller start failed: %v", err)
+	}
+	defer ctl.Stop()
+
+	f0, f1 := startFrameworks(t, appName, url, &testableTaskBuilder{})
+	waitFor := func(what string, cond func() bool) {
+		for i := 0; !cond(); i++ {
+			if i == 100 {
+				t.Fatalf("%s timed out", what)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	free := func(id string) func() bool {
+		return func() bool {
+			_, err := client.Get(etcdutil.FreeTaskPath(appName, id), false, false)
+			return err == nil
+		}
+	}
+
+	// Health expired frees the task, although its node is still there.
+	if err := ctl.ExpireHealth(1); err != nil {
+		t.Fatalf("ExpireHealth failed: %v", err)
+	}
+	waitFor("freeing task with health expired", free("1"))
+	// A node whose registration has been scrambled is fenced.
+	if err := ctl.ScrambleRegistration(1); err != nil {
+		t.Fatalf("ScrambleRegistration failed: %v", err)
+	}
+	waitFor("stopping task with registration scrambled", func() bool { return f1.State() == meritop.StateStopped })
+	// A node whose task has been revoked is fenced, and the task is freed
+	// once its health expires.
+	if err := ctl.RevokeTask(0); err != nil {
+		t.Fatalf("RevokeTask failed: %v", err)
+	}
+	waitFor("stopping task revoked", func() bool { return f0.State() == meritop.StateStopped })
+	waitFor("freeing task revoked", free("0"))
+	if err := ctl.RevokeTask(0); err == nil {
+		t.Errorf("RevokeTask of task not registered succeeded")
+	}
+
+	events, err := ctl.Events()
+	if err != nil {
+		t.Fatalf("Events failed: %v", err)
+	}
+	var faults []string
+	for _, e := range events {
+		if e.Kind == etcdutil.EventFault {
+			faults = append(faults, fmt.Sprintf("%d: %s", e.TaskID, e.Detail))
+		}
+	}
+	want := []string{"1: health expired", "1: registration scrambled", "0: task revoked"}
+	if !reflect.DeepEqual(faults, want) {
+		t.Errorf("faults = %v, want %v", faults, want)
+	}
+}
@@ -0,0 +1,116 @@
+// Code generated by protoc-gen-go.
+// source: datarequest.proto
+// DO NOT EDIT!
+
+/*
+Package frameworkgrpc is a generated protocol buffer package.
+
+It is generated from these files:
+	datarequest.proto
+
+It has these top-level messages:
+	DataRequest
+	DataResponse
+*/
+package frameworkgrpc
+
+import proto "github.com/golang/protobuf/proto"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+
+// DataRequest is sent by task "taskID" to ask one of its neighbors for data.
+type DataRequest struct {
+	TaskID uint64 `protobuf:"varint,1,opt,name=taskID,proto3" json:"taskID,omitempty"`
+	Epoch  uint64 `protobuf:"varint,2,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	Req    string `protobuf:"bytes,3,opt,name=req,proto3" json:"req,omitempty"`
+}
+
+func (m *DataRequest) Reset()         { *m = DataRequest{} }
+func (m *DataRequest) String() string { return proto.CompactTextString(m) }
+func (*DataRequest) ProtoMessage()    {}
+
+type DataResponse struct {
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	// epoch in which the data has been served.
+	Epoch uint64 `protobuf:"varint,2,opt,name=epoch,proto3" json:"epoch,omitempty"`
+}
+
+func (m *DataResponse) Reset()         { *m = DataResponse{} }
+func (m *DataResponse) String() string { return proto.CompactTextString(m) }
+func (*DataResponse) ProtoMessage()    {}
+
+func init() {
+}
+
+// Client API for DataService service
+
+type DataServiceClient interface {
+	GetData(ctx context.Context, in *DataRequest, opts ...grpc.CallOption) (*DataResponse, error)
+}
+
+type dataServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewDataServiceClient(cc *grpc.ClientConn) DataServiceClient {
+	return &dataServiceClient{cc}
+}
+
+func (c *dataServiceClient) GetData(ctx context.Context, in *DataRequest, opts ...grpc.CallOption) (*DataResponse, error) {
+	out := new(DataResponse)
+	err := grpc.Invoke(ctx, "/frameworkgrpc.DataService/GetData", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for DataService service
+
+type DataServiceServer interface {
+	GetData(context.Context, *DataRequest) (*DataResponse, error)
+}
+
+func RegisterDataServiceServer(s *grpc.Server, srv DataServiceServer) {
+	s.RegisterService(&_DataService_serviceDesc, srv)
+}
+
+func _DataService_GetData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DataServiceServer).GetData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/frameworkgrpc.DataService/GetData",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DataServiceServer).GetData(ctx, req.(*DataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _DataService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "frameworkgrpc.DataService",
+	HandlerType: (*DataServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetData",
+			Handler:    _DataService_GetData_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
@@ -0,0 +1,125 @@
+package frameworkgrpc
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	merrors "github.com/go-distributed/meritop/errors"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Transport is a gRPC based transport for framework data requests. Unlike
+// the HTTP one, connections to other tasks are kept open and reused, and
+// failures are reported with gRPC status codes.
+// It can be plugged in with framework.WithTransport.
+type Transport struct {
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func NewTransport() *Transport {
+	return &Transport{conns: make(map[string]*grpc.ClientConn)}
+}
+
+func (t *Transport) Serve(ln net.Listener, dg frameworkhttp.DataGetter) error {
+	s := grpc.NewServer()
+	RegisterDataServiceServer(s, &dataServer{dg})
+	return s.Serve(ln)
+}
+
+func (t *Transport) Send(ctx context.Context, addr, req string, from, to, epoch uint64) (*frameworkhttp.DataResponse, error) {
+	cc, err := t.getConn(addr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := NewDataServiceClient(cc).GetData(ctx, &DataRequest{
+		TaskID: from,
+		Epoch:  epoch,
+		Req:    req,
+	})
+	if err != nil {
+		switch grpc.Code(err) {
+		case codes.FailedPrecondition:
+			return nil, frameworkhttp.ErrReqEpochMismatch
+		case codes.Aborted:
+			return nil, frameworkhttp.ErrServerClosed
+		case codes.ResourceExhausted:
+			// The task isn't ready to serve the data yet.
+			return nil, &frameworkhttp.TooManyRequestsError{RetryAfter: time.Second}
+		case codes.InvalidArgument:
+			return nil, frameworkhttp.ErrBadRequest
+		case codes.Internal:
+			return nil, frameworkhttp.ErrServeFailed
+		case codes.Unavailable:
+			// The task at addr might have failed. Its replacement will
+			// register a different address, so don't keep the connection.
+			t.closeConn(addr, cc)
+		}
+		return nil, err
+	}
+	if resp.Epoch != epoch {
+		return nil, frameworkhttp.ErrReqEpochMismatch
+	}
+	return &frameworkhttp.DataResponse{
+		TaskID: to,
+		Epoch:  epoch,
+		Req:    req,
+		Data:   resp.Data,
+	}, nil
+}
+
+func (t *Transport) getConn(addr string) (*grpc.ClientConn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cc, ok := t.conns[addr]; ok {
+		return cc, nil
+	}
+	target, opts := addr, []grpc.DialOption{grpc.WithInsecure()}
+	if path, ok := frameworkhttp.UnixSocketPath(addr); ok {
+		target = path
+		opts = append(opts, grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+	}
+	cc, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	t.conns[addr] = cc
+	return cc, nil
+}
+
+func (t *Transport) closeConn(addr string, cc *grpc.ClientConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns[addr] == cc {
+		delete(t.conns, addr)
+		cc.Close()
+	}
+}
+
+type dataServer struct {
+	dg frameworkhttp.DataGetter
+}
+
+func (s *dataServer) GetData(ctx context.Context, in *DataRequest) (*DataResponse, error) {
+	b, err := s.dg.GetTaskData(in.TaskID, in.Epoch, in.Req)
+	switch {
+	case err == nil:
+		return &DataResponse{Data: b, Epoch: in.Epoch}, nil
+	case err == frameworkhttp.ErrReqEpochMismatch:
+		return nil, grpc.Errorf(codes.FailedPrecondition, "%v", err)
+	case err == frameworkhttp.ErrServerClosed:
+		return nil, grpc.Errorf(codes.Aborted, "%v", err)
+	case merrors.Is(err, merrors.ErrNotReady):
+		return nil, grpc.Errorf(codes.ResourceExhausted, "%v", err)
+	case merrors.Is(err, merrors.ErrBadRequest):
+		return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+	default:
+		return nil, grpc.Errorf(codes.Internal, "%v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package frameworkgrpc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"golang.org/x/net/context"
+)
+
+type tDataGetter struct{ epoch uint64 }
+
+func (g *tDataGetter) GetTaskData(taskID, epoch uint64, req string) ([]byte, error) {
+	if epoch != g.epoch {
+		return nil, frameworkhttp.ErrReqEpochMismatch
+	}
+	return []byte(req), nil
+}
+
+func TestTransport(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	defer ln.Close()
+	tr := NewTransport()
+	go tr.Serve(ln, &tDataGetter{epoch: 1})
+
+	tests := []struct {
+		epoch uint64
+		req   string
+		err   error
+	}{
+		{1, "parameters", nil},
+		{1, "gradient", nil},
+		{2, "parameters", frameworkhttp.ErrReqEpochMismatch},
+	}
+	for i, tt := range tests {
+		resp, err := tr.Send(context.Background(), ln.Addr().String(), tt.req, 1, 0, tt.epoch)
+		if err != tt.err {
+			t.Errorf("#%d: error want = %v, get = %v", i, tt.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if resp.TaskID != 0 || resp.Epoch != tt.epoch || resp.Req != tt.req ||
+			!bytes.Equal(resp.Data, []byte(tt.req)) {
+			t.Errorf("#%d: unexpected response: %v", i, resp)
+		}
+	}
+}
+
+func TestTransportUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frameworkgrpc")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ln, err := net.Listen("unix", filepath.Join(dir, "task.sock"))
+	if err != nil {
+		t.Fatalf("net.Listen(\"unix\") failed: %v", err)
+	}
+	defer ln.Close()
+	tr := NewTransport()
+	go tr.Serve(ln, &tDataGetter{epoch: 1})
+
+	resp, err := tr.Send(context.Background(), frameworkhttp.ListenerAddr(ln), "parameters", 1, 0, 1)
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !bytes.Equal(resp.Data, []byte("parameters")) {
+		t.Errorf("data want = %s, get = %s", "parameters", resp.Data)
+	}
+}
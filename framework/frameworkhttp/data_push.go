@@ -0,0 +1,94 @@
+package frameworkhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// DataPushPrefix is the path data is pushed to. The body of a push is the
+// JSON envelope of a data request, followed by a newline and the raw data.
+const DataPushPrefix string = "/datapush"
+
+// DataPushReceiver can be implemented by DataGetter to accept data pushed by
+// other tasks.
+type DataPushReceiver interface {
+	PushTaskData(fromID, epoch uint64, req string, data []byte) error
+}
+
+func (h *dataReqHandler) servePush(w http.ResponseWriter, r *http.Request) {
+	pr, ok := h.DataGetter.(DataPushReceiver)
+	if !ok {
+		http.Error(w, "data push not accepted", http.StatusNotImplemented)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusBadRequest)
+		return
+	}
+	dec := json.NewDecoder(r.Body)
+	var body dataRequestBody
+	if err := dec.Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("bad push body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if h.fenced(w, r, body.TaskID) {
+		return
+	}
+	if h.throttle(w, body.TaskID) {
+		return
+	}
+	data, err := ioutil.ReadAll(io.MultiReader(dec.Buffered(), r.Body))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("reading pushed data failed: %v", err), http.StatusBadRequest)
+		return
+	}
+	data = bytes.TrimPrefix(data, []byte("\n"))
+	if err := pr.PushTaskData(body.TaskID, body.Epoch, string(body.Req), data); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.Header().Set(DataResponseEpoch, strconv.FormatUint(body.Epoch, 10))
+}
+
+func pushData(ctx context.Context, client *http.Client, scheme, token, addr string, req string, from, epoch uint64, data []byte) error {
+	u := url.URL{
+		Scheme: scheme,
+		Host:   addr,
+		Path:   DataPushPrefix,
+	}
+	env, err := json.Marshal(&dataRequestBody{
+		TaskID: from,
+		Epoch:  epoch,
+		Req:    []byte(req),
+	})
+	if err != nil {
+		return err
+	}
+	body := io.MultiReader(bytes.NewReader(env), bytes.NewReader([]byte("\n")), bytes.NewReader(data))
+	hreq, err := http.NewRequest("POST", u.String(), body)
+	if err != nil {
+		return err
+	}
+	hreq.ContentLength = int64(len(env) + 1 + len(data))
+	if token != "" {
+		hreq.Header.Set(DataRequestAuth, token)
+	}
+	resp, err := ctxhttp.Do(ctx, client, hreq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	return nil
+}
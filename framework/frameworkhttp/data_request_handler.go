@@ -1,33 +1,82 @@
 package frameworkhttp
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
+
+	merrors "github.com/go-distributed/meritop/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
 )
 
 var (
-	ErrReqEpochMismatch error = errors.New("data request error: epoch mismatch")
+	ErrReqEpochMismatch error = merrors.New(merrors.ErrEpochConflict, "data request error: epoch mismatch")
 	ErrServerClosed     error = errors.New("server has been closed")
+	ErrChecksumMismatch error = errors.New("data request error: checksum mismatch")
+	ErrUnauthorized     error = merrors.New(merrors.ErrUnauthorized, "data request error: unauthorized")
+	// ErrBadRequest is returned for requests the serving task won't ever
+	// serve, and ErrServeFailed for ones it failed to serve this time.
+	ErrBadRequest  error = merrors.New(merrors.ErrBadRequest, "data request error: bad request")
+	ErrServeFailed error = errors.New("data request error: task failed to serve")
 )
 
+// notReadyRetryAfter is how long requesters wait for a task which isn't ready
+// to serve the data.
+const notReadyRetryAfter = time.Second
+
 const (
 	DataRequestPrefix string = "/datareq"
 	DataRequestTaskID string = "taskID"
 	DataRequestReq    string = "req"
 	DataRequestEpoch  string = "epoch"
+
+	// DataResponseEpoch is the header stamping the epoch in which the data
+	// has been served. Responses of other epochs are rejected by requester.
+	DataResponseEpoch string = "X-Epoch"
+
+	// DataChecksum carries the CRC-32C of the data in hex. It's sent as a
+	// trailer when data is streamed. Requester verifies it after reading the
+	// whole data, so truncated or corrupted data is never passed to task.
+	DataChecksum string = "X-Crc32c"
+
+	// DataRequestAuth carries the auth token of the job. Tasks serving with a
+	// token reject requests without it.
+	DataRequestAuth string = "X-Auth-Token"
 )
 
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 type DataGetter interface {
 	GetTaskData(uint64, uint64, string) ([]byte, error)
 }
 
+// DataStreamGetter can be implemented by DataGetter to serve data as a stream.
+// The handler copies the stream into response body and closes it.
+type DataStreamGetter interface {
+	GetTaskDataStream(uint64, uint64, string) (io.ReadCloser, error)
+}
+
 type dataReqHandler struct {
 	logger *log.Logger
 	DataGetter
+	// limiter is nil if requests aren't rate limited.
+	limiter *rateLimiter
+	// token is empty if requests aren't authenticated.
+	token string
+	// stale is nil if requests aren't fenced.
+	stale StaleFunc
 }
 
 type DataResponse struct {
@@ -35,6 +84,9 @@ type DataResponse struct {
 	Epoch  uint64
 	Req    string
 	Data   []byte
+	// Body is set instead of Data by streamed requests. Receiver needs to
+	// close it.
+	Body io.ReadCloser
 }
 
 func NewDataRequestHandler(logger *log.Logger, dg DataGetter) http.Handler {
@@ -44,75 +96,308 @@ func NewDataRequestHandler(logger *log.Logger, dg DataGetter) http.Handler {
 	}
 }
 
+// NewRateLimitedHandler is like NewDataRequestHandler, but requests over the
+// given limit are rejected with status 429 and a Retry-After header.
+func NewRateLimitedHandler(logger *log.Logger, dg DataGetter, rl RateLimit) http.Handler {
+	return &dataReqHandler{
+		logger:     logger,
+		DataGetter: dg,
+		limiter:    newRateLimiter(rl),
+	}
+}
+
 func (h *dataReqHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(DataRequestAuth)), []byte(h.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if h.unsupportedVersion(w, r) {
+		return
+	}
+	if r.URL.Path == DataPushPrefix {
+		h.servePush(w, r)
+		return
+	}
 	if r.URL.Path != DataRequestPrefix {
 		http.Error(w, "bad path", http.StatusBadRequest)
 		return
 	}
-	// parse url query
-	q := r.URL.Query()
-	fromIDStr := q.Get(DataRequestTaskID)
-	fromID, err := strconv.ParseUint(fromIDStr, 0, 64)
+	body, err := parseDataRequest(r)
 	if err != nil {
-		h.logger.Panic("Internal error: fromID couldn't be parsed")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	epochStr := q.Get(DataRequestEpoch)
-	epoch, err := strconv.ParseUint(epochStr, 0, 64)
-	if err != nil {
-		h.logger.Panic("Internal error: epoch couldn't be parsed")
+	if h.fenced(w, r, body.TaskID) {
+		return
 	}
-	req := q.Get(DataRequestReq)
+	if h.throttle(w, body.TaskID) {
+		return
+	}
+	if len(body.Reqs) > 0 {
+		h.serveMulti(w, body)
+		return
+	}
+	fromID, epoch, req := body.TaskID, body.Epoch, string(body.Req)
+	// Requests resuming a broken transfer ask for the data after what they
+	// already have.
+	offset := rangeOffset(r)
+	epochStr := strconv.FormatUint(epoch, 10)
 
-	b, err := h.GetTaskData(fromID, epoch, req)
-	if err != nil {
-		if err == ErrReqEpochMismatch || err == ErrServerClosed {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
+	if sg, ok := h.DataGetter.(DataStreamGetter); ok {
+		rc, err := sg.GetTaskDataStream(fromID, epoch, req)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		defer rc.Close()
+		w.Header().Set("Trailer", DataChecksum)
+		w.Header().Set(DataResponseEpoch, epochStr)
+		// Checksum always covers the whole data, including skipped part.
+		crc := crc32.New(crc32cTable)
+		if offset > 0 {
+			if _, err := io.CopyN(crc, rc, offset); err != nil {
+				http.Error(w, "range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		if _, err := io.Copy(io.MultiWriter(w, crc), rc); err != nil {
+			log.Printf("http: response write failed: %v", err)
 			return
 		}
-		h.logger.Panic("unimplemented")
+		w.Header().Set(DataChecksum, formatChecksum(crc.Sum32()))
+		return
+	}
+
+	b, err := h.GetTaskData(fromID, epoch, req)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.Header().Set(DataResponseEpoch, epochStr)
+	w.Header().Set(DataChecksum, formatChecksum(crc32.Checksum(b, crc32cTable)))
+	if offset > int64(len(b)) {
+		http.Error(w, "range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if offset > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(b)-1, len(b)))
+		w.WriteHeader(http.StatusPartialContent)
+		b = b[offset:]
 	}
 	if _, err := w.Write(b); err != nil {
 		log.Printf("http: response write failed: %v", err)
 	}
 }
 
+// dataRequestBody is the envelope of data requests POSTed to DataRequestPrefix.
+// Req is encoded as base64 in JSON, so it can carry any bytes. Reqs is set
+// instead of Req by multi-key requests.
+type dataRequestBody struct {
+	TaskID uint64   `json:"taskID"`
+	Epoch  uint64   `json:"epoch"`
+	Req    []byte   `json:"req"`
+	Reqs   [][]byte `json:"reqs,omitempty"`
+}
+
+// parseDataRequest reads parameters of a data request from its body. Requests
+// from older tasks are sent with GET and have them in the url query instead.
+func parseDataRequest(r *http.Request) (*dataRequestBody, error) {
+	if r.Method == "GET" {
+		q := r.URL.Query()
+		fromID, err := strconv.ParseUint(q.Get(DataRequestTaskID), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %v", DataRequestTaskID, err)
+		}
+		epoch, err := strconv.ParseUint(q.Get(DataRequestEpoch), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %v", DataRequestEpoch, err)
+		}
+		return &dataRequestBody{
+			TaskID: fromID,
+			Epoch:  epoch,
+			Req:    []byte(q.Get(DataRequestReq)),
+		}, nil
+	}
+	if r.Method != "POST" {
+		return nil, fmt.Errorf("method %s not allowed", r.Method)
+	}
+	body := new(dataRequestBody)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		return nil, fmt.Errorf("bad request body: %v", err)
+	}
+	return body, nil
+}
+
+func (h *dataReqHandler) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case err == ErrReqEpochMismatch:
+		w.WriteHeader(http.StatusInternalServerError)
+	case err == ErrServerClosed:
+		// The node has stopped, though a kept-alive connection could still
+		// reach it. Client should find the task elsewhere.
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case merrors.Is(err, merrors.ErrNotReady):
+		// Client retries later, as if the task was busy.
+		w.Header().Set("Retry-After", strconv.Itoa(int(notReadyRetryAfter.Seconds())))
+		w.WriteHeader(statusTooManyRequests)
+	case merrors.Is(err, merrors.ErrBadRequest):
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		// The task failed to serve the request.
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	w.Write([]byte(err.Error()))
+}
+
 func RequestData(addr string, req string, from, to, epoch uint64, logger *log.Logger) (*DataResponse, error) {
+	return requestData(context.Background(), http.DefaultClient, "http", "", addr, req, from, to, epoch, nil)
+}
+
+// requestData gets the whole data of the request. If partial data is given,
+// only the rest of it is asked for. Data read before a failure is returned in
+// PartialDataError, so the transfer can be resumed.
+func requestData(ctx context.Context, client *http.Client, scheme, token, addr string, req string, from, to, epoch uint64, partial []byte) (*DataResponse, error) {
+	d, err := requestDataStream(ctx, client, scheme, token, addr, req, from, to, epoch, partial)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Body.Close()
+	d.Data, err = ioutil.ReadAll(d.Body)
+	if err == ErrChecksumMismatch {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &PartialDataError{
+			Data: d.Data,
+			Err:  fmt.Errorf("http: reading response body failed: %v", err),
+		}
+	}
+	d.Body = nil
+	return d, nil
+}
+
+// requestDataStream sends the data request and returns the response body
+// unread in DataResponse.Body. Body starts with partial data, if given, and
+// has the rest of data following.
+func requestDataStream(ctx context.Context, client *http.Client, scheme, token, addr string, req string, from, to, epoch uint64, partial []byte) (*DataResponse, error) {
 	u := url.URL{
-		Scheme: "http",
+		Scheme: scheme,
 		Host:   addr,
 		Path:   DataRequestPrefix,
 	}
-	q := u.Query()
-	q.Add(DataRequestTaskID, strconv.FormatUint(from, 10))
-	q.Add(DataRequestReq, req)
-	q.Add(DataRequestEpoch, strconv.FormatUint(epoch, 10))
-	u.RawQuery = q.Encode()
-	urlStr := u.String()
+	body, err := json.Marshal(&dataRequestBody{
+		TaskID: from,
+		Epoch:  epoch,
+		Req:    []byte(req),
+	})
+	if err != nil {
+		return nil, err
+	}
 	// send request
 	// pass the response to the awaiting event loop for data response
-	resp, err := http.Get(urlStr)
+	hreq, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		hreq.Header.Set(DataRequestAuth, token)
+	}
+	if len(partial) > 0 {
+		hreq.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(partial)))
+	}
+	resp, err := ctxhttp.Do(ctx, client, hreq)
 	if err != nil {
 		// The error could be caused because: 1. network failure; 2. We might have
 		// sent request to failed server.
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusInternalServerError {
-			// Now assuming only epoch mismatch can cause this error.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, statusError(resp)
+	}
+	// Servers not stamping the epoch have checked it against request anyway.
+	if s := resp.Header.Get(DataResponseEpoch); s != "" {
+		ep, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || ep != epoch {
+			resp.Body.Close()
 			return nil, ErrReqEpochMismatch
 		}
-		logger.Fatalf("http: response code = %d, expect = %d", resp.StatusCode, 200)
-	}
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logger.Fatalf("http: ioutil.ReadAll(%v) returns error: %v", resp.Body, err)
 	}
 	return &DataResponse{
 		TaskID: to,
 		Epoch:  epoch,
 		Req:    req,
-		Data:   data,
+		Body:   newChecksumReader(resp, partial),
 	}, nil
 }
+
+// statusError maps the status of a failed response to the error returned by
+// server.
+func statusError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusInternalServerError:
+		// Now assuming only epoch mismatch can cause this error.
+		return ErrReqEpochMismatch
+	case http.StatusServiceUnavailable:
+		return ErrServerClosed
+	case statusTooManyRequests:
+		return parseRetryAfter(resp)
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusConflict:
+		return ErrStaleGeneration
+	case http.StatusRequestedRangeNotSatisfiable:
+		return errRangeNotSatisfiable
+	case http.StatusBadRequest:
+		return ErrBadRequest
+	case http.StatusPreconditionFailed:
+		return ErrProtocolVersion
+	case http.StatusBadGateway:
+		return ErrServeFailed
+	}
+	return fmt.Errorf("http: response code = %d, expect = %d", resp.StatusCode, 200)
+}
+
+func formatChecksum(sum uint32) string {
+	return strconv.FormatUint(uint64(sum), 16)
+}
+
+// checksumReader reads the response body, and returns ErrChecksumMismatch
+// instead of io.EOF if the data doesn't match the checksum sent by server.
+// Servers not sending checksums aren't verified.
+type checksumReader struct {
+	resp *http.Response
+	r    io.Reader
+	crc  hash.Hash32
+}
+
+// newChecksumReader reads the response body. If server only sent the data
+// after partial, partial is read first, since checksum covers the whole data.
+func newChecksumReader(resp *http.Response, partial []byte) *checksumReader {
+	r := &checksumReader{resp: resp, r: resp.Body, crc: crc32.New(crc32cTable)}
+	if resp.StatusCode == http.StatusPartialContent {
+		r.r = io.MultiReader(bytes.NewReader(partial), resp.Body)
+	}
+	return r
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+	// Trailer is only available after body has been read through.
+	expected := r.resp.Header.Get(DataChecksum)
+	if expected == "" {
+		expected = r.resp.Trailer.Get(DataChecksum)
+	}
+	if expected != "" && expected != formatChecksum(r.crc.Sum32()) {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
+
+func (r *checksumReader) Close() error { return r.resp.Body.Close() }
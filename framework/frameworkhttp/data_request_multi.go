@@ -0,0 +1,132 @@
+package frameworkhttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+var errBadParts = errors.New("http: malformed multi-part data")
+
+// serveMulti serves a multi-key request. Data of every key is framed in
+// response body as its length in uvarint followed by the data, in the order
+// of keys. Either all keys are served or none.
+func (h *dataReqHandler) serveMulti(w http.ResponseWriter, body *dataRequestBody) {
+	parts := make([][]byte, len(body.Reqs))
+	for i, req := range body.Reqs {
+		b, err := h.GetTaskData(body.TaskID, body.Epoch, string(req))
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		parts[i] = b
+	}
+	var buf bytes.Buffer
+	lb := make([]byte, binary.MaxVarintLen64)
+	for _, p := range parts {
+		buf.Write(lb[:binary.PutUvarint(lb, uint64(len(p)))])
+		buf.Write(p)
+	}
+	w.Header().Set(DataResponseEpoch, strconv.FormatUint(body.Epoch, 10))
+	w.Header().Set(DataChecksum, formatChecksum(crc32.Checksum(buf.Bytes(), crc32cTable)))
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("http: response write failed: %v", err)
+	}
+}
+
+// readParts reads n parts framed by serveMulti.
+func readParts(r io.Reader, n int) ([][]byte, error) {
+	br := bufio.NewReader(r)
+	parts := make([][]byte, n)
+	for i := range parts {
+		l, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, errBadParts
+		}
+		parts[i] = make([]byte, l)
+		if _, err := io.ReadFull(br, parts[i]); err != nil {
+			return nil, errBadParts
+		}
+	}
+	// The checksum is verified once the body is read through.
+	if _, err := ioutil.ReadAll(br); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// requestMulti asks for the data of several keys in one round trip. Responses
+// are returned in the order of keys.
+func requestMulti(ctx context.Context, client *http.Client, scheme, token, addr string, reqs []string, from, to, epoch uint64) ([]*DataResponse, error) {
+	u := url.URL{
+		Scheme: scheme,
+		Host:   addr,
+		Path:   DataRequestPrefix,
+	}
+	rb := &dataRequestBody{
+		TaskID: from,
+		Epoch:  epoch,
+		Reqs:   make([][]byte, len(reqs)),
+	}
+	for i, req := range reqs {
+		rb.Reqs[i] = []byte(req)
+	}
+	body, err := json.Marshal(rb)
+	if err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		hreq.Header.Set(DataRequestAuth, token)
+	}
+	resp, err := ctxhttp.Do(ctx, client, hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	if resp.Header.Get(DataResponseEpoch) != strconv.FormatUint(epoch, 10) {
+		return nil, ErrReqEpochMismatch
+	}
+	parts, err := readParts(newChecksumReader(resp, nil), len(reqs))
+	if err == ErrChecksumMismatch || err == errBadParts {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("http: reading response body failed: %v", err)
+	}
+	ds := make([]*DataResponse, len(reqs))
+	for i, req := range reqs {
+		ds[i] = &DataResponse{
+			TaskID: to,
+			Epoch:  epoch,
+			Req:    req,
+			Data:   parts[i],
+		}
+	}
+	return ds, nil
+}
+
+// SendMulti is like Send, but asks for the data of several keys at once.
+func (t *Transport) SendMulti(ctx context.Context, addr string, reqs []string, from, to, epoch uint64) ([]*DataResponse, error) {
+	return requestMulti(ctx, t.clientFor(to, addr), t.scheme(), t.authToken, urlHost(addr), reqs, from, to, epoch)
+}
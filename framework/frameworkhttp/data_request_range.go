@@ -0,0 +1,33 @@
+package frameworkhttp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errRangeNotSatisfiable = errors.New("http: range not satisfiable")
+
+// PartialDataError is returned when a transfer breaks after some data has been
+// received. The request can be resumed with the data received.
+type PartialDataError struct {
+	Data []byte
+	Err  error
+}
+
+func (e *PartialDataError) Error() string { return e.Err.Error() }
+
+// rangeOffset returns where the requested data starts. Only ranges in the form
+// "bytes=N-" are supported; others are ignored and the whole data is served.
+func rangeOffset(r *http.Request) int64 {
+	s := r.Header.Get("Range")
+	if !strings.HasPrefix(s, "bytes=") || !strings.HasSuffix(s, "-") {
+		return 0
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(s, "bytes="), "-"), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
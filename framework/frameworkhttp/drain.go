@@ -0,0 +1,61 @@
+package frameworkhttp
+
+import (
+	"net/http"
+	"sync"
+)
+
+// drainHandler keeps track of requests being handled, so that Serve can wait
+// for them once the listener is closed. Requests arriving on kept-alive
+// connections after that are turned away.
+type drainHandler struct {
+	http.Handler
+
+	mu      sync.Mutex
+	active  int
+	closed  bool
+	drained chan struct{}
+}
+
+func newDrainHandler(h http.Handler) *drainHandler {
+	return &drainHandler{Handler: h, drained: make(chan struct{})}
+}
+
+func (d *drainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !d.enter() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer d.leave()
+	d.Handler.ServeHTTP(w, r)
+}
+
+func (d *drainHandler) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	d.active++
+	return true
+}
+
+func (d *drainHandler) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.active--
+	if d.closed && d.active == 0 {
+		close(d.drained)
+	}
+}
+
+// drain turns away new requests and waits for those being handled.
+func (d *drainHandler) drain() {
+	d.mu.Lock()
+	d.closed = true
+	if d.active == 0 {
+		close(d.drained)
+	}
+	d.mu.Unlock()
+	<-d.drained
+}
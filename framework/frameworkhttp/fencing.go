@@ -0,0 +1,42 @@
+package frameworkhttp
+
+import (
+	"net/http"
+	"strconv"
+
+	merrors "github.com/go-distributed/meritop/errors"
+)
+
+// ErrStaleGeneration is returned for requests sent by a node which has lost its
+// task to another one.
+var ErrStaleGeneration = merrors.New(merrors.ErrTaskFenced, "data request error: stale generation")
+
+// DataRequestGeneration carries the generation of the requesting task, i.e.
+// how many times the task has been taken by a node. Tasks serving with fencing
+// reject requests of older generations with status 409.
+const DataRequestGeneration = "X-Generation"
+
+// StaleFunc tells if a node working for the task in the given generation has
+// lost it to another one.
+type StaleFunc func(taskID, generation uint64) bool
+
+// SetFencing makes the transport send generation with every request, and
+// reject requests of generations stale tells. It needs to be set before Serve.
+func (t *Transport) SetFencing(generation uint64, stale StaleFunc) {
+	t.generation = generation
+	t.stale = stale
+}
+
+// fenced rejects the request if its sender has lost the task. Requests without
+// generation, e.g. by transports not fencing, aren't checked.
+func (h *dataReqHandler) fenced(w http.ResponseWriter, r *http.Request, taskID uint64) bool {
+	if h.stale == nil {
+		return false
+	}
+	gen, err := strconv.ParseUint(r.Header.Get(DataRequestGeneration), 10, 64)
+	if err != nil || !h.stale(taskID, gen) {
+		return false
+	}
+	http.Error(w, ErrStaleGeneration.Error(), http.StatusConflict)
+	return true
+}
@@ -0,0 +1,193 @@
+package frameworkhttp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// muxSep separates the address of a Mux from the slot of a listener on it,
+// e.g. "127.0.0.1:8000#3".
+const muxSep = "#"
+
+// muxPreambleTimeout bounds the time a connection to a Mux takes to tell the
+// slot it's for.
+const muxPreambleTimeout = 10 * time.Second
+
+var errMuxClosed = errors.New("frameworkhttp: mux closed")
+
+// SplitMuxAddr splits an address of a listener on a Mux into the address of
+// the Mux and the slot. ok is false for addresses of other listeners.
+func SplitMuxAddr(addr string) (base string, slot uint64, ok bool) {
+	i := strings.LastIndex(addr, muxSep)
+	if i < 0 {
+		return addr, 0, false
+	}
+	slot, err := strconv.ParseUint(addr[i+len(muxSep):], 10, 64)
+	if err != nil {
+		return addr, 0, false
+	}
+	return addr[:i], slot, true
+}
+
+// Mux shares one listener among tasks hosted in the same process. Each of
+// them listens on a slot of the Mux, and has an address of its own, so that
+// they are registered in etcd like tasks listening on their own. Transport
+// dialing such an address tells the slot first, and the Mux hands the
+// connection over to the listener of it.
+type Mux struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	next   uint64
+	slots  map[uint64]*muxListener
+	closed bool
+}
+
+func NewMux(ln net.Listener) *Mux {
+	return &Mux{
+		ln:    ln,
+		slots: make(map[uint64]*muxListener),
+	}
+}
+
+// Listen returns a listener on a new slot of the Mux.
+func (m *Mux) Listen() net.Listener {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.listenLocked(m.next)
+	m.next++
+	return l
+}
+
+func (m *Mux) listenLocked(slot uint64) *muxListener {
+	l := &muxListener{
+		mux:   m,
+		slot:  slot,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	if m.closed {
+		close(l.done)
+	} else {
+		m.slots[slot] = l
+	}
+	return l
+}
+
+// Serve accepts connections until the Mux is closed, and routes them to the
+// listeners of their slots.
+func (m *Mux) Serve() error {
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			return err
+		}
+		go m.route(conn)
+	}
+}
+
+func (m *Mux) route(conn net.Conn) {
+	var preamble [8]byte
+	conn.SetReadDeadline(time.Now().Add(muxPreambleTimeout))
+	if _, err := io.ReadFull(conn, preamble[:]); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	m.mu.Lock()
+	l, ok := m.slots[binary.BigEndian.Uint64(preamble[:])]
+	m.mu.Unlock()
+	if !ok {
+		conn.Close()
+		return
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Close closes the shared listener and the listeners on all slots.
+func (m *Mux) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	slots := m.slots
+	m.slots = make(map[uint64]*muxListener)
+	m.mu.Unlock()
+	for _, l := range slots {
+		l.Close()
+	}
+	return m.ln.Close()
+}
+
+// muxListener is the listener on a slot of a Mux.
+type muxListener struct {
+	mux   *Mux
+	slot  uint64
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errMuxClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.mux.mu.Lock()
+		if l.mux.slots[l.slot] == l {
+			delete(l.mux.slots, l.slot)
+		}
+		l.mux.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return muxAddr{Addr: l.mux.ln.Addr(), slot: l.slot}
+}
+
+// Relisten listens on the slot again once the listener has been closed, so
+// that a task restarted keeps its address.
+func (l *muxListener) Relisten() (net.Listener, error) {
+	l.mux.mu.Lock()
+	defer l.mux.mu.Unlock()
+	if l.mux.closed {
+		return nil, errMuxClosed
+	}
+	return l.mux.listenLocked(l.slot), nil
+}
+
+type muxAddr struct {
+	net.Addr
+	slot uint64
+}
+
+func (a muxAddr) String() string {
+	return a.Addr.String() + muxSep + strconv.FormatUint(a.slot, 10)
+}
+
+// dialMux returns conn to a Mux after telling the slot it's for.
+func dialMux(conn net.Conn, slot uint64) (net.Conn, error) {
+	var preamble [8]byte
+	binary.BigEndian.PutUint64(preamble[:], slot)
+	if _, err := conn.Write(preamble[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
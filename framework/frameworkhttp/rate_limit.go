@@ -0,0 +1,126 @@
+package frameworkhttp
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// statusTooManyRequests is sent to requesters over the rate limit, along with
+// a Retry-After header in seconds.
+const statusTooManyRequests = 429
+
+// RateLimit configures how many data requests a task admits, so that serving
+// them doesn't starve the task's compute. Rates are in requests per second;
+// zero means no limit.
+type RateLimit struct {
+	// Rate and Burst limit requests from all tasks together.
+	Rate  float64
+	Burst int
+	// PerTaskRate and PerTaskBurst limit requests from each task.
+	PerTaskRate  float64
+	PerTaskBurst int
+}
+
+// TooManyRequestsError is returned when the serving task throttles the
+// request. It can be retried after RetryAfter.
+type TooManyRequestsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return fmt.Sprintf("data request error: too many requests, retry after %v", e.RetryAfter)
+}
+
+// tokenBucket allows rate requests per second on average, and up to burst at
+// once.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// take takes a token if there is one. Otherwise it returns how long it takes
+// to have one.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+type rateLimiter struct {
+	rl RateLimit
+
+	mu      sync.Mutex
+	global  *tokenBucket
+	perTask map[uint64]*tokenBucket
+}
+
+func newRateLimiter(rl RateLimit) *rateLimiter {
+	l := &rateLimiter{rl: rl, perTask: make(map[uint64]*tokenBucket)}
+	if rl.Rate > 0 {
+		l.global = newTokenBucket(rl.Rate, rl.Burst)
+	}
+	return l
+}
+
+// admit tells whether a request from the task is admitted. A rejected request
+// takes no token from global limit.
+func (l *rateLimiter) admit(fromID uint64) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.rl.PerTaskRate > 0 {
+		b, ok := l.perTask[fromID]
+		if !ok {
+			b = newTokenBucket(l.rl.PerTaskRate, l.rl.PerTaskBurst)
+			l.perTask[fromID] = b
+		}
+		if ok, wait := b.take(now); !ok {
+			return false, wait
+		}
+	}
+	if l.global != nil {
+		return l.global.take(now)
+	}
+	return true, 0
+}
+
+// throttle responds with statusTooManyRequests if the request isn't admitted.
+func (h *dataReqHandler) throttle(w http.ResponseWriter, fromID uint64) bool {
+	if h.limiter == nil {
+		return false
+	}
+	ok, wait := h.limiter.admit(fromID)
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "too many requests", statusTooManyRequests)
+	return true
+}
+
+func parseRetryAfter(resp *http.Response) *TooManyRequestsError {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		secs = 1
+	}
+	return &TooManyRequestsError{RetryAfter: time.Duration(secs) * time.Second}
+}
@@ -0,0 +1,251 @@
+package frameworkhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// MaxIdleConnsPerTask is the number of idle connections kept open to each
+// neighbor task for reuse. A task could send thousands of small requests to
+// its neighbors in one epoch.
+const MaxIdleConnsPerTask = 16
+
+// UnixScheme prefixes addresses of tasks listening on unix domain sockets, e.g.
+// "unix:///tmp/job-0.sock". Co-located tasks can use them to avoid TCP.
+const UnixScheme = "unix://"
+
+// UnixSocketPath returns the socket path of a task address in UnixScheme.
+func UnixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, UnixScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, UnixScheme), true
+}
+
+// ListenerAddr returns the address other tasks reach the listener at.
+func ListenerAddr(ln net.Listener) string {
+	if ln.Addr().Network() == "unix" {
+		return UnixScheme + ln.Addr().String()
+	}
+	return ln.Addr().String()
+}
+
+// urlHost returns the host put in request URLs for a task address. Unix
+// sockets have none, so a placeholder is used.
+func urlHost(addr string) string {
+	addr, _, _ = SplitMuxAddr(addr)
+	if _, ok := UnixSocketPath(addr); ok {
+		return "localhost"
+	}
+	return addr
+}
+
+// Transport is the default, HTTP based transport of framework. Requests are
+// POSTed to /datareq with a JSON envelope of taskID, epoch and req.
+// Connections are kept alive and pooled per neighbor task.
+type Transport struct {
+	logger    *log.Logger
+	tlsConfig *tls.Config
+	rateLimit *RateLimit
+	authToken string
+	// With fencing, requests carry generation and ones of stale generations
+	// are rejected.
+	generation uint64
+	stale      StaleFunc
+	// versions tells the versions tasks talk, if known.
+	versions VersionFunc
+
+	mu    sync.Mutex
+	peers map[uint64]*peer
+}
+
+// peer is the connection pool to the node serving a neighbor task.
+type peer struct {
+	addr      string
+	transport *http.Transport
+	client    *http.Client
+}
+
+func NewTransport(logger *log.Logger) *Transport {
+	return &Transport{
+		logger: logger,
+		peers:  make(map[uint64]*peer),
+	}
+}
+
+// NewTLSTransport creates a transport that serves and sends data requests
+// over TLS with the given config. If the config requires client certificates,
+// tasks are mutually authenticated.
+func NewTLSTransport(logger *log.Logger, cfg *tls.Config) *Transport {
+	return &Transport{
+		logger:    logger,
+		tlsConfig: cfg,
+		peers:     make(map[uint64]*peer),
+	}
+}
+
+// Serve answers data requests on ln until it's closed, and then waits for
+// requests being handled to finish.
+func (t *Transport) Serve(ln net.Listener, dg DataGetter) error {
+	if t.tlsConfig != nil {
+		ln = tls.NewListener(ln, t.tlsConfig)
+	}
+	h := &dataReqHandler{
+		logger:     t.logger,
+		DataGetter: dg,
+		token:      t.authToken,
+		stale:      t.stale,
+	}
+	if t.rateLimit != nil {
+		h.limiter = newRateLimiter(*t.rateLimit)
+	}
+	d := newDrainHandler(h)
+	err := http.Serve(ln, d)
+	// ln has been closed. Let requests being handled finish.
+	d.drain()
+	return err
+}
+
+// SetRateLimit limits the data requests served by the transport. It needs to
+// be set before Serve.
+func (t *Transport) SetRateLimit(rl RateLimit) {
+	t.rateLimit = &rl
+}
+
+func (t *Transport) Send(ctx context.Context, addr, req string, from, to, epoch uint64) (*DataResponse, error) {
+	return requestData(ctx, t.clientFor(to, addr), t.scheme(), t.authToken, urlHost(addr), req, from, to, epoch, nil)
+}
+
+// Resume is like Send, but only asks for the data following partial, which
+// has been received by a broken transfer. The whole data is returned.
+func (t *Transport) Resume(ctx context.Context, addr, req string, from, to, epoch uint64, partial []byte) (*DataResponse, error) {
+	return requestData(ctx, t.clientFor(to, addr), t.scheme(), t.authToken, urlHost(addr), req, from, to, epoch, partial)
+}
+
+// SendStream is like Send but leaves the data unread in the Body of returned
+// response.
+func (t *Transport) SendStream(ctx context.Context, addr, req string, from, to, epoch uint64) (*DataResponse, error) {
+	return requestDataStream(ctx, t.clientFor(to, addr), t.scheme(), t.authToken, urlHost(addr), req, from, to, epoch, nil)
+}
+
+// Push sends data to the task at addr without being asked for it.
+func (t *Transport) Push(ctx context.Context, addr, req string, from, to, epoch uint64, data []byte) error {
+	return pushData(ctx, t.clientFor(to, addr), t.scheme(), t.authToken, urlHost(addr), req, from, epoch, data)
+}
+
+// SetAuthToken makes the transport send the token with every request, and
+// reject requests not carrying it. It needs to be set before Serve.
+func (t *Transport) SetAuthToken(token string) {
+	t.authToken = token
+}
+
+// clientFor returns the client for sending requests to the given task.
+func (t *Transport) clientFor(taskID uint64, addr string) *http.Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.peers[taskID]
+	if ok && p.addr == addr {
+		return p.client
+	}
+	if ok {
+		// The task has been taken over by another node. Connections to the
+		// old one are of no use.
+		p.transport.CloseIdleConnections()
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	base, slot, muxed := SplitMuxAddr(addr)
+	dial := dialer.Dial
+	if path, ok := UnixSocketPath(base); ok {
+		dial = func(string, string) (net.Conn, error) {
+			return dialer.Dial("unix", path)
+		}
+	}
+	if muxed {
+		d := dial
+		dial = func(network, _ string) (net.Conn, error) {
+			conn, err := d(network, base)
+			if err != nil {
+				return nil, err
+			}
+			return dialMux(conn, slot)
+		}
+	}
+	tr := &http.Transport{
+		Dial:                dial,
+		TLSClientConfig:     t.tlsConfig,
+		MaxIdleConnsPerHost: MaxIdleConnsPerTask,
+	}
+	var rt http.RoundTripper = &headerRoundTripper{
+		rt:    tr,
+		key:   DataProtocolVersion,
+		value: strconv.Itoa(t.peerVersion(taskID)),
+	}
+	if t.generation != 0 {
+		rt = &headerRoundTripper{rt: rt, key: DataRequestGeneration, value: strconv.FormatUint(t.generation, 10)}
+	}
+	p = &peer{
+		addr:      addr,
+		transport: tr,
+		client:    &http.Client{Transport: rt},
+	}
+	t.peers[taskID] = p
+	return p.client
+}
+
+func (t *Transport) scheme() string {
+	if t.tlsConfig != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// TLSInfo points to the PEM encoded files used to set up TLS between tasks.
+// Certificates are verified against the registered task address, so they need
+// to carry the IP addresses (or host names) tasks listen on.
+type TLSInfo struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// Config creates a TLS config for both serving and sending data requests.
+// When a CA is given, peers on both sides must present a certificate signed
+// by it.
+func (info TLSInfo) Config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(info.CertFile, info.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if info.CAFile == "" {
+		return cfg, nil
+	}
+	pem, err := ioutil.ReadFile(info.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("frameworkhttp: no certificate found in %s", info.CAFile)
+	}
+	cfg.RootCAs = pool
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
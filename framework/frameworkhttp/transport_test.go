@@ -0,0 +1,665 @@
+package frameworkhttp
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	merrors "github.com/go-distributed/meritop/errors"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+	"golang.org/x/net/context"
+)
+
+type tDataGetter struct{}
+
+func (g *tDataGetter) GetTaskData(taskID, epoch uint64, req string) ([]byte, error) {
+	return []byte(req), nil
+}
+
+type tDataStreamGetter struct {
+	tDataGetter
+	size int64
+}
+
+func (g *tDataStreamGetter) GetTaskDataStream(taskID, epoch uint64, req string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(io.LimitReader(zeroReader{}, g.size)), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// TestTransportKeepAlive checks that requests to the same task reuse one
+// connection, and a new connection is made after the task moves.
+func TestTransportKeepAlive(t *testing.T) {
+	ln1, ln2 := newCountingListener(t), newCountingListener(t)
+	defer ln1.Close()
+	defer ln2.Close()
+	tr := NewTransport(nil)
+	go tr.Serve(ln1, &tDataGetter{})
+	go tr.Serve(ln2, &tDataGetter{})
+
+	for i := 0; i < 10; i++ {
+		if _, err := tr.Send(context.Background(), ln1.Addr().String(), "req", 1, 0, 0); err != nil {
+			t.Fatalf("#%d: Send failed: %v", i, err)
+		}
+	}
+	// task 0 is taken over by the node at ln2.
+	for i := 0; i < 10; i++ {
+		if _, err := tr.Send(context.Background(), ln2.Addr().String(), "req", 1, 0, 0); err != nil {
+			t.Fatalf("#%d: Send failed: %v", i, err)
+		}
+	}
+	if n := ln1.accepted(); n != 1 {
+		t.Errorf("connections to old node want = 1, get = %d", n)
+	}
+	if n := ln2.accepted(); n != 1 {
+		t.Errorf("connections to new node want = 1, get = %d", n)
+	}
+}
+
+type countingListener struct {
+	net.Listener
+	mu    sync.Mutex
+	count int
+}
+
+func newCountingListener(t *testing.T) *countingListener {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	return &countingListener{Listener: ln}
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.count++
+		l.mu.Unlock()
+	}
+	return c, err
+}
+
+func (l *countingListener) accepted() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// TestTransportStream checks that data served as a stream can be read from
+// the response body as it comes.
+func TestTransportStream(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	defer ln.Close()
+	size := int64(64 << 20)
+	tr := NewTransport(nil)
+	go tr.Serve(ln, &tDataStreamGetter{size: size})
+
+	resp, err := tr.SendStream(context.Background(), ln.Addr().String(), "parameters", 1, 0, 0)
+	if err != nil {
+		t.Fatalf("SendStream failed: %v", err)
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	if err != nil {
+		t.Fatalf("reading body failed: %v", err)
+	}
+	if n != size {
+		t.Errorf("data size want = %d, get = %d", size, n)
+	}
+}
+
+// TestTLSTransport checks that tasks with certificates signed by the same CA
+// can exchange data, and plaintext requests are refused.
+func TestTLSTransport(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "frameworkhttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	info := writeTestCerts(t, dir)
+	cfg, err := info.Config()
+	if err != nil {
+		t.Fatalf("TLSInfo.Config() failed: %v", err)
+	}
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	defer ln.Close()
+	tr := NewTLSTransport(nil, cfg)
+	go tr.Serve(ln, &tDataGetter{})
+
+	resp, err := tr.Send(context.Background(), ln.Addr().String(), "parameters", 1, 0, 0)
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !bytes.Equal(resp.Data, []byte("parameters")) {
+		t.Errorf("data want = %s, get = %s", "parameters", resp.Data)
+	}
+
+	plain, err := http.Get("http://" + ln.Addr().String() + DataRequestPrefix)
+	if err == nil {
+		plain.Body.Close()
+		if plain.StatusCode == http.StatusOK {
+			t.Errorf("plaintext request should fail")
+		}
+	}
+}
+
+// writeTestCerts creates a CA and a certificate for 127.0.0.1 signed by it.
+func writeTestCerts(t *testing.T, dir string) TLSInfo {
+	c := etcdutil.WriteTestCerts(t, dir)
+	return TLSInfo{CertFile: c.CertFile, KeyFile: c.KeyFile, CAFile: c.CAFile}
+}
+
+type tBlockingDataGetter struct{ unblock chan struct{} }
+
+func (g *tBlockingDataGetter) GetTaskData(taskID, epoch uint64, req string) ([]byte, error) {
+	<-g.unblock
+	return nil, ErrServerClosed
+}
+
+// TestTransportCancel checks that a pending request returns once canceled.
+func TestTransportCancel(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	defer ln.Close()
+	dg := &tBlockingDataGetter{unblock: make(chan struct{})}
+	defer close(dg.unblock)
+	tr := NewTransport(nil)
+	go tr.Serve(ln, dg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := tr.Send(ctx, ln.Addr().String(), "req", 1, 0, 0)
+		errc <- err
+	}()
+	cancel()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Errorf("canceled request should fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("canceled request is still pending")
+	}
+}
+
+// TestRequestDataStaleEpoch checks that a response stamped with an epoch other
+// than the requested one is rejected.
+func TestRequestDataStaleEpoch(t *testing.T) {
+	tests := []struct {
+		stamp string
+		err   error
+	}{
+		{"1", nil},
+		{"", nil},
+		{"2", ErrReqEpochMismatch},
+		{"bad", ErrReqEpochMismatch},
+	}
+	for i, tt := range tests {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.stamp != "" {
+				w.Header().Set(DataResponseEpoch, tt.stamp)
+			}
+			w.Write([]byte("data"))
+		}))
+		_, err := RequestData(s.Listener.Addr().String(), "req", 1, 0, 1, nil)
+		if err != tt.err {
+			t.Errorf("#%d: error want = %v, get = %v", i, tt.err, err)
+		}
+		s.Close()
+	}
+}
+
+// TestDataRequestWireFormat checks that requests carrying any bytes survive
+// the POST envelope, and GET requests of older tasks are still served.
+func TestDataRequestWireFormat(t *testing.T) {
+	s := httptest.NewServer(NewDataRequestHandler(nil, &tDataGetter{}))
+	defer s.Close()
+	addr := strings.TrimPrefix(s.URL, "http://")
+
+	req := "a&b=c?\x00\xff" + strings.Repeat("x", 1<<16)
+	resp, err := requestData(context.Background(), http.DefaultClient, "http", "", addr, req, 1, 0, 2, nil)
+	if err != nil {
+		t.Fatalf("requestData failed: %v", err)
+	}
+	if string(resp.Data) != req {
+		t.Errorf("data want = %q..., get = %q...", req[:10], resp.Data[:10])
+	}
+
+	q := url.Values{}
+	q.Add(DataRequestTaskID, "1")
+	q.Add(DataRequestReq, "parameters")
+	q.Add(DataRequestEpoch, "2")
+	get, err := http.Get(s.URL + DataRequestPrefix + "?" + q.Encode())
+	if err != nil {
+		t.Fatalf("http.Get failed: %v", err)
+	}
+	b, err := ioutil.ReadAll(get.Body)
+	get.Body.Close()
+	if err != nil {
+		t.Fatalf("reading body failed: %v", err)
+	}
+	if string(b) != "parameters" || get.Header.Get(DataResponseEpoch) != "2" {
+		t.Errorf("GET response want = %s in epoch 2, get = %s in epoch %s",
+			"parameters", b, get.Header.Get(DataResponseEpoch))
+	}
+
+	bad, err := http.Post(s.URL+DataRequestPrefix, "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatalf("http.Post failed: %v", err)
+	}
+	bad.Body.Close()
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("status code want = %d, get = %d", http.StatusBadRequest, bad.StatusCode)
+	}
+}
+
+type tPushReceiver struct {
+	tDataGetter
+	epoch  uint64
+	pushed chan []byte
+}
+
+func (r *tPushReceiver) PushTaskData(fromID, epoch uint64, req string, data []byte) error {
+	if epoch != r.epoch {
+		return ErrReqEpochMismatch
+	}
+	r.pushed <- append([]byte(req+":"), data...)
+	return nil
+}
+
+func TestTransportPush(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	defer ln.Close()
+	r := &tPushReceiver{epoch: 1, pushed: make(chan []byte, 1)}
+	tr := NewTransport(nil)
+	go tr.Serve(ln, r)
+
+	data := []byte{'\n', 0, 0xff}
+	if err := tr.Push(context.Background(), ln.Addr().String(), "parameters", 0, 1, 1, data); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	expected := append([]byte("parameters:"), data...)
+	if b := <-r.pushed; !bytes.Equal(b, expected) {
+		t.Errorf("pushed data want = %q, get = %q", expected, b)
+	}
+
+	err = tr.Push(context.Background(), ln.Addr().String(), "parameters", 0, 1, 2, data)
+	if err != ErrReqEpochMismatch {
+		t.Errorf("error want = %v, get = %v", ErrReqEpochMismatch, err)
+	}
+}
+
+// TestRequestDataChecksum checks that data not matching the checksum sent by
+// server is rejected, whether it's sent in header or trailer.
+func TestRequestDataChecksum(t *testing.T) {
+	tests := []struct {
+		trailer bool
+		sum     string
+		err     error
+	}{
+		{false, formatChecksum(crc32.Checksum([]byte("data"), crc32cTable)), nil},
+		{false, "0", ErrChecksumMismatch},
+		{true, formatChecksum(crc32.Checksum([]byte("data"), crc32cTable)), nil},
+		{true, "0", ErrChecksumMismatch},
+		// servers not sending checksums
+		{false, "", nil},
+	}
+	for i, tt := range tests {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.trailer {
+				w.Header().Set("Trailer", DataChecksum)
+			} else if tt.sum != "" {
+				w.Header().Set(DataChecksum, tt.sum)
+			}
+			w.Write([]byte("data"))
+			if tt.trailer {
+				w.Header().Set(DataChecksum, tt.sum)
+			}
+		}))
+		addr := strings.TrimPrefix(s.URL, "http://")
+		_, err := requestData(context.Background(), http.DefaultClient, "http", "", addr, "req", 1, 0, 0, nil)
+		if err != tt.err {
+			t.Errorf("#%d: error want = %v, get = %v", i, tt.err, err)
+		}
+		s.Close()
+	}
+}
+
+func TestTransportRateLimit(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	defer ln.Close()
+	tr := NewTransport(nil)
+	tr.SetRateLimit(RateLimit{PerTaskRate: 0.5, PerTaskBurst: 2, Rate: 0.5, Burst: 3})
+	go tr.Serve(ln, &tDataGetter{})
+
+	tests := []struct {
+		from      uint64
+		throttled bool
+	}{
+		{1, false},
+		{1, false},
+		// over limit of task 1
+		{1, true},
+		{2, false},
+		// over global limit
+		{2, true},
+	}
+	for i, tt := range tests {
+		_, err := tr.Send(context.Background(), ln.Addr().String(), "req", tt.from, 0, 0)
+		e, ok := err.(*TooManyRequestsError)
+		if ok != tt.throttled {
+			t.Errorf("#%d: throttled want = %v, get = %v (%v)", i, tt.throttled, ok, err)
+		}
+		if ok && e.RetryAfter != 2*time.Second {
+			t.Errorf("#%d: retry after want = %v, get = %v", i, 2*time.Second, e.RetryAfter)
+		}
+	}
+}
+
+func TestTransportAuth(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	defer ln.Close()
+	tr := NewTransport(nil)
+	tr.SetAuthToken("secret")
+	go tr.Serve(ln, &tDataGetter{})
+
+	tests := []struct {
+		token string
+		err   error
+	}{
+		{"secret", nil},
+		{"guess", ErrUnauthorized},
+		{"", ErrUnauthorized},
+	}
+	for i, tt := range tests {
+		c := NewTransport(nil)
+		c.SetAuthToken(tt.token)
+		_, err := c.Send(context.Background(), ln.Addr().String(), "req", 1, 0, 0)
+		if err != tt.err {
+			t.Errorf("#%d: error want = %v, get = %v", i, tt.err, err)
+		}
+	}
+}
+
+// TestTransportFencing checks that requests of a task are rejected once it
+// has been taken by a node of a newer generation.
+func TestTransportFencing(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	defer ln.Close()
+	tr := NewTransport(nil)
+	// Task 1 is at generation 2.
+	tr.SetFencing(1, func(taskID, generation uint64) bool {
+		return taskID == 1 && generation < 2
+	})
+	go tr.Serve(ln, &tDataGetter{})
+
+	tests := []struct {
+		from       uint64
+		generation uint64
+		err        error
+	}{
+		{1, 2, nil},
+		{1, 1, ErrStaleGeneration},
+		{2, 1, nil},
+		// Not fenced.
+		{1, 0, nil},
+	}
+	for i, tt := range tests {
+		c := NewTransport(nil)
+		c.SetFencing(tt.generation, nil)
+		_, err := c.Send(context.Background(), ln.Addr().String(), "req", tt.from, 0, 0)
+		if err != tt.err {
+			t.Errorf("#%d: error want = %v, get = %v", i, tt.err, err)
+		}
+	}
+}
+
+func TestTransportMulti(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	defer ln.Close()
+	tr := NewTransport(nil)
+	go tr.Serve(ln, &tDataGetter{})
+
+	reqs := []string{"parameters", "", "\x00\xff", strings.Repeat("x", 1<<16)}
+	resps, err := tr.SendMulti(context.Background(), ln.Addr().String(), reqs, 1, 0, 3)
+	if err != nil {
+		t.Fatalf("SendMulti failed: %v", err)
+	}
+	if len(resps) != len(reqs) {
+		t.Fatalf("number of responses want = %d, get = %d", len(reqs), len(resps))
+	}
+	for i, resp := range resps {
+		if resp.TaskID != 0 || resp.Epoch != 3 || resp.Req != reqs[i] || string(resp.Data) != reqs[i] {
+			t.Errorf("#%d: unexpected response: %v", i, resp)
+		}
+	}
+}
+
+// TestTransportResume checks that a broken transfer returns what has been
+// received, and resuming it gets the rest of the data.
+func TestTransportResume(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "8")
+		w.Write([]byte("para"))
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer s.Close()
+	_, err := requestData(context.Background(), http.DefaultClient, "http", "", strings.TrimPrefix(s.URL, "http://"), "req", 1, 0, 0, nil)
+	pe, ok := err.(*PartialDataError)
+	if !ok {
+		t.Fatalf("error want = PartialDataError, get = %v", err)
+	}
+	if string(pe.Data) != "para" {
+		t.Fatalf("partial data want = para, get = %q", pe.Data)
+	}
+
+	for i, dg := range []DataGetter{&tDataGetter{}, &tDataStreamGetter{size: 1 << 20}} {
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+		}
+		tr := NewTransport(nil)
+		go tr.Serve(ln, dg)
+
+		req := "parameters"
+		want := []byte(req)
+		if _, ok := dg.(*tDataStreamGetter); ok {
+			want = make([]byte, 1<<20)
+		}
+		for _, n := range []int{0, 4, len(want)} {
+			d, err := tr.Resume(context.Background(), ln.Addr().String(), req, 1, 0, 0, want[:n])
+			if err != nil {
+				t.Errorf("#%d: resuming from %d failed: %v", i, n, err)
+				continue
+			}
+			if !bytes.Equal(d.Data, want) {
+				t.Errorf("#%d: resuming from %d got %d bytes of data, want %d", i, n, len(d.Data), len(want))
+			}
+		}
+		// Bogus partial data fails the checksum.
+		if _, err := tr.Resume(context.Background(), ln.Addr().String(), req, 1, 0, 0, []byte("x")); err != ErrChecksumMismatch {
+			t.Errorf("#%d: error want = %v, get = %v", i, ErrChecksumMismatch, err)
+		}
+		ln.Close()
+	}
+}
+
+type tFailingDataGetter struct{}
+
+func (g *tFailingDataGetter) GetTaskData(taskID, epoch uint64, req string) ([]byte, error) {
+	switch req {
+	case "notready":
+		return nil, merrors.ErrNotReady
+	case "bad":
+		return nil, merrors.Wrap(merrors.ErrBadRequest, fmt.Errorf("unknown key"))
+	default:
+		return nil, fmt.Errorf("failed")
+	}
+}
+
+// TestTransportServeError checks that errors of tasks serving data are told
+// apart by requesters.
+func TestTransportServeError(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(\"tcp4\", \"\") failed: %v", err)
+	}
+	defer ln.Close()
+	tr := NewTransport(nil)
+	go tr.Serve(ln, &tFailingDataGetter{})
+
+	for i, req := range []string{"notready", "bad", "failed"} {
+		_, err := tr.Send(context.Background(), ln.Addr().String(), req, 1, 0, 0)
+		switch i {
+		case 0:
+			if e, ok := err.(*TooManyRequestsError); !ok || e.RetryAfter != notReadyRetryAfter {
+				t.Errorf("#%d: error want = retry after %v, get = %v", i, notReadyRetryAfter, err)
+			}
+		case 1:
+			if err != ErrBadRequest {
+				t.Errorf("#%d: error want = %v, get = %v", i, ErrBadRequest, err)
+			}
+		case 2:
+			if err != ErrServeFailed {
+				t.Errorf("#%d: error want = %v, get = %v", i, ErrServeFailed, err)
+			}
+		}
+	}
+}
+
+type tPrefixDataGetter string
+
+func (g tPrefixDataGetter) GetTaskData(taskID, epoch uint64, req string) ([]byte, error) {
+	return []byte(string(g) + req), nil
+}
+
+// TestMux checks that requests to listeners on a Mux reach the task of the
+// address they're sent to.
+func TestMux(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %v", err)
+	}
+	mux := NewMux(ln)
+	defer mux.Close()
+	go mux.Serve()
+	tr := NewTransport(nil)
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		l := mux.Listen()
+		go tr.Serve(l, tPrefixDataGetter(fmt.Sprintf("task%d-", i)))
+		addrs = append(addrs, ListenerAddr(l))
+	}
+	for i, addr := range addrs {
+		if base, _, ok := SplitMuxAddr(addr); !ok || base != ln.Addr().String() {
+			t.Errorf("#%d: address %s isn't on the mux at %s", i, addr, ln.Addr())
+		}
+		resp, err := tr.Send(context.Background(), addr, "req", 2, uint64(i), 0)
+		if err != nil {
+			t.Fatalf("#%d: Send failed: %v", i, err)
+		}
+		if w := fmt.Sprintf("task%d-req", i); string(resp.Data) != w {
+			t.Errorf("#%d: data want = %s, get = %s", i, w, resp.Data)
+		}
+	}
+}
+
+// TestTransportVersion checks that requests are sent in the version both ends
+// talk, and ones of versions the serving task doesn't talk are rejected.
+func TestTransportVersion(t *testing.T) {
+	versions := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions <- r.Header.Get(DataProtocolVersion)
+		NewDataRequestHandler(nil, &tDataGetter{}).ServeHTTP(w, r)
+	}))
+	defer s.Close()
+	addr := strings.TrimPrefix(s.URL, "http://")
+
+	tests := []struct {
+		peer int
+		want string
+		err  error
+	}{
+		// Tasks of unknown versions are talked ours.
+		{0, "2", nil},
+		{ProtocolV1, "1", nil},
+		{ProtocolV2, "2", nil},
+		// So are newer ones.
+		{ProtocolVersion + 1, "2", nil},
+	}
+	for i, tt := range tests {
+		tr := NewTransport(nil)
+		tr.SetPeerVersions(func(uint64) int { return tt.peer })
+		_, err := tr.Send(context.Background(), addr, "req", 1, 0, 0)
+		if err != tt.err {
+			t.Errorf("#%d: error want = %v, get = %v", i, tt.err, err)
+		}
+		if v := <-versions; v != tt.want {
+			t.Errorf("#%d: version want = %s, get = %s", i, tt.want, v)
+		}
+	}
+
+	hreq, err := http.NewRequest("POST", s.URL+DataRequestPrefix, strings.NewReader(`{"taskID":1,"epoch":0,"req":"cmVx"}`))
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	hreq.Header.Set(DataProtocolVersion, "99")
+	resp, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	resp.Body.Close()
+	<-versions
+	if err := statusError(resp); err != ErrProtocolVersion {
+		t.Errorf("error of version 99 want = %v, get = %v", ErrProtocolVersion, err)
+	}
+	if v := resp.Header.Get(DataProtocolVersion); v != "2" {
+		t.Errorf("version served in want = 2, get = %s", v)
+	}
+}
@@ -0,0 +1,88 @@
+package frameworkhttp
+
+import (
+	"net/http"
+	"strconv"
+
+	merrors "github.com/go-distributed/meritop/errors"
+)
+
+// Versions of the data protocol. Tasks register the version they talk in etcd,
+// so that tasks of another release can fall back to what both talk, e.g.
+// during rolling upgrades.
+const (
+	// ProtocolV1 is single data requests, as the first releases talk it.
+	ProtocolV1 = 1
+	// ProtocolV2 adds requests of several keys, resumed transfers and pushes.
+	ProtocolV2 = 2
+
+	// ProtocolVersion is the version tasks of this release talk. They serve
+	// requests of versions from MinProtocolVersion up to it.
+	ProtocolVersion    = ProtocolV2
+	MinProtocolVersion = ProtocolV1
+)
+
+// DataProtocolVersion carries the version requests are sent, and responses
+// served in. Requests without it are of ProtocolV1.
+const DataProtocolVersion = "X-Protocol-Version"
+
+// ErrProtocolVersion is returned for requests of a version the serving task
+// doesn't talk. They aren't retried.
+var ErrProtocolVersion = merrors.New(merrors.ErrBadRequest, "data request error: protocol version not supported")
+
+// VersionFunc returns the version the task registered, or 0 if it's unknown.
+type VersionFunc func(taskID uint64) int
+
+// SetPeerVersions makes the transport talk to each task in the lower of
+// ProtocolVersion and the version versions tells for it. It needs to be set
+// before sending requests.
+func (t *Transport) SetPeerVersions(versions VersionFunc) {
+	t.versions = versions
+}
+
+// peerVersion returns the version to talk to the task in.
+func (t *Transport) peerVersion(taskID uint64) int {
+	if t.versions == nil {
+		return ProtocolVersion
+	}
+	if v := t.versions(taskID); v > 0 && v < ProtocolVersion {
+		return v
+	}
+	return ProtocolVersion
+}
+
+// unsupportedVersion rejects requests of versions the handler doesn't talk
+// with status 412. Responses tell the version they're served in either way.
+func (h *dataReqHandler) unsupportedVersion(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set(DataProtocolVersion, strconv.Itoa(ProtocolVersion))
+	s := r.Header.Get(DataProtocolVersion)
+	if s == "" {
+		return false
+	}
+	v, err := strconv.Atoi(s)
+	if err == nil && v >= MinProtocolVersion && v <= ProtocolVersion {
+		return false
+	}
+	http.Error(w, ErrProtocolVersion.Error(), http.StatusPreconditionFailed)
+	return true
+}
+
+// headerRoundTripper stamps requests with a header, e.g. the generation of
+// the task.
+type headerRoundTripper struct {
+	rt    http.RoundTripper
+	key   string
+	value string
+}
+
+func (h *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the request.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set(h.key, h.value)
+	return h.rt.RoundTrip(r)
+}
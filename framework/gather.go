@@ -0,0 +1,58 @@
+package framework
+
+import (
+	"sort"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+)
+
+// gather reduces data of all children for GatherFromChildren.
+type gather struct {
+	reduce func(acc, item []byte) []byte
+	done   func(result []byte)
+}
+
+func (f *framework) gatherFromChildren(req string, reduce func(acc, item []byte) []byte, done func(result []byte), epoch uint64) {
+	children := f.children(epoch)
+	if len(children) == 0 {
+		done(nil)
+		return
+	}
+	f.dataBatchToSendChan <- &dataBatch{
+		taskIDs: children,
+		epoch:   epoch,
+		req:     req,
+		gather:  &gather{reduce: reduce, done: done},
+	}
+}
+
+// handleGather reduces responses of all children, and passes the result on.
+func (f *framework) handleGather(ctx meritop.Context, b *dataBatchResponse) {
+	sort.Sort(byTaskID(b.resps))
+	var acc []byte
+	for _, resp := range b.resps {
+		data, err := f.wholeData(resp)
+		if err != nil {
+			f.log.Warnf("task %d reading data from task %d failed: %v", f.taskID, resp.TaskID, err)
+			f.handleDataReqFailure(ctx, &dataRequestFailure{
+				taskID: resp.TaskID,
+				epoch:  b.epoch,
+				req:    b.req,
+				err:    err,
+			})
+			return
+		}
+		acc = b.gather.reduce(acc, data)
+		if f.isChild(b.epoch, resp.TaskID) {
+			f.childResponded(b.epoch, resp.TaskID)
+		}
+	}
+	b.gather.done(acc)
+}
+
+type byTaskID []*frameworkhttp.DataResponse
+
+func (r byTaskID) Len() int           { return len(r) }
+func (r byTaskID) Less(i, j int) bool { return r[i].TaskID < r[j].TaskID }
+func (r byTaskID) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
@@ -3,19 +3,103 @@ package framework
 import (
 	"time"
 
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
 	"github.com/go-distributed/meritop/pkg/etcdutil"
 )
 
-var (
-	heartbeatInterval = 1 * time.Second
-)
+const defaultHeartbeatInterval = 1 * time.Second
+
+// heartbeatInterval is how often the task, or replica, keeps itself alive in
+// the coordinator. The task is taken to have failed after about three intervals missed.
+func (f *framework) heartbeatInterval() time.Duration {
+	if f.heartbeatEvery <= 0 {
+		return defaultHeartbeatInterval
+	}
+	return f.heartbeatEvery
+}
+
+// setupLease finds out whether the job has a lease for tasks to keep, see
+// controller.SetTTL.
+func (f *framework) setupLease() error {
+	ttl, _, err := f.coord.Lease(f.name)
+	if err != nil {
+		return err
+	}
+	f.leaseTTL = ttl
+	return nil
+}
 
+// heartbeat keeps the task alive until heartbeatStop is closed, which
+// is done last when framework stops. The lease of the job, if any, is kept
+// alive along with it.
 func (f *framework) heartbeat() {
-	f.heartbeatStop = make(chan struct{})
+	f.heartbeatStop = make(chan bool)
+	if f.leaseTTL > 0 {
+		go func(stop chan bool) {
+			if err := f.coord.KeepLease(f.name, f.leaseTTL, stop); err != nil {
+				f.log.Warnf("Keeping lease of job stops with error: %v\n", err)
+			}
+		}(f.heartbeatStop)
+	}
 	go func() {
-		err := etcdutil.Heartbeat(f.etcdClient, f.name, f.taskID, heartbeatInterval, f.heartbeatStop)
+		err := f.coord.Heartbeat(f.name, f.taskID,
+			frameworkhttp.ListenerAddr(f.ln), f.heartbeatInterval(), f.heartbeatStop)
+		if err == etcdutil.ErrTaskFenced {
+			// Another node is working for the task. Stop before doing
+			// anything on its behalf.
+			f.reportError(meritop.SeverityFatal, "heartbeat", err)
+			return
+		}
 		if err != nil {
-			f.log.Printf("Heartbeat stops with error: %v\n", err)
+			f.log.Warnf("Heartbeat stops with error: %v\n", err)
 		}
 	}()
 }
+
+// detectFailure reports tasks whose heartbeat expired to /FreeTasks, so that
+// standbys could take them over. Every task does it, so that a failed task
+// gets replaced even without a controller running.
+func (f *framework) detectFailure() {
+	f.failureStop = make(chan bool, 1)
+	go func() {
+		err := f.coord.DetectFailure(f.name, etcdutil.TaskActor(f.taskID), f.failureStop, f.log)
+		if err != nil {
+			f.log.Warnf("DetectFailure stops with error: %v\n", err)
+		}
+	}()
+}
+
+// watchHealth passes parents and children of the task failing or being taken
+// over to event loop.
+func (f *framework) watchHealth() {
+	f.healthStop = make(chan bool, 1)
+	f.coord.WatchHealth(f.name, f.healthStop, func(taskID uint64, healthy bool) {
+		if taskID == f.taskID {
+			return
+		}
+		f.healthChan <- &healthChange{taskID: taskID, healthy: healthy}
+	})
+}
+
+func (f *framework) handleHealthChange(ctx *epochContext, h *healthChange) {
+	defer f.recoverTask()
+	st, ok := f.task.(meritop.StatefulTask)
+	if !ok {
+		return
+	}
+	switch {
+	case f.isParent(ctx.epoch, h.taskID):
+		if h.healthy {
+			st.ParentRestart(ctx, h.taskID)
+		} else {
+			st.ParentDie(ctx, h.taskID)
+		}
+	case f.isChild(ctx.epoch, h.taskID):
+		if h.healthy {
+			st.ChildRestart(ctx, h.taskID)
+		} else {
+			st.ChildDie(ctx, h.taskID)
+		}
+	}
+}
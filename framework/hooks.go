@@ -0,0 +1,40 @@
+package framework
+
+// Hooks are called by framework as the task goes through epochs, e.g. to
+// record metrics or flush caches, so that such concerns don't have to be
+// wedged into every task. Hooks left nil are skipped. They are called from the
+// event loop, so they'd better be quick.
+type Hooks struct {
+	// OnEpochStart is called as the task starts epoch, before the task is
+	// told.
+	OnEpochStart func(taskID, epoch uint64)
+	// OnEpochEnd is called once the task is done with epoch, i.e. the job has
+	// moved to another epoch, or the task stops.
+	OnEpochEnd func(taskID, epoch uint64)
+	// OnShutdown is called once the task has exited on this node.
+	OnShutdown func(taskID uint64)
+}
+
+func (f *framework) fireEpochStart() {
+	for _, h := range f.hooks {
+		if h.OnEpochStart != nil {
+			h.OnEpochStart(f.taskID, f.epoch)
+		}
+	}
+}
+
+func (f *framework) fireEpochEnd() {
+	for _, h := range f.hooks {
+		if h.OnEpochEnd != nil {
+			h.OnEpochEnd(f.taskID, f.epoch)
+		}
+	}
+}
+
+func (f *framework) fireShutdown() {
+	for _, h := range f.hooks {
+		if h.OnShutdown != nil {
+			h.OnShutdown(f.taskID)
+		}
+	}
+}
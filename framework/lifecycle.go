@@ -0,0 +1,48 @@
+package framework
+
+import "github.com/go-distributed/meritop"
+
+// Subscribers not keeping up miss transitions beyond this many.
+const stateChangesBuffer = 16
+
+func (f *framework) State() meritop.FrameworkState {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	return f.state
+}
+
+func (f *framework) StateChanges() <-chan meritop.FrameworkState {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	c := make(chan meritop.FrameworkState, stateChangesBuffer)
+	c <- f.state
+	if f.state == meritop.StateStopped {
+		close(c)
+		return c
+	}
+	f.stateSubs = append(f.stateSubs, c)
+	return c
+}
+
+// setState moves framework to state s, and tells subscribers. They are done
+// with once it has stopped.
+func (f *framework) setState(s meritop.FrameworkState) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	if f.state == s {
+		return
+	}
+	f.state = s
+	for _, c := range f.stateSubs {
+		select {
+		case c <- s:
+		default:
+		}
+		if s == meritop.StateStopped {
+			close(c)
+		}
+	}
+	if s == meritop.StateStopped {
+		f.stateSubs = nil
+	}
+}
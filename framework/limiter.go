@@ -0,0 +1,34 @@
+package framework
+
+// Requests in flight are bounded, so that a task with hundreds of neighbors
+// won't exhaust sockets and memory.
+const defaultMaxInFlightRequests = 64
+
+// limiter bounds the number of requests in flight. acquire blocks once the
+// limit is reached, which pushes back on whoever issues more requests.
+type limiter chan struct{}
+
+func newLimiter(n int) limiter { return make(limiter, n) }
+
+// acquire takes a slot, waiting for one to be released if needed. It returns
+// false if stop is closed meanwhile.
+func (l limiter) acquire(stop <-chan struct{}) bool {
+	select {
+	case l <- struct{}{}:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (l limiter) release() { <-l }
+
+func (f *framework) setupLimiters() {
+	n := f.maxInFlightReqs
+	if n <= 0 {
+		n = defaultMaxInFlightRequests
+	}
+	f.sendLimiter = newLimiter(n)
+	f.serveLimiter = newLimiter(n)
+	f.handleLimiter = newLimiter(n)
+}
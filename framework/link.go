@@ -0,0 +1,75 @@
+package framework
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"github.com/go-distributed/meritop/pkg/topoutil"
+)
+
+// linkedTask returns the task as meritop.LinkedTask to handle neighbors of the
+// link type. Topology having link types other than parent and child needs the
+// task to implement it, or meritop.PeerTask for peers.
+func (f *framework) linkedTask(linkType string) meritop.LinkedTask {
+	if p, ok := f.task.(meritop.PeerTask); ok && linkType == topoutil.LinkPeer {
+		return peerTask{p}
+	}
+	t, ok := f.task.(meritop.LinkedTask)
+	if !ok {
+		panic(fmt.Sprintf("task %d has neighbors, but doesn't implement LinkedTask", f.taskID))
+	}
+	return t
+}
+
+func (f *framework) handleNeighborMeta(ctx meritop.Context, linkType string, taskID uint64, meta *meritop.Meta) {
+	defer f.recoverTask()
+	f.linkedTask(linkType).NeighborMetaReady(ctx, linkType, taskID, meta.Kind)
+}
+
+// serveAsNeighbor gets data for a request from a task which is neither parent
+// nor child. It returns false if the task isn't linked to this one at all.
+func (f *framework) serveAsNeighbor(epoch, fromID uint64, req string) (io.ReadCloser, bool) {
+	linkType, ok := f.linkTypeOf(epoch, fromID)
+	if !ok {
+		return nil, false
+	}
+	data := f.linkedTask(linkType).ServeAsNeighbor(linkType, fromID, req)
+	return ioutil.NopCloser(bytes.NewReader(data)), true
+}
+
+// handleNeighborData passes data from a task which is neither parent nor child.
+// It returns false if the task isn't linked to this one at all.
+func (f *framework) handleNeighborData(ctx meritop.Context, resp *frameworkhttp.DataResponse) bool {
+	linkType, ok := f.linkTypeOf(resp.Epoch, resp.TaskID)
+	if !ok {
+		return false
+	}
+	data, err := f.wholeData(resp)
+	if err != nil {
+		f.log.Warnf("task %d reading data from task %d failed: %v", f.taskID, resp.TaskID, err)
+		return true
+	}
+	f.linkedTask(linkType).NeighborDataReady(ctx, linkType, resp.TaskID, resp.Req, data)
+	return true
+}
+
+// peerTask handles peers as neighbors of link type "peer".
+type peerTask struct {
+	meritop.PeerTask
+}
+
+func (t peerTask) NeighborMetaReady(ctx meritop.Context, linkType string, fromID uint64, meta string) {
+	t.PeerMetaReady(ctx, fromID, meta)
+}
+
+func (t peerTask) ServeAsNeighbor(linkType string, fromID uint64, req string) []byte {
+	return t.ServeAsPeer(fromID, req)
+}
+
+func (t peerTask) NeighborDataReady(ctx meritop.Context, linkType string, fromID uint64, req string, resp []byte) {
+	t.PeerDataReady(ctx, fromID, req, resp)
+}
@@ -0,0 +1,80 @@
+package framework
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/go-distributed/meritop"
+)
+
+// taskLogger annotates messages logged by framework, and by tasks with
+// Framework.GetLogger, with the job, task and epoch they're logged in, and
+// fields set with with, e.g. of data requests. They go to the logger of
+// framework.
+type taskLogger struct {
+	f      *framework
+	fields string
+}
+
+// outputter is implemented by loggers which can be told where messages are
+// logged from, e.g. meritop.StdLogger.
+type outputter interface {
+	Output(calldepth int, level meritop.Level, s string) error
+}
+
+// with returns a logger adding key=value to messages.
+func (l *taskLogger) with(key string, value interface{}) *taskLogger {
+	return &taskLogger{f: l.f, fields: fmt.Sprintf("%s %s=%v", l.fields, key, value)}
+}
+
+func (l *taskLogger) Debugf(format string, v ...interface{}) {
+	l.output(meritop.LevelDebug, format, v...)
+}
+
+func (l *taskLogger) Infof(format string, v ...interface{}) {
+	l.output(meritop.LevelInfo, format, v...)
+}
+
+func (l *taskLogger) Warnf(format string, v ...interface{}) {
+	l.output(meritop.LevelWarn, format, v...)
+}
+
+func (l *taskLogger) Errorf(format string, v ...interface{}) {
+	l.output(meritop.LevelError, format, v...)
+}
+
+func (l *taskLogger) output(level meritop.Level, format string, v ...interface{}) {
+	s := fmt.Sprintf("job=%s task=%d epoch=%d%s: %s",
+		l.f.name, l.f.taskID, l.f.epoch, l.fields, fmt.Sprintf(format, v...))
+	if o, ok := l.f.logger.(outputter); ok {
+		// Called by the methods above, which are called by the one logging.
+		o.Output(3, level, s)
+		return
+	}
+	switch level {
+	case meritop.LevelDebug:
+		l.f.logger.Debugf("%s", s)
+	case meritop.LevelInfo:
+		l.f.logger.Infof("%s", s)
+	case meritop.LevelWarn:
+		l.f.logger.Warnf("%s", s)
+	default:
+		l.f.logger.Errorf("%s", s)
+	}
+}
+
+// logWriter passes lines written to a *log.Logger on to a meritop.Logger, for
+// parts taking a *log.Logger, e.g. the HTTP transport.
+type logWriter struct {
+	l meritop.Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.l.Infof("%s", bytes.TrimRight(p, "\n"))
+	return len(p), nil
+}
+
+func newStdLog(l meritop.Logger) *log.Logger {
+	return log.New(logWriter{l}, "", 0)
+}
@@ -0,0 +1,91 @@
+package framework
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/go-distributed/meritop"
+)
+
+// Metas are kept in etcd as JSON, in which payload is base64 encoded. So any
+// bytes can be carried without breaking the stored value.
+func encodeMeta(meta *meritop.Meta) (string, error) {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodeMeta(value string) (*meritop.Meta, error) {
+	meta := new(meritop.Meta)
+	if err := json.Unmarshal([]byte(value), meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// Metas flagged to the same path in an epoch are kept together, in order they
+// were flagged. So watchers can catch up on all of them from any value they
+// read, even if some changes in between were missed.
+func encodeMetas(metas []*meritop.Meta) (string, error) {
+	b, err := json.Marshal(metas)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// decodeMetas decodes metas kept by encodeMetas. It takes a single meta kept
+// by encodeMeta too, and nothing if no meta has been flagged.
+func decodeMetas(value string) ([]*meritop.Meta, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(value, "[") {
+		meta, err := decodeMeta(value)
+		if err != nil {
+			return nil, err
+		}
+		return []*meritop.Meta{meta}, nil
+	}
+	var metas []*meritop.Meta
+	if err := json.Unmarshal([]byte(value), &metas); err != nil {
+		return nil, err
+	}
+	return metas, nil
+}
+
+// serialQueue runs functions pushed one at a time, in order, without blocking
+// the one pushing.
+type serialQueue struct {
+	mu      sync.Mutex
+	fns     []func()
+	running bool
+}
+
+func (q *serialQueue) push(fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.fns = append(q.fns, fn)
+	if !q.running {
+		q.running = true
+		go q.drain()
+	}
+}
+
+func (q *serialQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.fns) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		fn := q.fns[0]
+		q.fns = q.fns[1:]
+		q.mu.Unlock()
+		fn()
+	}
+}
@@ -0,0 +1,64 @@
+package framework
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-distributed/meritop"
+)
+
+func TestMetaEncoding(t *testing.T) {
+	tests := []*meritop.Meta{
+		{Kind: "ParamReady"},
+		{Kind: "", Epoch: 3},
+		{Kind: "with-dash-and-\"quotes\"", Epoch: 1, Payload: []byte("ok")},
+		{Kind: "binary", Epoch: 1 << 40, Payload: []byte{0, 0xff, '-', '\n', 0x80}},
+	}
+	for i, tt := range tests {
+		value, err := encodeMeta(tt)
+		if err != nil {
+			t.Fatalf("#%d: encodeMeta failed: %v", i, err)
+		}
+		meta, err := decodeMeta(value)
+		if err != nil {
+			t.Fatalf("#%d: decodeMeta(%q) failed: %v", i, value, err)
+		}
+		if !reflect.DeepEqual(meta, tt) {
+			t.Errorf("#%d: meta want = %v, get = %v", i, tt, meta)
+		}
+	}
+}
+
+func TestMetasEncoding(t *testing.T) {
+	metas := []*meritop.Meta{
+		{Kind: "ParamReady", Epoch: 1, Seq: 1},
+		{Kind: "GradientReady", Epoch: 1, Seq: 2, Payload: []byte("ok")},
+	}
+	value, err := encodeMetas(metas)
+	if err != nil {
+		t.Fatalf("encodeMetas failed: %v", err)
+	}
+	single, err := encodeMeta(metas[0])
+	if err != nil {
+		t.Fatalf("encodeMeta failed: %v", err)
+	}
+	tests := []struct {
+		value string
+		want  []*meritop.Meta
+	}{
+		{value, metas},
+		// meta flagged alone
+		{single, metas[:1]},
+		// nothing flagged yet
+		{"", nil},
+	}
+	for i, tt := range tests {
+		get, err := decodeMetas(tt.value)
+		if err != nil {
+			t.Fatalf("#%d: decodeMetas(%q) failed: %v", i, tt.value, err)
+		}
+		if !reflect.DeepEqual(get, tt.want) {
+			t.Errorf("#%d: metas want = %v, get = %v", i, tt.want, get)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package framework
+
+import (
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+)
+
+// reportProgress keeps progress of the task in the epoch, for the
+// controller to aggregate.
+func (f *framework) reportProgress(epoch uint64, p meritop.Progress) {
+	if err := f.coord.SetProgress(f.name, epoch, f.taskID, p); err != nil {
+		f.reportError(meritop.SeverityRecoverable, fmt.Sprintf("reporting progress of epoch %d", epoch), err)
+	}
+}
@@ -1,7 +1,6 @@
 package framework
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -31,6 +30,17 @@ type dummyData struct {
 	Value int32
 }
 
+// decodeDummyData decodes data encoded by the other tasks. Bad data is
+// decoded as zero value.
+func decodeDummyData(c meritop.Codec, b []byte) *dummyData {
+	if v, err := c.Unmarshal(b); err == nil {
+		if d, ok := v.(*dummyData); ok {
+			return d
+		}
+	}
+	return new(dummyData)
+}
+
 // dummyMaster is prototype of parameter server, for now it does not
 // carry out optimization yet. But it should be easy to add support when
 // this full tests out.
@@ -54,6 +64,7 @@ type dummyMaster struct {
 func (t *dummyMaster) Init(taskID uint64, framework meritop.Framework) {
 	t.taskID = taskID
 	t.framework = framework
+	framework.GetCodec().Register(&dummyData{})
 	t.logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
 	// t.logger = log.New(ioutil.Discard, "", log.Ldate|log.Ltime|log.Lshortfile)
 }
@@ -89,7 +100,7 @@ func (t *dummyMaster) SetEpoch(ctx meritop.Context, epoch uint64) {
 
 // These are payload rpc for application purpose.
 func (t *dummyMaster) ServeAsParent(fromID uint64, req string) []byte {
-	b, err := json.Marshal(t.param)
+	b, err := t.framework.GetCodec().Marshal(t.param)
 	if err != nil {
 		t.logger.Fatalf("Master can't encode parameter: %v, error: %v\n", t.param, err)
 	}
@@ -102,8 +113,7 @@ func (t *dummyMaster) ServeAsChild(fromID uint64, req string) []byte {
 
 func (t *dummyMaster) ParentDataReady(ctx meritop.Context, parentID uint64, req string, resp []byte) {}
 func (t *dummyMaster) ChildDataReady(ctx meritop.Context, childID uint64, req string, resp []byte) {
-	d := new(dummyData)
-	json.Unmarshal(resp, d)
+	d := decodeDummyData(t.framework.GetCodec(), resp)
 	if _, ok := t.fromChildren[childID]; ok {
 		return
 	}
@@ -179,6 +189,7 @@ type dummySlave struct {
 func (t *dummySlave) Init(taskID uint64, framework meritop.Framework) {
 	t.taskID = taskID
 	t.framework = framework
+	framework.GetCodec().Register(&dummyData{})
 	t.logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
 	// t.logger = log.New(ioutil.Discard, "", log.Ldate|log.Ltime|log.Lshortfile)
 }
@@ -211,7 +222,7 @@ func (t *dummySlave) SetEpoch(ctx meritop.Context, epoch uint64) {
 
 // These are payload rpc for application purpose.
 func (t *dummySlave) ServeAsParent(fromID uint64, req string) []byte {
-	b, err := json.Marshal(t.param)
+	b, err := t.framework.GetCodec().Marshal(t.param)
 	if err != nil {
 		t.logger.Fatalf("Slave can't encode parameter: %v, error: %v\n", t.param, err)
 	}
@@ -219,7 +230,7 @@ func (t *dummySlave) ServeAsParent(fromID uint64, req string) []byte {
 }
 
 func (t *dummySlave) ServeAsChild(fromID uint64, req string) []byte {
-	b, err := json.Marshal(t.gradient)
+	b, err := t.framework.GetCodec().Marshal(t.gradient)
 	if err != nil {
 		t.logger.Fatalf("Slave can't encode gradient: %v, error: %v\n", t.gradient, err)
 	}
@@ -234,8 +245,7 @@ func (t *dummySlave) ParentDataReady(ctx meritop.Context, parentID uint64, req s
 	if t.gradientReady.Count() == 0 {
 		return
 	}
-	t.param = new(dummyData)
-	json.Unmarshal(resp, t.param)
+	t.param = decodeDummyData(t.framework.GetCodec(), resp)
 	// We need to carry out local compuation.
 	t.gradient.Value = t.param.Value * int32(t.framework.GetTaskID())
 	t.gradientReady.CountDown()
@@ -253,8 +263,7 @@ func (t *dummySlave) ParentDataReady(ctx meritop.Context, parentID uint64, req s
 }
 
 func (t *dummySlave) ChildDataReady(ctx meritop.Context, childID uint64, req string, resp []byte) {
-	d := new(dummyData)
-	json.Unmarshal(resp, d)
+	d := decodeDummyData(t.framework.GetCodec(), resp)
 	if _, ok := t.fromChildren[childID]; ok {
 		return
 	}
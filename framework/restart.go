@@ -0,0 +1,54 @@
+package framework
+
+import (
+	"fmt"
+	"time"
+)
+
+// waitRestartBackoff waits before taking over the task which failed recently,
+// the longer the more times it did, so that a task failing on start, e.g. on a
+// bad data shard, isn't restarted over and over at once. The task is poisoned
+// instead once it failed more than maxRestarts times. It returns false if the
+// task shouldn't be taken.
+func (f *framework) waitRestartBackoff(taskID uint64) bool {
+	if f.restartBackoff <= 0 && f.maxRestarts <= 0 {
+		return true
+	}
+	r, err := f.coord.Restarts(f.name, taskID)
+	if err != nil {
+		f.log.Warnf("getting restarts of task %d failed: %v", taskID, err)
+		return true
+	}
+	if f.maxRestarts > 0 && r.Count > f.maxRestarts {
+		reason := fmt.Sprintf("failed %d times, last at %v", r.Count, r.Last.Format(time.RFC3339))
+		if err := f.coord.PoisonTask(f.name, taskID, reason); err != nil {
+			f.log.Warnf("poisoning task %d failed: %v", taskID, err)
+		}
+		f.log.Errorf("task %d poisoned: %s", taskID, reason)
+		return false
+	}
+	if wait := f.backoff(r.Count) - time.Since(r.Last); wait > 0 {
+		f.log.Warnf("task %d failed %d times. Wait %v before taking it over.", taskID, r.Count, wait)
+		time.Sleep(wait)
+	}
+	return true
+}
+
+// backoff doubles from restartBackoff for each failure after the first, up
+// to maxRestartBackoff.
+func (f *framework) backoff(failures int) time.Duration {
+	if f.restartBackoff <= 0 || failures <= 1 {
+		return 0
+	}
+	d := f.restartBackoff
+	for i := 2; i < failures; i++ {
+		d *= 2
+		if f.maxRestartBackoff > 0 && d >= f.maxRestartBackoff {
+			break
+		}
+	}
+	if f.maxRestartBackoff > 0 && d > f.maxRestartBackoff {
+		d = f.maxRestartBackoff
+	}
+	return d
+}
@@ -0,0 +1,27 @@
+package framework
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	f := &framework{restartBackoff: time.Second, maxRestartBackoff: 5 * time.Second}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, time.Second},
+		{3, 2 * time.Second},
+		{4, 4 * time.Second},
+		{5, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+	for i, tt := range tests {
+		if get := f.backoff(tt.failures); get != tt.want {
+			t.Errorf("#%d: backoff(%d) want = %v, get = %v", i, tt.failures, tt.want, get)
+		}
+	}
+}
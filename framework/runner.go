@@ -0,0 +1,99 @@
+package framework
+
+import (
+	"net"
+	"sync"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// Runner hosts several frameworks of a job in one process, e.g. for tests and
+// experiments at laptop scale. They share an etcd client, and one listener
+// multiplexed by frameworkhttp.Mux, instead of each opening their own, unless
+// they coordinate through one given by WithCoordinator. Data
+// requests are routed to frameworks by their addresses on the Mux, so hosted
+// frameworks need the default HTTP transport.
+type Runner struct {
+	name     string
+	etcdURLs []string
+	client   etcdutil.Client
+	mux      *frameworkhttp.Mux
+	opts     []Option
+
+	mu         sync.Mutex
+	bootstraps []meritop.Bootstrap
+}
+
+// NewRunner creates a runner serving data requests of frameworks it hosts on
+// ln. opts are applied to all of them. The etcd client they share is the one
+// given by WithEtcdClient, or else created with what's given by
+// WithEtcdClientInfo, e.g. to connect over mutual TLS.
+func NewRunner(jobName string, etcdURLs []string, ln net.Listener, opts ...Option) *Runner {
+	var f framework
+	for _, opt := range opts {
+		opt(&f)
+	}
+	client := f.etcdClient
+	if client == nil && f.coord == nil {
+		c, err := etcdutil.NewClient(etcdURLs, f.etcdInfo)
+		if err == nil {
+			client = c
+		}
+		// Otherwise frameworks fail to create one of their own alike, and
+		// give up starting.
+	}
+	r := &Runner{
+		name:     jobName,
+		etcdURLs: etcdURLs,
+		client:   client,
+		mux:      frameworkhttp.NewMux(ln),
+		opts:     opts,
+	}
+	go r.mux.Serve()
+	return r
+}
+
+// NewBootStrap creates a framework hosted by the runner. opts are applied
+// after the ones of the runner.
+func (r *Runner) NewBootStrap(opts ...Option) meritop.Bootstrap {
+	var all []Option
+	if r.client != nil {
+		all = append(all, WithEtcdClient(r.client))
+	}
+	all = append(all, r.opts...)
+	b := NewBootStrap(r.name, r.etcdURLs, r.mux.Listen(), nil, append(all, opts...)...)
+	r.mu.Lock()
+	r.bootstraps = append(r.bootstraps, b)
+	r.mu.Unlock()
+	return b
+}
+
+// Start starts all frameworks created, and returns once all of them have
+// returned from Start.
+func (r *Runner) Start() {
+	var wg sync.WaitGroup
+	for _, b := range r.hosted() {
+		wg.Add(1)
+		go func(b meritop.Bootstrap) {
+			defer wg.Done()
+			b.Start()
+		}(b)
+	}
+	wg.Wait()
+}
+
+// Stop stops all frameworks hosted, and closes the listener.
+func (r *Runner) Stop() {
+	for _, b := range r.hosted() {
+		b.Stop()
+	}
+	r.mux.Close()
+}
+
+func (r *Runner) hosted() []meritop.Bootstrap {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]meritop.Bootstrap(nil), r.bootstraps...)
+}
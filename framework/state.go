@@ -0,0 +1,63 @@
+package framework
+
+import (
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// coordStateStore is the default meritop.StateStore, keeping checkpoints
+// with the coordinator of the framework, e.g. under the task directory in
+// etcd.
+type coordStateStore struct {
+	coord etcdutil.Coordinator
+	name  string
+}
+
+func (s *coordStateStore) Save(taskID, epoch uint64, data []byte) error {
+	return s.coord.SaveTaskState(s.name, taskID, epoch, data)
+}
+
+func (s *coordStateStore) Load(taskID uint64) (uint64, []byte, bool, error) {
+	return s.coord.LoadTaskState(s.name, taskID)
+}
+
+func (f *framework) SaveState(epoch uint64, data []byte) {
+	if err := f.stateStore.Save(f.taskID, epoch, data); err != nil {
+		f.reportError(meritop.SeverityRecoverable, fmt.Sprintf("saving state of epoch %d", epoch), err)
+		return
+	}
+	f.truncateWAL()
+}
+
+func (f *framework) LoadLatestState() (uint64, []byte) {
+	epoch, data, ok, err := f.stateStore.Load(f.taskID)
+	if err != nil {
+		f.reportError(meritop.SeverityRecoverable, "loading state", err)
+		return 0, nil
+	}
+	if !ok {
+		return 0, nil
+	}
+	return epoch, data
+}
+
+// restoreState passes the latest checkpoint of the task to it, if the task
+// implements meritop.Restorable and there is one.
+func (f *framework) restoreState() {
+	r, ok := f.task.(meritop.Restorable)
+	if !ok {
+		return
+	}
+	epoch, data, ok, err := f.stateStore.Load(f.taskID)
+	if err != nil {
+		f.reportError(meritop.SeverityRecoverable, "restoring state", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	f.log.Infof("task %d restoring state of epoch %d", f.taskID, epoch)
+	r.Restore(epoch, data)
+}
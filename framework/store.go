@@ -0,0 +1,40 @@
+package framework
+
+import (
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// coordStore is the meritop.Store of a task, keeping values with the
+// coordinator of the framework, e.g. under the task directory in etcd.
+type coordStore struct {
+	coord  etcdutil.Coordinator
+	name   string
+	taskID uint64
+}
+
+func (f *framework) Store() meritop.Store {
+	return &coordStore{coord: f.coord, name: f.name, taskID: f.taskID}
+}
+
+func (s *coordStore) Get(key string) ([]byte, bool, error) {
+	return s.coord.TaskData(s.name, s.taskID, key)
+}
+
+func (s *coordStore) Set(key string, value []byte) error {
+	return s.coord.SetTaskData(s.name, s.taskID, key, value)
+}
+
+func (s *coordStore) CompareAndSwap(key string, prev, value []byte) (bool, error) {
+	return s.coord.CASTaskData(s.name, s.taskID, key, prev, value)
+}
+
+func (s *coordStore) Delete(key string) error {
+	return s.coord.DeleteTaskData(s.name, s.taskID, key)
+}
+
+func (s *coordStore) Watch(key string, stop chan bool) <-chan []byte {
+	values := make(chan []byte, 1)
+	s.coord.WatchTaskData(s.name, s.taskID, key, values, stop)
+	return values
+}
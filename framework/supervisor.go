@@ -0,0 +1,84 @@
+package framework
+
+import (
+	"net"
+	"runtime/debug"
+	"sync"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+)
+
+// recoverTask stops the framework if the task panicked, so that the node can
+// take a task again. It needs to be deferred by everything calling the task.
+// Unsupervised, the panic goes on and kills the process.
+func (f *framework) recoverTask() {
+	if !f.supervise {
+		return
+	}
+	p := recover()
+	if p == nil {
+		return
+	}
+	f.log.Errorf("task %d panicked: %v\n%s", f.taskID, p, debug.Stack())
+	f.panicMu.Lock()
+	if f.taskPanic == nil {
+		f.taskPanic = p
+	}
+	f.panicMu.Unlock()
+	f.stop()
+}
+
+// initTask initializes the task taken, and restores its state. The task is
+// stopped if it fails to initialize.
+func (f *framework) initTask() {
+	defer f.recoverTask()
+	if err := f.callInit(); err != nil {
+		f.reportError(meritop.SeverityFatal, "initializing task", err)
+		return
+	}
+	f.restoreState()
+}
+
+// restartTask reports the task panicked as failed, so that it's counted
+// along with other failures of it, and gets the framework ready to take a
+// task again. It returns false if the framework can't.
+func (f *framework) restartTask() bool {
+	f.log.Warnf("task %d failed, restarting the node", f.taskID)
+	// The event loop might have panicked before releasing watches of the epoch.
+	f.releaseEpochResource()
+	// The node mustn't reclaim the task without it being counted as failed.
+	addr := frameworkhttp.ListenerAddr(f.ln)
+	if err := f.coord.FailTask(f.name, f.taskID, addr, etcdutil.TaskActor(f.taskID), "task panicked"); err != nil {
+		f.log.Warnf("reporting failure of task %d failed: %v", f.taskID, err)
+	}
+
+	// The listener has been closed when framework stopped.
+	ln, err := relisten(f.ln)
+	if err != nil {
+		f.log.Warnf("listening on %s again failed: %v", addr, err)
+		return false
+	}
+	f.ln = ln
+
+	f.task = nil
+	f.taskPanic = nil
+	f.stopOnce = sync.Once{}
+	f.exitOnce = sync.Once{}
+	f.forgetFlagged()
+	f.reqCtx, f.cancelReqs = nil, nil
+	f.checkpointPending = false
+	return true
+}
+
+// relisten listens again on the address of ln, which has been closed.
+// Listeners on a frameworkhttp.Mux are taken back from it.
+func relisten(ln net.Listener) (net.Listener, error) {
+	if r, ok := ln.(interface {
+		Relisten() (net.Listener, error)
+	}); ok {
+		return r.Relisten()
+	}
+	return net.Listen(ln.Addr().Network(), ln.Addr().String())
+}
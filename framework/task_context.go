@@ -0,0 +1,53 @@
+package framework
+
+import (
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+	"golang.org/x/net/context"
+)
+
+// callInit initializes the task, with the context framework runs it in if
+// it's a meritop.ContextTask. Only a meritop.TaskV2 can fail.
+func (f *framework) callInit() error {
+	if t, ok := f.task.(meritop.TaskV2); ok {
+		return t.TryInit(f.taskID, f)
+	}
+	if t, ok := f.task.(meritop.ContextTask); ok {
+		t.InitContext(f.runCtx, f.taskID, f)
+		return nil
+	}
+	f.task.Init(f.taskID, f)
+	return nil
+}
+
+// setEpoch tells the task the epoch has started, with the context of the epoch
+// if it's a meritop.ContextTask. A meritop.TaskV2 failing to start the epoch
+// is stopped, so that another node takes it over.
+func (f *framework) setEpoch(ctx *epochContext) {
+	if t, ok := f.task.(meritop.TaskV2); ok {
+		if err := t.TrySetEpoch(ctx, ctx.epoch); err != nil {
+			f.reportError(meritop.SeverityFatal, fmt.Sprintf("setting epoch %d", ctx.epoch), err)
+		}
+		return
+	}
+	if t, ok := f.task.(meritop.ContextTask); ok {
+		t.SetEpochContext(f.epochCtx, ctx, ctx.epoch)
+		return
+	}
+	f.task.SetEpoch(ctx, ctx.epoch)
+}
+
+// mergeContext returns a context of parent, which is also canceled once other
+// is done.
+func mergeContext(parent, other context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-other.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
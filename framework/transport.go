@@ -0,0 +1,251 @@
+package framework
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+	"github.com/go-distributed/meritop/pkg/etcdutil"
+	"golang.org/x/net/context"
+)
+
+// Transport carries data requests and responses between tasks. Framework
+// talks HTTP by default (see frameworkhttp.Transport). Other transports, e.g.
+// gRPC, raw TCP or in-process ones for testing, can be plugged in with
+// WithTransport without touching task code.
+type Transport interface {
+	// Serve answers data requests arriving on ln with the data returned by dg.
+	// It blocks until ln is closed, and should let requests being answered
+	// finish before returning.
+	Serve(ln net.Listener, dg frameworkhttp.DataGetter) error
+
+	// Send asks the task serving at addr for data. "from" is the requesting
+	// task, "to" the serving one, and epoch the epoch of the request.
+	// Send should give up and return once ctx is canceled.
+	Send(ctx context.Context, addr, req string, from, to, epoch uint64) (*frameworkhttp.DataResponse, error)
+}
+
+// StreamTransport is implemented by transports which can hand over data before
+// it is fully received. Framework uses it for tasks implementing
+// meritop.DataStreamReceiver.
+type StreamTransport interface {
+	// SendStream is like Send, but the data is left unread in the Body of
+	// returned response.
+	SendStream(ctx context.Context, addr, req string, from, to, epoch uint64) (*frameworkhttp.DataResponse, error)
+}
+
+// MultiTransport is implemented by transports which can ask for the data of
+// several keys in one round trip. Framework falls back to one request per key
+// for others.
+type MultiTransport interface {
+	SendMulti(ctx context.Context, addr string, reqs []string, from, to, epoch uint64) ([]*frameworkhttp.DataResponse, error)
+}
+
+// ResumeTransport is implemented by transports which can continue a broken
+// transfer of data. partial is the data received before the break; the whole
+// data is returned. Framework restarts the transfer from zero for others.
+type ResumeTransport interface {
+	Resume(ctx context.Context, addr, req string, from, to, epoch uint64, partial []byte) (*frameworkhttp.DataResponse, error)
+}
+
+// PushTransport is implemented by transports which can push data to tasks
+// without being asked. Framework needs it for Context.DataPush.
+type PushTransport interface {
+	Push(ctx context.Context, addr, req string, from, to, epoch uint64, data []byte) error
+}
+
+// AuthTransport is implemented by transports which can authenticate data
+// requests with a token shared by tasks of the job.
+type AuthTransport interface {
+	SetAuthToken(token string)
+}
+
+// FencedTransport is implemented by transports which can reject data requests
+// of nodes having lost their task to another node.
+type FencedTransport interface {
+	SetFencing(generation uint64, stale frameworkhttp.StaleFunc)
+}
+
+// Option configures optional behavior of the framework created by NewBootStrap.
+type Option func(*framework)
+
+// WithLogger makes framework log to l, e.g. to plug in a logging library, or
+// to log at another level.
+func WithLogger(l meritop.Logger) Option {
+	return func(f *framework) { f.logger = l }
+}
+
+// WithEtcdClient makes framework talk to etcd with c instead of a client of
+// its own, e.g. to share one among frameworks hosted in the same process, or
+// an etcdutil.MemClient in tests.
+func WithEtcdClient(c etcdutil.Client) Option {
+	return func(f *framework) { f.etcdClient = c }
+}
+
+// WithEtcdClientInfo makes the etcd client framework creates connect with
+// info, e.g. over mutual TLS or with credentials of etcd auth. It has no
+// effect if a client is given by WithEtcdClient.
+func WithEtcdClientInfo(info etcdutil.ClientInfo) Option {
+	return func(f *framework) { f.etcdInfo = info }
+}
+
+// WithCoordinator makes framework coordinate the task with the rest of the job
+// through c instead of etcd, e.g. a backend on ZooKeeper, or an
+// etcdutil.MemCoordinator in tests. No etcd client is created then, see
+// etcdutil.Coordinator.
+func WithCoordinator(c etcdutil.Coordinator) Option {
+	return func(f *framework) { f.coord = c }
+}
+
+// WithEtcdRoot makes framework look for the job under root in etcd, where the
+// controller has set it up (see controller.SetRoot).
+func WithEtcdRoot(root string) Option {
+	return func(f *framework) { f.name = etcdutil.JobName(root, f.jobName) }
+}
+
+// WithTransport lets framework exchange data with other tasks via t instead
+// of the default HTTP transport.
+func WithTransport(t Transport) Option {
+	return func(f *framework) { f.transport = t }
+}
+
+// WithTLS makes the default HTTP transport serve and send data requests over
+// TLS. See frameworkhttp.TLSInfo for creating the config from files. It has
+// no effect if a transport is given by WithTransport.
+func WithTLS(cfg *tls.Config) Option {
+	return func(f *framework) { f.tlsConfig = cfg }
+}
+
+// WithRateLimit makes the default HTTP transport limit the rate of data
+// requests served by the task. Throttled requesters retry after the time
+// told by the task. It has no effect if a transport is given by WithTransport.
+func WithRateLimit(rl frameworkhttp.RateLimit) Option {
+	return func(f *framework) { f.rateLimit = &rl }
+}
+
+// WithAuthToken sets the token tasks authenticate data requests with. All tasks
+// of the job need the same one. Without it, the token created by controller
+// (see Controller.EnableAuth) is used if there is one.
+func WithAuthToken(token string) Option {
+	return func(f *framework) { f.authToken = token }
+}
+
+// WithRequiredConfig makes framework check that keys are set in the
+// configuration of the job before it takes a task. Without them, Start gives
+// up.
+func WithRequiredConfig(keys ...string) Option {
+	return func(f *framework) { f.requiredConfig = keys }
+}
+
+// WithHooks registers hooks framework calls as the task goes through epochs.
+// It can be given more than once, and hooks are called in order.
+func WithHooks(h Hooks) Option {
+	return func(f *framework) { f.hooks = append(f.hooks, h) }
+}
+
+// WithMaxDataRequestAttempts sets how many times a failed data request is
+// tried before framework gives up on it. Requests throttled by the serving
+// task are tried 4 times as many.
+func WithMaxDataRequestAttempts(n int) Option {
+	return func(f *framework) { f.maxDataReqAttempts = n }
+}
+
+// WithDataRequestWorkers sets how many requests of a DataRequestAll batch are
+// sent concurrently.
+func WithDataRequestWorkers(n int) Option {
+	return func(f *framework) { f.dataReqWorkers = n }
+}
+
+// WithMaxInFlightRequests bounds the number of data requests a task sends at
+// the same time, and the number it serves. Once the limit is reached,
+// Context.DataRequest blocks until a request is done, and incoming requests
+// wait before reaching the task.
+func WithMaxInFlightRequests(n int) Option {
+	return func(f *framework) { f.maxInFlightReqs = n }
+}
+
+// WithCodec sets the codec returned by Framework.GetCodec. All tasks of a job
+// need to use the same codec. It's JSON by default.
+func WithCodec(c meritop.Codec) Option {
+	return func(f *framework) { f.codec = c }
+}
+
+// WithStateStore makes framework keep checkpoints saved by Framework.SaveState
+// in s, instead of etcd. It's needed for states too large for etcd.
+func WithStateStore(s meritop.StateStore) Option {
+	return func(f *framework) { f.stateStore = s }
+}
+
+// WithHeartbeatInterval sets how often framework heartbeats for the task in
+// etcd. The task is taken to have failed, and might be taken over, after
+// missing about three of them. It's a second by default.
+func WithHeartbeatInterval(d time.Duration) Option {
+	return func(f *framework) { f.heartbeatEvery = d }
+}
+
+// WithRestartBackoff makes standbys wait before taking over a task which
+// failed more than once recently, base for the second failure and doubling
+// from there up to max. A task which failed more than maxRestarts times is
+// poisoned, i.e. taken off free tasks for good (see Controller.UnpoisonTask).
+// Non-positive maxRestarts means never.
+func WithRestartBackoff(base, max time.Duration, maxRestarts int) Option {
+	return func(f *framework) {
+		f.restartBackoff = base
+		f.maxRestartBackoff = max
+		f.maxRestarts = maxRestarts
+	}
+}
+
+// WithSupervisor makes Start recover from panics of the task instead of
+// crashing the process. The task is reported failed, and the node takes a task
+// again, which could be the same one, once others have had the chance to.
+func WithSupervisor() Option {
+	return func(f *framework) { f.supervise = true }
+}
+
+// WithLocalWAL makes framework keep a write-ahead log for the task in dir on
+// the local node, see meritop.LoggedFramework.
+func WithLocalWAL(dir string) Option {
+	return func(f *framework) { f.walDir = dir }
+}
+
+// WithBackups makes framework a backup copy of some task if it's started when
+// all tasks are taken, keeping up to n of them for each task. Backups get
+// update logs shipped by the primary with BackedUpFramework.Update, and take
+// the task over once the primary fails. Tasks need to be meritop.Backupable.
+func WithBackups(n int) Option {
+	return func(f *framework) { f.maxBackups = n }
+}
+
+// WithEpochBarrier makes Context.IncEpoch wait for quorum tasks to be done with
+// the epoch (see Context.EpochDone) before it advances. All tasks of the job
+// need to be done if quorum isn't positive.
+func WithEpochBarrier(quorum int) Option {
+	return func(f *framework) {
+		f.barrier = true
+		f.barrierQuorum = quorum
+	}
+}
+
+// WithEpochDeadline makes framework call meritop.EpochStallHandler of task
+// taskID once an epoch hasn't advanced for timeout, and again each time it
+// stays for another timeout.
+func WithEpochDeadline(timeout time.Duration, taskID uint64) Option {
+	return func(f *framework) {
+		f.epochDeadline = timeout
+		f.deadlineTaskID = taskID
+	}
+}
+
+// WithChildrenReady makes framework call meritop.ChildrenReadyHandler in each
+// epoch once fraction of children have had their data delivered, or timeout
+// has elapsed, whichever comes first. Non-positive fraction means all
+// children, and non-positive timeout means no timeout.
+func WithChildrenReady(fraction float64, timeout time.Duration) Option {
+	return func(f *framework) {
+		f.childrenFraction = fraction
+		f.childrenTimeout = timeout
+	}
+}
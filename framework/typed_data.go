@@ -0,0 +1,39 @@
+package framework
+
+import (
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+	merrors "github.com/go-distributed/meritop/errors"
+	"github.com/go-distributed/meritop/framework/frameworkhttp"
+)
+
+// marshalTyped encodes the value served by a meritop.TypedTask. Values the
+// codec can't encode won't ever be served, so the request isn't retried.
+func (f *framework) marshalTyped(req string, v interface{}) ([]byte, error) {
+	data, err := f.codec.Marshal(v)
+	if err != nil {
+		return nil, merrors.New(merrors.ErrBadRequest, fmt.Sprintf("framework: marshaling data of %s failed: %v", req, err))
+	}
+	return data, nil
+}
+
+// handleTypedData passes the value decoded from the response to a
+// meritop.TypedTask. Data the codec can't decode is reported, and dropped.
+func (f *framework) handleTypedData(ctx meritop.Context, t meritop.TypedTask, resp *frameworkhttp.DataResponse) {
+	v, err := f.codec.Unmarshal(resp.Data)
+	if err != nil {
+		f.reportError(meritop.SeverityRecoverable,
+			fmt.Sprintf("unmarshaling data of %s from task %d", resp.Req, resp.TaskID), err)
+		return
+	}
+	switch {
+	case f.isParent(resp.Epoch, resp.TaskID):
+		t.ParentTypedDataReady(ctx, resp.TaskID, resp.Req, v)
+	case f.isChild(resp.Epoch, resp.TaskID):
+		t.ChildTypedDataReady(ctx, resp.TaskID, resp.Req, v)
+		f.childResponded(resp.Epoch, resp.TaskID)
+	default:
+		panic("unexpected")
+	}
+}
@@ -0,0 +1,24 @@
+package framework
+
+import "github.com/go-distributed/meritop/framework/frameworkhttp"
+
+// VersionedTransport is implemented by transports which can talk older
+// versions of the protocol to tasks of older releases.
+type VersionedTransport interface {
+	SetPeerVersions(versions frameworkhttp.VersionFunc)
+}
+
+// setupVersion registers the protocol version the task talks, and lets
+// transport know the versions of others.
+func (f *framework) setupVersion() error {
+	if vt, ok := f.transport.(VersionedTransport); ok {
+		vt.SetPeerVersions(f.addrCache.version)
+	}
+	return f.coord.SetVersion(f.name, f.taskID, frameworkhttp.ProtocolVersion)
+}
+
+// peerTalks tells if the task talks version of the protocol, so that features
+// it lacks, e.g. pushes, aren't asked of it.
+func (f *framework) peerTalks(taskID uint64, version int) bool {
+	return f.addrCache.version(taskID) >= version
+}
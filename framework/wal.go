@@ -0,0 +1,57 @@
+package framework
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/wal"
+)
+
+var errNoWAL = errors.New("framework: local WAL isn't set up, see WithLocalWAL")
+
+// openWAL opens the local log of the task, the one left by the node which
+// worked for the task here before if any.
+func (f *framework) openWAL() {
+	if f.walDir == "" {
+		return
+	}
+	path := filepath.Join(f.walDir, fmt.Sprintf("%s-%d.wal", f.jobName, f.taskID))
+	w, err := wal.Open(path)
+	if err != nil {
+		f.reportError(meritop.SeverityRecoverable, "opening WAL "+path, err)
+		return
+	}
+	f.wal = w
+}
+
+func (f *framework) closeWAL() {
+	if f.wal != nil {
+		f.wal.Close()
+	}
+}
+
+// truncateWAL drops updates logged, since the state they update is saved.
+func (f *framework) truncateWAL() {
+	if f.wal == nil {
+		return
+	}
+	if err := f.wal.Truncate(); err != nil {
+		f.reportError(meritop.SeverityRecoverable, "truncating WAL", err)
+	}
+}
+
+func (f *framework) AppendLog(data []byte) error {
+	if f.wal == nil {
+		return errNoWAL
+	}
+	return f.wal.Append(data)
+}
+
+func (f *framework) ReplayLog(fn func(data []byte) error) error {
+	if f.wal == nil {
+		return errNoWAL
+	}
+	return f.wal.Replay(fn)
+}
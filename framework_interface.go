@@ -1,6 +1,6 @@
 package meritop
 
-import "log"
+import "golang.org/x/net/context"
 
 // This interface is used by application during taskgraph configuration phase.
 type Bootstrap interface {
@@ -14,15 +14,64 @@ type Bootstrap interface {
 	// After all the configure is done, driver need to call start so that all
 	// nodes will get into the event loop to run the application.
 	Start()
+
+	// Stop stops the framework on this node alone, and returns once Start has
+	// returned. The task it runs is freed for standbys to take over. It mustn't
+	// be called by the task, which can Exit or Drain instead.
+	Stop()
 }
 
 // Note that framework can decide how update can be done, and how to serve the updatelog.
+// Framework implements it, so tasks can get it by asserting Framework.
 type BackedUpFramework interface {
 	// Ask framework to do update on this update on this task, which consists
-	// of one primary and some backup copies.
-	Update(taskID uint64, log UpdateLog)
+	// of one primary and some backup copies. The update is kept in a durable
+	// log, and the ID assigned to it is returned.
+	Update(taskID uint64, data []byte) uint64
 }
 
+// LoggedFramework keeps a write-ahead log on the local node, for tasks with
+// states too large for StateStore to log updates to them. A task restarted on
+// the same node replays them, e.g. in Init, without going to the network.
+// The log is truncated whenever the task saves state, with SaveState or at
+// checkpoints. Framework implements it, so tasks can get it by asserting
+// Framework. See framework.WithLocalWAL.
+type LoggedFramework interface {
+	// AppendLog appends data to the log, synced to disk once it returns.
+	AppendLog(data []byte) error
+
+	// ReplayLog passes data logged since state was last saved to fn in order.
+	ReplayLog(fn func(data []byte) error) error
+}
+
+// ContextFramework gives tasks the context framework runs the task in, e.g.
+// to bound their own blocking calls. It's canceled once framework stops.
+// Framework implements it, so tasks can get it by asserting Framework.
+type ContextFramework interface {
+	Context() context.Context
+}
+
+// ConfiguredFramework gives tasks the configuration of the job, loaded from
+// etcd as framework starts the task, and kept up to date as it changes. See
+// Controller.SetConfig, ConfigWatcher and framework.WithRequiredConfig.
+// Framework implements it, so tasks can get it by asserting Framework.
+type ConfiguredFramework interface {
+	Config() Config
+}
+
+// ContextRequester lets tasks cancel data requests themselves, on top of
+// framework canceling them on epoch change. Requests canceled are not reported
+// to DataRequestFailureHandler. Context of framework implements it, so tasks
+// can get it by asserting Context.
+type ContextRequester interface {
+	DataRequestContext(ctx context.Context, toID uint64, req string)
+}
+
+// ReduceOp combines two pieces of data into one for Framework.AllReduce. Data
+// is reduced in the order of the tree, so it needs to be associative and
+// commutative, e.g. summing up vectors.
+type ReduceOp func(a, b []byte) []byte
+
 // Framework hides distributed system complexity and provides users convenience of
 // high level features.
 type Framework interface {
@@ -34,10 +83,78 @@ type Framework interface {
 	// TODO: @param status
 	ShutdownJob()
 
-	GetLogger() *log.Logger
+	// GetLogger returns the logger framework logs to, for tasks to log along.
+	GetLogger() Logger
 
 	// This is used to figure out taskid for current node
 	GetTaskID() uint64
+
+	// GetNumTasks returns the number of tasks of the job, e.g. for tasks to
+	// shard data by task ID. It's kept from etcd as each epoch starts, since
+	// the job can be resized while it runs.
+	GetNumTasks() uint64
+
+	// GetJobName returns the name of the job, which its etcd layout is under.
+	GetJobName() string
+
+	// Cancel all in-flight data requests sent by this task. Responses of
+	// canceled requests will not be delivered. Framework does this itself
+	// on epoch change and when the task stops.
+	CancelAllRequests()
+
+	// GetCodec returns the codec tasks should use to encode data served to
+	// other tasks and decode data received from them.
+	GetCodec() Codec
+
+	// SaveState checkpoints state of the task taken at epoch, replacing the
+	// previous one. It's kept across failures of the task, so whoever takes
+	// it over can pick up from there.
+	SaveState(epoch uint64, data []byte)
+
+	// LoadLatestState returns the latest checkpoint saved by the task, or nil
+	// data if there is none.
+	LoadLatestState() (epoch uint64, data []byte)
+
+	// Store returns the key-value store of the task, e.g. to keep counters
+	// and offsets across failures of the task.
+	Store() Store
+
+	// Drain hands the task over to another node, e.g. for planned maintenance
+	// of this one. Tasks implementing Checkpointer are checkpointed, and others
+	// should SaveState beforehand. The task then exits, and is freed for
+	// standbys to take over without being counted as failed.
+	Drain()
+
+	// Exit stops this task alone, e.g. once it's done with its share of work,
+	// while the rest of the job goes on. Task.Exit is called, and the task is
+	// reported exited in etcd, so it's neither counted as failed nor taken
+	// over by standbys. Use ShutdownJob to stop all tasks.
+	Exit()
+
+	// State returns where framework is in its lifecycle.
+	State() FrameworkState
+
+	// StateChanges returns a channel getting the current state, and then
+	// states framework moves to, e.g. for tests to wait for the task to run
+	// instead of sleeping. It's closed once framework has stopped. States are
+	// dropped if the receiver falls too far behind.
+	StateChanges() <-chan FrameworkState
+
+	// AllReduce reduces data of all tasks with op, and returns the result
+	// to each of them. It's done over the tree of the topology in the current
+	// epoch: data is reduced up to the root, and the result passed back down.
+	// Every task has to call it, and calls are matched across tasks by their
+	// order in the epoch. It blocks until the result arrives, so tasks call it
+	// from goroutines of their own rather than from callbacks. An error of
+	// kind ErrEpochConflict is returned if the epoch changes meanwhile.
+	AllReduce(data []byte, op ReduceOp) ([]byte, error)
+
+	// Barrier blocks until all tasks of the job have entered the barrier of
+	// the name in the current epoch, e.g. to go through phases of work within
+	// the epoch together. Like AllReduce, tasks call it from goroutines of
+	// their own, and an error of kind ErrEpochConflict is returned if the
+	// epoch changes meanwhile.
+	Barrier(name string) error
 }
 
 // Context is used in task callbacks. It provides APIs for tasks to ask framework
@@ -49,9 +166,71 @@ type Context interface {
 	FlagMetaToParent(meta string)
 	FlagMetaToChild(meta string)
 
-	// Some task can inform all participating tasks to new epoch
+	// These are like the above, but flag a meta of given kind carrying
+	// payload. Receivers get it as a whole if they implement TypedMetaReceiver,
+	// otherwise only the kind is passed to ParentMetaReady/ChildMetaReady.
+	FlagTypedMetaToParent(kind string, payload []byte)
+	FlagTypedMetaToChild(kind string, payload []byte)
+
+	// FlagMetaToPeer notifies peers of this task. It needs Topology to be a
+	// PeerTopology.
+	FlagMetaToPeer(meta string)
+
+	// FlagMetaToNeighbors notifies tasks linked to this one with given link
+	// type. It needs Topology to be a LinkTopology.
+	FlagMetaToNeighbors(linkType string, meta string)
+
+	// Some task can inform all participating tasks to new epoch. It does
+	// nothing if the controller paces epochs of the job, see
+	// controller.Controller.PaceEpochsEvery.
 	IncEpoch()
 
-	// Request data from parent or children.
+	// EpochDone tells framework that the task has finished its work of the
+	// epoch. With an epoch barrier set up, IncEpoch only advances the epoch
+	// once enough tasks are done with it; the task calling IncEpoch counts as
+	// done. If the controller paces epochs once tasks are ready, it advances
+	// the epoch once all tasks are done with it. It does nothing otherwise.
+	EpochDone()
+
+	// Request data from parent or children. Requests framework gives up on
+	// are reported to DataRequestFailureHandler.
 	DataRequest(toID uint64, meta string)
+
+	// DataRequestFunc is like DataRequest, but the response is passed to fn
+	// rather than ParentDataReady/ChildDataReady, or the error the request
+	// failed with rather than to DataRequestFailureHandler. fn isn't called
+	// once the epoch has changed.
+	DataRequestFunc(toID uint64, req string, fn func(data []byte, err error))
+
+	// Request data of several keys from a parent or child in one round trip.
+	// Data of each key is passed to ParentDataReady/ChildDataReady as if
+	// requested one by one.
+	DataRequestMulti(toID uint64, reqs []string)
+
+	// Request data from several parents or children at once. Requests are
+	// sent concurrently, and responses are passed to
+	// BatchDataReceiver.DataAllReady together once all of them arrived.
+	DataRequestAll(toIDs []uint64, req string)
+	// DataRequestQuorum is like DataRequestAll, but responses are passed on
+	// once quorum of them arrived. The rest are canceled.
+	DataRequestQuorum(toIDs []uint64, req string, quorum int)
+
+	// BroadcastToChildren passes data to all children with one call. meta is
+	// flagged to them, and data is staged for the epoch, which framework of
+	// each child fetches and passes to ParentDataReady with meta as req.
+	// ServeAsParent isn't called for it, and children aren't told the meta.
+	BroadcastToChildren(meta string, data []byte)
+
+	// GatherFromChildren requests data from all children, and reduces their
+	// responses with reduce in order of child IDs, acc being nil for the first
+	// one. done is then called with the result, or nil if the task has no
+	// children. Responses aren't passed to ChildDataReady, and done isn't
+	// called if a request failed, which is reported to
+	// DataRequestFailureHandler as usual.
+	GatherFromChildren(req string, reduce func(acc, item []byte) []byte, done func(result []byte))
+
+	// Push data to a parent or child without it being requested. It's passed
+	// to DataPushReceiver.DataPushed of receiving task. Transport needs to
+	// support pushing, which the default HTTP one does.
+	DataPush(toID uint64, req string, data []byte)
 }
@@ -17,7 +17,7 @@ func TestHeartbeat(t *testing.T) {
 	taskID := uint64(1)
 	ttl := uint64(1)
 	interval := time.Duration(ttl) * time.Second
-	stop := make(chan struct{}, 1)
+	stop := make(chan bool, 1)
 
 	client.Create(etcdutil.TaskHealthyPath(name, taskID), "health", ttl)
 	time.Sleep(2 * interval)
@@ -27,6 +27,8 @@ func TestMasterSetEpochFailure(t *testing.T) {
 	controller := controller.New(job, etcd.NewClient(etcdURLs), numOfTasks)
 	controller.Start()
 	defer controller.Stop()
+	runner := framework.NewRunner(job, etcdURLs, createListener(t))
+	defer runner.Stop()
 
 	// We need to set etcd so that nodes know what to do.
 	taskBuilder := &framework.SimpleTaskBuilder{
@@ -41,13 +43,13 @@ func TestMasterSetEpochFailure(t *testing.T) {
 		NumberOfIterations: numOfIterations,
 	}
 	for i := uint64(0); i < numOfTasks; i++ {
-		go drive(t, job, etcdURLs, numOfTasks, taskBuilder)
+		go drive(runner, numOfTasks, taskBuilder)
 	}
 	if <-taskBuilder.NodeProducer {
 		taskBuilder.MasterConfig = nil
 		log.Println("Starting a new node")
 		// this time we start a new bootstrap whose task master doesn't fail.
-		go drive(t, job, etcdURLs, numOfTasks, taskBuilder)
+		go drive(runner, numOfTasks, taskBuilder)
 	}
 
 	wantData := []int32{0, 105, 210, 315, 420, 525, 630, 735, 840, 945, 1050}
@@ -96,6 +98,8 @@ func testSlaveFailure(t *testing.T, job string, slaveConfig map[string]string) {
 	controller := controller.New(job, etcd.NewClient(etcdURLs), numOfTasks)
 	controller.Start()
 	defer controller.Stop()
+	runner := framework.NewRunner(job, etcdURLs, createListener(t))
+	defer runner.Stop()
 
 	// We need to set etcd so that nodes know what to do.
 	taskBuilder := &framework.SimpleTaskBuilder{
@@ -108,7 +112,7 @@ func testSlaveFailure(t *testing.T, job string, slaveConfig map[string]string) {
 	go func() {
 		for _ = range taskBuilder.NodeProducer {
 			log.Println("Starting a new node")
-			go drive(t, job, etcdURLs, numOfTasks, taskBuilder)
+			go drive(runner, numOfTasks, taskBuilder)
 		}
 	}()
 	for i := uint64(0); i < numOfTasks; i++ {
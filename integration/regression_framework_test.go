@@ -35,8 +35,11 @@ func TestRegressionFramework(t *testing.T) {
 		FinishChan:         make(chan struct{}),
 		NumberOfIterations: numOfIterations,
 	}
+	// Nodes are hosted in one runner, which stops them once the test is done.
+	runner := framework.NewRunner(job, etcds, createListener(t))
+	defer runner.Stop()
 	for i := uint64(0); i < numOfTasks; i++ {
-		go drive(t, job, etcds, numOfTasks, taskBuilder)
+		go drive(runner, numOfTasks, taskBuilder)
 	}
 
 	wantData := []int32{0, 105, 210, 315, 420, 525, 630, 735, 840, 945, 1050}
@@ -62,8 +65,8 @@ func createListener(t *testing.T) net.Listener {
 }
 
 // This is used to show how to drive the network.
-func drive(t *testing.T, jobName string, etcds []string, ntask uint64, taskBuilder meritop.TaskBuilder) {
-	bootstrap := framework.NewBootStrap(jobName, etcds, createListener(t), nil)
+func drive(runner *framework.Runner, ntask uint64, taskBuilder meritop.TaskBuilder) {
+	bootstrap := runner.NewBootStrap()
 	bootstrap.SetTaskBuilder(taskBuilder)
 	bootstrap.SetTopology(example.NewTreeTopology(2, ntask))
 	bootstrap.Start()
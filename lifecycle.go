@@ -0,0 +1,30 @@
+package meritop
+
+import "fmt"
+
+// FrameworkState is where framework is in its lifecycle, see Framework.State.
+type FrameworkState int
+
+const (
+	// StateStarting is until the node has taken a task, and the task has been
+	// initialized.
+	StateStarting FrameworkState = iota
+	// StateRunning is while the task works in an epoch.
+	StateRunning
+	// StateEpochTransition is while framework moves the task on to another
+	// epoch, which could be deferred by an EpochGate.
+	StateEpochTransition
+	// StateStopping is while framework releases resources of the task.
+	StateStopping
+	// StateStopped is once framework is done, and Start has returned.
+	StateStopped
+)
+
+var stateNames = [...]string{"Starting", "Running", "EpochTransition", "Stopping", "Stopped"}
+
+func (s FrameworkState) String() string {
+	if s < StateStarting || s > StateStopped {
+		return fmt.Sprintf("FrameworkState(%d)", int(s))
+	}
+	return stateNames[s]
+}
@@ -0,0 +1,73 @@
+package meritop
+
+import (
+	"fmt"
+	"log"
+)
+
+// Logger is what framework logs to. Implement it to plug in a logging library
+// of choice, or wrap a *log.Logger with NewStdLogger.
+type Logger interface {
+	// Debugf logs chatty details, e.g. of watches on etcd and events dropped
+	// for epoch mismatch.
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	// Warnf logs failures framework copes with, e.g. by retrying.
+	Warnf(format string, v ...interface{})
+	// Errorf logs failures the task or the job is affected by.
+	Errorf(format string, v ...interface{})
+}
+
+// Level is the level of log messages, from the most verbose.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = [...]string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// StdLogger is a Logger writing to a *log.Logger messages of Level or above,
+// prefixed with their levels.
+type StdLogger struct {
+	Logger *log.Logger
+	Level  Level
+}
+
+// NewStdLogger returns a Logger writing to l messages of level or above.
+func NewStdLogger(l *log.Logger, level Level) *StdLogger {
+	return &StdLogger{Logger: l, Level: level}
+}
+
+func (l *StdLogger) Debugf(format string, v ...interface{}) { l.logf(LevelDebug, format, v...) }
+
+func (l *StdLogger) Infof(format string, v ...interface{}) { l.logf(LevelInfo, format, v...) }
+
+func (l *StdLogger) Warnf(format string, v ...interface{}) { l.logf(LevelWarn, format, v...) }
+
+func (l *StdLogger) Errorf(format string, v ...interface{}) { l.logf(LevelError, format, v...) }
+
+// Output writes s logged at level, if it's not below Level. calldepth counts
+// frames to skip to tell where it's logged from, as of log.Output, so that
+// Loggers wrapping a StdLogger can keep it.
+func (l *StdLogger) Output(calldepth int, level Level, s string) error {
+	if level < l.Level {
+		return nil
+	}
+	return l.Logger.Output(calldepth+1, level.String()+": "+s)
+}
+
+func (l *StdLogger) logf(level Level, format string, v ...interface{}) {
+	// Called by the methods above, which are called by the one logging.
+	l.Output(3, level, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,21 @@
+package meritop
+
+// Meta is a small notification flagged by a task to its parents or children.
+// Kind tells receivers what happened, e.g. "ParamReady", and Payload can carry
+// a few bytes along with it. Metas might be stored in etcd, so they have to be
+// really small.
+type Meta struct {
+	Kind string `json:"kind"`
+	// Epoch in which the meta was flagged. It's filled by framework.
+	Epoch uint64 `json:"epoch"`
+	// Seq numbers metas flagged to the same parents, children or neighbors
+	// in order. It's filled by framework.
+	Seq uint64 `json:"seq,omitempty"`
+	// Generation of the task flagging the meta, i.e. how many times it has
+	// been taken by a node. It's filled by framework.
+	Generation uint64 `json:"gen,omitempty"`
+	// Broadcast is set for metas flagged by Context.BroadcastToChildren, whose
+	// data receivers fetch by themselves. It's filled by framework.
+	Broadcast bool   `json:"bcast,omitempty"`
+	Payload   []byte `json:"payload,omitempty"`
+}
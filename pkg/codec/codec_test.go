@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-distributed/meritop"
+	"github.com/golang/protobuf/proto"
+)
+
+type tParam struct {
+	Value  int32
+	Vector []float64
+}
+
+type tProtoParam struct {
+	Value  int32     `protobuf:"varint,1,opt,name=value,proto3" json:"value,omitempty"`
+	Vector []float64 `protobuf:"fixed64,2,rep,packed,name=vector,proto3" json:"vector,omitempty"`
+}
+
+func (m *tProtoParam) Reset()         { *m = tProtoParam{} }
+func (m *tProtoParam) String() string { return proto.CompactTextString(m) }
+func (*tProtoParam) ProtoMessage()    {}
+
+func TestCodecs(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec meritop.Codec
+		v     interface{}
+	}{
+		{"json", NewJSON(), &tParam{Value: 3, Vector: []float64{1, 2.5}}},
+		{"json", NewJSON(), tParam{Value: 3}},
+		{"gob", NewGob(), &tParam{Value: 3, Vector: []float64{1, 2.5}}},
+		{"proto", NewProto(), &tProtoParam{Value: 3, Vector: []float64{1, 2.5}}},
+	}
+	for i, tt := range tests {
+		tt.codec.Register(tt.v)
+		b, err := tt.codec.Marshal(tt.v)
+		if err != nil {
+			t.Errorf("#%d (%s): Marshal failed: %v", i, tt.name, err)
+			continue
+		}
+		v, err := tt.codec.Unmarshal(b)
+		if err != nil {
+			t.Errorf("#%d (%s): Unmarshal failed: %v", i, tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, tt.v) {
+			t.Errorf("#%d (%s): value want = %v, get = %v", i, tt.name, tt.v, v)
+		}
+	}
+}
+
+func TestCodecUnregistered(t *testing.T) {
+	for i, c := range []meritop.Codec{NewJSON(), NewProto()} {
+		v := &tProtoParam{Value: 1}
+		b, err := c.Marshal(v)
+		if err != nil {
+			t.Fatalf("#%d: Marshal failed: %v", i, err)
+		}
+		if _, err := c.Unmarshal(b); err == nil {
+			t.Errorf("#%d: Unmarshal of unregistered type should fail", i)
+		}
+	}
+}
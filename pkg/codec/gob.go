@@ -0,0 +1,33 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/go-distributed/meritop"
+)
+
+type gobCodec struct{}
+
+// NewGob returns a codec encoding values with encoding/gob. Note that gob
+// keeps registered types globally.
+func NewGob() meritop.Codec { return gobCodec{} }
+
+func (gobCodec) Register(v interface{}) { gob.Register(v) }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	// Encoding through an interface makes gob send the type name.
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
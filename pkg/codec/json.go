@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"encoding/json"
+
+	"github.com/go-distributed/meritop"
+)
+
+type jsonCodec struct {
+	reg *typeRegistry
+}
+
+type jsonEnvelope struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// NewJSON returns a codec encoding values as JSON. It's the easiest to
+// debug, but slow for large numeric data.
+func NewJSON() meritop.Codec {
+	return &jsonCodec{reg: newTypeRegistry()}
+}
+
+func (c *jsonCodec) Register(v interface{}) { c.reg.register(typeName(v), v) }
+
+func (c *jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&jsonEnvelope{Type: typeName(v), Value: b})
+}
+
+func (c *jsonCodec) Unmarshal(data []byte) (interface{}, error) {
+	var e jsonEnvelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	p, err := c.reg.newValue(e.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(e.Value, p.Interface()); err != nil {
+		return nil, err
+	}
+	return c.reg.result(e.Type, p), nil
+}
@@ -0,0 +1,60 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+	"github.com/golang/protobuf/proto"
+)
+
+type protoCodec struct {
+	reg *typeRegistry
+}
+
+// NewProto returns a codec encoding values with protocol buffers. Only
+// proto.Message values are supported. It's the most compact one.
+func NewProto() meritop.Codec {
+	return &protoCodec{reg: newTypeRegistry()}
+}
+
+func (c *protoCodec) Register(v interface{}) { c.reg.register(typeName(v), v) }
+
+// Marshal writes the type name prefixed by its length in uvarint, followed by
+// the encoded message.
+func (c *protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: %T is not a proto.Message", v)
+	}
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	name := typeName(v)
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(name)+len(b))
+	n := binary.PutUvarint(buf, uint64(len(name)))
+	buf = append(buf[:n], name...)
+	return append(buf, b...), nil
+}
+
+func (c *protoCodec) Unmarshal(data []byte) (interface{}, error) {
+	l, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < l {
+		return nil, errors.New("codec: malformed proto data")
+	}
+	name := string(data[n : n+int(l)])
+	p, err := c.reg.newValue(name)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := p.Interface().(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: %s is not a proto.Message", name)
+	}
+	if err := proto.Unmarshal(data[n+int(l):], m); err != nil {
+		return nil, err
+	}
+	return c.reg.result(name, p), nil
+}
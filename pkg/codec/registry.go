@@ -0,0 +1,53 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// typeRegistry maps type names written on the wire to registered types.
+type typeRegistry struct {
+	mu    sync.RWMutex
+	types map[string]reflect.Type
+}
+
+func newTypeRegistry() *typeRegistry {
+	return &typeRegistry{types: make(map[string]reflect.Type)}
+}
+
+func (r *typeRegistry) register(name string, v interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[name] = reflect.TypeOf(v)
+}
+
+// newValue returns a pointer to a new value of the type registered by name.
+func (r *typeRegistry) newValue(name string) (reflect.Value, error) {
+	r.mu.RLock()
+	t, ok := r.types[name]
+	r.mu.RUnlock()
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("codec: type %q is not registered", name)
+	}
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()), nil
+	}
+	return reflect.New(t), nil
+}
+
+// result turns the pointer made by newValue into a value of the registered
+// type.
+func (r *typeRegistry) result(name string, p reflect.Value) interface{} {
+	r.mu.RLock()
+	t := r.types[name]
+	r.mu.RUnlock()
+	if t.Kind() == reflect.Ptr {
+		return p.Interface()
+	}
+	return p.Elem().Interface()
+}
+
+func typeName(v interface{}) string {
+	return reflect.TypeOf(v).String()
+}
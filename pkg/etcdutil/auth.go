@@ -0,0 +1,32 @@
+package etcdutil
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// etcd error code of missing keys.
+const ecodeKeyNotFound = 100
+
+// NewAuthToken creates a random token for authenticating tasks of a job.
+func NewAuthToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GetAuthToken returns the auth token of the job, or "" if the job has none.
+func GetAuthToken(client Client, name string) (string, error) {
+	resp, err := client.Get(AuthTokenPath(name), false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return resp.Node.Value, nil
+}
@@ -0,0 +1,157 @@
+package etcdutil
+
+import (
+	"path"
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+const ecodeTestFailed = 101
+
+// MarkEpochDone checks the task in at the barrier of the epoch.
+func MarkEpochDone(client Client, name string, epoch, taskID uint64) error {
+	_, err := client.Set(path.Join(BarrierPath(name, epoch), strconv.FormatUint(taskID, 10)), "done", 0)
+	return err
+}
+
+// MarkEpochAdvance asks for the epoch to advance once enough tasks are done
+// with it.
+func MarkEpochAdvance(client Client, name string, epoch uint64) error {
+	_, err := client.Set(path.Join(BarrierPath(name, epoch), BarrierAdvance), "", 0)
+	return err
+}
+
+// TryPassBarrier advances the epoch if it has been asked to, and quorum tasks
+// are done with it. Each task checking in tries it, so whoever is the last
+// one gets it through. It returns true if the epoch has been advanced, by
+// this call or another.
+func TryPassBarrier(client Client, name string, epoch uint64, quorum int) (bool, error) {
+	resp, err := client.Get(BarrierPath(name, epoch), false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			// Cleaned up after passing.
+			return true, nil
+		}
+		return false, err
+	}
+	done, advance := 0, false
+	for _, n := range resp.Node.Nodes {
+		if path.Base(n.Key) == BarrierAdvance {
+			advance = true
+		} else {
+			done++
+		}
+	}
+	if !advance || done < quorum {
+		return false, nil
+	}
+	err = CASEpoch(client, name, epoch, epoch+1)
+	if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeTestFailed {
+		// Someone else got it through.
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	RecordEvent(client, name, Event{Kind: EventEpoch, Actor: "barrier", Epoch: epoch + 1, Detail: "advanced"})
+	client.Delete(BarrierPath(name, epoch), true)
+	return true, nil
+}
+
+// EnterPhaseBarrier checks the task in at the named barrier of the epoch.
+func EnterPhaseBarrier(client Client, name string, epoch uint64, barrier string, taskID uint64) error {
+	_, err := client.Set(path.Join(PhaseBarrierPath(name, epoch, barrier), strconv.FormatUint(taskID, 10)), "", 0)
+	return err
+}
+
+// WaitPhaseBarrier blocks until n tasks have entered the named barrier of the
+// epoch. It returns false if stop is closed before. stop has to be closed
+// after it returns all the same, to stop watching the barrier.
+func WaitPhaseBarrier(client Client, name string, epoch uint64, barrier string, n int, stop chan bool) (bool, error) {
+	p := PhaseBarrierPath(name, epoch, barrier)
+	resp, err := client.Get(p, false, false)
+	if err != nil {
+		return false, err
+	}
+	if len(resp.Node.Nodes) >= n {
+		return true, nil
+	}
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, p, resp.EtcdIndex+1, true, receiver, stop)
+	for range receiver {
+		resp, err := client.Get(p, false, false)
+		if err != nil {
+			return false, err
+		}
+		if len(resp.Node.Nodes) >= n {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetControllerPaced makes the controller the only one to advance the epoch
+// of the job. Tasks still check in at the barrier of each epoch they are done
+// with.
+func SetControllerPaced(client Client, name string) error {
+	_, err := client.Set(PacingPath(name), PacingCtrl, 0)
+	return err
+}
+
+// IsControllerPaced tells whether only the controller advances the epoch of
+// the job.
+func IsControllerPaced(client Client, name string) (bool, error) {
+	resp, err := client.Get(PacingPath(name), false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return resp.Node.Value == PacingCtrl, nil
+}
+
+// WaitEpochDone blocks until n tasks have checked in at the barrier of the
+// epoch. It returns false if stop is closed before. stop has to be closed
+// after it returns all the same, to stop watching the barrier.
+func WaitEpochDone(client Client, name string, epoch uint64, n int, stop chan bool) (bool, error) {
+	p := BarrierPath(name, epoch)
+	done := func(resp *etcd.Response) bool {
+		count := 0
+		for _, node := range resp.Node.Nodes {
+			if path.Base(node.Key) != BarrierAdvance {
+				count++
+			}
+		}
+		return count >= n
+	}
+	var index uint64
+	resp, err := client.Get(p, false, false)
+	switch e, ok := err.(*etcd.EtcdError); {
+	case err == nil:
+		if done(resp) {
+			return true, nil
+		}
+		index = resp.EtcdIndex + 1
+	case ok && e.ErrorCode == ecodeKeyNotFound:
+		index = e.Index + 1
+	default:
+		return false, err
+	}
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, p, index, true, receiver, stop)
+	for range receiver {
+		resp, err := client.Get(p, false, false)
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if done(resp) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,107 @@
+package etcdutil
+
+import (
+	"path"
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// RequestCheckpoint asks all tasks to checkpoint at the start of epoch.
+func RequestCheckpoint(client Client, name string, epoch uint64) error {
+	_, err := client.Set(CheckpointRequestPath(name), strconv.FormatUint(epoch, 10), 0)
+	return err
+}
+
+// WatchCheckpointRequest passes epochs tasks are asked to checkpoint at to
+// handler until stop, starting with the one asked before, if any.
+func WatchCheckpointRequest(client Client, name string, stop chan bool, handler func(epoch uint64)) error {
+	var index uint64
+	resp, err := client.Get(CheckpointRequestPath(name), false, false)
+	switch e, ok := err.(*etcd.EtcdError); {
+	case err == nil:
+		if epoch, err := strconv.ParseUint(resp.Node.Value, 10, 64); err == nil {
+			handler(epoch)
+		}
+		index = resp.EtcdIndex + 1
+	case ok && e.ErrorCode == ecodeKeyNotFound:
+		index = e.Index + 1
+	default:
+		return err
+	}
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, CheckpointRequestPath(name), index, false, receiver, stop)
+	go func() {
+		for resp := range receiver {
+			if resp.Action != "set" && resp.Action != "get" {
+				continue
+			}
+			if epoch, err := strconv.ParseUint(resp.Node.Value, 10, 64); err == nil {
+				handler(epoch)
+			}
+		}
+	}()
+	return nil
+}
+
+// MarkCheckpointDone checks the task in as checkpointed at the epoch.
+func MarkCheckpointDone(client Client, name string, epoch, taskID uint64) error {
+	_, err := client.Set(path.Join(CheckpointPath(name, epoch), strconv.FormatUint(taskID, 10)), "done", 0)
+	return err
+}
+
+// WaitCheckpoint blocks until n tasks have checkpointed at the epoch.
+func WaitCheckpoint(client Client, name string, epoch uint64, n int) error {
+	key := CheckpointPath(name, epoch)
+	for {
+		var index uint64
+		resp, err := client.Get(key, false, true)
+		switch e, ok := err.(*etcd.EtcdError); {
+		case err == nil:
+			if len(resp.Node.Nodes) >= n {
+				return nil
+			}
+			index = resp.EtcdIndex + 1
+		case ok && e.ErrorCode == ecodeKeyNotFound:
+			index = e.Index + 1
+		default:
+			return err
+		}
+		if _, err := client.Watch(key, index, true, nil, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// SetLastCheckpoint records the epoch as the last all tasks checkpointed at,
+// and drops what's kept for those before.
+func SetLastCheckpoint(client Client, name string, epoch uint64) error {
+	prev, ok, err := LastCheckpoint(client, name)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Set(LastCheckpointPath(name), strconv.FormatUint(epoch, 10), 0); err != nil {
+		return err
+	}
+	if ok && prev != epoch {
+		client.Delete(CheckpointPath(name, prev), true)
+	}
+	return nil
+}
+
+// LastCheckpoint returns the last epoch all tasks checkpointed at. It returns
+// false if there is none.
+func LastCheckpoint(client Client, name string) (uint64, bool, error) {
+	resp, err := client.Get(LastCheckpointPath(name), false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	epoch, err := strconv.ParseUint(resp.Node.Value, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return epoch, true, nil
+}
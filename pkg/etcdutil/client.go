@@ -0,0 +1,107 @@
+package etcdutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+const (
+	clientDialTimeout      = time.Second
+	clientKeepAlive        = 30 * time.Second
+	clientHandshakeTimeout = 10 * time.Second
+)
+
+// Client is the etcd v2 API helpers of the package keep the state of jobs
+// through. *etcd.Client, the default, implements it, and MemClient keeps keys
+// in memory for tests. Helpers tell failures apart by the error codes of etcd:
+// key not found (100), compare failed (101), key exists (105) and event index
+// cleared (401).
+//
+// Watch is only called with a nil receiver, returning the first change at or
+// after waitIndex, or etcd.ErrWatchStoppedByUser once stop is closed.
+type Client interface {
+	Get(key string, sort, recursive bool) (*etcd.Response, error)
+	Set(key string, value string, ttl uint64) (*etcd.Response, error)
+	Create(key string, value string, ttl uint64) (*etcd.Response, error)
+	CreateDir(key string, ttl uint64) (*etcd.Response, error)
+	CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error)
+	Update(key string, value string, ttl uint64) (*etcd.Response, error)
+	CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error)
+	CompareAndDelete(key string, prevValue string, prevIndex uint64) (*etcd.Response, error)
+	Delete(key string, recursive bool) (*etcd.Response, error)
+	DeleteDir(key string) (*etcd.Response, error)
+	Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error)
+}
+
+var _ Client = (*etcd.Client)(nil)
+
+// ClientInfo is what etcd clients connect with besides endpoints: PEM encoded
+// files for TLS, and credentials for etcd auth. Empty ones are left out. With
+// CertFile and KeyFile, clients present the certificate, as etcd requiring
+// mutual TLS verifies. With CAFile, clients verify etcd against the CA rather
+// than the system pool.
+type ClientInfo struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Username string
+	Password string
+}
+
+// NewClient creates an etcd client of the endpoints, connecting with info.
+func NewClient(urls []string, info ClientInfo) (*etcd.Client, error) {
+	client := etcd.NewClient(urls)
+	if info.CAFile != "" || info.CertFile != "" || info.KeyFile != "" {
+		cfg, err := info.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		client.SetTransport(&http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: (&net.Dialer{
+				Timeout:   clientDialTimeout,
+				KeepAlive: clientKeepAlive,
+			}).Dial,
+			TLSHandshakeTimeout: clientHandshakeTimeout,
+			TLSClientConfig:     cfg,
+		})
+	}
+	if info.Username != "" {
+		client.SetCredentials(info.Username, info.Password)
+	}
+	return client, nil
+}
+
+func (info ClientInfo) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if info.CertFile != "" || info.KeyFile != "" {
+		if info.CertFile == "" || info.KeyFile == "" {
+			return nil, fmt.Errorf("etcdutil: client certificate needs both cert and key files")
+		}
+		cert, err := tls.LoadX509KeyPair(info.CertFile, info.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if info.CAFile == "" {
+		return cfg, nil
+	}
+	pem, err := ioutil.ReadFile(info.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("etcdutil: no certificate found in %s", info.CAFile)
+	}
+	cfg.RootCAs = pool
+	return cfg, nil
+}
@@ -0,0 +1,85 @@
+package etcdutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientInfoTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdutil_test_tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certs := WriteTestCerts(t, dir)
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		info  ClientInfo
+		err   bool
+		certs int
+		roots bool
+	}{
+		{ClientInfo{}, false, 0, false},
+		{ClientInfo{CertFile: certs.CertFile}, true, 0, false},
+		{ClientInfo{KeyFile: certs.KeyFile}, true, 0, false},
+		{ClientInfo{CertFile: certs.CertFile, KeyFile: certs.CAFile}, true, 0, false},
+		{ClientInfo{CAFile: notPEM}, true, 0, false},
+		{ClientInfo{CAFile: filepath.Join(dir, "missing.pem")}, true, 0, false},
+		{ClientInfo{CAFile: certs.CAFile}, false, 0, true},
+		{certs, false, 1, true},
+	}
+	for i, tt := range tests {
+		cfg, err := tt.info.tlsConfig()
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: tlsConfig error = %v, want error %v", i, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(cfg.Certificates) != tt.certs {
+			t.Errorf("#%d: certificates = %d, want %d", i, len(cfg.Certificates), tt.certs)
+		}
+		if (cfg.RootCAs != nil) != tt.roots {
+			t.Errorf("#%d: root CAs set = %v, want %v", i, cfg.RootCAs != nil, tt.roots)
+		}
+	}
+}
+
+// TestNewClientMutualTLS checks that clients reach etcd requiring mutual TLS
+// only if they present a certificate.
+func TestNewClientMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdutil_test_mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certs := WriteTestCerts(t, dir)
+	m := StartNewTLSEtcdServer(t, "etcdutil_test_mtls", certs)
+	defer m.Terminate(t)
+
+	client, err := NewClient([]string{m.URL()}, certs)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if _, err := client.Set("/mtls", "v", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if resp, err := client.Get("/mtls", false, false); err != nil || resp.Node.Value != "v" {
+		t.Fatalf("Get = (%v, %v), want v", resp, err)
+	}
+
+	noCert, err := NewClient([]string{m.URL()}, ClientInfo{CAFile: certs.CAFile})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if _, err := noCert.Get("/mtls", false, false); err == nil {
+		t.Errorf("Get without a client certificate should fail")
+	}
+}
@@ -17,6 +17,8 @@
 package etcdutil
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -58,9 +60,39 @@ func StartNewEtcdServer(t *testing.T, name string) *member {
 	return m
 }
 
+// StartNewTLSEtcdServer starts a member serving clients over TLS with the
+// certificate of info. Clients must present one signed by the CA of info.
+func StartNewTLSEtcdServer(t *testing.T, name string, info ClientInfo) *member {
+	m := MustNewMember(t, name)
+	cert, err := tls.LoadX509KeyPair(info.CertFile, info.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ioutil.ReadFile(info.CAFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		t.Fatalf("no certificate found in %s", info.CAFile)
+	}
+	m.clientTLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	m.ClientURLs, err = types.NewURLs([]string{"https://" + m.ClientListeners[0].Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Launch()
+	return m
+}
+
 type member struct {
 	etcdserver.ServerConfig
 	PeerListeners, ClientListeners []net.Listener
+	clientTLS                      *tls.Config
 
 	raftHandler *testutil.PauseableHandler
 	s           *etcdserver.EtcdServer
@@ -128,7 +160,12 @@ func (m *member) Launch() error {
 			Listener: ln,
 			Config:   &http.Server{Handler: etcdhttp.NewClientHandler(m.s)},
 		}
-		hs.Start()
+		if m.clientTLS != nil {
+			hs.TLS = m.clientTLS
+			hs.StartTLS()
+		} else {
+			hs.Start()
+		}
 		m.hss = append(m.hss, hs)
 	}
 	return nil
@@ -0,0 +1,67 @@
+package etcdutil
+
+import (
+	"path"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// GetConfig returns the configuration of the job, i.e. keys and values under
+// its config directory. It's empty if there is none.
+func GetConfig(client Client, name string) (map[string]string, error) {
+	cfg, _, err := getConfig(client, name)
+	return cfg, err
+}
+
+// getConfig returns the configuration of the job along with the etcd index it
+// was read at.
+func getConfig(client Client, name string) (map[string]string, uint64, error) {
+	cfg := make(map[string]string)
+	resp, err := client.Get(ConfigPath(name), false, false)
+	if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+		return cfg, e.Index, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, n := range resp.Node.Nodes {
+		if !n.Dir {
+			cfg[path.Base(n.Key)] = n.Value
+		}
+	}
+	return cfg, resp.EtcdIndex, nil
+}
+
+// WatchConfig calls handler with the configuration of the job, and again each
+// time a key of it changes, until stop.
+func WatchConfig(client Client, name string, stop chan bool, handler func(cfg map[string]string)) error {
+	cfg, index, err := getConfig(client, name)
+	if err != nil {
+		return err
+	}
+	handler(cfg)
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, ConfigPath(name), index+1, true, receiver, stop)
+	go func() {
+		for range receiver {
+			// Keys may change together. Read all of them again.
+			cfg, _, err := getConfig(client, name)
+			if err != nil {
+				continue
+			}
+			handler(cfg)
+		}
+	}()
+	return nil
+}
+
+// SetConfig sets keys of the configuration of the job to the given values.
+// Other keys are kept.
+func SetConfig(client Client, name string, cfg map[string]string) error {
+	for key, value := range cfg {
+		if _, err := client.Set(ConfigKeyPath(name, key), value, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,574 @@
+package etcdutil
+
+import (
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
+)
+
+// Coordinator is what framework coordinates a task with the rest of its job
+// through: everything tasks keep and watch besides data they pass each other.
+// NewCoordinator returns the one on etcd, the default. V3Coordinator keeps
+// tasks on etcd through the v3 API. Other backends, e.g. on ZooKeeper or
+// Consul, implement it to run tasks on their cluster without etcd, and
+// MemCoordinator runs them in memory. Failures are reported as errors of
+// package errors where callers tell them apart, e.g. ErrTaskFenced.
+//
+// Stops are closed, or sent a value, once callers are done with what's
+// started. The job itself is set up by the controller on etcd, and by other
+// backends their own way.
+type Coordinator interface {
+	TaskCoordinator
+	EpochCoordinator
+	MetaCoordinator
+	JobCoordinator
+	BackupCoordinator
+	DataCoordinator
+}
+
+// TaskCoordinator registers nodes for tasks, and keeps track of their health.
+type TaskCoordinator interface {
+	// OccupyTask registers the node at addr as the one working for the task,
+	// unless another one does. It returns false then.
+	OccupyTask(name string, taskID uint64, addr string) bool
+	// ReclaimTask takes back the task registered at addr, e.g. by a node
+	// restarted before the registration expired. It returns false if no task
+	// is registered at addr.
+	ReclaimTask(name string, addr string) (uint64, bool)
+	// ReleaseTask frees the task the node at addr works for, for another one
+	// to take over.
+	ReleaseTask(name string, taskID uint64, addr string) error
+	// MarkTaskExited reports that the task exited by itself, so that it isn't
+	// taken for failed and freed once it's gone.
+	MarkTaskExited(name string, taskID uint64) error
+	// ExitTask unregisters the task exited at addr. Unlike ReleaseTask, the
+	// task isn't taken over.
+	ExitTask(name string, taskID uint64, addr string) error
+	// FailTask gives up the task the node at addr works for as failed, e.g.
+	// once it panicked, so that it's freed and counted along with other
+	// failures. The failure is recorded as caused by actor, for reason.
+	FailTask(name string, taskID uint64, addr, actor, reason string) error
+	// FreeTasks returns the tasks free for nodes to take.
+	FreeTasks(name string) ([]uint64, error)
+	// WaitFreeTask blocks until a task is free, and returns it. It fails with
+	// ErrWaitFreeTaskTimeout if none has been for a while, and with
+	// ErrWaitFreeTaskStopped once stop is closed.
+	WaitFreeTask(name string, logger meritop.Logger, stop chan bool) (uint64, error)
+	// Address returns the address of the node working for the task.
+	Address(name string, taskID uint64) (string, error)
+
+	// Heartbeat keeps the task healthy, and registered at addr, every
+	// interval until stop is closed. It returns ErrTaskFenced once another
+	// node works for the task.
+	Heartbeat(name string, taskID uint64, addr string, interval time.Duration, stop chan bool) error
+	// WatchHealth passes changes of health of tasks to handler until stop:
+	// false once a task failed or has been given up, and true once it's been
+	// occupied again.
+	WatchHealth(name string, stop chan bool, handler func(taskID uint64, healthy bool))
+	// DetectFailure frees tasks whose heartbeat expired until stop, unless
+	// they exited by themselves. Failures are recorded as caused by actor.
+	DetectFailure(name, actor string, stop chan bool, logger meritop.Logger) error
+	// Lease returns the TTL of the lease of the job. It returns false if the
+	// job has no lease.
+	Lease(name string) (time.Duration, bool, error)
+	// KeepLease renews the lease of ttl of the job until stop is closed. It
+	// returns ErrLeaseExpired once the lease has expired.
+	KeepLease(name string, ttl time.Duration, stop chan bool) error
+
+	// NextGeneration bumps the generation of the task taken by a node, and
+	// returns it.
+	NextGeneration(name string, taskID uint64) (Generation, error)
+	// Generation returns the current generation of the task, zero if no node
+	// has taken it yet.
+	Generation(name string, taskID uint64) (Generation, error)
+	// SetVersion registers the version of the data protocol the node having
+	// taken the task talks.
+	SetVersion(name string, taskID uint64, version int) error
+	// Version returns the version of the data protocol registered for the
+	// task, or 0 if there is none.
+	Version(name string, taskID uint64) (int, error)
+	// WatchTasks passes changes of addresses, generations and versions of
+	// tasks made after the call to handler until stop.
+	WatchTasks(name string, stop chan bool, handler func(change TaskChange))
+}
+
+// EpochCoordinator keeps the epoch of the job, and what tasks check in at
+// epochs.
+type EpochCoordinator interface {
+	// Epoch returns the epoch of the job.
+	Epoch(name string) (uint64, error)
+	// CASEpoch moves the job from epoch prev to next, failing with an error
+	// of kind errors.ErrEpochConflict if it isn't at prev any more.
+	CASEpoch(name string, prev, next uint64) error
+	// WatchEpoch returns the epoch of the job, and passes the epochs it
+	// moves to on to epochC until stop.
+	WatchEpoch(name string, epochC chan uint64, stop chan bool) (uint64, error)
+
+	// ControllerPaced tells whether only the controller advances the epoch
+	// of the job.
+	ControllerPaced(name string) (bool, error)
+	// MarkEpochAdvance asks for the epoch to advance once enough tasks are
+	// done with it.
+	MarkEpochAdvance(name string, epoch uint64) error
+	// MarkEpochDone checks the task in at the barrier of the epoch.
+	MarkEpochDone(name string, epoch, taskID uint64) error
+	// TryPassBarrier advances the epoch if it has been asked to, and quorum
+	// tasks are done with it. It returns true if the epoch has been advanced,
+	// by this call or another.
+	TryPassBarrier(name string, epoch uint64, quorum int) (bool, error)
+	// EnterBarrier checks the task in at the named barrier of the epoch.
+	EnterBarrier(name string, epoch uint64, barrier string, taskID uint64) error
+	// WaitBarrier blocks until n tasks have entered the named barrier of the
+	// epoch. It returns false if stop is closed before.
+	WaitBarrier(name string, epoch uint64, barrier string, n int, stop chan bool) (bool, error)
+
+	// WatchCheckpointRequest passes epochs tasks are asked to checkpoint at
+	// to handler until stop, starting with the one asked before, if any.
+	WatchCheckpointRequest(name string, stop chan bool, handler func(epoch uint64)) error
+	// MarkCheckpointDone checks the task in as checkpointed at the epoch.
+	MarkCheckpointDone(name string, epoch, taskID uint64) error
+}
+
+// MetaCoordinator keeps metas flagged between tasks.
+type MetaCoordinator interface {
+	// SetMeta keeps value as the meta at key, e.g. ParentMetaPath of a task.
+	SetMeta(key, value string) error
+	// Meta returns the meta at key, or false if none has been set.
+	Meta(key string) (string, bool, error)
+	// WatchMeta passes the meta at key, if any, and every one set later to
+	// handler until stop. rev orders the change against others, e.g. the
+	// generation of the task taken over, see Generation.
+	WatchMeta(key string, stop chan bool, handler func(value string, rev uint64)) error
+}
+
+// JobCoordinator keeps what's set up for the job, and what tasks report of it.
+type JobCoordinator interface {
+	// NumOfTasks returns the number of tasks of the job. It returns false if
+	// the job doesn't keep the number.
+	NumOfTasks(name string) (uint64, bool, error)
+	// AuthToken returns the auth token of the job, or "" if it has none.
+	AuthToken(name string) (string, error)
+	// Config returns the configuration of the job.
+	Config(name string) (map[string]string, error)
+	// WatchConfig calls handler with the configuration of the job, and again
+	// each time it changes, until stop.
+	WatchConfig(name string, stop chan bool, handler func(cfg map[string]string)) error
+	// MarkJobDone reports the job done, once it's been shut down.
+	MarkJobDone(name string) error
+	// RecordEvent appends the event to the audit log of the job.
+	RecordEvent(name string, e Event) error
+	// SetProgress keeps the progress the task reported in the epoch.
+	SetProgress(name string, epoch, taskID uint64, p meritop.Progress) error
+	// Restarts returns failures of the task, none if it hasn't failed.
+	Restarts(name string, taskID uint64) (Restarts, error)
+	// PoisonTask takes the task off free tasks for good, for reason.
+	PoisonTask(name string, taskID uint64, reason string) error
+}
+
+// BackupCoordinator registers backup copies of tasks, and keeps update logs
+// shipped to them.
+type BackupCoordinator interface {
+	// OccupyReplica registers a backup copy of the task at replicaID, which
+	// should be greater than 0. It returns false if there is one already.
+	OccupyReplica(name string, taskID, replicaID uint64, addr string) bool
+	// ReleaseReplica unregisters the backup copy of the task at replicaID.
+	ReleaseReplica(name string, taskID, replicaID uint64) error
+	// HeartbeatReplica keeps the backup copy registered every interval until
+	// stop is closed, like Heartbeat does for tasks.
+	HeartbeatReplica(name string, taskID, replicaID uint64, addr string, interval time.Duration, stop chan bool) error
+	// ShipUpdateLog appends an update log of the task. It fails if the ID
+	// has been taken.
+	ShipUpdateLog(name string, taskID uint64, ul meritop.UpdateLog) error
+	// LastUpdateLogID returns the ID of the last update log of the task, or
+	// 0 if there is none.
+	LastUpdateLogID(name string, taskID uint64) (uint64, error)
+	// WatchUpdateLog passes update logs of the task with IDs greater than
+	// from to logs in order of IDs until stop, and then closes logs.
+	WatchUpdateLog(name string, taskID, from uint64, logs chan<- meritop.UpdateLog, stop chan bool)
+	// WaitTaskFailure blocks until the task isn't healthy.
+	WaitTaskFailure(name string, taskID uint64) error
+}
+
+// DataCoordinator keeps values of tasks, and their checkpoints.
+type DataCoordinator interface {
+	// TaskData returns the value of key kept by the task. It returns false
+	// if it's not set.
+	TaskData(name string, taskID uint64, key string) ([]byte, bool, error)
+	// SetTaskData sets key kept by the task to value.
+	SetTaskData(name string, taskID uint64, key string, value []byte) error
+	// CASTaskData sets key kept by the task to value only if it's prev now,
+	// or isn't set if prev is nil. It returns false if it isn't.
+	CASTaskData(name string, taskID uint64, key string, prev, value []byte) (bool, error)
+	// DeleteTaskData unsets key kept by the task.
+	DeleteTaskData(name string, taskID uint64, key string) error
+	// WatchTaskData passes values key kept by the task is set to after the
+	// call, and nil once it's unset, until stop. values is closed then.
+	WatchTaskData(name string, taskID uint64, key string, values chan<- []byte, stop chan bool)
+	// SaveTaskState replaces the checkpoint of the task.
+	SaveTaskState(name string, taskID, epoch uint64, data []byte) error
+	// LoadTaskState returns the checkpoint of the task. It returns false if
+	// the task has none.
+	LoadTaskState(name string, taskID uint64) (uint64, []byte, bool, error)
+}
+
+// Kinds of TaskChange.
+const (
+	// TaskChangeAddress is the node working for the task changing.
+	TaskChangeAddress = "address"
+	// TaskChangeGeneration is the task taken by a node of a new generation.
+	TaskChangeGeneration = "generation"
+	// TaskChangeVersion is the protocol version registered for the task
+	// changing.
+	TaskChangeVersion = "version"
+)
+
+// TaskChange is a change of what's registered for a task, passed on by
+// WatchTasks. Only fields of its kind are set.
+type TaskChange struct {
+	Kind   string
+	TaskID uint64
+	// Address is the one of the node working for the task.
+	Address    string
+	Generation Generation
+	Version    int
+	// Deleted is set once the address or version is gone.
+	Deleted bool
+}
+
+// NewCoordinator returns the Coordinator keeping tasks of jobs in etcd
+// through client.
+func NewCoordinator(client Client) Coordinator {
+	return &etcdCoordinator{client: client}
+}
+
+type etcdCoordinator struct {
+	client Client
+}
+
+func (c *etcdCoordinator) OccupyTask(name string, taskID uint64, addr string) bool {
+	return TryOccupyTask(c.client, name, taskID, addr)
+}
+
+func (c *etcdCoordinator) ReclaimTask(name string, addr string) (uint64, bool) {
+	return ReclaimTask(c.client, name, addr)
+}
+
+func (c *etcdCoordinator) ReleaseTask(name string, taskID uint64, addr string) error {
+	return WrapError(ReleaseTask(c.client, name, taskID, addr))
+}
+
+func (c *etcdCoordinator) MarkTaskExited(name string, taskID uint64) error {
+	return WrapError(MarkTaskExited(c.client, name, taskID))
+}
+
+func (c *etcdCoordinator) ExitTask(name string, taskID uint64, addr string) error {
+	return WrapError(ExitTask(c.client, name, taskID, addr))
+}
+
+func (c *etcdCoordinator) FailTask(name string, taskID uint64, addr, actor, reason string) error {
+	// The node mustn't reclaim the task without it being counted as failed.
+	c.client.CompareAndDelete(TaskMasterPath(name, taskID), addr, 0)
+	c.client.Delete(TaskHealthyPath(name, taskID), false)
+	return WrapError(ReportFailure(c.client, name, strconv.FormatUint(taskID, 10), actor, reason))
+}
+
+func (c *etcdCoordinator) FreeTasks(name string) ([]uint64, error) {
+	resp, err := c.client.Get(FreeTaskDir(name), false, true)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	var free []uint64
+	for _, n := range resp.Node.Nodes {
+		if id, err := strconv.ParseUint(path.Base(n.Key), 10, 64); err == nil {
+			free = append(free, id)
+		}
+	}
+	return free, nil
+}
+
+func (c *etcdCoordinator) WaitFreeTask(name string, logger meritop.Logger, stop chan bool) (uint64, error) {
+	taskID, err := WaitFreeTask(c.client, name, logger, stop)
+	return taskID, WrapError(err)
+}
+
+func (c *etcdCoordinator) Address(name string, taskID uint64) (string, error) {
+	addr, err := GetAddress(c.client, name, taskID)
+	return addr, WrapError(err)
+}
+
+func (c *etcdCoordinator) Heartbeat(name string, taskID uint64, addr string, interval time.Duration, stop chan bool) error {
+	return WrapError(HeartbeatTask(c.client, name, taskID, addr, interval, stop))
+}
+
+func (c *etcdCoordinator) WatchHealth(name string, stop chan bool, handler func(taskID uint64, healthy bool)) {
+	WatchHealthy(c.client, name, stop, handler)
+}
+
+func (c *etcdCoordinator) DetectFailure(name, actor string, stop chan bool, logger meritop.Logger) error {
+	return WrapError(DetectFailure(c.client, name, actor, stop, logger))
+}
+
+func (c *etcdCoordinator) Lease(name string) (time.Duration, bool, error) {
+	ttl, ok, err := GetLease(c.client, name)
+	return ttl, ok, WrapError(err)
+}
+
+func (c *etcdCoordinator) KeepLease(name string, ttl time.Duration, stop chan bool) error {
+	return WrapError(KeepLease(c.client, name, ttl, stop))
+}
+
+func (c *etcdCoordinator) NextGeneration(name string, taskID uint64) (Generation, error) {
+	gen, err := NextGeneration(c.client, name, taskID)
+	return gen, WrapError(err)
+}
+
+func (c *etcdCoordinator) Generation(name string, taskID uint64) (Generation, error) {
+	gen, err := GetGeneration(c.client, name, taskID)
+	return gen, WrapError(err)
+}
+
+func (c *etcdCoordinator) SetVersion(name string, taskID uint64, version int) error {
+	return WrapError(SetProtocolVersion(c.client, name, taskID, version))
+}
+
+func (c *etcdCoordinator) Version(name string, taskID uint64) (int, error) {
+	v, err := GetProtocolVersion(c.client, name, taskID)
+	return v, WrapError(err)
+}
+
+func (c *etcdCoordinator) WatchTasks(name string, stop chan bool, handler func(change TaskChange)) {
+	// Watch from the current index so that no change after this is missed.
+	var index uint64
+	resp, err := c.client.Get(TaskDirPath(name), false, false)
+	if e, ok := err.(*etcd.EtcdError); ok {
+		index = e.Index + 1
+	} else if err == nil {
+		index = resp.EtcdIndex + 1
+	}
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(c.client, TaskDirPath(name), index, true, receiver, stop)
+	go func() {
+		for resp := range receiver {
+			if ch, ok := parseTaskChange(name, resp); ok {
+				handler(ch)
+			}
+		}
+	}()
+}
+
+// parseTaskChange tells the change of a task from the change of a key under
+// its directory. It returns false if the key isn't watched by WatchTasks.
+func parseTaskChange(name string, resp *etcd.Response) (TaskChange, bool) {
+	key := resp.Node.Key
+	taskID, err := strconv.ParseUint(path.Base(path.Dir(key)), 10, 64)
+	if err != nil {
+		return TaskChange{}, false
+	}
+	ch := TaskChange{TaskID: taskID}
+	switch key {
+	case TaskMasterPath(name, taskID):
+		ch.Kind = TaskChangeAddress
+	case TaskGenerationPath(name, taskID):
+		ch.Kind = TaskChangeGeneration
+	case TaskVersionPath(name, taskID):
+		ch.Kind = TaskChangeVersion
+	default:
+		return TaskChange{}, false
+	}
+	switch resp.Action {
+	case "set", "create", "update", "compareAndSwap", "get":
+	default:
+		ch.Deleted = true
+		return ch, true
+	}
+	switch ch.Kind {
+	case TaskChangeAddress:
+		ch.Address = resp.Node.Value
+	case TaskChangeGeneration:
+		if ch.Generation, err = ParseGeneration(resp.Node); err != nil {
+			return TaskChange{}, false
+		}
+	case TaskChangeVersion:
+		if ch.Version, err = strconv.Atoi(resp.Node.Value); err != nil {
+			return TaskChange{}, false
+		}
+	}
+	return ch, true
+}
+
+func (c *etcdCoordinator) Epoch(name string) (uint64, error) {
+	epoch, err := GetEpoch(c.client, name)
+	return epoch, WrapError(err)
+}
+
+func (c *etcdCoordinator) CASEpoch(name string, prev, next uint64) error {
+	return CASEpoch(c.client, name, prev, next)
+}
+
+func (c *etcdCoordinator) WatchEpoch(name string, epochC chan uint64, stop chan bool) (uint64, error) {
+	epoch, err := GetAndWatchEpoch(c.client, name, epochC, stop)
+	return epoch, WrapError(err)
+}
+
+func (c *etcdCoordinator) ControllerPaced(name string) (bool, error) {
+	paced, err := IsControllerPaced(c.client, name)
+	return paced, WrapError(err)
+}
+
+func (c *etcdCoordinator) MarkEpochAdvance(name string, epoch uint64) error {
+	return WrapError(MarkEpochAdvance(c.client, name, epoch))
+}
+
+func (c *etcdCoordinator) MarkEpochDone(name string, epoch, taskID uint64) error {
+	return WrapError(MarkEpochDone(c.client, name, epoch, taskID))
+}
+
+func (c *etcdCoordinator) TryPassBarrier(name string, epoch uint64, quorum int) (bool, error) {
+	passed, err := TryPassBarrier(c.client, name, epoch, quorum)
+	return passed, WrapError(err)
+}
+
+func (c *etcdCoordinator) EnterBarrier(name string, epoch uint64, barrier string, taskID uint64) error {
+	return WrapError(EnterPhaseBarrier(c.client, name, epoch, barrier, taskID))
+}
+
+func (c *etcdCoordinator) WaitBarrier(name string, epoch uint64, barrier string, n int, stop chan bool) (bool, error) {
+	passed, err := WaitPhaseBarrier(c.client, name, epoch, barrier, n, stop)
+	return passed, WrapError(err)
+}
+
+func (c *etcdCoordinator) WatchCheckpointRequest(name string, stop chan bool, handler func(epoch uint64)) error {
+	return WrapError(WatchCheckpointRequest(c.client, name, stop, handler))
+}
+
+func (c *etcdCoordinator) MarkCheckpointDone(name string, epoch, taskID uint64) error {
+	return WrapError(MarkCheckpointDone(c.client, name, epoch, taskID))
+}
+
+func (c *etcdCoordinator) SetMeta(key, value string) error {
+	_, err := c.client.Set(key, value, 0)
+	return WrapError(err)
+}
+
+func (c *etcdCoordinator) Meta(key string) (string, bool, error) {
+	resp, err := c.client.Get(key, false, false)
+	if isKeyNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, WrapError(err)
+	}
+	return resp.Node.Value, true, nil
+}
+
+func (c *etcdCoordinator) WatchMeta(key string, stop chan bool, handler func(value string, rev uint64)) error {
+	return WrapError(WatchMeta(c.client, 0, key, stop, func(resp *etcd.Response, _ uint64) {
+		if resp.Action != "set" && resp.Action != "get" {
+			return
+		}
+		handler(resp.Node.Value, resp.Node.ModifiedIndex)
+	}))
+}
+
+func (c *etcdCoordinator) NumOfTasks(name string) (uint64, bool, error) {
+	n, ok, err := GetNumOfTasks(c.client, name)
+	return n, ok, WrapError(err)
+}
+
+func (c *etcdCoordinator) AuthToken(name string) (string, error) {
+	token, err := GetAuthToken(c.client, name)
+	return token, WrapError(err)
+}
+
+func (c *etcdCoordinator) Config(name string) (map[string]string, error) {
+	cfg, err := GetConfig(c.client, name)
+	return cfg, WrapError(err)
+}
+
+func (c *etcdCoordinator) WatchConfig(name string, stop chan bool, handler func(cfg map[string]string)) error {
+	return WrapError(WatchConfig(c.client, name, stop, handler))
+}
+
+func (c *etcdCoordinator) MarkJobDone(name string) error {
+	return WrapError(SetJobStatus(c.client, name, 0))
+}
+
+func (c *etcdCoordinator) RecordEvent(name string, e Event) error {
+	return WrapError(RecordEvent(c.client, name, e))
+}
+
+func (c *etcdCoordinator) SetProgress(name string, epoch, taskID uint64, p meritop.Progress) error {
+	return WrapError(SetProgress(c.client, name, epoch, taskID, p))
+}
+
+func (c *etcdCoordinator) Restarts(name string, taskID uint64) (Restarts, error) {
+	r, err := GetRestarts(c.client, name, taskID)
+	return r, WrapError(err)
+}
+
+func (c *etcdCoordinator) PoisonTask(name string, taskID uint64, reason string) error {
+	return WrapError(PoisonTask(c.client, name, taskID, reason))
+}
+
+func (c *etcdCoordinator) OccupyReplica(name string, taskID, replicaID uint64, addr string) bool {
+	return TryOccupyReplica(c.client, name, taskID, replicaID, addr)
+}
+
+func (c *etcdCoordinator) ReleaseReplica(name string, taskID, replicaID uint64) error {
+	return WrapError(ReleaseReplica(c.client, name, taskID, replicaID))
+}
+
+func (c *etcdCoordinator) HeartbeatReplica(name string, taskID, replicaID uint64, addr string, interval time.Duration, stop chan bool) error {
+	return WrapError(HeartbeatReplica(c.client, name, taskID, replicaID, addr, interval, stop))
+}
+
+func (c *etcdCoordinator) ShipUpdateLog(name string, taskID uint64, ul meritop.UpdateLog) error {
+	return WrapError(ShipUpdateLog(c.client, name, taskID, ul))
+}
+
+func (c *etcdCoordinator) LastUpdateLogID(name string, taskID uint64) (uint64, error) {
+	id, err := LastUpdateLogID(c.client, name, taskID)
+	return id, WrapError(err)
+}
+
+func (c *etcdCoordinator) WatchUpdateLog(name string, taskID, from uint64, logs chan<- meritop.UpdateLog, stop chan bool) {
+	WatchUpdateLog(c.client, name, taskID, from, logs, stop)
+}
+
+func (c *etcdCoordinator) WaitTaskFailure(name string, taskID uint64) error {
+	return WrapError(WaitTaskFailure(c.client, name, taskID))
+}
+
+func (c *etcdCoordinator) TaskData(name string, taskID uint64, key string) ([]byte, bool, error) {
+	value, ok, err := GetTaskData(c.client, name, taskID, key)
+	return value, ok, WrapError(err)
+}
+
+func (c *etcdCoordinator) SetTaskData(name string, taskID uint64, key string, value []byte) error {
+	return WrapError(SetTaskData(c.client, name, taskID, key, value))
+}
+
+func (c *etcdCoordinator) CASTaskData(name string, taskID uint64, key string, prev, value []byte) (bool, error) {
+	ok, err := CASTaskData(c.client, name, taskID, key, prev, value)
+	return ok, WrapError(err)
+}
+
+func (c *etcdCoordinator) DeleteTaskData(name string, taskID uint64, key string) error {
+	return WrapError(DeleteTaskData(c.client, name, taskID, key))
+}
+
+func (c *etcdCoordinator) WatchTaskData(name string, taskID uint64, key string, values chan<- []byte, stop chan bool) {
+	WatchTaskData(c.client, name, taskID, key, values, stop)
+}
+
+func (c *etcdCoordinator) SaveTaskState(name string, taskID, epoch uint64, data []byte) error {
+	return WrapError(SaveTaskState(c.client, name, taskID, epoch, data))
+}
+
+func (c *etcdCoordinator) LoadTaskState(name string, taskID uint64) (uint64, []byte, bool, error) {
+	epoch, data, ok, err := LoadTaskState(c.client, name, taskID)
+	return epoch, data, ok, WrapError(err)
+}
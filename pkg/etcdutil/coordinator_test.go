@@ -0,0 +1,269 @@
+package etcdutil
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/integration"
+	"github.com/go-distributed/meritop"
+	merrors "github.com/go-distributed/meritop/errors"
+)
+
+// testCoordinators returns coordinators of all kinds, on etcd v2, on etcd v3
+// and in memory, each with a job of two tasks set up at epoch 0, both free.
+// cleanup closes them.
+func testCoordinators(t *testing.T, name string) (cs map[string]Coordinator, cleanup func()) {
+	m := NewMemClient()
+	if _, err := m.Set(EpochPath(name), "0", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := SetNumOfTasks(m, name, 2); err != nil {
+		t.Fatalf("SetNumOfTasks failed: %v", err)
+	}
+	for _, id := range []string{"0", "1"} {
+		if _, err := m.Set(FreeTaskPath(name, id), "", 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	mc := NewMemCoordinator()
+	if err := mc.SetupJob(name, 2); err != nil {
+		t.Fatalf("SetupJob failed: %v", err)
+	}
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	vc := NewV3Coordinator(clus.RandClient())
+	if err := vc.SetupJob(name, 2); err != nil {
+		t.Fatalf("SetupJob failed: %v", err)
+	}
+	cs = map[string]Coordinator{"etcd": NewCoordinator(m), "v3": vc, "mem": mc}
+	return cs, func() {
+		m.Close()
+		mc.Close()
+		clus.Terminate(t)
+	}
+}
+
+func TestCoordinatorEpoch(t *testing.T) {
+	cs, cleanup := testCoordinators(t, "job")
+	defer cleanup()
+	for kind, c := range cs {
+		testCoordinatorEpoch(t, kind, c)
+	}
+}
+
+func testCoordinatorEpoch(t *testing.T, kind string, c Coordinator) {
+	epochC := make(chan uint64, 1)
+	stop := make(chan bool)
+	defer close(stop)
+	if epoch, err := c.WatchEpoch("job", epochC, stop); err != nil || epoch != 0 {
+		t.Fatalf("%s: WatchEpoch = (%d, %v), want (0, nil)", kind, epoch, err)
+	}
+	tests := []struct {
+		prev, next uint64
+		conflict   bool
+	}{
+		{0, 1, false},
+		{0, 2, true},
+		{1, 2, false},
+	}
+	for i, tt := range tests {
+		err := c.CASEpoch("job", tt.prev, tt.next)
+		if conflict := merrors.Is(err, merrors.ErrEpochConflict); conflict != tt.conflict || (err != nil && !conflict) {
+			t.Errorf("%s: #%d: CASEpoch(%d, %d) = %v, want conflict %v", kind, i, tt.prev, tt.next, err, tt.conflict)
+			continue
+		}
+		if tt.conflict {
+			continue
+		}
+		select {
+		case epoch := <-epochC:
+			if epoch != tt.next {
+				t.Errorf("%s: #%d: epoch watched = %d, want %d", kind, i, epoch, tt.next)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: #%d: epoch %d not watched", kind, i, tt.next)
+		}
+	}
+	if epoch, err := c.Epoch("job"); err != nil || epoch != 2 {
+		t.Errorf("%s: Epoch = (%d, %v), want (2, nil)", kind, epoch, err)
+	}
+}
+
+func TestCoordinatorMeta(t *testing.T) {
+	cs, cleanup := testCoordinators(t, "job")
+	defer cleanup()
+	for kind, c := range cs {
+		testCoordinatorMeta(t, kind, c)
+	}
+}
+
+func testCoordinatorMeta(t *testing.T, kind string, c Coordinator) {
+	key := ParentMetaPath("job", 0)
+	if _, ok, err := c.Meta(key); ok || err != nil {
+		t.Fatalf("%s: Meta of none set = (%v, %v), want (false, nil)", kind, ok, err)
+	}
+	if err := c.SetMeta(key, "first"); err != nil {
+		t.Fatalf("%s: SetMeta failed: %v", kind, err)
+	}
+
+	type change struct {
+		value string
+		rev   uint64
+	}
+	changes := make(chan change, 2)
+	stop := make(chan bool)
+	defer close(stop)
+	err := c.WatchMeta(key, stop, func(value string, rev uint64) {
+		changes <- change{value, rev}
+	})
+	if err != nil {
+		t.Fatalf("%s: WatchMeta failed: %v", kind, err)
+	}
+	if err := c.SetMeta(key, "second"); err != nil {
+		t.Fatalf("%s: SetMeta failed: %v", kind, err)
+	}
+	var last uint64
+	for i, want := range []string{"first", "second"} {
+		select {
+		case ch := <-changes:
+			if ch.value != want || ch.rev <= last {
+				t.Errorf("%s: #%d: meta = (%s, %d), want %s after %d", kind, i, ch.value, ch.rev, want, last)
+			}
+			last = ch.rev
+		case <-time.After(time.Second):
+			t.Fatalf("%s: #%d: meta %s not watched", kind, i, want)
+		}
+	}
+	if value, ok, err := c.Meta(key); value != "second" || !ok || err != nil {
+		t.Errorf("%s: Meta = (%s, %v, %v), want (second, true, nil)", kind, value, ok, err)
+	}
+}
+
+func TestCoordinatorTasks(t *testing.T) {
+	cs, cleanup := testCoordinators(t, "job")
+	defer cleanup()
+	for kind, c := range cs {
+		testCoordinatorTasks(t, kind, c)
+	}
+}
+
+func testCoordinatorTasks(t *testing.T, kind string, c Coordinator) {
+	changes := make(chan TaskChange, 10)
+	stop := make(chan bool)
+	defer close(stop)
+	c.WatchTasks("job", stop, func(ch TaskChange) { changes <- ch })
+
+	if !c.OccupyTask("job", 0, "a") {
+		t.Fatalf("%s: OccupyTask of free task failed", kind)
+	}
+	if c.OccupyTask("job", 0, "b") {
+		t.Fatalf("%s: OccupyTask of task occupied succeeded", kind)
+	}
+	if free, err := c.FreeTasks("job"); !reflect.DeepEqual(free, []uint64{1}) || err != nil {
+		t.Errorf("%s: FreeTasks = (%v, %v), want ([1], nil)", kind, free, err)
+	}
+	if addr, err := c.Address("job", 0); addr != "a" || err != nil {
+		t.Errorf("%s: Address = (%s, %v), want (a, nil)", kind, addr, err)
+	}
+	gen, err := c.NextGeneration("job", 0)
+	if gen.Value != 1 || err != nil {
+		t.Fatalf("%s: NextGeneration = (%d, %v), want (1, nil)", kind, gen.Value, err)
+	}
+	if got, err := c.Generation("job", 0); got != gen || err != nil {
+		t.Errorf("%s: Generation = (%v, %v), want (%v, nil)", kind, got, err, gen)
+	}
+	if err := c.SetVersion("job", 0, 2); err != nil {
+		t.Fatalf("%s: SetVersion failed: %v", kind, err)
+	}
+	if v, err := c.Version("job", 0); v != 2 || err != nil {
+		t.Errorf("%s: Version = (%d, %v), want (2, nil)", kind, v, err)
+	}
+	for i, want := range []TaskChange{
+		{Kind: TaskChangeAddress, Address: "a"},
+		{Kind: TaskChangeGeneration, Generation: gen},
+		{Kind: TaskChangeVersion, Version: 2},
+	} {
+		select {
+		case ch := <-changes:
+			if ch != want {
+				t.Errorf("%s: #%d: change = %+v, want %+v", kind, i, ch, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: #%d: change %+v not watched", kind, i, want)
+		}
+	}
+
+	// Another node takes the task over once it's released, and the one
+	// released is fenced off.
+	if err := c.ReleaseTask("job", 0, "a"); err != nil {
+		t.Fatalf("%s: ReleaseTask failed: %v", kind, err)
+	}
+	if _, err := c.Address("job", 0); err == nil {
+		t.Errorf("%s: Address of task released succeeded", kind)
+	}
+	if !c.OccupyTask("job", 0, "b") {
+		t.Fatalf("%s: OccupyTask of task released failed", kind)
+	}
+	if err := c.Heartbeat("job", 0, "a", time.Second, stop); err != ErrTaskFenced {
+		t.Errorf("%s: Heartbeat of node taken over = %v, want %v", kind, err, ErrTaskFenced)
+	}
+}
+
+func TestCoordinatorFailure(t *testing.T) {
+	cs, cleanup := testCoordinators(t, "job")
+	defer cleanup()
+	logger := meritop.NewStdLogger(log.New(os.Stderr, "", log.LstdFlags), meritop.LevelInfo)
+	for kind, c := range cs {
+		testCoordinatorFailure(t, kind, c, logger)
+	}
+}
+
+// testCoordinatorFailure checks that a task whose heartbeat expired is freed,
+// and counted as failed, while one which exited by itself isn't.
+func testCoordinatorFailure(t *testing.T, kind string, c Coordinator, logger meritop.Logger) {
+	stop := make(chan bool)
+	defer close(stop)
+	health := make(chan uint64, 2)
+	c.WatchHealth("job", stop, func(taskID uint64, healthy bool) {
+		if !healthy {
+			health <- taskID
+		}
+	})
+	go c.DetectFailure("job", "detector", stop, logger)
+
+	for id := uint64(0); id < 2; id++ {
+		if !c.OccupyTask("job", id, "addr") {
+			t.Fatalf("%s: OccupyTask %d failed", kind, id)
+		}
+	}
+	if err := c.MarkTaskExited("job", 1); err != nil {
+		t.Fatalf("%s: MarkTaskExited failed: %v", kind, err)
+	}
+	// Both expire, since neither heartbeats.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-health:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: #%d: task not expired", kind, i)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		free, err := c.FreeTasks("job")
+		if err != nil {
+			t.Fatalf("%s: FreeTasks failed: %v", kind, err)
+		}
+		if reflect.DeepEqual(free, []uint64{0}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: FreeTasks = %v, want [0]", kind, free)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r, err := c.Restarts("job", 0); r.Count != 1 || err != nil {
+		t.Errorf("%s: Restarts = (%d, %v), want (1, nil)", kind, r.Count, err)
+	}
+}
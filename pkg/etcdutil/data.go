@@ -0,0 +1,102 @@
+package etcdutil
+
+import (
+	"encoding/base64"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Values of tasks are base64 encoded, since etcd values are strings.
+
+// GetTaskData returns the value of key kept by the task. It returns false if
+// it's not set.
+func GetTaskData(client Client, name string, taskID uint64, key string) ([]byte, bool, error) {
+	resp, err := client.Get(TaskDataPath(name, taskID, key), false, false)
+	if isKeyNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	value, err := base64.StdEncoding.DecodeString(resp.Node.Value)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// SetTaskData sets key kept by the task to value.
+func SetTaskData(client Client, name string, taskID uint64, key string, value []byte) error {
+	_, err := client.Set(TaskDataPath(name, taskID, key), encodeTaskData(value), 0)
+	return err
+}
+
+// CASTaskData sets key kept by the task to value only if it's prev now, or
+// isn't set if prev is nil. It returns false if it isn't.
+func CASTaskData(client Client, name string, taskID uint64, key string, prev, value []byte) (bool, error) {
+	p := TaskDataPath(name, taskID, key)
+	var err error
+	if prev == nil {
+		_, err = client.Create(p, encodeTaskData(value), 0)
+	} else {
+		_, err = client.CompareAndSwap(p, encodeTaskData(value), 0, encodeTaskData(prev), 0)
+	}
+	if e, ok := err.(*etcd.EtcdError); ok {
+		switch e.ErrorCode {
+		case ecodeKeyNotFound, ecodeTestFailed, ecodeNodeExist:
+			return false, nil
+		}
+	}
+	return err == nil, err
+}
+
+// DeleteTaskData unsets key kept by the task.
+func DeleteTaskData(client Client, name string, taskID uint64, key string) error {
+	_, err := client.Delete(TaskDataPath(name, taskID, key), false)
+	if isKeyNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// WatchTaskData passes values key kept by the task is set to after the call,
+// and nil once it's unset, until stop is closed. values is closed then.
+func WatchTaskData(client Client, name string, taskID uint64, key string, values chan<- []byte, stop chan bool) {
+	p := TaskDataPath(name, taskID, key)
+	// Changes are watched from the current index, so that none made after
+	// the call is missed.
+	var index uint64
+	resp, err := client.Get(p, false, false)
+	if e, ok := err.(*etcd.EtcdError); ok {
+		index = e.Index + 1
+	} else if err == nil {
+		index = resp.EtcdIndex + 1
+	}
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, p, index, false, receiver, stop)
+	go func() {
+		defer close(values)
+		for resp := range receiver {
+			var value []byte
+			switch resp.Action {
+			case "delete", "expire", "compareAndDelete":
+			default:
+				v, err := base64.StdEncoding.DecodeString(resp.Node.Value)
+				if err != nil {
+					continue
+				}
+				// Values set empty aren't taken as unset.
+				value = append([]byte{}, v...)
+			}
+			select {
+			case values <- value:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func encodeTaskData(value []byte) string {
+	return base64.StdEncoding.EncodeToString(value)
+}
@@ -0,0 +1,95 @@
+package etcdutil
+
+import (
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	merrors "github.com/go-distributed/meritop/errors"
+)
+
+// ErrNotLeader is returned by KeepLeader once the lead has been lost.
+var ErrNotLeader = merrors.New(merrors.ErrNotLeader, "etcdutil: leadership lost")
+
+// Campaign blocks until id has been elected leader of the job, or stop is
+// closed. The lead expires unless kept by KeepLeader every interval. It
+// returns false if stop is closed before.
+func Campaign(client Client, name, id string, interval time.Duration, stop chan bool) (bool, error) {
+	key := LeaderPath(name)
+	for {
+		_, err := client.Create(key, id, computeTTL(interval))
+		if err == nil {
+			return true, nil
+		}
+		e, ok := err.(*etcd.EtcdError)
+		if !ok || e.ErrorCode != ecodeNodeExist {
+			return false, err
+		}
+		if !waitVacant(client, key, e.Index+1, stop) {
+			return false, nil
+		}
+	}
+}
+
+// waitVacant blocks until key might have been deleted since index, or stop is
+// closed. It returns false if stop is closed before.
+func waitVacant(client Client, key string, index uint64, stop chan bool) bool {
+	watchStop := make(chan bool)
+	defer close(watchStop)
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, key, index, false, receiver, watchStop)
+	for {
+		select {
+		case resp := <-receiver:
+			switch resp.Action {
+			case "delete", "expire", "compareAndDelete", "get":
+				return true
+			}
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// KeepLeader keeps id leading the job, refreshing the lead every interval,
+// until stop is closed, and gives the lead up then. It returns ErrNotLeader
+// once another has taken the lead, or an error once the lead couldn't be
+// refreshed, after which id mustn't act as leader any more.
+func KeepLeader(client Client, name, id string, interval time.Duration, stop chan bool) error {
+	key := LeaderPath(name)
+	for {
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return Resign(client, name, id)
+		}
+		_, err := client.CompareAndSwap(key, id, computeTTL(interval), id, 0)
+		if e, ok := err.(*etcd.EtcdError); ok && (e.ErrorCode == ecodeTestFailed || e.ErrorCode == ecodeKeyNotFound) {
+			return ErrNotLeader
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Resign gives the lead of the job up if id has it, so that another is
+// elected without waiting for it to expire.
+func Resign(client Client, name, id string) error {
+	_, err := client.CompareAndDelete(LeaderPath(name), id, 0)
+	if e, ok := err.(*etcd.EtcdError); ok && (e.ErrorCode == ecodeTestFailed || e.ErrorCode == ecodeKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// GetLeader returns the ID of the replica leading the job, or "" if none does.
+func GetLeader(client Client, name string) (string, error) {
+	resp, err := client.Get(LeaderPath(name), false, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return resp.Node.Value, nil
+}
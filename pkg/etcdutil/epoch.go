@@ -2,30 +2,51 @@ package etcdutil
 
 import (
 	"log"
+	"math"
+	"path"
 	"strconv"
 
 	"github.com/coreos/go-etcd/etcd"
+	merrors "github.com/go-distributed/meritop/errors"
 )
 
-func GetAndWatchEpoch(client *etcd.Client, appname string, epochC chan uint64, stop chan bool) (uint64, error) {
+// ErrRollbackForward is returned by RollbackEpoch if the epoch to roll back to
+// is beyond the current one.
+var ErrRollbackForward = merrors.New(merrors.ErrEpochConflict, "etcdutil: can't roll epoch back to a later one")
+
+// ExitEpoch is the epoch the job is set to once it's shut down. Tasks exit as
+// they see it.
+const ExitEpoch = math.MaxUint64
+
+// GetEpoch returns the current epoch of the job.
+func GetEpoch(client Client, appname string) (uint64, error) {
+	resp, err := client.Get(EpochPath(appname), false, false)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(resp.Node.Value, 10, 64)
+}
+
+func GetAndWatchEpoch(client Client, appname string, epochC chan uint64, stop chan bool) (uint64, error) {
 	resp, err := client.Get(EpochPath(appname), false, false)
 	if err != nil {
-		log.Fatal("etcdutil: can not get epoch from etcd")
+		return 0, err
 	}
 	ep, err := strconv.ParseUint(resp.Node.Value, 10, 64)
 	if err != nil {
 		return 0, err
 	}
 	receiver := make(chan *etcd.Response, 1)
-	go client.Watch(EpochPath(appname), resp.EtcdIndex+1, false, receiver, stop)
+	go Watch(client, EpochPath(appname), resp.EtcdIndex+1, false, receiver, stop)
 	go func() {
 		for resp := range receiver {
-			if resp.Action != "compareAndSwap" && resp.Action != "set" {
+			if resp.Action != "compareAndSwap" && resp.Action != "set" && resp.Action != "get" {
 				continue
 			}
 			epoch, err := strconv.ParseUint(resp.Node.Value, 10, 64)
 			if err != nil {
-				log.Fatal("etcdutil: can't parse epoch from etcd")
+				log.Printf("etcdutil: can't parse epoch %q from etcd: %v", resp.Node.Value, err)
+				continue
 			}
 			epochC <- epoch
 		}
@@ -34,9 +55,90 @@ func GetAndWatchEpoch(client *etcd.Client, appname string, epochC chan uint64, s
 	return ep, nil
 }
 
-func CASEpoch(client *etcd.Client, appname string, prevEpoch, epoch uint64) error {
+// CASEpoch moves the job from prevEpoch to epoch, failing with
+// merrors.ErrEpochConflict if it isn't at prevEpoch any more. Moving to the
+// next epoch is counted towards CounterEpochs of the job.
+func CASEpoch(client Client, appname string, prevEpoch, epoch uint64) error {
 	prevEpochStr := strconv.FormatUint(prevEpoch, 10)
 	epochStr := strconv.FormatUint(epoch, 10)
 	_, err := client.CompareAndSwap(EpochPath(appname), epochStr, 0, prevEpochStr, 0)
+	if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeTestFailed {
+		// Somebody else has moved the epoch on.
+		return merrors.Wrap(merrors.ErrEpochConflict, err)
+	}
+	if err == nil && epoch == prevEpoch+1 {
+		IncCounter(client, appname, CounterEpochs)
+	}
+	return WrapError(err)
+}
+
+// RollbackEpoch sets epoch of the job back to the given one, which can be the
+// current epoch to start it again. Metas flagged and tasks checked in at
+// barriers since then are cleared first, so they won't be taken for ones of
+// the epoch started again.
+func RollbackEpoch(client Client, appname string, epoch uint64) error {
+	resp, err := client.Get(EpochPath(appname), false, false)
+	if err != nil {
+		return err
+	}
+	cur, err := strconv.ParseUint(resp.Node.Value, 10, 64)
+	if err != nil {
+		return err
+	}
+	if epoch > cur {
+		return ErrRollbackForward
+	}
+	if err := clearMetas(client, appname); err != nil {
+		return err
+	}
+	for e := epoch; e <= cur; e++ {
+		client.Delete(BarrierPath(appname, e), true)
+	}
+	// Compare and swap, so that it fails if epoch moves on meanwhile.
+	_, err = client.CompareAndSwap(EpochPath(appname), strconv.FormatUint(epoch, 10), 0, resp.Node.Value, 0)
 	return err
 }
+
+// clearMetas empties metas flagged by all tasks.
+func clearMetas(client Client, appname string) error {
+	resp, err := client.Get(TaskDirPath(appname), false, true)
+	if err != nil {
+		return err
+	}
+	for _, task := range resp.Node.Nodes {
+		for _, n := range task.Nodes {
+			switch path.Base(n.Key) {
+			case TaskParentMeta, TaskChildMeta:
+				_, err = client.Set(n.Key, "", 0)
+			case TaskLinkMeta:
+				_, err = client.Delete(n.Key, true)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WaitEpoch blocks until the job is at epoch, e.g. ExitEpoch to wait for it to
+// be shut down. It returns false if stop is closed before. stop has to be
+// closed after it returns all the same, to stop watching the epoch.
+func WaitEpoch(client Client, appname string, epoch uint64, stop chan bool) (bool, error) {
+	want := strconv.FormatUint(epoch, 10)
+	resp, err := client.Get(EpochPath(appname), false, false)
+	if err != nil {
+		return false, err
+	}
+	if resp.Node.Value == want {
+		return true, nil
+	}
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, EpochPath(appname), resp.EtcdIndex+1, false, receiver, stop)
+	for resp := range receiver {
+		if resp.Node.Value == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
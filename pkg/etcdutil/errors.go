@@ -0,0 +1,15 @@
+package etcdutil
+
+import (
+	"github.com/coreos/go-etcd/etcd"
+	merrors "github.com/go-distributed/meritop/errors"
+)
+
+// WrapError returns err as an error of merrors.ErrEtcdUnavailable if etcd
+// couldn't be reached. Other errors are returned as they are.
+func WrapError(err error) error {
+	if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcd.ErrCodeEtcdNotReachable {
+		return merrors.Wrap(merrors.ErrEtcdUnavailable, err)
+	}
+	return err
+}
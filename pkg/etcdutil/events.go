@@ -0,0 +1,74 @@
+package etcdutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Kinds of events in the audit log of a job.
+const (
+	// EventEpoch is the epoch of the job changing. Epoch is the new one.
+	EventEpoch = "epoch"
+	// EventRegister is a node registering for the task TaskID.
+	EventRegister = "register"
+	// EventFailure is the task TaskID reported as failed, and freed.
+	EventFailure = "failure"
+	// EventShutdown is the job shut down.
+	EventShutdown = "shutdown"
+	// EventFault is a fault injected into the task TaskID on purpose, e.g. by
+	// a test of the controller.
+	EventFault = "fault"
+)
+
+// Event is an entry of the audit log of a job, kept for postmortems. Only
+// fields making sense for the kind of event are set.
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// Actor is who caused the event, e.g. "controller" or "task 3".
+	Actor  string `json:"actor"`
+	Epoch  uint64 `json:"epoch"`
+	TaskID uint64 `json:"taskID"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// TaskActor names the task as an actor of events.
+func TaskActor(taskID uint64) string {
+	return fmt.Sprintf("task %d", taskID)
+}
+
+// RecordEvent appends the event to the audit log of the job, timestamped now
+// if it isn't. The log is append only; it's deleted along with the job.
+func RecordEvent(client Client, name string, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = client.CreateInOrder(EventsPath(name), string(b), 0)
+	return err
+}
+
+// ListEvents returns the audit log of the job, in the order events have been
+// recorded. Entries which can't be decoded are skipped.
+func ListEvents(client Client, name string) ([]Event, error) {
+	resp, err := client.Get(EventsPath(name), true, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// Keys of in order nodes sort in the order they have been created.
+	var events []Event
+	for _, n := range resp.Node.Nodes {
+		var e Event
+		if json.Unmarshal([]byte(n.Value), &e) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
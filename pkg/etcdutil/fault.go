@@ -0,0 +1,27 @@
+package etcdutil
+
+// ExpireHealth deletes the healthy key of the task, as if the node running it
+// missed its heartbeats, e.g. across a network partition. Detectors free the
+// task, while the node, unless it's gone, heartbeats again.
+func ExpireHealth(client Client, name string, taskID uint64) error {
+	_, err := client.Delete(TaskHealthyPath(name, taskID), false)
+	return err
+}
+
+// ScrambleRegistration points the registration of the task at connection, as
+// if it had been garbled. Other tasks can't reach the task any more, and the
+// node running it is fenced at its next heartbeat. It fails if no node has the
+// task.
+func ScrambleRegistration(client Client, name string, taskID uint64, connection string) error {
+	// It expires as the registration of a node which stopped heartbeating.
+	_, err := client.Update(TaskMasterPath(name, taskID), connection, 3)
+	return err
+}
+
+// RevokeTask takes the task from the node registered for it without freeing
+// it, as if the node lost its slot, e.g. to a scheduler. The node is fenced at
+// its next heartbeat, and the task is freed only once its health expires.
+func RevokeTask(client Client, name string, taskID uint64) error {
+	_, err := client.Delete(TaskMasterPath(name, taskID), false)
+	return err
+}
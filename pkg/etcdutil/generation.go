@@ -0,0 +1,72 @@
+package etcdutil
+
+import (
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+	merrors "github.com/go-distributed/meritop/errors"
+)
+
+// ErrTaskFenced is returned once the task has been taken by another node, so
+// the node which had it is to stop.
+var ErrTaskFenced = merrors.New(merrors.ErrTaskFenced, "etcdutil: task has been taken by another node")
+
+// Generation counts the nodes which have taken a task, so the latest one can be
+// told from the ones it replaced. Index is the etcd index it was taken at.
+// Anything the nodes before did later than that is stale.
+type Generation struct {
+	Value uint64
+	Index uint64
+}
+
+// NextGeneration bumps the generation of the task taken by a node, and
+// returns it. Tasks which another node had are counted towards
+// CounterReassignments of the job.
+func NextGeneration(client Client, name string, taskID uint64) (Generation, error) {
+	key := TaskGenerationPath(name, taskID)
+	for {
+		g, err := GetGeneration(client, name, taskID)
+		if err != nil {
+			return g, err
+		}
+		value := strconv.FormatUint(g.Value+1, 10)
+		var resp *etcd.Response
+		if g.Index == 0 {
+			resp, err = client.Create(key, value, 0)
+		} else {
+			resp, err = client.CompareAndSwap(key, value, 0, "", g.Index)
+		}
+		if e, ok := err.(*etcd.EtcdError); ok && (e.ErrorCode == ecodeNodeExist || e.ErrorCode == ecodeTestFailed) {
+			continue
+		}
+		if err != nil {
+			return g, err
+		}
+		if g.Value > 0 {
+			IncCounter(client, name, CounterReassignments)
+		}
+		return Generation{Value: g.Value + 1, Index: resp.Node.ModifiedIndex}, nil
+	}
+}
+
+// GetGeneration returns the current generation of the task, zero if no node
+// has taken it yet.
+func GetGeneration(client Client, name string, taskID uint64) (Generation, error) {
+	resp, err := client.Get(TaskGenerationPath(name, taskID), false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return Generation{}, nil
+		}
+		return Generation{}, err
+	}
+	return ParseGeneration(resp.Node)
+}
+
+// ParseGeneration reads the generation kept in the node.
+func ParseGeneration(n *etcd.Node) (Generation, error) {
+	v, err := strconv.ParseUint(n.Value, 10, 64)
+	if err != nil {
+		return Generation{}, err
+	}
+	return Generation{Value: v, Index: n.ModifiedIndex}, nil
+}
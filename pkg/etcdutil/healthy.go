@@ -1,23 +1,57 @@
 package etcdutil
 
 import (
-	"fmt"
-	"log"
 	"math/rand"
 	"path"
 	"strconv"
 	"time"
 
 	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
+	merrors "github.com/go-distributed/meritop/errors"
 )
 
+// ErrWaitFreeTaskTimeout is returned by WaitFreeTask if no task has been
+// freed for a while. Standbys could check the job and wait again.
+var ErrWaitFreeTaskTimeout = merrors.New(merrors.ErrNoFreeTask, "WaitFailure timeout!")
+
+// ErrWaitFreeTaskStopped is returned by WaitFreeTask if it's been stopped
+// before any task was freed.
+var ErrWaitFreeTaskStopped = merrors.New(merrors.ErrNoFreeTask, "etcdutil: waiting for free task stopped")
+
 // heartbeat to etcd cluster until stop
-func Heartbeat(client *etcd.Client, name string, taskID uint64, interval time.Duration, stop chan struct{}) error {
-	for {
-		_, err := client.Set(TaskHealthyPath(name, taskID), "health", computeTTL(interval))
+func Heartbeat(client Client, name string, taskID uint64, interval time.Duration, stop chan bool) error {
+	return heartbeat(interval, stop, func(ttl uint64) error {
+		_, err := client.Set(TaskHealthyPath(name, taskID), "health", ttl)
+		return err
+	})
+}
+
+// HeartbeatTask is like Heartbeat, but also keeps the task registered at
+// connection. Both expire if the node crashed, and the task returns to free
+// tasks.
+func HeartbeatTask(client Client, name string, taskID uint64, connection string, interval time.Duration, stop chan bool) error {
+	return heartbeat(interval, stop, func(ttl uint64) error {
+		// Only while the task is still registered at connection. Otherwise
+		// another node has taken it over.
+		_, err := client.CompareAndSwap(TaskMasterPath(name, taskID), connection, ttl, connection, 0)
+		if e, ok := err.(*etcd.EtcdError); ok && (e.ErrorCode == ecodeTestFailed || e.ErrorCode == ecodeKeyNotFound) {
+			return ErrTaskFenced
+		}
 		if err != nil {
 			return err
 		}
+		_, err = client.Set(TaskHealthyPath(name, taskID), "health", ttl)
+		return err
+	})
+}
+
+// heartbeat calls beat with TTL of keys to be set every interval until stop.
+func heartbeat(interval time.Duration, stop chan bool, beat func(ttl uint64) error) error {
+	for {
+		if err := beat(computeTTL(interval)); err != nil {
+			return err
+		}
 		select {
 		case <-time.After(interval):
 		case <-stop:
@@ -26,31 +60,92 @@ func Heartbeat(client *etcd.Client, name string, taskID uint64, interval time.Du
 	}
 }
 
-// detect failure of the given taskID
-func DetectFailure(client *etcd.Client, name string, stop chan bool, logger *log.Logger) error {
+// detect failure of the given taskID. Failures are reported on behalf of
+// actor, see Event.
+func DetectFailure(client Client, name, actor string, stop chan bool, logger meritop.Logger) error {
 	receiver := make(chan *etcd.Response, 1)
-	go client.Watch(HealthyPath(name), 0, true, receiver, stop)
+	go Watch(client, HealthyPath(name), 0, true, receiver, stop)
 	for resp := range receiver {
 		if resp.Action != "expire" && resp.Action != "delete" {
 			continue
 		}
-		err := ReportFailure(client, name, path.Base(resp.Node.Key))
+		// Several detectors might be watching. Don't report the task if it
+		// has been occupied again in the meantime.
+		if _, err := client.Get(resp.Node.Key, false, false); err == nil {
+			continue
+		}
+		// The directory went with the job.
+		id, err := strconv.ParseUint(path.Base(resp.Node.Key), 10, 64)
 		if err != nil {
-			logger.Printf("ReportFailure returns error: %v", err)
+			continue
+		}
+		// Tasks exited by themselves haven't failed.
+		if exited, _ := IsTaskExited(client, name, id); exited {
+			continue
+		}
+		// Nor have tasks the job has been shrunk off.
+		if n, ok, _ := GetNumOfTasks(client, name); ok && id >= n {
+			continue
+		}
+		if err := ReportFailure(client, name, path.Base(resp.Node.Key), actor, "heartbeat expired"); err != nil {
+			logger.Warnf("ReportFailure returns error: %v", err)
 		}
 	}
 	return nil
 }
 
+// WatchHealthy passes changes of health of tasks to handler until stop:
+// false once the healthy key of a task expired or was deleted, and true once
+// the task has been occupied again.
+func WatchHealthy(client Client, name string, stop chan bool, handler func(taskID uint64, healthy bool)) {
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, HealthyPath(name), 0, true, receiver, stop)
+	go func() {
+		for resp := range receiver {
+			var healthy bool
+			switch resp.Action {
+			case "expire", "delete":
+			case "create":
+				healthy = true
+			default:
+				continue
+			}
+			id, err := strconv.ParseUint(path.Base(resp.Node.Key), 10, 64)
+			if err != nil {
+				continue
+			}
+			handler(id, healthy)
+		}
+	}()
+}
+
 // report failure to etcd cluster
 // If a framework detects a failure, it tries to report failure to /FreeTasks/{taskID}
-func ReportFailure(client *etcd.Client, name, failedTask string) error {
-	_, err := client.Set(FreeTaskPath(name, failedTask), "failed", 0)
-	return err
+// Only the first reporter of a failure frees the task, counts the failure, and
+// records it in the audit log as actor, for reason. It's counted towards
+// CounterFailures of the job as well.
+func ReportFailure(client Client, name, failedTask, actor, reason string) error {
+	_, err := client.Create(FreeTaskPath(name, failedTask), "failed", 0)
+	if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeNodeExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	taskID, err := strconv.ParseUint(failedTask, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := RecordRestart(client, name, taskID); err != nil {
+		return err
+	}
+	IncCounter(client, name, CounterFailures)
+	return RecordEvent(client, name, Event{Kind: EventFailure, Actor: actor, TaskID: taskID, Detail: reason})
 }
 
-// WaitFreeTask blocks until it gets a hint of free task
-func WaitFreeTask(client *etcd.Client, name string, logger *log.Logger) (uint64, error) {
+// WaitFreeTask blocks until it gets a hint of free task, or until stop is
+// closed.
+func WaitFreeTask(client Client, name string, logger meritop.Logger, stop chan bool) (uint64, error) {
 	slots, err := client.Get(FreeTaskDir(name), false, true)
 	if err != nil {
 		return 0, err
@@ -63,21 +158,25 @@ func WaitFreeTask(client *etcd.Client, name string, logger *log.Logger) (uint64,
 		if err != nil {
 			return 0, err
 		}
-		logger.Printf("got free task %v at index %d, randomly choose %d to try...", ListKeys(slots.Node.Nodes), slots.EtcdIndex, ri)
+		logger.Infof("got free task %v at index %d, randomly choose %d to try...", ListKeys(slots.Node.Nodes), slots.EtcdIndex, ri)
 		return id, nil
 	}
 
 	watchIndex := slots.EtcdIndex + 1
 	respChan := make(chan *etcd.Response, 1)
+	watchStop := make(chan bool, 1)
 	go func() {
 		for {
-			logger.Printf("start to wait failure at index %d", watchIndex)
-			resp, err := client.Watch(FreeTaskDir(name), watchIndex, true, nil, nil)
+			logger.Debugf("start to wait failure at index %d", watchIndex)
+			resp, err := client.Watch(FreeTaskDir(name), watchIndex, true, nil, watchStop)
+			if err == etcd.ErrWatchStoppedByUser {
+				return
+			}
 			if err != nil {
-				logger.Printf("WARN: WaitFailure watch failed: %v", err)
+				logger.Warnf("WaitFailure watch failed: %v", err)
 				return
 			}
-			if resp.Action == "set" {
+			if resp.Action == "set" || resp.Action == "create" {
 				respChan <- resp
 				return
 			}
@@ -88,7 +187,11 @@ func WaitFreeTask(client *etcd.Client, name string, logger *log.Logger) (uint64,
 	select {
 	case resp = <-respChan:
 	case <-time.After(10 * time.Second):
-		return 0, fmt.Errorf("WaitFailure timeout!")
+		watchStop <- true
+		return 0, ErrWaitFreeTaskTimeout
+	case <-stop:
+		watchStop <- true
+		return 0, ErrWaitFreeTaskStopped
 	}
 	idStr := path.Base(resp.Node.Key)
 	id, err := strconv.ParseUint(idStr, 10, 64)
@@ -0,0 +1,70 @@
+package etcdutil
+
+import (
+	"encoding/json"
+	"path"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	merrors "github.com/go-distributed/meritop/errors"
+)
+
+// ErrJobExists is returned by RegisterJob if a job of the name has been
+// registered already.
+var ErrJobExists = merrors.New(merrors.ErrJobExists, "etcdutil: job of the name exists")
+
+// JobInfo is what the registry of jobs under a root keeps of a job.
+type JobInfo struct {
+	Name    string    `json:"name"`
+	Owner   string    `json:"owner"`
+	Created time.Time `json:"created"`
+	// TTL is how long the job lives without tasks heartbeating, 0 if it
+	// lives until deleted, see SetLease.
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// RegisterJob adds the job to the registry of root. Only one job of a name can
+// be registered at a time.
+func RegisterJob(client Client, root string, info JobInfo) error {
+	if info.Name == JobsDir {
+		return merrors.New(merrors.ErrJobExists, "etcdutil: job name "+JobsDir+" is reserved")
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	_, err = client.Create(JobInfoPath(root, info.Name), string(data), 0)
+	if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeNodeExist {
+		return ErrJobExists
+	}
+	return err
+}
+
+// UnregisterJob takes the job off the registry of root.
+func UnregisterJob(client Client, root, job string) error {
+	_, err := client.Delete(JobInfoPath(root, job), false)
+	if isKeyNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ListJobs returns jobs registered under root, sorted by name.
+func ListJobs(client Client, root string) ([]JobInfo, error) {
+	resp, err := client.Get(JobsDirPath(root), true, false)
+	if isKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]JobInfo, 0, len(resp.Node.Nodes))
+	for _, n := range resp.Node.Nodes {
+		var info JobInfo
+		// Entries garbled are still listed, by name.
+		json.Unmarshal([]byte(n.Value), &info)
+		info.Name = path.Base(n.Key)
+		jobs = append(jobs, info)
+	}
+	return jobs, nil
+}
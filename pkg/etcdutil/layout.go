@@ -1,42 +1,188 @@
 package etcdutil
 
 import (
+	"fmt"
 	"path"
 	"strconv"
 )
 
-// The directory layout we going to define in etcd:
-//   /{app}/config -> application configuration
+// The directory layout we going to define in etcd. Jobs sharing an etcd
+// cluster can be kept under a root prefix of their own, e.g. one per team, in
+// which case {app} is {root}/{job} (see JobName):
+//   /{root}/jobs/{job} -> owner and creation time of jobs set up, see JobInfo
+//   /{app}/config/{key} -> application configuration, see meritop.Config
 //   /{app}/epoch -> global value for epoch
+//   /{app}/numOfTasks -> number of tasks, which can change while job runs
+//   /{app}/authToken -> secret of the job tasks send with data requests
+//   /{app}/topology -> type and params of the topology of the job in JSON, see controller.JobSpec
 //   /{app}/tasks/: register tasks under this directory
-//   /{app}/tasks/{taskID}/{replicaID} -> pointer to nodes, 0 replicaID means master
+//   /{app}/tasks/{taskID}/{replicaID} -> pointer to nodes, 0 replicaID means master, expiring with heartbeat
 //   /{app}/tasks/{taskID}/parentMeta
 //   /{app}/tasks/{taskID}/childMeta
+//   /{app}/tasks/{taskID}/linkMeta/{linkType} -> meta flagged to neighbors
+//   /{app}/tasks/{taskID}/state -> latest checkpoint of the task
+//   /{app}/tasks/{taskID}/data/{key} -> values the task keeps with Framework.Store
+//   /{app}/tasks/{taskID}/status -> "exited" once the task exited by itself, and is not to be taken over
+//   /{app}/tasks/{taskID}/generation -> bumped each time a node takes the task
+//   /{app}/tasks/{taskID}/version -> data protocol version the node of the task talks
+//   /{app}/tasks/{taskID}/restarts -> times the task failed recently, and when it last did
+//   /{app}/tasks/{taskID}/updateLog/{logID} -> update logs shipped from master to replicas
+//   /{app}/barrier/{epoch}/{taskID} -> tasks done with the epoch
+//   /{app}/barrier/{epoch}/advance -> epoch is to advance once enough tasks are done
+//   /{app}/pacing -> "controller" if only the controller advances the epoch
+//   /{app}/leader -> ID of the replica of the controller leading the job
+//   /{app}/lease -> TTL of the job in seconds, expiring unless tasks keep it, see KeepLease
+//   /{app}/events/{index} -> audit log of the job, an etcdutil.Event in JSON each, in order
+//   /{app}/metrics/{counter} -> counters of the job, e.g. of failures, see IncCounter
+//   /{app}/phase/{epoch}/{barrier}/{taskID} -> tasks entered the named barrier in the epoch
+//   /{app}/progress/{epoch}/{taskID} -> progress the task reported in the epoch, a meritop.Progress in JSON
+//   /{app}/checkpoint/request -> epoch at the start of which all tasks are to checkpoint
+//   /{app}/checkpoint/last -> last epoch all tasks checkpointed at
+//   /{app}/checkpoint/{epoch}/{taskID} -> tasks checkpointed at the epoch
 //   /{app}/healthy/{taskID} -> tasks' healthy condition
 //   /{app}/nodes/: register nodes under this directory
 //   /{app}/nodes/{nodeID}/address -> scheme://host:port/{path(if http)}
 //   /{app}/nodes/{nodeID}/ttl -> keep alive timeout
+//   /{app}/nodes/{nodeID}/locality -> rack or zone of node placed for task nodeID
 //   /{app}/FreeTasks/{taskID}
+//   /{app}/poisoned/{taskID} -> tasks failing too often, which nobody takes any more
 
 const (
 	TasksDir       = "tasks"
 	NodesDir       = "nodes"
 	ConfigDir      = "config"
 	FreeDir        = "freeTasks"
+	PoisonedDir    = "poisoned"
 	Epoch          = "epoch"
 	Status         = "status"
 	TaskMaster     = "0"
 	TaskParentMeta = "parentMeta"
 	TaskChildMeta  = "childMeta"
+	TaskLinkMeta   = "linkMeta"
+	TaskState      = "state"
+	TaskData       = "data"
+	TaskRestarts   = "restarts"
+	TaskGeneration = "generation"
+	TaskVersion    = "version"
+	TaskStatus     = "status"
+	TaskExited     = "exited"
+	TaskUpdateLog  = "updateLog"
 	NodeAddr       = "address"
 	NodeTTL        = "ttl"
+	NodeLocality   = "locality"
 	Healthy        = "healthy"
+	BarrierDir     = "barrier"
+	BarrierAdvance = "advance"
+	PhaseDir       = "phase"
+	CheckpointDir  = "checkpoint"
+	CheckpointReq  = "request"
+	CheckpointLast = "last"
+	AuthToken      = "authToken"
+	NumOfTasks     = "numOfTasks"
+	JobsDir        = "jobs"
+	Topology       = "topology"
+	Pacing         = "pacing"
+	PacingCtrl     = "controller"
+	Leader         = "leader"
+	EventsDir      = "events"
+	MetricsDir     = "metrics"
+	Lease          = "lease"
+	ProgressDir    = "progress"
 )
 
+// JobName returns the name the job is kept under in etcd, which the paths
+// below take as appName. Jobs with no root are kept at the top.
+func JobName(root, job string) string {
+	if root == "" {
+		return job
+	}
+	return path.Join("/", root, job)
+}
+
+func JobsDirPath(root string) string {
+	return path.Join("/", root, JobsDir)
+}
+
+func JobInfoPath(root, job string) string {
+	return path.Join(JobsDirPath(root), job)
+}
+
 func EpochPath(appName string) string {
 	return path.Join("/", appName, Epoch)
 }
 
+func ConfigPath(appName string) string {
+	return path.Join("/", appName, ConfigDir)
+}
+
+func ConfigKeyPath(appName, key string) string {
+	return path.Join("/", appName, ConfigDir, key)
+}
+
+func NumOfTasksPath(appName string) string {
+	return path.Join("/", appName, NumOfTasks)
+}
+
+func AuthTokenPath(appName string) string {
+	return path.Join("/", appName, AuthToken)
+}
+
+func EventsPath(appName string) string {
+	return path.Join("/", appName, EventsDir)
+}
+
+func MetricsPath(appName string) string {
+	return path.Join("/", appName, MetricsDir)
+}
+
+func CounterPath(appName, counter string) string {
+	return path.Join("/", appName, MetricsDir, counter)
+}
+
+func LeasePath(appName string) string {
+	return path.Join("/", appName, Lease)
+}
+
+func LeaderPath(appName string) string {
+	return path.Join("/", appName, Leader)
+}
+
+func PacingPath(appName string) string {
+	return path.Join("/", appName, Pacing)
+}
+
+func TopologyPath(appName string) string {
+	return path.Join("/", appName, Topology)
+}
+
+func ProgressPath(appName string) string {
+	return path.Join("/", appName, ProgressDir)
+}
+
+func TaskProgressPath(appName string, epoch, taskID uint64) string {
+	return path.Join(ProgressPath(appName), strconv.FormatUint(epoch, 10), strconv.FormatUint(taskID, 10))
+}
+
+func BarrierPath(appName string, epoch uint64) string {
+	return path.Join("/", appName, BarrierDir, strconv.FormatUint(epoch, 10))
+}
+
+func PhaseBarrierPath(appName string, epoch uint64, barrier string) string {
+	return path.Join("/", appName, PhaseDir, strconv.FormatUint(epoch, 10), barrier)
+}
+
+func CheckpointRequestPath(appName string) string {
+	return path.Join("/", appName, CheckpointDir, CheckpointReq)
+}
+
+func LastCheckpointPath(appName string) string {
+	return path.Join("/", appName, CheckpointDir, CheckpointLast)
+}
+
+func CheckpointPath(appName string, epoch uint64) string {
+	return path.Join("/", appName, CheckpointDir, strconv.FormatUint(epoch, 10))
+}
+
 func JobStatusPath(appName string) string {
 	return path.Join("/", appName, Status)
 }
@@ -59,10 +205,22 @@ func TaskDirPath(appName string) string {
 	return path.Join("/", appName, TasksDir)
 }
 
+func TaskPath(appName string, taskID uint64) string {
+	return path.Join("/", appName, TasksDir, strconv.FormatUint(taskID, 10))
+}
+
 func TaskMasterPath(appName string, taskID uint64) string {
 	return path.Join("/", appName, TasksDir, strconv.FormatUint(taskID, 10), TaskMaster)
 }
 
+func TaskReplicaPath(appName string, taskID, replicaID uint64) string {
+	return path.Join("/",
+		appName,
+		TasksDir,
+		strconv.FormatUint(taskID, 10),
+		strconv.FormatUint(replicaID, 10))
+}
+
 func ParentMetaPath(appName string, taskID uint64) string {
 	return path.Join("/",
 		appName,
@@ -78,3 +236,90 @@ func ChildMetaPath(appName string, taskID uint64) string {
 		strconv.FormatUint(taskID, 10),
 		TaskChildMeta)
 }
+
+func LinkMetaPath(appName string, taskID uint64, linkType string) string {
+	return path.Join("/",
+		appName,
+		TasksDir,
+		strconv.FormatUint(taskID, 10),
+		TaskLinkMeta,
+		linkType)
+}
+
+func TaskStatePath(appName string, taskID uint64) string {
+	return path.Join("/",
+		appName,
+		TasksDir,
+		strconv.FormatUint(taskID, 10),
+		TaskState)
+}
+
+func TaskDataPath(appName string, taskID uint64, key string) string {
+	return path.Join("/",
+		appName,
+		TasksDir,
+		strconv.FormatUint(taskID, 10),
+		TaskData,
+		key)
+}
+
+func TaskGenerationPath(appName string, taskID uint64) string {
+	return path.Join("/",
+		appName,
+		TasksDir,
+		strconv.FormatUint(taskID, 10),
+		TaskGeneration)
+}
+
+func TaskVersionPath(appName string, taskID uint64) string {
+	return path.Join("/",
+		appName,
+		TasksDir,
+		strconv.FormatUint(taskID, 10),
+		TaskVersion)
+}
+
+func TaskStatusPath(appName string, taskID uint64) string {
+	return path.Join("/",
+		appName,
+		TasksDir,
+		strconv.FormatUint(taskID, 10),
+		TaskStatus)
+}
+
+func TaskRestartsPath(appName string, taskID uint64) string {
+	return path.Join("/",
+		appName,
+		TasksDir,
+		strconv.FormatUint(taskID, 10),
+		TaskRestarts)
+}
+
+func PoisonedDirPath(appName string) string {
+	return path.Join("/", appName, PoisonedDir)
+}
+
+func PoisonedTaskPath(appName string, taskID uint64) string {
+	return path.Join(PoisonedDirPath(appName), strconv.FormatUint(taskID, 10))
+}
+
+func UpdateLogPath(appName string, taskID uint64) string {
+	return path.Join("/",
+		appName,
+		TasksDir,
+		strconv.FormatUint(taskID, 10),
+		TaskUpdateLog)
+}
+
+// UpdateLogEntryPath pads logID, so that logs are sorted by ID in etcd.
+func UpdateLogEntryPath(appName string, taskID, logID uint64) string {
+	return path.Join(UpdateLogPath(appName, taskID), fmt.Sprintf("%020d", logID))
+}
+
+func NodesDirPath(appName string) string {
+	return path.Join("/", appName, NodesDir)
+}
+
+func NodeLocalityPath(appName string, nodeID uint64) string {
+	return path.Join(NodesDirPath(appName), strconv.FormatUint(nodeID, 10), NodeLocality)
+}
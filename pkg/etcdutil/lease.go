@@ -0,0 +1,91 @@
+package etcdutil
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// ErrLeaseExpired is returned by KeepLease once the lease of the job has
+// expired, and the job is to be torn down as abandoned.
+var ErrLeaseExpired = errors.New("etcdutil: lease of job expired")
+
+// SetLease gives the job a lease of ttl, at least a second, which expires
+// unless tasks keep it with KeepLease. The job is abandoned once it has.
+func SetLease(client Client, name string, ttl time.Duration) error {
+	secs := leaseSeconds(ttl)
+	_, err := client.Set(LeasePath(name), strconv.FormatUint(secs, 10), secs)
+	return err
+}
+
+// GetLease returns the TTL of the lease of the job. It returns false if the job
+// has no lease, or it has expired.
+func GetLease(client Client, name string) (time.Duration, bool, error) {
+	resp, err := client.Get(LeasePath(name), false, false)
+	if isKeyNotFound(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	secs, err := strconv.ParseUint(resp.Node.Value, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return time.Duration(secs) * time.Second, true, nil
+}
+
+// KeepLease renews the lease of ttl of the job, a few times within ttl, until
+// stop is closed. A lease expired isn't taken back, since the job might have
+// been torn down meanwhile; ErrLeaseExpired is returned instead.
+func KeepLease(client Client, name string, ttl time.Duration, stop chan bool) error {
+	secs := leaseSeconds(ttl)
+	value := strconv.FormatUint(secs, 10)
+	interval := time.Duration(secs) * time.Second / 3
+	for {
+		_, err := client.Update(LeasePath(name), value, secs)
+		if isKeyNotFound(err) {
+			return ErrLeaseExpired
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// WaitLeaseExpired blocks until the lease of the job has expired, or been
+// deleted. It returns false if stop is closed before. stop has to be closed
+// after it returns all the same, to stop watching the lease.
+func WaitLeaseExpired(client Client, name string, stop chan bool) (bool, error) {
+	resp, err := client.Get(LeasePath(name), false, false)
+	if isKeyNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, LeasePath(name), resp.EtcdIndex+1, false, receiver, stop)
+	for resp := range receiver {
+		switch resp.Action {
+		case "expire", "delete":
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func leaseSeconds(ttl time.Duration) uint64 {
+	secs := uint64(ttl / time.Second)
+	if secs == 0 {
+		secs = 1
+	}
+	return secs
+}
@@ -0,0 +1,39 @@
+package etcdutil
+
+import (
+	"path"
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// SetNodeLocality labels the node placed for the task with its rack or zone.
+func SetNodeLocality(client Client, name string, nodeID uint64, locality string) error {
+	_, err := client.Set(NodeLocalityPath(name, nodeID), locality, 0)
+	return err
+}
+
+// GetNodeLocalities returns locality labels of nodes, keyed by node ID. Nodes
+// without a label are left out.
+func GetNodeLocalities(client Client, name string) (map[uint64]string, error) {
+	localities := make(map[uint64]string)
+	resp, err := client.Get(NodesDirPath(name), false, true)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return localities, nil
+		}
+		return nil, err
+	}
+	for _, node := range resp.Node.Nodes {
+		id, err := strconv.ParseUint(path.Base(node.Key), 10, 64)
+		if err != nil {
+			continue
+		}
+		for _, n := range node.Nodes {
+			if path.Base(n.Key) == NodeLocality {
+				localities[id] = n.Value
+			}
+		}
+	}
+	return localities, nil
+}
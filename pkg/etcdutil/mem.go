@@ -0,0 +1,425 @@
+package etcdutil
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+const (
+	ecodeNotFile     = 102
+	ecodeNotDir      = 104
+	ecodeRootROnly   = 107
+	ecodeDirNotEmpty = 108
+
+	// memHistory is how many changes MemClient keeps for watches to
+	// catch up on, as many as etcd does.
+	memHistory = 1000
+	// memExpireInterval is how often MemClient expires keys.
+	memExpireInterval = 50 * time.Millisecond
+)
+
+// MemClient is a Client keeping keys in memory, for tests to run
+// controller and frameworks of a job in one process without an etcd cluster.
+// Keys expire as they do in etcd, with an "expire" change watches see. It
+// needs to be closed once done with.
+type MemClient struct {
+	mu    sync.Mutex
+	index uint64
+	root  *memNode
+	// history keeps the last memHistory changes, and changed is closed on
+	// every change, for watches waiting on it.
+	history []*etcd.Response
+	changed chan struct{}
+	stop    chan struct{}
+	once    sync.Once
+}
+
+type memNode struct {
+	key      string
+	value    string
+	dir      bool
+	children map[string]*memNode
+	expire   time.Time
+	created  uint64
+	modified uint64
+}
+
+func NewMemClient() *MemClient {
+	m := &MemClient{
+		root:    &memNode{key: "/", dir: true, children: make(map[string]*memNode)},
+		changed: make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+	go m.expireLoop()
+	return m
+}
+
+// Close stops expiring keys. Watches return as if etcd is unreachable.
+func (m *MemClient) Close() {
+	m.once.Do(func() { close(m.stop) })
+}
+
+func (m *MemClient) Get(key string, sorted, recursive bool) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key = cleanKey(key)
+	n := m.find(key)
+	if n == nil {
+		return nil, m.error(ecodeKeyNotFound, "Key not found", key)
+	}
+	return &etcd.Response{Action: "get", Node: n.toNode(true, recursive), EtcdIndex: m.index}, nil
+}
+
+func (m *MemClient) Set(key string, value string, ttl uint64) (*etcd.Response, error) {
+	return m.put("set", key, value, ttl, false)
+}
+
+func (m *MemClient) Create(key string, value string, ttl uint64) (*etcd.Response, error) {
+	return m.put("create", key, value, ttl, false)
+}
+
+func (m *MemClient) CreateDir(key string, ttl uint64) (*etcd.Response, error) {
+	return m.put("create", key, "", ttl, true)
+}
+
+func (m *MemClient) CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.putLocked("create", fmt.Sprintf("%s/%020d", cleanKey(dir), m.index+1), value, ttl, false)
+}
+
+func (m *MemClient) Update(key string, value string, ttl uint64) (*etcd.Response, error) {
+	return m.put("update", key, value, ttl, false)
+}
+
+func (m *MemClient) CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key = cleanKey(key)
+	n, err := m.compare(key, prevValue, prevIndex)
+	if err != nil {
+		return nil, err
+	}
+	prev := n.toNode(false, false)
+	m.index++
+	n.value, n.expire, n.modified = value, expireAt(ttl), m.index
+	return m.emit(&etcd.Response{Action: "compareAndSwap", Node: n.toNode(false, false), PrevNode: prev}), nil
+}
+
+func (m *MemClient) CompareAndDelete(key string, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key = cleanKey(key)
+	n, err := m.compare(key, prevValue, prevIndex)
+	if err != nil {
+		return nil, err
+	}
+	return m.remove("compareAndDelete", n), nil
+}
+
+func (m *MemClient) Delete(key string, recursive bool) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key = cleanKey(key)
+	if key == "/" {
+		return nil, m.error(ecodeRootROnly, "Root is read only", key)
+	}
+	n := m.find(key)
+	if n == nil {
+		return nil, m.error(ecodeKeyNotFound, "Key not found", key)
+	}
+	if n.dir && !recursive {
+		return nil, m.error(ecodeNotFile, "Not a file", key)
+	}
+	return m.remove("delete", n), nil
+}
+
+func (m *MemClient) DeleteDir(key string) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key = cleanKey(key)
+	if key == "/" {
+		return nil, m.error(ecodeRootROnly, "Root is read only", key)
+	}
+	n := m.find(key)
+	switch {
+	case n == nil:
+		return nil, m.error(ecodeKeyNotFound, "Key not found", key)
+	case !n.dir:
+		return nil, m.error(ecodeNotDir, "Not a directory", key)
+	case len(n.children) > 0:
+		return nil, m.error(ecodeDirNotEmpty, "Directory not empty", key)
+	}
+	return m.remove("delete", n), nil
+}
+
+// Watch returns the first change of the key, or of keys under it if
+// recursive, at or after waitIndex, or the next one if waitIndex is 0. With a
+// receiver, it passes on changes until stop is closed, and closes receiver.
+func (m *MemClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	if receiver == nil {
+		return m.watchOnce(cleanKey(prefix), waitIndex, recursive, stop)
+	}
+	defer close(receiver)
+	for {
+		resp, err := m.watchOnce(cleanKey(prefix), waitIndex, recursive, stop)
+		if err != nil {
+			return nil, err
+		}
+		waitIndex = resp.Node.ModifiedIndex + 1
+		select {
+		case receiver <- resp:
+		case <-stop:
+			return nil, etcd.ErrWatchStoppedByUser
+		}
+	}
+}
+
+func (m *MemClient) watchOnce(key string, waitIndex uint64, recursive bool, stop chan bool) (*etcd.Response, error) {
+	m.mu.Lock()
+	if waitIndex == 0 {
+		waitIndex = m.index + 1
+	}
+	m.mu.Unlock()
+	for {
+		m.mu.Lock()
+		if len(m.history) > 0 && waitIndex < m.history[0].Node.ModifiedIndex {
+			first := m.history[0].Node.ModifiedIndex
+			m.mu.Unlock()
+			return nil, &etcd.EtcdError{
+				ErrorCode: ecodeEventIndexCleared,
+				Message:   "The event in requested index is outdated and cleared",
+				Cause:     fmt.Sprintf("the requested history has been cleared [%d/%d]", first, waitIndex),
+				Index:     m.index,
+			}
+		}
+		for _, resp := range m.history {
+			if resp.Node.ModifiedIndex >= waitIndex && watched(resp, key, recursive) {
+				m.mu.Unlock()
+				return copyResponse(resp), nil
+			}
+		}
+		changed := m.changed
+		m.mu.Unlock()
+		select {
+		case <-changed:
+		case <-stop:
+			return nil, etcd.ErrWatchStoppedByUser
+		case <-m.stop:
+			return nil, &etcd.EtcdError{ErrorCode: etcd.ErrCodeEtcdNotReachable, Message: "All the given peers are not reachable"}
+		}
+	}
+}
+
+// watched tells whether a watch on the key sees the change, which it does for
+// changes of keys under it if recursive, and for deletes of directories it's
+// under.
+func watched(resp *etcd.Response, key string, recursive bool) bool {
+	changed := resp.Node.Key
+	switch {
+	case changed == key:
+		return true
+	case recursive && strings.HasPrefix(changed, strings.TrimSuffix(key, "/")+"/"):
+		return true
+	case resp.Action != "set" && resp.Action != "create" && resp.Action != "update" && resp.Action != "compareAndSwap":
+		return resp.Node.Dir && strings.HasPrefix(key, changed+"/")
+	}
+	return false
+}
+
+func (m *MemClient) put(action, key, value string, ttl uint64, dir bool) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.putLocked(action, cleanKey(key), value, ttl, dir)
+}
+
+func (m *MemClient) putLocked(action, key, value string, ttl uint64, dir bool) (*etcd.Response, error) {
+	if key == "/" {
+		return nil, m.error(ecodeRootROnly, "Root is read only", key)
+	}
+	n := m.find(key)
+	switch {
+	case n != nil && action == "create":
+		return nil, m.error(ecodeNodeExist, "Key already exists", key)
+	case n == nil && action == "update":
+		return nil, m.error(ecodeKeyNotFound, "Key not found", key)
+	case n != nil && n.dir:
+		return nil, m.error(ecodeNotFile, "Not a file", key)
+	}
+	parent, err := m.mkdirs(path.Dir(key))
+	if err != nil {
+		return nil, err
+	}
+	m.index++
+	var prev *etcd.Node
+	if n != nil {
+		prev = n.toNode(false, false)
+		n.value, n.expire, n.modified = value, expireAt(ttl), m.index
+	} else {
+		n = &memNode{key: key, value: value, dir: dir, expire: expireAt(ttl), created: m.index, modified: m.index}
+		if dir {
+			n.children = make(map[string]*memNode)
+		}
+		parent.children[path.Base(key)] = n
+	}
+	return m.emit(&etcd.Response{Action: action, Node: n.toNode(false, false), PrevNode: prev}), nil
+}
+
+// mkdirs returns the directory, creating it and directories it's under as
+// needed, as etcd does for keys created under them.
+func (m *MemClient) mkdirs(dir string) (*memNode, error) {
+	n := m.root
+	if dir == "/" {
+		return n, nil
+	}
+	for _, name := range strings.Split(strings.TrimPrefix(dir, "/"), "/") {
+		c, ok := n.children[name]
+		if !ok {
+			c = &memNode{key: path.Join(n.key, name), dir: true, children: make(map[string]*memNode), created: m.index + 1, modified: m.index + 1}
+			n.children[name] = c
+		}
+		if !c.dir {
+			return nil, m.error(ecodeNotDir, "Not a directory", c.key)
+		}
+		n = c
+	}
+	return n, nil
+}
+
+func (m *MemClient) compare(key, prevValue string, prevIndex uint64) (*memNode, error) {
+	n := m.find(key)
+	if n == nil {
+		return nil, m.error(ecodeKeyNotFound, "Key not found", key)
+	}
+	if n.dir {
+		return nil, m.error(ecodeNotFile, "Not a file", key)
+	}
+	if (prevValue != "" && prevValue != n.value) || (prevIndex != 0 && prevIndex != n.modified) {
+		return nil, m.error(ecodeTestFailed, "Compare failed",
+			fmt.Sprintf("[%s != %s] [%d != %d]", prevValue, n.value, prevIndex, n.modified))
+	}
+	return n, nil
+}
+
+// remove removes the node, and keys under it, as the action.
+func (m *MemClient) remove(action string, n *memNode) *etcd.Response {
+	prev := n.toNode(false, false)
+	delete(m.find(path.Dir(n.key)).children, path.Base(n.key))
+	m.index++
+	node := &etcd.Node{Key: n.key, Dir: n.dir, CreatedIndex: n.created, ModifiedIndex: m.index}
+	return m.emit(&etcd.Response{Action: action, Node: node, PrevNode: prev})
+}
+
+func (m *MemClient) find(key string) *memNode {
+	n := m.root
+	if key == "/" {
+		return n
+	}
+	for _, name := range strings.Split(strings.TrimPrefix(key, "/"), "/") {
+		if !n.dir {
+			return nil
+		}
+		c, ok := n.children[name]
+		if !ok {
+			return nil
+		}
+		n = c
+	}
+	return n
+}
+
+// emit records the change for watches and wakes them up.
+func (m *MemClient) emit(resp *etcd.Response) *etcd.Response {
+	resp.EtcdIndex = m.index
+	m.history = append(m.history, resp)
+	if len(m.history) > memHistory {
+		m.history = m.history[len(m.history)-memHistory:]
+	}
+	close(m.changed)
+	m.changed = make(chan struct{})
+	return copyResponse(resp)
+}
+
+func (m *MemClient) error(code int, message, cause string) error {
+	return &etcd.EtcdError{ErrorCode: code, Message: message, Cause: cause, Index: m.index}
+}
+
+func (m *MemClient) expireLoop() {
+	ticker := time.NewTicker(memExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.stop:
+			return
+		}
+		m.mu.Lock()
+		var expired []*memNode
+		now := time.Now()
+		var walk func(n *memNode)
+		walk = func(n *memNode) {
+			for _, c := range n.children {
+				if !c.expire.IsZero() && !c.expire.After(now) {
+					expired = append(expired, c)
+				} else if c.dir {
+					walk(c)
+				}
+			}
+		}
+		walk(m.root)
+		for _, n := range expired {
+			m.remove("expire", n)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// toNode returns the node as etcd does, with its children if withChildren,
+// and theirs if recursive as well.
+func (n *memNode) toNode(withChildren, recursive bool) *etcd.Node {
+	node := &etcd.Node{Key: n.key, Value: n.value, Dir: n.dir, CreatedIndex: n.created, ModifiedIndex: n.modified}
+	if n.key == "/" {
+		node.Key = ""
+	}
+	if !n.expire.IsZero() {
+		expire := n.expire
+		node.Expiration = &expire
+		node.TTL = int64(expire.Sub(time.Now())/time.Second) + 1
+	}
+	if n.dir && withChildren {
+		node.Nodes = make(etcd.Nodes, 0, len(n.children))
+		for _, c := range n.children {
+			node.Nodes = append(node.Nodes, c.toNode(recursive, recursive))
+		}
+		sort.Sort(node.Nodes)
+	}
+	return node
+}
+
+func copyResponse(resp *etcd.Response) *etcd.Response {
+	r := *resp
+	node := *resp.Node
+	r.Node = &node
+	if resp.PrevNode != nil {
+		prev := *resp.PrevNode
+		r.PrevNode = &prev
+	}
+	return &r
+}
+
+func cleanKey(key string) string {
+	return path.Clean("/" + key)
+}
+
+func expireAt(ttl uint64) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(ttl) * time.Second)
+}
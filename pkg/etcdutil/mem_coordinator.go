@@ -0,0 +1,1280 @@
+package etcdutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-distributed/meritop"
+	merrors "github.com/go-distributed/meritop/errors"
+)
+
+const (
+	// memTaskTTL is how long a task is healthy once occupied, until the
+	// first heartbeat, as on etcd.
+	memTaskTTL = 3 * time.Second
+	// memWaitFreeTaskTimeout is how long WaitFreeTask waits for a task to
+	// be freed, as on etcd.
+	memWaitFreeTaskTimeout = 10 * time.Second
+)
+
+var (
+	errMemNoJob         = errors.New("etcdutil: job hasn't been set up in memory")
+	errMemNoTask        = errors.New("etcdutil: no node works for the task")
+	errMemClosed        = errors.New("etcdutil: MemCoordinator closed")
+	errMemLogTaken      = errors.New("etcdutil: update log ID has been taken")
+	errMemJobExists     = merrors.New(merrors.ErrJobExists, "etcdutil: job has been set up in memory")
+	errMemEpochConflict = merrors.New(merrors.ErrEpochConflict, "etcdutil: epoch of job has moved on")
+)
+
+// MemCoordinator is a Coordinator keeping jobs in memory, for tests to run
+// frameworks of a job in one process without etcd, nor any other backend.
+// Jobs are set up by SetupJob, as the controller does on etcd, without auth
+// token, lease or pacing by controller. Heartbeats expire as they do on etcd.
+// It needs to be closed once done with.
+type MemCoordinator struct {
+	mu    sync.Mutex
+	rev   uint64
+	jobs  map[string]*memJob
+	metas map[string]memMeta
+	// watchers are passed changes matched, and changed is closed on every
+	// change, for waits on what's kept.
+	watchers map[*memWatcher]bool
+	changed  chan struct{}
+	stop     chan struct{}
+	once     sync.Once
+}
+
+type memJob struct {
+	numOfTasks    uint64
+	epoch         uint64
+	done          bool
+	tasks         map[uint64]*memTask
+	free          map[uint64]bool
+	poisoned      map[uint64]string
+	config        map[string]string
+	barriers      map[uint64]*memBarrier
+	phases        map[string]map[uint64]bool
+	checkpointAt  uint64
+	checkpointSet bool
+	checkpoints   map[uint64]map[uint64]bool
+	events        []Event
+	progress      map[uint64]map[uint64]meritop.Progress
+}
+
+type memTask struct {
+	addr     string
+	healthy  bool
+	expire   time.Time
+	exited   bool
+	gen      Generation
+	version  int
+	restarts Restarts
+	replicas map[uint64]memReplica
+	logs     []meritop.UpdateLog
+	data     map[string][]byte
+	state    *taskState
+}
+
+type memReplica struct {
+	addr   string
+	expire time.Time
+}
+
+type memBarrier struct {
+	done    map[uint64]bool
+	advance bool
+}
+
+type memMeta struct {
+	value string
+	rev   uint64
+}
+
+// Kinds of memChange.
+const (
+	memKindEpoch      = "epoch"
+	memKindHealth     = "health"
+	memKindTask       = "task"
+	memKindMeta       = "meta"
+	memKindConfig     = "config"
+	memKindCheckpoint = "checkpoint"
+	memKindData       = "data"
+)
+
+// memChange is a change passed to watchers. Only fields of its kind are set.
+type memChange struct {
+	kind    string
+	job     string
+	taskID  uint64
+	key     string
+	value   string
+	data    []byte
+	epoch   uint64
+	healthy bool
+	task    TaskChange
+	cfg     map[string]string
+	rev     uint64
+}
+
+// memWatcher queues changes matched for a watch, so that a slow handler
+// doesn't hold up others.
+type memWatcher struct {
+	match   func(c *memChange) bool
+	mu      sync.Mutex
+	changes []*memChange
+	ready   chan struct{}
+}
+
+func NewMemCoordinator() *MemCoordinator {
+	m := &MemCoordinator{
+		jobs:     make(map[string]*memJob),
+		metas:    make(map[string]memMeta),
+		watchers: make(map[*memWatcher]bool),
+		changed:  make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+	go m.expireLoop()
+	return m
+}
+
+// Close stops expiring heartbeats. Watches and waits return as if stopped.
+func (m *MemCoordinator) Close() {
+	m.once.Do(func() { close(m.stop) })
+}
+
+// SetupJob sets up the job of numOfTasks tasks, all free for nodes to take,
+// at epoch 0.
+func (m *MemCoordinator) SetupJob(name string, numOfTasks uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[name]; ok {
+		return errMemJobExists
+	}
+	j := &memJob{
+		numOfTasks:  numOfTasks,
+		tasks:       make(map[uint64]*memTask),
+		free:        make(map[uint64]bool),
+		poisoned:    make(map[uint64]string),
+		config:      make(map[string]string),
+		barriers:    make(map[uint64]*memBarrier),
+		phases:      make(map[string]map[uint64]bool),
+		checkpoints: make(map[uint64]map[uint64]bool),
+		progress:    make(map[uint64]map[uint64]meritop.Progress),
+	}
+	for id := uint64(0); id < numOfTasks; id++ {
+		j.free[id] = true
+	}
+	m.jobs[name] = j
+	m.emitLocked(nil)
+	return nil
+}
+
+// SetConfig sets keys of the configuration of the job to the given values,
+// as the controller does on etcd. Other keys are kept.
+func (m *MemCoordinator) SetConfig(name string, cfg map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	for key, value := range cfg {
+		j.config[key] = value
+	}
+	m.emitLocked(&memChange{kind: memKindConfig, job: name, cfg: copyConfig(j.config)})
+	return nil
+}
+
+// RequestCheckpoint asks all tasks of the job to checkpoint at the start of
+// epoch, as the controller does on etcd.
+func (m *MemCoordinator) RequestCheckpoint(name string, epoch uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	j.checkpointAt, j.checkpointSet = epoch, true
+	m.emitLocked(&memChange{kind: memKindCheckpoint, job: name, epoch: epoch})
+	return nil
+}
+
+// Events returns the audit log of the job, in the order events have been
+// recorded.
+func (m *MemCoordinator) Events(name string) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return nil
+	}
+	return append([]Event(nil), j.events...)
+}
+
+func (m *MemCoordinator) OccupyTask(name string, taskID uint64, addr string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return false
+	}
+	t := j.task(taskID)
+	if t.healthy {
+		return false
+	}
+	delete(j.free, taskID)
+	t.expire = time.Now().Add(memTaskTTL)
+	m.setHealthyLocked(name, taskID, t, true)
+	m.setAddressLocked(name, taskID, t, addr)
+	return true
+}
+
+func (m *MemCoordinator) ReclaimTask(name string, addr string) (uint64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return 0, false
+	}
+	for id, t := range j.tasks {
+		if t.addr != addr {
+			continue
+		}
+		// It might have been reported free meanwhile.
+		delete(j.free, id)
+		t.expire = time.Now().Add(memTaskTTL)
+		m.setHealthyLocked(name, id, t, true)
+		return id, true
+	}
+	return 0, false
+}
+
+func (m *MemCoordinator) ReleaseTask(name string, taskID uint64, addr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	// The task is freed before it turns unhealthy, so that failure
+	// detectors don't count it as failed.
+	j.free[taskID] = true
+	m.emitLocked(nil)
+	m.unregisterLocked(name, taskID, j.task(taskID), addr)
+	return nil
+}
+
+func (m *MemCoordinator) MarkTaskExited(name string, taskID uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	j.task(taskID).exited = true
+	m.emitLocked(nil)
+	return nil
+}
+
+func (m *MemCoordinator) ExitTask(name string, taskID uint64, addr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	m.unregisterLocked(name, taskID, j.task(taskID), addr)
+	return nil
+}
+
+func (m *MemCoordinator) FailTask(name string, taskID uint64, addr, actor, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	t := j.task(taskID)
+	// The node mustn't reclaim the task without it being counted as failed.
+	if t.addr == addr {
+		m.setAddressLocked(name, taskID, t, "")
+	}
+	m.setHealthyLocked(name, taskID, t, false)
+	m.reportFailureLocked(name, j, taskID, actor, reason)
+	return nil
+}
+
+func (m *MemCoordinator) FreeTasks(name string) ([]uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return nil, errMemNoJob
+	}
+	return j.freeTasks(), nil
+}
+
+func (m *MemCoordinator) WaitFreeTask(name string, logger meritop.Logger, stop chan bool) (uint64, error) {
+	timeout := time.After(memWaitFreeTaskTimeout)
+	for {
+		m.mu.Lock()
+		j, ok := m.jobs[name]
+		if !ok {
+			m.mu.Unlock()
+			return 0, errMemNoJob
+		}
+		free := j.freeTasks()
+		changed := m.changed
+		m.mu.Unlock()
+		if len(free) > 0 {
+			ri := rand.Intn(len(free))
+			logger.Infof("got free task %v, randomly choose %d to try...", free, ri)
+			return free[ri], nil
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return 0, ErrWaitFreeTaskTimeout
+		case <-stop:
+			return 0, ErrWaitFreeTaskStopped
+		case <-m.stop:
+			return 0, errMemClosed
+		}
+	}
+}
+
+func (m *MemCoordinator) Address(name string, taskID uint64) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return "", errMemNoJob
+	}
+	t, ok := j.tasks[taskID]
+	if !ok || t.addr == "" {
+		return "", errMemNoTask
+	}
+	return t.addr, nil
+}
+
+func (m *MemCoordinator) Heartbeat(name string, taskID uint64, addr string, interval time.Duration, stop chan bool) error {
+	return heartbeat(interval, stop, func(ttl uint64) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		j, ok := m.jobs[name]
+		if !ok {
+			return errMemNoJob
+		}
+		// Only while the task is still registered at addr. Otherwise
+		// another node has taken it over.
+		t := j.task(taskID)
+		if t.addr != addr {
+			return ErrTaskFenced
+		}
+		t.expire = time.Now().Add(time.Duration(ttl) * time.Second)
+		m.setHealthyLocked(name, taskID, t, true)
+		return nil
+	})
+}
+
+func (m *MemCoordinator) WatchHealth(name string, stop chan bool, handler func(taskID uint64, healthy bool)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchLocked(stop, nil, func(c *memChange) bool {
+		return c.kind == memKindHealth && c.job == name
+	}, func(c *memChange, _ <-chan struct{}) {
+		handler(c.taskID, c.healthy)
+	})
+}
+
+func (m *MemCoordinator) DetectFailure(name, actor string, stop chan bool, logger meritop.Logger) error {
+	m.mu.Lock()
+	if _, ok := m.jobs[name]; !ok {
+		m.mu.Unlock()
+		return errMemNoJob
+	}
+	done := m.watchLocked(stop, nil, func(c *memChange) bool {
+		return c.kind == memKindHealth && c.job == name && !c.healthy
+	}, func(c *memChange, _ <-chan struct{}) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		j := m.jobs[name]
+		t := j.task(c.taskID)
+		// Tasks occupied again, exited by themselves, or the job has been
+		// shrunk off haven't failed.
+		if t.healthy || t.exited || c.taskID >= j.numOfTasks {
+			return
+		}
+		m.reportFailureLocked(name, j, c.taskID, actor, "heartbeat expired")
+	})
+	m.mu.Unlock()
+	<-done
+	return nil
+}
+
+func (m *MemCoordinator) Lease(name string) (time.Duration, bool, error) {
+	if _, err := m.job(name); err != nil {
+		return 0, false, err
+	}
+	return 0, false, nil
+}
+
+func (m *MemCoordinator) KeepLease(name string, ttl time.Duration, stop chan bool) error {
+	return ErrLeaseExpired
+}
+
+func (m *MemCoordinator) NextGeneration(name string, taskID uint64) (Generation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return Generation{}, errMemNoJob
+	}
+	t := j.task(taskID)
+	// It's taken at the revision of the change emitted.
+	t.gen = Generation{Value: t.gen.Value + 1, Index: m.rev + 1}
+	m.emitLocked(&memChange{kind: memKindTask, job: name, task: TaskChange{
+		Kind:       TaskChangeGeneration,
+		TaskID:     taskID,
+		Generation: t.gen,
+	}})
+	return t.gen, nil
+}
+
+func (m *MemCoordinator) Generation(name string, taskID uint64) (Generation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return Generation{}, errMemNoJob
+	}
+	return j.task(taskID).gen, nil
+}
+
+func (m *MemCoordinator) SetVersion(name string, taskID uint64, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	j.task(taskID).version = version
+	m.emitLocked(&memChange{kind: memKindTask, job: name, task: TaskChange{
+		Kind:    TaskChangeVersion,
+		TaskID:  taskID,
+		Version: version,
+	}})
+	return nil
+}
+
+func (m *MemCoordinator) Version(name string, taskID uint64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return 0, errMemNoJob
+	}
+	return j.task(taskID).version, nil
+}
+
+func (m *MemCoordinator) WatchTasks(name string, stop chan bool, handler func(change TaskChange)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchLocked(stop, nil, func(c *memChange) bool {
+		return c.kind == memKindTask && c.job == name
+	}, func(c *memChange, _ <-chan struct{}) {
+		handler(c.task)
+	})
+}
+
+func (m *MemCoordinator) Epoch(name string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return 0, errMemNoJob
+	}
+	return j.epoch, nil
+}
+
+func (m *MemCoordinator) CASEpoch(name string, prev, next uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	if j.epoch != prev {
+		return errMemEpochConflict
+	}
+	m.setEpochLocked(name, j, next)
+	return nil
+}
+
+func (m *MemCoordinator) WatchEpoch(name string, epochC chan uint64, stop chan bool) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return 0, errMemNoJob
+	}
+	m.watchLocked(stop, nil, func(c *memChange) bool {
+		return c.kind == memKindEpoch && c.job == name
+	}, func(c *memChange, _ <-chan struct{}) {
+		epochC <- c.epoch
+	})
+	return j.epoch, nil
+}
+
+func (m *MemCoordinator) ControllerPaced(name string) (bool, error) {
+	if _, err := m.job(name); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (m *MemCoordinator) MarkEpochAdvance(name string, epoch uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	j.barrier(epoch).advance = true
+	m.emitLocked(nil)
+	return nil
+}
+
+func (m *MemCoordinator) MarkEpochDone(name string, epoch, taskID uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	j.barrier(epoch).done[taskID] = true
+	m.emitLocked(nil)
+	return nil
+}
+
+func (m *MemCoordinator) TryPassBarrier(name string, epoch uint64, quorum int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return false, errMemNoJob
+	}
+	b, ok := j.barriers[epoch]
+	if !ok {
+		// Cleaned up after passing.
+		return true, nil
+	}
+	if !b.advance || len(b.done) < quorum {
+		return false, nil
+	}
+	if j.epoch != epoch {
+		// Someone else got it through.
+		return true, nil
+	}
+	m.setEpochLocked(name, j, epoch+1)
+	j.record(Event{Kind: EventEpoch, Actor: "barrier", Epoch: epoch + 1, Detail: "advanced"})
+	delete(j.barriers, epoch)
+	return true, nil
+}
+
+func (m *MemCoordinator) EnterBarrier(name string, epoch uint64, barrier string, taskID uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	key := phaseKey(epoch, barrier)
+	if j.phases[key] == nil {
+		j.phases[key] = make(map[uint64]bool)
+	}
+	j.phases[key][taskID] = true
+	m.emitLocked(nil)
+	return nil
+}
+
+func (m *MemCoordinator) WaitBarrier(name string, epoch uint64, barrier string, n int, stop chan bool) (bool, error) {
+	var err error
+	passed := m.wait(stop, func() bool {
+		j, ok := m.jobs[name]
+		if !ok {
+			err = errMemNoJob
+			return true
+		}
+		return len(j.phases[phaseKey(epoch, barrier)]) >= n
+	})
+	if err != nil {
+		return false, err
+	}
+	if !passed {
+		return false, m.closedErr()
+	}
+	return true, nil
+}
+
+func (m *MemCoordinator) WatchCheckpointRequest(name string, stop chan bool, handler func(epoch uint64)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	var initial []*memChange
+	if j.checkpointSet {
+		initial = append(initial, &memChange{kind: memKindCheckpoint, job: name, epoch: j.checkpointAt})
+	}
+	m.watchLocked(stop, initial, func(c *memChange) bool {
+		return c.kind == memKindCheckpoint && c.job == name
+	}, func(c *memChange, _ <-chan struct{}) {
+		handler(c.epoch)
+	})
+	return nil
+}
+
+func (m *MemCoordinator) MarkCheckpointDone(name string, epoch, taskID uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	if j.checkpoints[epoch] == nil {
+		j.checkpoints[epoch] = make(map[uint64]bool)
+	}
+	j.checkpoints[epoch][taskID] = true
+	m.emitLocked(nil)
+	return nil
+}
+
+func (m *MemCoordinator) SetMeta(key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := &memChange{kind: memKindMeta, key: key, value: value}
+	m.emitLocked(c)
+	m.metas[key] = memMeta{value: value, rev: c.rev}
+	return nil
+}
+
+func (m *MemCoordinator) Meta(key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	meta, ok := m.metas[key]
+	return meta.value, ok, nil
+}
+
+func (m *MemCoordinator) WatchMeta(key string, stop chan bool, handler func(value string, rev uint64)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var initial []*memChange
+	if meta, ok := m.metas[key]; ok && meta.value != "" {
+		initial = append(initial, &memChange{kind: memKindMeta, key: key, value: meta.value, rev: meta.rev})
+	}
+	m.watchLocked(stop, initial, func(c *memChange) bool {
+		return c.kind == memKindMeta && c.key == key
+	}, func(c *memChange, _ <-chan struct{}) {
+		handler(c.value, c.rev)
+	})
+	return nil
+}
+
+func (m *MemCoordinator) NumOfTasks(name string) (uint64, bool, error) {
+	j, err := m.job(name)
+	if err != nil {
+		return 0, false, err
+	}
+	return j.numOfTasks, true, nil
+}
+
+func (m *MemCoordinator) AuthToken(name string) (string, error) {
+	_, err := m.job(name)
+	return "", err
+}
+
+func (m *MemCoordinator) Config(name string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return nil, errMemNoJob
+	}
+	return copyConfig(j.config), nil
+}
+
+func (m *MemCoordinator) WatchConfig(name string, stop chan bool, handler func(cfg map[string]string)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	initial := []*memChange{{kind: memKindConfig, job: name, cfg: copyConfig(j.config)}}
+	m.watchLocked(stop, initial, func(c *memChange) bool {
+		return c.kind == memKindConfig && c.job == name
+	}, func(c *memChange, _ <-chan struct{}) {
+		handler(copyConfig(c.cfg))
+	})
+	return nil
+}
+
+func (m *MemCoordinator) MarkJobDone(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	j.done = true
+	m.emitLocked(nil)
+	return nil
+}
+
+func (m *MemCoordinator) RecordEvent(name string, e Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	j.record(e)
+	return nil
+}
+
+func (m *MemCoordinator) SetProgress(name string, epoch, taskID uint64, p meritop.Progress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	if j.progress[epoch] == nil {
+		j.progress[epoch] = make(map[uint64]meritop.Progress)
+	}
+	j.progress[epoch][taskID] = p
+	return nil
+}
+
+func (m *MemCoordinator) Restarts(name string, taskID uint64) (Restarts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return Restarts{}, errMemNoJob
+	}
+	return j.task(taskID).restarts, nil
+}
+
+func (m *MemCoordinator) PoisonTask(name string, taskID uint64, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	j.poisoned[taskID] = reason
+	delete(j.free, taskID)
+	m.emitLocked(nil)
+	return nil
+}
+
+func (m *MemCoordinator) OccupyReplica(name string, taskID, replicaID uint64, addr string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return false
+	}
+	t := j.task(taskID)
+	if r, ok := t.replicas[replicaID]; ok && r.expire.After(time.Now()) {
+		return false
+	}
+	t.replicas[replicaID] = memReplica{addr: addr, expire: time.Now().Add(memTaskTTL)}
+	return true
+}
+
+func (m *MemCoordinator) ReleaseReplica(name string, taskID, replicaID uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	delete(j.task(taskID).replicas, replicaID)
+	return nil
+}
+
+func (m *MemCoordinator) HeartbeatReplica(name string, taskID, replicaID uint64, addr string, interval time.Duration, stop chan bool) error {
+	return heartbeat(interval, stop, func(ttl uint64) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		j, ok := m.jobs[name]
+		if !ok {
+			return errMemNoJob
+		}
+		expire := time.Now().Add(time.Duration(ttl) * time.Second)
+		j.task(taskID).replicas[replicaID] = memReplica{addr: addr, expire: expire}
+		return nil
+	})
+}
+
+func (m *MemCoordinator) ShipUpdateLog(name string, taskID uint64, ul meritop.UpdateLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	t := j.task(taskID)
+	i := sort.Search(len(t.logs), func(i int) bool { return t.logs[i].ID >= ul.ID })
+	if i < len(t.logs) && t.logs[i].ID == ul.ID {
+		return errMemLogTaken
+	}
+	ul.Data = append([]byte(nil), ul.Data...)
+	t.logs = append(t.logs, meritop.UpdateLog{})
+	copy(t.logs[i+1:], t.logs[i:])
+	t.logs[i] = ul
+	m.emitLocked(nil)
+	return nil
+}
+
+func (m *MemCoordinator) LastUpdateLogID(name string, taskID uint64) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return 0, errMemNoJob
+	}
+	t := j.task(taskID)
+	if len(t.logs) == 0 {
+		return 0, nil
+	}
+	return t.logs[len(t.logs)-1].ID, nil
+}
+
+func (m *MemCoordinator) WatchUpdateLog(name string, taskID, from uint64, logs chan<- meritop.UpdateLog, stop chan bool) {
+	go func() {
+		defer close(logs)
+		last := from
+		for {
+			var next []meritop.UpdateLog
+			ok := m.wait(stop, func() bool {
+				j, ok := m.jobs[name]
+				if !ok {
+					return false
+				}
+				for _, ul := range j.task(taskID).logs {
+					if ul.ID > last {
+						next = append(next, ul)
+					}
+				}
+				return len(next) > 0
+			})
+			if !ok {
+				return
+			}
+			for _, ul := range next {
+				select {
+				case logs <- ul:
+				case <-stop:
+					return
+				case <-m.stop:
+					return
+				}
+				last = ul.ID
+			}
+		}
+	}()
+}
+
+func (m *MemCoordinator) WaitTaskFailure(name string, taskID uint64) error {
+	var err error
+	failed := m.wait(nil, func() bool {
+		j, ok := m.jobs[name]
+		if !ok {
+			err = errMemNoJob
+			return true
+		}
+		return !j.task(taskID).healthy
+	})
+	if err != nil {
+		return err
+	}
+	if !failed {
+		return errMemClosed
+	}
+	return nil
+}
+
+func (m *MemCoordinator) TaskData(name string, taskID uint64, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return nil, false, errMemNoJob
+	}
+	value, ok := j.task(taskID).data[key]
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]byte{}, value...), true, nil
+}
+
+func (m *MemCoordinator) SetTaskData(name string, taskID uint64, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	m.setTaskDataLocked(name, j.task(taskID), taskID, key, value)
+	return nil
+}
+
+func (m *MemCoordinator) CASTaskData(name string, taskID uint64, key string, prev, value []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return false, errMemNoJob
+	}
+	t := j.task(taskID)
+	cur, ok := t.data[key]
+	if prev == nil && ok || prev != nil && (!ok || !bytes.Equal(cur, prev)) {
+		return false, nil
+	}
+	m.setTaskDataLocked(name, t, taskID, key, value)
+	return true, nil
+}
+
+func (m *MemCoordinator) DeleteTaskData(name string, taskID uint64, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	t := j.task(taskID)
+	if _, ok := t.data[key]; !ok {
+		return nil
+	}
+	delete(t.data, key)
+	m.emitLocked(&memChange{kind: memKindData, job: name, taskID: taskID, key: key})
+	return nil
+}
+
+func (m *MemCoordinator) WatchTaskData(name string, taskID uint64, key string, values chan<- []byte, stop chan bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	done := m.watchLocked(stop, nil, func(c *memChange) bool {
+		return c.kind == memKindData && c.job == name && c.taskID == taskID && c.key == key
+	}, func(c *memChange, quit <-chan struct{}) {
+		var value []byte
+		if c.data != nil {
+			value = append([]byte{}, c.data...)
+		}
+		select {
+		case values <- value:
+		case <-quit:
+		}
+	})
+	go func() {
+		<-done
+		close(values)
+	}()
+}
+
+func (m *MemCoordinator) SaveTaskState(name string, taskID, epoch uint64, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return errMemNoJob
+	}
+	j.task(taskID).state = &taskState{Epoch: epoch, Data: append([]byte(nil), data...)}
+	m.emitLocked(nil)
+	return nil
+}
+
+func (m *MemCoordinator) LoadTaskState(name string, taskID uint64) (uint64, []byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return 0, nil, false, errMemNoJob
+	}
+	s := j.task(taskID).state
+	if s == nil {
+		return 0, nil, false, nil
+	}
+	return s.Epoch, append([]byte(nil), s.Data...), true, nil
+}
+
+func (m *MemCoordinator) job(name string) (*memJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[name]
+	if !ok {
+		return nil, errMemNoJob
+	}
+	return j, nil
+}
+
+func (m *MemCoordinator) setHealthyLocked(name string, taskID uint64, t *memTask, healthy bool) {
+	if t.healthy == healthy {
+		return
+	}
+	t.healthy = healthy
+	m.emitLocked(&memChange{kind: memKindHealth, job: name, taskID: taskID, healthy: healthy})
+}
+
+func (m *MemCoordinator) setAddressLocked(name string, taskID uint64, t *memTask, addr string) {
+	t.addr = addr
+	m.emitLocked(&memChange{kind: memKindTask, job: name, task: TaskChange{
+		Kind:    TaskChangeAddress,
+		TaskID:  taskID,
+		Address: addr,
+		Deleted: addr == "",
+	}})
+}
+
+// unregisterLocked drops the registration of the task at addr. Either might
+// have expired already.
+func (m *MemCoordinator) unregisterLocked(name string, taskID uint64, t *memTask, addr string) {
+	if t.addr == addr {
+		m.setAddressLocked(name, taskID, t, "")
+	}
+	m.setHealthyLocked(name, taskID, t, false)
+}
+
+// reportFailureLocked frees the failed task unless it's free already, and
+// counts and records the failure.
+func (m *MemCoordinator) reportFailureLocked(name string, j *memJob, taskID uint64, actor, reason string) {
+	if j.free[taskID] {
+		return
+	}
+	j.free[taskID] = true
+	r := &j.task(taskID).restarts
+	now := time.Now()
+	if now.Sub(r.Last) > RestartWindow {
+		r.Count = 0
+	}
+	r.Count++
+	r.Last = now
+	j.record(Event{Kind: EventFailure, Actor: actor, TaskID: taskID, Detail: reason})
+	m.emitLocked(nil)
+}
+
+func (m *MemCoordinator) setEpochLocked(name string, j *memJob, epoch uint64) {
+	j.epoch = epoch
+	m.emitLocked(&memChange{kind: memKindEpoch, job: name, epoch: epoch})
+}
+
+func (m *MemCoordinator) setTaskDataLocked(name string, t *memTask, taskID uint64, key string, value []byte) {
+	// Values set empty aren't taken as unset.
+	value = append([]byte{}, value...)
+	t.data[key] = value
+	m.emitLocked(&memChange{kind: memKindData, job: name, taskID: taskID, key: key, data: value})
+}
+
+// emitLocked counts a change, and passes c, if any, to watchers matching it.
+// It's called with mu held.
+func (m *MemCoordinator) emitLocked(c *memChange) {
+	m.rev++
+	close(m.changed)
+	m.changed = make(chan struct{})
+	if c == nil {
+		return
+	}
+	c.rev = m.rev
+	for w := range m.watchers {
+		if w.match(c) {
+			w.push(c)
+		}
+	}
+}
+
+// watchLocked passes initial changes, and changes emitted later matching, to
+// handle in order until stop. It's called with mu held, so that no change is
+// missed after what's read along. The returned channel is closed once the
+// watch is over. handle is to give up on what blocks once quit is closed.
+func (m *MemCoordinator) watchLocked(stop chan bool, initial []*memChange, match func(c *memChange) bool, handle func(c *memChange, quit <-chan struct{})) <-chan struct{} {
+	w := &memWatcher{match: match, changes: initial, ready: make(chan struct{}, 1)}
+	if len(initial) > 0 {
+		w.ready <- struct{}{}
+	}
+	m.watchers[w] = true
+	quit := m.quitOn(stop)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			m.mu.Lock()
+			delete(m.watchers, w)
+			m.mu.Unlock()
+		}()
+		for {
+			select {
+			case <-w.ready:
+			case <-quit:
+				return
+			}
+			for _, c := range w.take() {
+				handle(c, quit)
+			}
+		}
+	}()
+	return done
+}
+
+// wait blocks until done, called with mu held, returns true. It returns false
+// once stop is closed, or sent a value, or the coordinator is closed.
+func (m *MemCoordinator) wait(stop chan bool, done func() bool) bool {
+	for {
+		m.mu.Lock()
+		ok := done()
+		changed := m.changed
+		m.mu.Unlock()
+		if ok {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-stop:
+			return false
+		case <-m.stop:
+			return false
+		}
+	}
+}
+
+// quitOn returns a channel closed once stop is closed, or sent a value, or
+// the coordinator is closed.
+func (m *MemCoordinator) quitOn(stop chan bool) <-chan struct{} {
+	quit := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+		case <-m.stop:
+		}
+		close(quit)
+	}()
+	return quit
+}
+
+// closedErr returns errMemClosed once the coordinator is closed.
+func (m *MemCoordinator) closedErr() error {
+	select {
+	case <-m.stop:
+		return errMemClosed
+	default:
+		return nil
+	}
+}
+
+// expireLoop takes tasks whose heartbeat expired for unhealthy, and drops
+// their registrations, as etcd expires their keys.
+func (m *MemCoordinator) expireLoop() {
+	ticker := time.NewTicker(memExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.stop:
+			return
+		}
+		m.mu.Lock()
+		now := time.Now()
+		for name, j := range m.jobs {
+			for id, t := range j.tasks {
+				if t.healthy && !t.expire.After(now) {
+					m.unregisterLocked(name, id, t, t.addr)
+				}
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+func (w *memWatcher) push(c *memChange) {
+	w.mu.Lock()
+	w.changes = append(w.changes, c)
+	w.mu.Unlock()
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memWatcher) take() []*memChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	changes := w.changes
+	w.changes = nil
+	return changes
+}
+
+// task returns the task, kept once it's first got at.
+func (j *memJob) task(taskID uint64) *memTask {
+	t, ok := j.tasks[taskID]
+	if !ok {
+		t = &memTask{
+			replicas: make(map[uint64]memReplica),
+			data:     make(map[string][]byte),
+		}
+		j.tasks[taskID] = t
+	}
+	return t
+}
+
+func (j *memJob) barrier(epoch uint64) *memBarrier {
+	b, ok := j.barriers[epoch]
+	if !ok {
+		b = &memBarrier{done: make(map[uint64]bool)}
+		j.barriers[epoch] = b
+	}
+	return b
+}
+
+func (j *memJob) freeTasks() []uint64 {
+	free := make([]uint64, 0, len(j.free))
+	for id := range j.free {
+		free = append(free, id)
+	}
+	sort.Sort(uint64s(free))
+	return free
+}
+
+// record appends the event to the audit log, timestamped now if it isn't.
+func (j *memJob) record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	j.events = append(j.events, e)
+}
+
+func phaseKey(epoch uint64, barrier string) string {
+	return fmt.Sprintf("%d/%s", epoch, barrier)
+}
+
+func copyConfig(cfg map[string]string) map[string]string {
+	c := make(map[string]string, len(cfg))
+	for key, value := range cfg {
+		c[key] = value
+	}
+	return c
+}
+
+type uint64s []uint64
+
+func (s uint64s) Len() int           { return len(s) }
+func (s uint64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
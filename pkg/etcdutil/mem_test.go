@@ -0,0 +1,191 @@
+package etcdutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// errorCode returns the etcd error code of err, or 0 if it's nil.
+func errorCode(t *testing.T, err error) int {
+	if err == nil {
+		return 0
+	}
+	e, ok := err.(*etcd.EtcdError)
+	if !ok {
+		t.Fatalf("error %v isn't an etcd error", err)
+	}
+	return e.ErrorCode
+}
+
+func TestMemClientCompareAndSwap(t *testing.T) {
+	m := NewMemClient()
+	defer m.Close()
+	if _, err := m.CreateDir("/cas/dir", 0); err != nil {
+		t.Fatalf("CreateDir failed: %v", err)
+	}
+
+	// prevIndex is compared against the index the key is set at, plus
+	// indexOffset, or not at all if indexOffset is negative.
+	tests := []struct {
+		key         string
+		prevValue   string
+		indexOffset int
+		code        int
+	}{
+		{"/cas/key", "v1", -1, 0},
+		{"/cas/key", "v2", -1, ecodeTestFailed},
+		{"/cas/key", "", 0, 0},
+		{"/cas/key", "", 100, ecodeTestFailed},
+		{"/cas/key", "v1", 100, ecodeTestFailed},
+		{"/cas/missing", "v1", -1, ecodeKeyNotFound},
+		{"/cas/dir", "", -1, ecodeNotFile},
+	}
+	for i, tt := range tests {
+		// Set the key back, so that each case compares against the same.
+		resp, err := m.Set("/cas/key", "v1", 0)
+		if err != nil {
+			t.Fatalf("#%d: Set failed: %v", i, err)
+		}
+		var prevIndex uint64
+		if tt.indexOffset >= 0 {
+			prevIndex = resp.Node.ModifiedIndex + uint64(tt.indexOffset)
+		}
+		_, err = m.CompareAndSwap(tt.key, "swapped", 0, tt.prevValue, prevIndex)
+		if code := errorCode(t, err); code != tt.code {
+			t.Errorf("#%d: CompareAndSwap error code = %d, want %d", i, code, tt.code)
+		}
+		_, err = m.CompareAndDelete(tt.key, tt.prevValue, prevIndex)
+		if tt.code == 0 {
+			// The swap changed the value and index compared against.
+			if code := errorCode(t, err); code != ecodeTestFailed {
+				t.Errorf("#%d: CompareAndDelete after swap error code = %d, want %d", i, code, ecodeTestFailed)
+			}
+			continue
+		}
+		if code := errorCode(t, err); code != tt.code {
+			t.Errorf("#%d: CompareAndDelete error code = %d, want %d", i, code, tt.code)
+		}
+	}
+}
+
+func TestMemClientWatchHistory(t *testing.T) {
+	m := NewMemClient()
+	defer m.Close()
+	var indexes []uint64
+	for _, change := range []func() (*etcd.Response, error){
+		func() (*etcd.Response, error) { return m.Set("/w/dir/a", "1", 0) },
+		func() (*etcd.Response, error) { return m.Set("/w/dir/b", "2", 0) },
+		func() (*etcd.Response, error) { return m.Delete("/w/dir/a", false) },
+		func() (*etcd.Response, error) { return m.Set("/w/other", "3", 0) },
+		func() (*etcd.Response, error) { return m.Delete("/w/dir", true) },
+	} {
+		resp, err := change()
+		if err != nil {
+			t.Fatalf("change failed: %v", err)
+		}
+		indexes = append(indexes, resp.Node.ModifiedIndex)
+	}
+
+	tests := []struct {
+		prefix    string
+		waitIndex uint64
+		recursive bool
+		action    string
+		key       string
+	}{
+		{"/w/dir", indexes[0], true, "set", "/w/dir/a"},
+		{"/w/dir", indexes[1], true, "set", "/w/dir/b"},
+		{"/w/dir", indexes[2], true, "delete", "/w/dir/a"},
+		{"/w/dir", indexes[3], true, "delete", "/w/dir"},
+		// Watches on a key see only changes of it, and deletes of
+		// directories it's under.
+		{"/w/dir/b", indexes[0], false, "set", "/w/dir/b"},
+		{"/w/dir/b", indexes[2], false, "delete", "/w/dir"},
+		{"/w/dir", indexes[0], false, "delete", "/w/dir"},
+		{"/w/other", indexes[0], false, "set", "/w/other"},
+	}
+	for i, tt := range tests {
+		resp, err := m.Watch(tt.prefix, tt.waitIndex, tt.recursive, nil, nil)
+		if err != nil {
+			t.Fatalf("#%d: Watch failed: %v", i, err)
+		}
+		if resp.Action != tt.action || resp.Node.Key != tt.key {
+			t.Errorf("#%d: change = %s of %s, want %s of %s", i, resp.Action, resp.Node.Key, tt.action, tt.key)
+		}
+	}
+
+	// Changes older than the history kept are cleared, as in etcd.
+	for i := 0; i < memHistory; i++ {
+		if _, err := m.Set("/w/other", "4", 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	_, err := m.Watch("/w", indexes[0], true, nil, nil)
+	if code := errorCode(t, err); code != ecodeEventIndexCleared {
+		t.Errorf("Watch of cleared changes error code = %d, want %d", code, ecodeEventIndexCleared)
+	}
+}
+
+func TestMemClientTTL(t *testing.T) {
+	m := NewMemClient()
+	defer m.Close()
+	if _, err := m.Set("/ttl/expiring", "v", 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := m.Set("/ttl/refreshed", "v", 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := m.Set("/ttl/kept", "v", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := m.CreateDir("/ttl/dir", 1); err != nil {
+		t.Fatalf("CreateDir failed: %v", err)
+	}
+	resp, err := m.Set("/ttl/dir/child", "v", 0)
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	// Refreshed before it expires, the key is kept as long again.
+	time.Sleep(500 * time.Millisecond)
+	if _, err := m.Set("/ttl/refreshed", "v", 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	receiver := make(chan *etcd.Response, 10)
+	stop := make(chan bool)
+	defer close(stop)
+	go m.Watch("/ttl", resp.Node.ModifiedIndex+1, true, receiver, stop)
+	expired := make(map[string]bool)
+	for len(expired) < 2 {
+		select {
+		case resp := <-receiver:
+			if resp.Action == "expire" {
+				expired[resp.Node.Key] = true
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("keys expired = %v, want /ttl/expiring and /ttl/dir", expired)
+		}
+	}
+
+	tests := []struct {
+		key   string
+		found bool
+	}{
+		{"/ttl/expiring", false},
+		{"/ttl/dir", false},
+		{"/ttl/dir/child", false},
+		{"/ttl/kept", true},
+		{"/ttl/refreshed", true},
+	}
+	for i, tt := range tests {
+		_, err := m.Get(tt.key, false, false)
+		if found := err == nil; found != tt.found {
+			t.Errorf("#%d: %s found = %v, want %v (%v)", i, tt.key, found, tt.found, err)
+		}
+		if expired[tt.key] == tt.found && tt.key != "/ttl/dir/child" {
+			t.Errorf("#%d: %s expired = %v, want %v", i, tt.key, expired[tt.key], !tt.found)
+		}
+	}
+}
@@ -2,17 +2,26 @@ package etcdutil
 
 import "github.com/coreos/go-etcd/etcd"
 
-func WatchMeta(c *etcd.Client, taskID uint64, path string, stop chan bool, responseHandler func(*etcd.Response, uint64)) error {
+// WatchMeta passes the meta at path, and any later change of it, to
+// responseHandler. The path might not exist yet, e.g. metas of link types,
+// which are only created when first flagged.
+func WatchMeta(c Client, taskID uint64, path string, stop chan bool, responseHandler func(*etcd.Response, uint64)) error {
+	var index uint64
 	resp, err := c.Get(path, false, false)
-	if err != nil {
+	switch e, ok := err.(*etcd.EtcdError); {
+	case err == nil:
+		// Get previous meta. We need to handle it.
+		if resp.Node.Value != "" {
+			responseHandler(resp, taskID)
+		}
+		index = resp.EtcdIndex + 1
+	case ok && e.ErrorCode == ecodeKeyNotFound:
+		index = e.Index + 1
+	default:
 		return err
 	}
-	// Get previous meta. We need to handle it.
-	if resp.Node.Value != "" {
-		responseHandler(resp, taskID)
-	}
 	receiver := make(chan *etcd.Response, 1)
-	go c.Watch(path, resp.EtcdIndex+1, false, receiver, stop)
+	go Watch(c, path, index, false, receiver, stop)
 	go func(receiver chan *etcd.Response) {
 		for resp := range receiver {
 			responseHandler(resp, taskID)
@@ -0,0 +1,66 @@
+package etcdutil
+
+import (
+	"path"
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Counters of a job, kept for operators to alert on unhealthy jobs.
+const (
+	// CounterFailures counts tasks reported as failed.
+	CounterFailures = "failures"
+	// CounterReassignments counts tasks taken by a node after another one had
+	// them, e.g. a standby taking a failed task over.
+	CounterReassignments = "reassignments"
+	// CounterEpochs counts epochs completed, i.e. the job moving from an epoch
+	// to the next one.
+	CounterEpochs = "epochs"
+)
+
+// IncCounter adds one to the counter of the job. Counters are best effort:
+// callers counting what they have done don't fail for a counter they couldn't
+// bump.
+func IncCounter(client Client, name, counter string) error {
+	key := CounterPath(name, counter)
+	for {
+		resp, err := client.Get(key, false, false)
+		switch {
+		case isKeyNotFound(err):
+			_, err = client.Create(key, "1", 0)
+		case err != nil:
+			return err
+		default:
+			v, perr := strconv.ParseUint(resp.Node.Value, 10, 64)
+			if perr != nil {
+				return perr
+			}
+			_, err = client.CompareAndSwap(key, strconv.FormatUint(v+1, 10), 0, "", resp.Node.ModifiedIndex)
+		}
+		if e, ok := err.(*etcd.EtcdError); ok && (e.ErrorCode == ecodeNodeExist || e.ErrorCode == ecodeTestFailed) {
+			// Somebody else has bumped it meanwhile.
+			continue
+		}
+		return err
+	}
+}
+
+// GetCounters returns the counters of the job, leaving out those which have
+// never been bumped.
+func GetCounters(client Client, name string) (map[string]uint64, error) {
+	counters := make(map[string]uint64)
+	resp, err := client.Get(MetricsPath(name), false, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return counters, nil
+		}
+		return nil, err
+	}
+	for _, n := range resp.Node.Nodes {
+		if v, err := strconv.ParseUint(n.Value, 10, 64); err == nil {
+			counters[path.Base(n.Key)] = v
+		}
+	}
+	return counters, nil
+}
@@ -0,0 +1,58 @@
+package etcdutil
+
+import (
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// GetNumOfTasks returns the number of tasks of the job. It returns false if
+// the job doesn't keep the number in etcd, e.g. set up by older controllers.
+func GetNumOfTasks(client Client, name string) (uint64, bool, error) {
+	resp, err := client.Get(NumOfTasksPath(name), false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	n, err := strconv.ParseUint(resp.Node.Value, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
+
+// SetNumOfTasks changes the number of tasks of the job. Running tasks pick it
+// up at the next epoch.
+func SetNumOfTasks(client Client, name string, n uint64) error {
+	_, err := client.Set(NumOfTasksPath(name), strconv.FormatUint(n, 10), 0)
+	return err
+}
+
+// AddTaskSlot sets task taskID up for a node to take, e.g. once the job grows.
+// Whatever a task of the same ID removed before left is cleared.
+func AddTaskSlot(client Client, name string, taskID uint64) error {
+	if _, err := client.Delete(TaskPath(name, taskID), true); err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	for _, key := range []string{ParentMetaPath(name, taskID), ChildMetaPath(name, taskID)} {
+		if _, err := client.Set(key, "", 0); err != nil {
+			return err
+		}
+	}
+	_, err := client.Set(FreeTaskPath(name, strconv.FormatUint(taskID, 10)), "", 0)
+	return err
+}
+
+// RemoveTaskSlot takes task taskID off free and poisoned tasks, e.g. once the
+// job shrinks, so that nobody takes it any more. A node running it exits by
+// itself at the next epoch.
+func RemoveTaskSlot(client Client, name string, taskID uint64) error {
+	for _, key := range []string{FreeTaskPath(name, strconv.FormatUint(taskID, 10)), PoisonedTaskPath(name, taskID)} {
+		if _, err := client.Delete(key, false); err != nil && !isKeyNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package etcdutil
+
+import (
+	"encoding/json"
+	"path"
+	"strconv"
+
+	"github.com/go-distributed/meritop"
+)
+
+// SetProgress keeps the progress the task reported in the epoch, replacing
+// what it reported before.
+func SetProgress(client Client, name string, epoch, taskID uint64, p meritop.Progress) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = client.Set(TaskProgressPath(name, epoch, taskID), string(b), 0)
+	return err
+}
+
+// ListProgress returns progress tasks reported, by epoch and task ID. Entries
+// which can't be decoded are skipped.
+func ListProgress(client Client, name string) (map[uint64]map[uint64]meritop.Progress, error) {
+	progress := make(map[uint64]map[uint64]meritop.Progress)
+	resp, err := client.Get(ProgressPath(name), false, true)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return progress, nil
+		}
+		return nil, err
+	}
+	for _, en := range resp.Node.Nodes {
+		epoch, err := strconv.ParseUint(path.Base(en.Key), 10, 64)
+		if err != nil {
+			continue
+		}
+		tasks := make(map[uint64]meritop.Progress)
+		for _, tn := range en.Nodes {
+			taskID, err := strconv.ParseUint(path.Base(tn.Key), 10, 64)
+			if err != nil {
+				continue
+			}
+			var p meritop.Progress
+			if json.Unmarshal([]byte(tn.Value), &p) == nil {
+				tasks[taskID] = p
+			}
+		}
+		progress[epoch] = tasks
+	}
+	return progress, nil
+}
@@ -0,0 +1,164 @@
+package etcdutil
+
+import (
+	"encoding/base64"
+	"log"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
+)
+
+// TryOccupyReplica registers a backup copy of the task at replicaID, which
+// should be greater than 0. It fails if there is one already.
+func TryOccupyReplica(client Client, name string, taskID, replicaID uint64, connection string) bool {
+	_, err := client.Create(TaskReplicaPath(name, taskID, replicaID), connection, 3)
+	return err == nil
+}
+
+// ReleaseReplica unregisters the backup copy of the task at replicaID.
+func ReleaseReplica(client Client, name string, taskID, replicaID uint64) error {
+	_, err := client.Delete(TaskReplicaPath(name, taskID, replicaID), false)
+	return err
+}
+
+// HeartbeatReplica keeps the backup copy registered until stop, like Heartbeat
+// does for tasks.
+func HeartbeatReplica(client Client, name string, taskID, replicaID uint64, connection string, interval time.Duration, stop chan bool) error {
+	return heartbeat(interval, stop, func(ttl uint64) error {
+		_, err := client.Set(TaskReplicaPath(name, taskID, replicaID), connection, ttl)
+		return err
+	})
+}
+
+// ShipUpdateLog appends an update log of the task to its durable log, passing
+// it to backup copies. It fails if the ID has been taken, e.g. by a primary
+// that has been replaced.
+func ShipUpdateLog(client Client, name string, taskID uint64, ul meritop.UpdateLog) error {
+	_, err := client.Create(UpdateLogEntryPath(name, taskID, ul.ID),
+		base64.StdEncoding.EncodeToString(ul.Data), 0)
+	return err
+}
+
+// LastUpdateLogID returns the ID of the last update log of the task, or 0 if
+// there is none.
+func LastUpdateLogID(client Client, name string, taskID uint64) (uint64, error) {
+	resp, err := client.Get(UpdateLogPath(name, taskID), true, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(resp.Node.Nodes) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseUint(path.Base(resp.Node.Nodes[len(resp.Node.Nodes)-1].Key), 10, 64)
+}
+
+// WatchUpdateLog passes update logs of the task with IDs greater than from to
+// logs in order of IDs until stop, and then closes logs. Logs kept already are
+// replayed first. If the watch falls behind, e.g. etcd has cleared the events
+// it needs, missed logs are replayed again.
+func WatchUpdateLog(client Client, name string, taskID, from uint64, logs chan<- meritop.UpdateLog, stop chan bool) {
+	go func() {
+		defer close(logs)
+		last := from
+		for {
+			index, err := replayUpdateLog(client, name, taskID, &last, logs)
+			if err != nil {
+				log.Printf("etcdutil: replaying update logs of task %d failed: %v", taskID, err)
+				return
+			}
+			for {
+				resp, err := client.Watch(UpdateLogPath(name, taskID), index, true, nil, stop)
+				if err == etcd.ErrWatchStoppedByUser {
+					return
+				}
+				if err != nil {
+					break
+				}
+				index = resp.Node.ModifiedIndex + 1
+				if resp.Action != "create" {
+					continue
+				}
+				ul, err := decodeUpdateLog(resp.Node)
+				if err != nil {
+					log.Printf("etcdutil: decoding update log %s failed: %v", resp.Node.Key, err)
+					continue
+				}
+				if ul.ID <= last {
+					continue
+				}
+				if ul.ID != last+1 {
+					// Missed some; replay from the log.
+					break
+				}
+				logs <- ul
+				last = ul.ID
+			}
+		}
+	}()
+}
+
+// replayUpdateLog passes logs kept with IDs greater than last, and returns
+// the index to watch later logs from.
+func replayUpdateLog(client Client, name string, taskID uint64, last *uint64, logs chan<- meritop.UpdateLog) (uint64, error) {
+	resp, err := client.Get(UpdateLogPath(name, taskID), true, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return e.Index + 1, nil
+		}
+		return 0, err
+	}
+	for _, n := range resp.Node.Nodes {
+		ul, err := decodeUpdateLog(n)
+		if err != nil {
+			return 0, err
+		}
+		if ul.ID <= *last {
+			continue
+		}
+		logs <- ul
+		*last = ul.ID
+	}
+	return resp.EtcdIndex + 1, nil
+}
+
+func decodeUpdateLog(n *etcd.Node) (meritop.UpdateLog, error) {
+	id, err := strconv.ParseUint(path.Base(n.Key), 10, 64)
+	if err != nil {
+		return meritop.UpdateLog{}, err
+	}
+	data, err := base64.StdEncoding.DecodeString(n.Value)
+	if err != nil {
+		return meritop.UpdateLog{}, err
+	}
+	return meritop.UpdateLog{ID: id, Data: data}, nil
+}
+
+// WaitTaskFailure blocks until the healthy key of the task expires or is
+// deleted. It returns at once if the task isn't healthy.
+func WaitTaskFailure(client Client, name string, taskID uint64) error {
+	key := TaskHealthyPath(name, taskID)
+	resp, err := client.Get(key, false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return nil
+		}
+		return err
+	}
+	index := resp.EtcdIndex + 1
+	for {
+		resp, err := client.Watch(key, index, false, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.Action == "expire" || resp.Action == "delete" {
+			return nil
+		}
+		index = resp.Node.ModifiedIndex + 1
+	}
+}
@@ -0,0 +1,137 @@
+package etcdutil
+
+import (
+	"encoding/json"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+const ecodeNodeExist = 105
+
+// RestartWindow is how long a task needs to run well for its failures before
+// to be forgotten.
+var RestartWindow = 10 * time.Minute
+
+// Restarts tells how many times a task failed recently, and when it last did.
+type Restarts struct {
+	Count int       `json:"count"`
+	Last  time.Time `json:"last"`
+}
+
+// GetRestarts returns failures of the task, none if it hasn't failed.
+func GetRestarts(client Client, name string, taskID uint64) (Restarts, error) {
+	var r Restarts
+	resp, err := client.Get(TaskRestartsPath(name, taskID), false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return r, nil
+		}
+		return r, err
+	}
+	err = json.Unmarshal([]byte(resp.Node.Value), &r)
+	return r, err
+}
+
+// RecordRestart counts one more failure of the task. It starts over if the
+// last one is beyond RestartWindow.
+func RecordRestart(client Client, name string, taskID uint64) error {
+	key := TaskRestartsPath(name, taskID)
+	for {
+		var r Restarts
+		var index uint64
+		resp, err := client.Get(key, false, false)
+		switch e, ok := err.(*etcd.EtcdError); {
+		case err == nil:
+			index = resp.Node.ModifiedIndex
+			if err := json.Unmarshal([]byte(resp.Node.Value), &r); err != nil {
+				return err
+			}
+		case ok && e.ErrorCode == ecodeKeyNotFound:
+		default:
+			return err
+		}
+		now := time.Now()
+		if now.Sub(r.Last) > RestartWindow {
+			r.Count = 0
+		}
+		r.Count++
+		r.Last = now
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if index == 0 {
+			_, err = client.Create(key, string(b), 0)
+		} else {
+			_, err = client.CompareAndSwap(key, string(b), 0, "", index)
+		}
+		if e, ok := err.(*etcd.EtcdError); ok && (e.ErrorCode == ecodeNodeExist || e.ErrorCode == ecodeTestFailed) {
+			// Recorded by someone else meanwhile. Try again.
+			continue
+		}
+		return err
+	}
+}
+
+// PoisonTask takes the task off free tasks for good, so that nobody takes it
+// over any more, e.g. if it keeps failing on start.
+func PoisonTask(client Client, name string, taskID uint64, reason string) error {
+	if _, err := client.Set(PoisonedTaskPath(name, taskID), reason, 0); err != nil {
+		return err
+	}
+	_, err := client.Delete(FreeTaskPath(name, strconv.FormatUint(taskID, 10)), false)
+	if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// UnpoisonTask forgets failures of the poisoned task, and frees it to be taken
+// over again.
+func UnpoisonTask(client Client, name string, taskID uint64) error {
+	client.Delete(TaskRestartsPath(name, taskID), false)
+	if _, err := client.Delete(PoisonedTaskPath(name, taskID), false); err != nil {
+		return err
+	}
+	_, err := client.Set(FreeTaskPath(name, strconv.FormatUint(taskID, 10)), "failed", 0)
+	return err
+}
+
+// PoisonedTasks returns tasks poisoned, with reasons.
+func PoisonedTasks(client Client, name string) (map[uint64]string, error) {
+	resp, err := client.Get(PoisonedDirPath(name), false, true)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	tasks := make(map[uint64]string, len(resp.Node.Nodes))
+	for _, n := range resp.Node.Nodes {
+		id, err := strconv.ParseUint(path.Base(n.Key), 10, 64)
+		if err != nil {
+			continue
+		}
+		tasks[id] = n.Value
+	}
+	return tasks, nil
+}
+
+// WatchPoisoned passes tasks poisoned, with reasons, to handler until stop.
+func WatchPoisoned(client Client, name string, stop chan bool, handler func(taskID uint64, reason string)) {
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, PoisonedDirPath(name), 0, true, receiver, stop)
+	for resp := range receiver {
+		if resp.Action != "set" && resp.Action != "create" && resp.Action != "get" {
+			continue
+		}
+		id, err := strconv.ParseUint(path.Base(resp.Node.Key), 10, 64)
+		if err != nil {
+			continue
+		}
+		handler(id, resp.Node.Value)
+	}
+}
@@ -0,0 +1,41 @@
+package etcdutil
+
+import (
+	"encoding/json"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// taskState is how a checkpoint of task is kept in etcd. Data is base64
+// encoded by json, since etcd values are strings.
+type taskState struct {
+	Epoch uint64 `json:"epoch"`
+	Data  []byte `json:"data"`
+}
+
+// SaveTaskState replaces the checkpoint of the task.
+func SaveTaskState(client Client, name string, taskID, epoch uint64, data []byte) error {
+	value, err := json.Marshal(&taskState{Epoch: epoch, Data: data})
+	if err != nil {
+		return err
+	}
+	_, err = client.Set(TaskStatePath(name, taskID), string(value), 0)
+	return err
+}
+
+// LoadTaskState returns the checkpoint of the task. It returns false if the
+// task has none.
+func LoadTaskState(client Client, name string, taskID uint64) (uint64, []byte, bool, error) {
+	resp, err := client.Get(TaskStatePath(name, taskID), false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+			return 0, nil, false, nil
+		}
+		return 0, nil, false, err
+	}
+	var s taskState
+	if err := json.Unmarshal([]byte(resp.Node.Value), &s); err != nil {
+		return 0, nil, false, err
+	}
+	return s.Epoch, s.Data, true, nil
+}
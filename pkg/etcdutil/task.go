@@ -2,30 +2,66 @@ package etcdutil
 
 import (
 	"log"
+	"path"
 	"strconv"
 
 	"github.com/coreos/go-etcd/etcd"
 )
 
-func TryOccupyTask(client *etcd.Client, name string, taskID uint64, connection string) bool {
+func TryOccupyTask(client Client, name string, taskID uint64, connection string) bool {
 	_, err := client.Create(TaskHealthyPath(name, taskID), "health", 3)
 	if err != nil {
 		return false
 	}
 	idStr := strconv.FormatUint(taskID, 10)
 	client.Delete(FreeTaskPath(name, idStr), false)
-	_, err = client.Set(TaskMasterPath(name, taskID), connection, 0)
+	// It expires along with the healthy key, unless kept by HeartbeatTask.
+	_, err = client.Set(TaskMasterPath(name, taskID), connection, 3)
 	if err != nil {
-		log.Fatal(err)
+		// The healthy key expires, and the task is reported free again.
+		log.Printf("etcdutil: registering task %d failed: %v", taskID, err)
+		return false
 	}
 	return true
 }
 
+// ReclaimTask takes back the task registered at connection, e.g. by a node
+// restarted before the task expired. Only one node can listen on connection,
+// so the node registered before must be gone.
+func ReclaimTask(client Client, name string, connection string) (uint64, bool) {
+	resp, err := client.Get(TaskDirPath(name), false, true)
+	if err != nil {
+		return 0, false
+	}
+	for _, dir := range resp.Node.Nodes {
+		taskID, err := strconv.ParseUint(path.Base(dir.Key), 10, 64)
+		if err != nil {
+			continue
+		}
+		for _, n := range dir.Nodes {
+			if n.Key != TaskMasterPath(name, taskID) || n.Value != connection {
+				continue
+			}
+			_, err := client.CompareAndSwap(n.Key, connection, 3, connection, 0)
+			if err != nil {
+				return 0, false
+			}
+			if _, err := client.Set(TaskHealthyPath(name, taskID), "health", 3); err != nil {
+				return 0, false
+			}
+			// It might have been reported free meanwhile.
+			client.Delete(FreeTaskPath(name, strconv.FormatUint(taskID, 10)), false)
+			return taskID, true
+		}
+	}
+	return 0, false
+}
+
 // getAddress will return the host:port address of the service taking care of
 // the task that we want to talk to.
-// Currently we grab the information from etcd every time. Local cache could be used.
+// It always goes to etcd; callers sending many requests should cache the result.
 // If it failed, e.g. network failure, it should return error.
-func GetAddress(client *etcd.Client, name string, id uint64) (string, error) {
+func GetAddress(client Client, name string, id uint64) (string, error) {
 	resp, err := client.Get(TaskMasterPath(name, id), false, false)
 	if err != nil {
 		return "", err
@@ -33,7 +69,118 @@ func GetAddress(client *etcd.Client, name string, id uint64) (string, error) {
 	return resp.Node.Value, nil
 }
 
-func SetJobStatus(client *etcd.Client, name string, status int) error {
+func SetJobStatus(client Client, name string, status int) error {
 	_, err := client.Set(JobStatusPath(name), "done", 0)
 	return err
 }
+
+// ReleaseTask frees the task registered at connection, e.g. to hand it over to
+// another node. The task is freed before the healthy key goes, so that
+// failure detectors don't count it as failed.
+func ReleaseTask(client Client, name string, taskID uint64, connection string) error {
+	_, err := client.Create(FreeTaskPath(name, strconv.FormatUint(taskID, 10)), "released", 0)
+	if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeNodeExist {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	// Either might have expired already.
+	_, err = client.CompareAndDelete(TaskMasterPath(name, taskID), connection, 0)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	_, err = client.Delete(TaskHealthyPath(name, taskID), false)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// MarkTaskExited reports that the task exited by itself, so that failure
+// detectors don't free it for standbys to take over.
+func MarkTaskExited(client Client, name string, taskID uint64) error {
+	_, err := client.Set(TaskStatusPath(name, taskID), TaskExited, 0)
+	return err
+}
+
+// IsTaskExited returns true if the task exited by itself.
+func IsTaskExited(client Client, name string, taskID uint64) (bool, error) {
+	resp, err := client.Get(TaskStatusPath(name, taskID), false, false)
+	if isKeyNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return resp.Node.Value == TaskExited, nil
+}
+
+// ExitTask unregisters the task exited at connection. Unlike ReleaseTask, it's
+// not freed, and nobody takes it over.
+func ExitTask(client Client, name string, taskID uint64, connection string) error {
+	_, err := client.CompareAndDelete(TaskMasterPath(name, taskID), connection, 0)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	_, err = client.Delete(TaskHealthyPath(name, taskID), false)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func isKeyNotFound(err error) bool {
+	e, ok := err.(*etcd.EtcdError)
+	return ok && e.ErrorCode == ecodeKeyNotFound
+}
+
+// WaitTasksGone blocks until no node is registered for any task of the job,
+// e.g. once all of them exited after the job was shut down. Tasks of nodes
+// which crashed are gone once their registrations expire. It returns false if
+// stop is closed before. stop has to be closed after it returns all the
+// same, to stop watching tasks.
+func WaitTasksGone(client Client, name string, stop chan bool) (bool, error) {
+	gone := func() (uint64, bool, error) {
+		resp, err := client.Get(TaskDirPath(name), false, true)
+		if isKeyNotFound(err) {
+			return err.(*etcd.EtcdError).Index, true, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		for _, dir := range resp.Node.Nodes {
+			for _, n := range dir.Nodes {
+				if path.Base(n.Key) == TaskMaster {
+					return resp.EtcdIndex, false, nil
+				}
+			}
+		}
+		return resp.EtcdIndex, true, nil
+	}
+	index, ok, err := gone()
+	if ok || err != nil {
+		return ok, err
+	}
+	receiver := make(chan *etcd.Response, 1)
+	go Watch(client, TaskDirPath(name), index+1, true, receiver, stop)
+	for range receiver {
+		if _, ok, err := gone(); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// FailTask takes the task from the node registered for it, as if the node
+// failed, e.g. since it hangs, and frees it for a standby to take over. The
+// node finds out at its next heartbeat, and stops. The failure is recorded as
+// caused by actor.
+func FailTask(client Client, name string, taskID uint64, actor string) error {
+	for _, key := range []string{TaskMasterPath(name, taskID), TaskHealthyPath(name, taskID)} {
+		if _, err := client.Delete(key, false); err != nil && !isKeyNotFound(err) {
+			return err
+		}
+	}
+	return ReportFailure(client, name, strconv.FormatUint(taskID, 10), actor, "failed on request")
+}
@@ -0,0 +1,21 @@
+package etcdutil
+
+// SetTopology keeps the topology of the job the controller has been given, so
+// that tasks can set it up in the same way.
+func SetTopology(client Client, name, topology string) error {
+	_, err := client.Set(TopologyPath(name), topology, 0)
+	return err
+}
+
+// GetTopology returns the topology kept for the job. It returns false if there
+// is none, e.g. tasks of the job are to set it up by themselves.
+func GetTopology(client Client, name string) (string, bool, error) {
+	resp, err := client.Get(TopologyPath(name), false, false)
+	if isKeyNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return resp.Node.Value, true, nil
+}
@@ -1,9 +1,10 @@
 package etcdutil
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/coreos/go-etcd/etcd"
+	"github.com/go-distributed/meritop"
 )
 
 func ListKeys(nodes []*etcd.Node) []string {
@@ -14,10 +15,12 @@ func ListKeys(nodes []*etcd.Node) []string {
 	return res
 }
 
-func MustCreate(c *etcd.Client, logger *log.Logger, key, value string, ttl uint64) *etcd.Response {
+func MustCreate(c Client, logger meritop.Logger, key, value string, ttl uint64) *etcd.Response {
 	resp, err := c.Create(key, value, ttl)
 	if err != nil {
-		logger.Panicf("controller create failed. Key: %s, err: %v", key, err)
+		msg := fmt.Sprintf("controller create failed. Key: %s, err: %v", key, err)
+		logger.Errorf("%s", msg)
+		panic(msg)
 	}
 	return resp
 }
@@ -0,0 +1,1202 @@
+package etcdutil
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/go-distributed/meritop"
+	merrors "github.com/go-distributed/meritop/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// v3TaskTTL is the TTL in seconds of the lease a task is occupied with,
+	// until the first heartbeat, as on etcd v2.
+	v3TaskTTL = 3
+	// v3RequestTimeout bounds each request to etcd.
+	v3RequestTimeout = 5 * time.Second
+)
+
+var (
+	errV3NoTask        = errors.New("etcdutil: no node works for the task")
+	errV3NoEpoch       = errors.New("etcdutil: job has no epoch on etcd")
+	errV3LogTaken      = errors.New("etcdutil: update log ID has been taken")
+	errV3JobExists     = merrors.New(merrors.ErrJobExists, "etcdutil: job has been set up on etcd")
+	errV3EpochConflict = merrors.New(merrors.ErrEpochConflict, "etcdutil: epoch of job has moved on")
+)
+
+// V3Coordinator is a Coordinator keeping jobs in etcd through the v3 API, at
+// the same keys as the one on etcd v2. Tasks are registered with leases, which
+// heartbeats keep alive, so that all keys of a node crashed go at once, and
+// keys changed together, e.g. by OccupyTask or TryPassBarrier, are changed in
+// one transaction. Revisions passed on, e.g. by WatchMeta and Generation, are
+// ones of etcd, so they order changes the way indexes do on etcd v2.
+//
+// Jobs are set up by SetupJob, as the controller does on etcd v2.
+type V3Coordinator struct {
+	client *clientv3.Client
+}
+
+func NewV3Coordinator(client *clientv3.Client) *V3Coordinator {
+	return &V3Coordinator{client: client}
+}
+
+// SetupJob sets up the job of numOfTasks tasks, all free for nodes to take,
+// at epoch 0. It fails with an error of kind errors.ErrJobExists if the job
+// has been set up before.
+func (c *V3Coordinator) SetupJob(name string, numOfTasks uint64) error {
+	ops := []clientv3.Op{
+		clientv3.OpPut(EpochPath(name), "0"),
+		clientv3.OpPut(NumOfTasksPath(name), strconv.FormatUint(numOfTasks, 10)),
+	}
+	for id := uint64(0); id < numOfTasks; id++ {
+		ops = append(ops, clientv3.OpPut(FreeTaskPath(name, strconv.FormatUint(id, 10)), ""))
+	}
+	resp, err := c.txn([]clientv3.Cmp{notExists(EpochPath(name))}, ops, nil)
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return errV3JobExists
+	}
+	return nil
+}
+
+// SetConfig sets keys of the configuration of the job to the given values,
+// all at once. Other keys are kept.
+func (c *V3Coordinator) SetConfig(name string, cfg map[string]string) error {
+	var ops []clientv3.Op
+	for key, value := range cfg {
+		ops = append(ops, clientv3.OpPut(ConfigKeyPath(name, key), value))
+	}
+	_, err := c.txn(nil, ops, nil)
+	return err
+}
+
+// RequestCheckpoint asks all tasks of the job to checkpoint at the start of
+// epoch.
+func (c *V3Coordinator) RequestCheckpoint(name string, epoch uint64) error {
+	return c.put(CheckpointRequestPath(name), strconv.FormatUint(epoch, 10))
+}
+
+// Events returns the audit log of the job, in the order events have been
+// recorded. Entries which can't be decoded are skipped.
+func (c *V3Coordinator) Events(name string) ([]Event, error) {
+	resp, err := c.get(dirKey(EventsPath(name)), clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	for _, kv := range resp.Kvs {
+		var e Event
+		if json.Unmarshal(kv.Value, &e) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (c *V3Coordinator) OccupyTask(name string, taskID uint64, addr string) bool {
+	lease, err := c.grant(v3TaskTTL)
+	if err != nil {
+		return false
+	}
+	healthy := TaskHealthyPath(name, taskID)
+	resp, err := c.txn([]clientv3.Cmp{notExists(healthy)}, []clientv3.Op{
+		clientv3.OpPut(healthy, "health", clientv3.WithLease(lease)),
+		clientv3.OpPut(TaskMasterPath(name, taskID), addr, clientv3.WithLease(lease)),
+		clientv3.OpDelete(FreeTaskPath(name, strconv.FormatUint(taskID, 10))),
+	}, nil)
+	if err != nil || !resp.Succeeded {
+		c.revoke(lease)
+		return false
+	}
+	return true
+}
+
+func (c *V3Coordinator) ReclaimTask(name string, addr string) (uint64, bool) {
+	resp, err := c.get(dirKey(TaskDirPath(name)), clientv3.WithPrefix())
+	if err != nil {
+		return 0, false
+	}
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		taskID, err := strconv.ParseUint(path.Base(path.Dir(key)), 10, 64)
+		if err != nil || key != TaskMasterPath(name, taskID) || string(kv.Value) != addr {
+			continue
+		}
+		lease, err := c.grant(v3TaskTTL)
+		if err != nil {
+			return 0, false
+		}
+		// It might have been reported free meanwhile.
+		resp, err := c.txn([]clientv3.Cmp{registeredAt(key, addr)}, []clientv3.Op{
+			clientv3.OpPut(key, addr, clientv3.WithLease(lease)),
+			clientv3.OpPut(TaskHealthyPath(name, taskID), "health", clientv3.WithLease(lease)),
+			clientv3.OpDelete(FreeTaskPath(name, strconv.FormatUint(taskID, 10))),
+		}, nil)
+		if err != nil || !resp.Succeeded {
+			c.revoke(lease)
+			return 0, false
+		}
+		return taskID, true
+	}
+	return 0, false
+}
+
+func (c *V3Coordinator) ReleaseTask(name string, taskID uint64, addr string) error {
+	// The task is freed along with its healthy key going, so that failure
+	// detectors find it free, and don't count it as failed.
+	free := FreeTaskPath(name, strconv.FormatUint(taskID, 10))
+	_, err := c.unregister(name, taskID, addr, clientv3.OpPut(free, "released"))
+	return err
+}
+
+func (c *V3Coordinator) MarkTaskExited(name string, taskID uint64) error {
+	return c.put(TaskStatusPath(name, taskID), TaskExited)
+}
+
+func (c *V3Coordinator) ExitTask(name string, taskID uint64, addr string) error {
+	_, err := c.unregister(name, taskID, addr)
+	return err
+}
+
+func (c *V3Coordinator) FailTask(name string, taskID uint64, addr, actor, reason string) error {
+	// The node mustn't reclaim the task without it being counted as failed.
+	if _, err := c.unregister(name, taskID, addr); err != nil {
+		return err
+	}
+	return c.reportFailure(name, taskID, actor, reason)
+}
+
+func (c *V3Coordinator) FreeTasks(name string) ([]uint64, error) {
+	resp, err := c.get(dirKey(FreeTaskDir(name)), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	var free []uint64
+	for _, kv := range resp.Kvs {
+		if id, err := strconv.ParseUint(path.Base(string(kv.Key)), 10, 64); err == nil {
+			free = append(free, id)
+		}
+	}
+	return free, nil
+}
+
+func (c *V3Coordinator) WaitFreeTask(name string, logger meritop.Logger, stop chan bool) (uint64, error) {
+	dir := dirKey(FreeTaskDir(name))
+	resp, err := c.get(dir, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	if total := len(resp.Kvs); total > 0 {
+		ri := rand.Intn(total)
+		id, err := strconv.ParseUint(path.Base(string(resp.Kvs[ri].Key)), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		logger.Infof("got %d free tasks at revision %d, randomly choose %d to try...", total, resp.Header.Revision, id)
+		return id, nil
+	}
+
+	freed := make(chan uint64, 1)
+	watchStop := make(chan bool)
+	defer close(watchStop)
+	go c.watch(dir, resp.Header.Revision+1, true, watchStop, func(ev *clientv3.Event) bool {
+		if ev.Type != clientv3.EventTypePut {
+			return true
+		}
+		id, err := strconv.ParseUint(path.Base(string(ev.Kv.Key)), 10, 64)
+		if err != nil {
+			return true
+		}
+		freed <- id
+		return false
+	})
+	select {
+	case id := <-freed:
+		return id, nil
+	case <-time.After(10 * time.Second):
+		return 0, ErrWaitFreeTaskTimeout
+	case <-stop:
+		return 0, ErrWaitFreeTaskStopped
+	}
+}
+
+func (c *V3Coordinator) Address(name string, taskID uint64) (string, error) {
+	addr, ok, err := c.value(TaskMasterPath(name, taskID))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", errV3NoTask
+	}
+	return addr, nil
+}
+
+func (c *V3Coordinator) Heartbeat(name string, taskID uint64, addr string, interval time.Duration, stop chan bool) error {
+	master := TaskMasterPath(name, taskID)
+	return c.heartbeatLease(interval, stop, func(lease clientv3.LeaseID, moved bool) error {
+		// Only while the task is still registered at addr. Otherwise
+		// another node has taken it over.
+		var ops []clientv3.Op
+		if moved {
+			ops = []clientv3.Op{
+				clientv3.OpPut(master, addr, clientv3.WithLease(lease)),
+				clientv3.OpPut(TaskHealthyPath(name, taskID), "health", clientv3.WithLease(lease)),
+			}
+		}
+		resp, err := c.txn([]clientv3.Cmp{registeredAt(master, addr)}, ops, nil)
+		if err != nil {
+			return err
+		}
+		if !resp.Succeeded {
+			return ErrTaskFenced
+		}
+		return nil
+	})
+}
+
+func (c *V3Coordinator) WatchHealth(name string, stop chan bool, handler func(taskID uint64, healthy bool)) {
+	dir := dirKey(HealthyPath(name))
+	rev, err := c.revision(dir)
+	if err != nil {
+		log.Printf("etcdutil: watching health of tasks failed: %v", err)
+		return
+	}
+	go c.watch(dir, rev+1, true, stop, func(ev *clientv3.Event) bool {
+		// Only the key created, not put again by heartbeats, tells the task
+		// has been occupied again.
+		if ev.Type == clientv3.EventTypePut && !ev.IsCreate() {
+			return true
+		}
+		if id, err := strconv.ParseUint(path.Base(string(ev.Kv.Key)), 10, 64); err == nil {
+			handler(id, ev.IsCreate())
+		}
+		return true
+	})
+}
+
+func (c *V3Coordinator) DetectFailure(name, actor string, stop chan bool, logger meritop.Logger) error {
+	dir := dirKey(HealthyPath(name))
+	rev, err := c.revision(dir)
+	if err != nil {
+		return err
+	}
+	c.watch(dir, rev+1, true, stop, func(ev *clientv3.Event) bool {
+		if ev.Type != clientv3.EventTypeDelete {
+			return true
+		}
+		// Several detectors might be watching. Don't report the task if it
+		// has been occupied again in the meantime.
+		if _, ok, err := c.value(string(ev.Kv.Key)); ok || err != nil {
+			return true
+		}
+		id, err := strconv.ParseUint(path.Base(string(ev.Kv.Key)), 10, 64)
+		if err != nil {
+			return true
+		}
+		// Tasks exited by themselves haven't failed.
+		if status, _, _ := c.value(TaskStatusPath(name, id)); status == TaskExited {
+			return true
+		}
+		// Nor have tasks the job has been shrunk off.
+		if n, ok, _ := c.NumOfTasks(name); ok && id >= n {
+			return true
+		}
+		if err := c.reportFailure(name, id, actor, "heartbeat expired"); err != nil {
+			logger.Warnf("reporting failure of task %d returns error: %v", id, err)
+		}
+		return true
+	})
+	return nil
+}
+
+func (c *V3Coordinator) Lease(name string) (time.Duration, bool, error) {
+	value, ok, err := c.value(LeasePath(name))
+	if !ok || err != nil {
+		return 0, false, err
+	}
+	secs, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return time.Duration(secs) * time.Second, true, nil
+}
+
+func (c *V3Coordinator) KeepLease(name string, ttl time.Duration, stop chan bool) error {
+	resp, err := c.get(LeasePath(name))
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 || resp.Kvs[0].Lease == 0 {
+		return ErrLeaseExpired
+	}
+	lease := clientv3.LeaseID(resp.Kvs[0].Lease)
+	interval := time.Duration(leaseSeconds(ttl)) * time.Second / 3
+	for {
+		if err := c.keepAlive(lease); err != nil {
+			if _, ok, gerr := c.value(LeasePath(name)); gerr == nil && !ok {
+				return ErrLeaseExpired
+			}
+			return err
+		}
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+func (c *V3Coordinator) NextGeneration(name string, taskID uint64) (Generation, error) {
+	var value uint64
+	rev, err := c.update(TaskGenerationPath(name, taskID), func(prev string, ok bool) (string, error) {
+		value = 1
+		if ok {
+			v, err := strconv.ParseUint(prev, 10, 64)
+			if err != nil {
+				return "", err
+			}
+			value = v + 1
+		}
+		return strconv.FormatUint(value, 10), nil
+	})
+	if err != nil {
+		return Generation{}, err
+	}
+	if value > 1 {
+		c.incCounter(name, CounterReassignments)
+	}
+	return Generation{Value: value, Index: uint64(rev)}, nil
+}
+
+func (c *V3Coordinator) Generation(name string, taskID uint64) (Generation, error) {
+	resp, err := c.get(TaskGenerationPath(name, taskID))
+	if err != nil || len(resp.Kvs) == 0 {
+		return Generation{}, err
+	}
+	v, err := strconv.ParseUint(string(resp.Kvs[0].Value), 10, 64)
+	if err != nil {
+		return Generation{}, err
+	}
+	return Generation{Value: v, Index: uint64(resp.Kvs[0].ModRevision)}, nil
+}
+
+func (c *V3Coordinator) SetVersion(name string, taskID uint64, version int) error {
+	return c.put(TaskVersionPath(name, taskID), strconv.Itoa(version))
+}
+
+func (c *V3Coordinator) Version(name string, taskID uint64) (int, error) {
+	value, ok, err := c.value(TaskVersionPath(name, taskID))
+	if !ok || err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+func (c *V3Coordinator) WatchTasks(name string, stop chan bool, handler func(change TaskChange)) {
+	// Watch from the current revision so that no change after this is
+	// missed.
+	dir := dirKey(TaskDirPath(name))
+	rev, err := c.revision(dir)
+	if err != nil {
+		log.Printf("etcdutil: watching tasks failed: %v", err)
+		return
+	}
+	go c.watch(dir, rev+1, true, stop, func(ev *clientv3.Event) bool {
+		if ch, ok := parseV3TaskChange(name, ev); ok {
+			handler(ch)
+		}
+		return true
+	})
+}
+
+// parseV3TaskChange is parseTaskChange for events of etcd v3.
+func parseV3TaskChange(name string, ev *clientv3.Event) (TaskChange, bool) {
+	key := string(ev.Kv.Key)
+	taskID, err := strconv.ParseUint(path.Base(path.Dir(key)), 10, 64)
+	if err != nil {
+		return TaskChange{}, false
+	}
+	ch := TaskChange{TaskID: taskID}
+	switch key {
+	case TaskMasterPath(name, taskID):
+		ch.Kind = TaskChangeAddress
+	case TaskGenerationPath(name, taskID):
+		ch.Kind = TaskChangeGeneration
+	case TaskVersionPath(name, taskID):
+		ch.Kind = TaskChangeVersion
+	default:
+		return TaskChange{}, false
+	}
+	if ev.Type == clientv3.EventTypeDelete {
+		ch.Deleted = true
+		return ch, true
+	}
+	value := string(ev.Kv.Value)
+	switch ch.Kind {
+	case TaskChangeAddress:
+		ch.Address = value
+	case TaskChangeGeneration:
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return TaskChange{}, false
+		}
+		ch.Generation = Generation{Value: v, Index: uint64(ev.Kv.ModRevision)}
+	case TaskChangeVersion:
+		if ch.Version, err = strconv.Atoi(value); err != nil {
+			return TaskChange{}, false
+		}
+	}
+	return ch, true
+}
+
+func (c *V3Coordinator) Epoch(name string) (uint64, error) {
+	value, ok, err := c.value(EpochPath(name))
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errV3NoEpoch
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func (c *V3Coordinator) CASEpoch(name string, prev, next uint64) error {
+	key := EpochPath(name)
+	resp, err := c.txn([]clientv3.Cmp{
+		clientv3.Compare(clientv3.Value(key), "=", strconv.FormatUint(prev, 10)),
+	}, []clientv3.Op{clientv3.OpPut(key, strconv.FormatUint(next, 10))}, nil)
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		// Somebody else has moved the epoch on.
+		return errV3EpochConflict
+	}
+	if next == prev+1 {
+		c.incCounter(name, CounterEpochs)
+	}
+	return nil
+}
+
+func (c *V3Coordinator) WatchEpoch(name string, epochC chan uint64, stop chan bool) (uint64, error) {
+	key := EpochPath(name)
+	resp, err := c.get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, errV3NoEpoch
+	}
+	epoch, err := strconv.ParseUint(string(resp.Kvs[0].Value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	go c.watch(key, resp.Header.Revision+1, false, stop, func(ev *clientv3.Event) bool {
+		if ev.Type != clientv3.EventTypePut {
+			return true
+		}
+		epoch, err := strconv.ParseUint(string(ev.Kv.Value), 10, 64)
+		if err != nil {
+			log.Printf("etcdutil: can't parse epoch %q from etcd: %v", ev.Kv.Value, err)
+			return true
+		}
+		epochC <- epoch
+		return true
+	})
+	return epoch, nil
+}
+
+func (c *V3Coordinator) ControllerPaced(name string) (bool, error) {
+	value, _, err := c.value(PacingPath(name))
+	return value == PacingCtrl, err
+}
+
+func (c *V3Coordinator) MarkEpochAdvance(name string, epoch uint64) error {
+	return c.put(path.Join(BarrierPath(name, epoch), BarrierAdvance), "")
+}
+
+func (c *V3Coordinator) MarkEpochDone(name string, epoch, taskID uint64) error {
+	return c.put(path.Join(BarrierPath(name, epoch), strconv.FormatUint(taskID, 10)), "done")
+}
+
+func (c *V3Coordinator) TryPassBarrier(name string, epoch uint64, quorum int) (bool, error) {
+	dir := dirKey(BarrierPath(name, epoch))
+	resp, err := c.get(dir, clientv3.WithPrefix())
+	if err != nil {
+		return false, err
+	}
+	done, advance := 0, false
+	for _, kv := range resp.Kvs {
+		if path.Base(string(kv.Key)) == BarrierAdvance {
+			advance = true
+		} else {
+			done++
+		}
+	}
+	if !advance || done < quorum {
+		return false, nil
+	}
+	// The epoch advances and the barrier is cleaned up at once, so no task
+	// checks in at the barrier passed.
+	key := EpochPath(name)
+	tresp, err := c.txn([]clientv3.Cmp{
+		clientv3.Compare(clientv3.Value(key), "=", strconv.FormatUint(epoch, 10)),
+	}, []clientv3.Op{
+		clientv3.OpPut(key, strconv.FormatUint(epoch+1, 10)),
+		clientv3.OpDelete(dir, clientv3.WithPrefix()),
+	}, nil)
+	if err != nil {
+		return false, err
+	}
+	if !tresp.Succeeded {
+		// Someone else got it through.
+		return true, nil
+	}
+	c.incCounter(name, CounterEpochs)
+	c.RecordEvent(name, Event{Kind: EventEpoch, Actor: "barrier", Epoch: epoch + 1, Detail: "advanced"})
+	return true, nil
+}
+
+func (c *V3Coordinator) EnterBarrier(name string, epoch uint64, barrier string, taskID uint64) error {
+	return c.put(path.Join(PhaseBarrierPath(name, epoch, barrier), strconv.FormatUint(taskID, 10)), "")
+}
+
+func (c *V3Coordinator) WaitBarrier(name string, epoch uint64, barrier string, n int, stop chan bool) (bool, error) {
+	dir := dirKey(PhaseBarrierPath(name, epoch, barrier))
+	resp, err := c.get(dir, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	if resp.Count >= int64(n) {
+		return true, nil
+	}
+	entered := int(resp.Count)
+	c.watch(dir, resp.Header.Revision+1, true, stop, func(ev *clientv3.Event) bool {
+		if ev.IsCreate() {
+			entered++
+		}
+		return entered < n
+	})
+	return entered >= n, nil
+}
+
+func (c *V3Coordinator) WatchCheckpointRequest(name string, stop chan bool, handler func(epoch uint64)) error {
+	key := CheckpointRequestPath(name)
+	resp, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) > 0 {
+		if epoch, err := strconv.ParseUint(string(resp.Kvs[0].Value), 10, 64); err == nil {
+			handler(epoch)
+		}
+	}
+	go c.watch(key, resp.Header.Revision+1, false, stop, func(ev *clientv3.Event) bool {
+		if ev.Type != clientv3.EventTypePut {
+			return true
+		}
+		if epoch, err := strconv.ParseUint(string(ev.Kv.Value), 10, 64); err == nil {
+			handler(epoch)
+		}
+		return true
+	})
+	return nil
+}
+
+func (c *V3Coordinator) MarkCheckpointDone(name string, epoch, taskID uint64) error {
+	return c.put(path.Join(CheckpointPath(name, epoch), strconv.FormatUint(taskID, 10)), "done")
+}
+
+func (c *V3Coordinator) SetMeta(key, value string) error {
+	return c.put(key, value)
+}
+
+func (c *V3Coordinator) Meta(key string) (string, bool, error) {
+	return c.value(key)
+}
+
+func (c *V3Coordinator) WatchMeta(key string, stop chan bool, handler func(value string, rev uint64)) error {
+	resp, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	// Get previous meta. We need to handle it.
+	if len(resp.Kvs) > 0 && len(resp.Kvs[0].Value) > 0 {
+		handler(string(resp.Kvs[0].Value), uint64(resp.Kvs[0].ModRevision))
+	}
+	go c.watch(key, resp.Header.Revision+1, false, stop, func(ev *clientv3.Event) bool {
+		if ev.Type == clientv3.EventTypePut {
+			handler(string(ev.Kv.Value), uint64(ev.Kv.ModRevision))
+		}
+		return true
+	})
+	return nil
+}
+
+func (c *V3Coordinator) NumOfTasks(name string) (uint64, bool, error) {
+	value, ok, err := c.value(NumOfTasksPath(name))
+	if !ok || err != nil {
+		return 0, false, err
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
+
+func (c *V3Coordinator) AuthToken(name string) (string, error) {
+	token, _, err := c.value(AuthTokenPath(name))
+	return token, err
+}
+
+func (c *V3Coordinator) Config(name string) (map[string]string, error) {
+	cfg, _, err := c.config(name)
+	return cfg, err
+}
+
+// config returns the configuration of the job, and the revision it's read
+// at.
+func (c *V3Coordinator) config(name string) (map[string]string, int64, error) {
+	resp, err := c.get(dirKey(ConfigPath(name)), clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	cfg := make(map[string]string)
+	for _, kv := range resp.Kvs {
+		cfg[path.Base(string(kv.Key))] = string(kv.Value)
+	}
+	return cfg, resp.Header.Revision, nil
+}
+
+func (c *V3Coordinator) WatchConfig(name string, stop chan bool, handler func(cfg map[string]string)) error {
+	cfg, rev, err := c.config(name)
+	if err != nil {
+		return err
+	}
+	handler(cfg)
+	go c.watch(dirKey(ConfigPath(name)), rev+1, true, stop, func(*clientv3.Event) bool {
+		if cfg, _, err := c.config(name); err == nil {
+			handler(cfg)
+		}
+		return true
+	})
+	return nil
+}
+
+func (c *V3Coordinator) MarkJobDone(name string) error {
+	return c.put(JobStatusPath(name), "done")
+}
+
+func (c *V3Coordinator) RecordEvent(name string, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// Events sort in the order they have been created, whatever their keys.
+	for {
+		key := path.Join(EventsPath(name), fmt.Sprintf("%020d", time.Now().UnixNano()))
+		resp, err := c.txn([]clientv3.Cmp{notExists(key)}, []clientv3.Op{clientv3.OpPut(key, string(b))}, nil)
+		if err != nil || resp.Succeeded {
+			return err
+		}
+	}
+}
+
+func (c *V3Coordinator) SetProgress(name string, epoch, taskID uint64, p meritop.Progress) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return c.put(TaskProgressPath(name, epoch, taskID), string(b))
+}
+
+func (c *V3Coordinator) Restarts(name string, taskID uint64) (Restarts, error) {
+	var r Restarts
+	value, ok, err := c.value(TaskRestartsPath(name, taskID))
+	if !ok || err != nil {
+		return r, err
+	}
+	err = json.Unmarshal([]byte(value), &r)
+	return r, err
+}
+
+func (c *V3Coordinator) PoisonTask(name string, taskID uint64, reason string) error {
+	_, err := c.txn(nil, []clientv3.Op{
+		clientv3.OpPut(PoisonedTaskPath(name, taskID), reason),
+		clientv3.OpDelete(FreeTaskPath(name, strconv.FormatUint(taskID, 10))),
+	}, nil)
+	return err
+}
+
+func (c *V3Coordinator) OccupyReplica(name string, taskID, replicaID uint64, addr string) bool {
+	lease, err := c.grant(v3TaskTTL)
+	if err != nil {
+		return false
+	}
+	key := TaskReplicaPath(name, taskID, replicaID)
+	resp, err := c.txn([]clientv3.Cmp{notExists(key)}, []clientv3.Op{
+		clientv3.OpPut(key, addr, clientv3.WithLease(lease)),
+	}, nil)
+	if err != nil || !resp.Succeeded {
+		c.revoke(lease)
+		return false
+	}
+	return true
+}
+
+func (c *V3Coordinator) ReleaseReplica(name string, taskID, replicaID uint64) error {
+	return c.del(TaskReplicaPath(name, taskID, replicaID))
+}
+
+func (c *V3Coordinator) HeartbeatReplica(name string, taskID, replicaID uint64, addr string, interval time.Duration, stop chan bool) error {
+	key := TaskReplicaPath(name, taskID, replicaID)
+	return c.heartbeatLease(interval, stop, func(lease clientv3.LeaseID, moved bool) error {
+		if !moved {
+			return nil
+		}
+		return c.put(key, addr, clientv3.WithLease(lease))
+	})
+}
+
+func (c *V3Coordinator) ShipUpdateLog(name string, taskID uint64, ul meritop.UpdateLog) error {
+	key := UpdateLogEntryPath(name, taskID, ul.ID)
+	resp, err := c.txn([]clientv3.Cmp{notExists(key)}, []clientv3.Op{
+		clientv3.OpPut(key, base64.StdEncoding.EncodeToString(ul.Data)),
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return errV3LogTaken
+	}
+	return nil
+}
+
+func (c *V3Coordinator) LastUpdateLogID(name string, taskID uint64) (uint64, error) {
+	// Keys of update logs sort in the order of their IDs.
+	resp, err := c.get(dirKey(UpdateLogPath(name, taskID)), clientv3.WithLastKey()...)
+	if err != nil || len(resp.Kvs) == 0 {
+		return 0, err
+	}
+	return strconv.ParseUint(path.Base(string(resp.Kvs[0].Key)), 10, 64)
+}
+
+func (c *V3Coordinator) WatchUpdateLog(name string, taskID, from uint64, logs chan<- meritop.UpdateLog, stop chan bool) {
+	dir := dirKey(UpdateLogPath(name, taskID))
+	go func() {
+		defer close(logs)
+		last := from
+		for {
+			// Replay logs shipped before, and then watch for the next ones.
+			resp, err := c.get(dir, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+			if err != nil {
+				log.Printf("etcdutil: replaying update logs of task %d failed: %v", taskID, err)
+				return
+			}
+			for _, kv := range resp.Kvs {
+				ul, err := decodeV3UpdateLog(kv.Key, kv.Value)
+				if err != nil {
+					log.Printf("etcdutil: decoding update log %s failed: %v", kv.Key, err)
+					return
+				}
+				if ul.ID > last {
+					logs <- ul
+					last = ul.ID
+				}
+			}
+			gap, stopped := false, true
+			c.watch(dir, resp.Header.Revision+1, true, stop, func(ev *clientv3.Event) bool {
+				if !ev.IsCreate() {
+					return true
+				}
+				ul, err := decodeV3UpdateLog(ev.Kv.Key, ev.Kv.Value)
+				if err != nil {
+					log.Printf("etcdutil: decoding update log %s failed: %v", ev.Kv.Key, err)
+					return true
+				}
+				if ul.ID <= last {
+					return true
+				}
+				if ul.ID != last+1 {
+					// Shipped out of order. Replay to catch up.
+					gap, stopped = true, false
+					return false
+				}
+				logs <- ul
+				last = ul.ID
+				return true
+			})
+			if stopped || !gap {
+				return
+			}
+		}
+	}()
+}
+
+func decodeV3UpdateLog(key, value []byte) (meritop.UpdateLog, error) {
+	id, err := strconv.ParseUint(path.Base(string(key)), 10, 64)
+	if err != nil {
+		return meritop.UpdateLog{}, err
+	}
+	data, err := base64.StdEncoding.DecodeString(string(value))
+	if err != nil {
+		return meritop.UpdateLog{}, err
+	}
+	return meritop.UpdateLog{ID: id, Data: data}, nil
+}
+
+func (c *V3Coordinator) WaitTaskFailure(name string, taskID uint64) error {
+	key := TaskHealthyPath(name, taskID)
+	resp, err := c.get(key)
+	if err != nil || len(resp.Kvs) == 0 {
+		return err
+	}
+	c.watch(key, resp.Header.Revision+1, false, nil, func(ev *clientv3.Event) bool {
+		return ev.Type != clientv3.EventTypeDelete
+	})
+	return nil
+}
+
+func (c *V3Coordinator) TaskData(name string, taskID uint64, key string) ([]byte, bool, error) {
+	value, ok, err := c.value(TaskDataPath(name, taskID, key))
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (c *V3Coordinator) SetTaskData(name string, taskID uint64, key string, value []byte) error {
+	return c.put(TaskDataPath(name, taskID, key), encodeTaskData(value))
+}
+
+func (c *V3Coordinator) CASTaskData(name string, taskID uint64, key string, prev, value []byte) (bool, error) {
+	p := TaskDataPath(name, taskID, key)
+	cmp := notExists(p)
+	if prev != nil {
+		cmp = clientv3.Compare(clientv3.Value(p), "=", encodeTaskData(prev))
+	}
+	resp, err := c.txn([]clientv3.Cmp{cmp}, []clientv3.Op{clientv3.OpPut(p, encodeTaskData(value))}, nil)
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+func (c *V3Coordinator) DeleteTaskData(name string, taskID uint64, key string) error {
+	return c.del(TaskDataPath(name, taskID, key))
+}
+
+func (c *V3Coordinator) WatchTaskData(name string, taskID uint64, key string, values chan<- []byte, stop chan bool) {
+	p := TaskDataPath(name, taskID, key)
+	rev, err := c.revision(p)
+	if err != nil {
+		log.Printf("etcdutil: watching data %s of task %d failed: %v", key, taskID, err)
+		close(values)
+		return
+	}
+	go func() {
+		defer close(values)
+		c.watch(p, rev+1, false, stop, func(ev *clientv3.Event) bool {
+			var value []byte
+			if ev.Type == clientv3.EventTypePut {
+				v, err := base64.StdEncoding.DecodeString(string(ev.Kv.Value))
+				if err != nil {
+					return true
+				}
+				value = append([]byte{}, v...)
+			}
+			select {
+			case values <- value:
+				return true
+			case <-stop:
+				return false
+			}
+		})
+	}()
+}
+
+func (c *V3Coordinator) SaveTaskState(name string, taskID, epoch uint64, data []byte) error {
+	value, err := json.Marshal(&taskState{Epoch: epoch, Data: data})
+	if err != nil {
+		return err
+	}
+	return c.put(TaskStatePath(name, taskID), string(value))
+}
+
+func (c *V3Coordinator) LoadTaskState(name string, taskID uint64) (uint64, []byte, bool, error) {
+	value, ok, err := c.value(TaskStatePath(name, taskID))
+	if !ok || err != nil {
+		return 0, nil, false, err
+	}
+	var s taskState
+	if err := json.Unmarshal([]byte(value), &s); err != nil {
+		return 0, nil, false, err
+	}
+	return s.Epoch, s.Data, true, nil
+}
+
+// unregister deletes the registration of the task at addr, if it's still
+// there, and the healthy key of the task, along with ops, at once.
+func (c *V3Coordinator) unregister(name string, taskID uint64, addr string, ops ...clientv3.Op) (bool, error) {
+	master := TaskMasterPath(name, taskID)
+	ops = append(ops, clientv3.OpDelete(TaskHealthyPath(name, taskID)))
+	resp, err := c.txn([]clientv3.Cmp{registeredAt(master, addr)},
+		append([]clientv3.Op{clientv3.OpDelete(master)}, ops...), ops)
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// reportFailure frees the failed task, and counts the failure, unless the
+// task has been freed before, as ReportFailure does on etcd v2.
+func (c *V3Coordinator) reportFailure(name string, taskID uint64, actor, reason string) error {
+	free := FreeTaskPath(name, strconv.FormatUint(taskID, 10))
+	resp, err := c.txn([]clientv3.Cmp{notExists(free)}, []clientv3.Op{clientv3.OpPut(free, "failed")}, nil)
+	if err != nil || !resp.Succeeded {
+		return err
+	}
+	_, err = c.update(TaskRestartsPath(name, taskID), func(prev string, ok bool) (string, error) {
+		var r Restarts
+		if ok {
+			if err := json.Unmarshal([]byte(prev), &r); err != nil {
+				return "", err
+			}
+		}
+		now := time.Now()
+		if now.Sub(r.Last) > RestartWindow {
+			r.Count = 0
+		}
+		r.Count++
+		r.Last = now
+		b, err := json.Marshal(r)
+		return string(b), err
+	})
+	if err != nil {
+		return err
+	}
+	c.incCounter(name, CounterFailures)
+	return c.RecordEvent(name, Event{Kind: EventFailure, Actor: actor, TaskID: taskID, Detail: reason})
+}
+
+// incCounter is IncCounter on etcd v3.
+func (c *V3Coordinator) incCounter(name, counter string) error {
+	_, err := c.update(CounterPath(name, counter), func(prev string, ok bool) (string, error) {
+		if !ok {
+			return "1", nil
+		}
+		v, err := strconv.ParseUint(prev, 10, 64)
+		return strconv.FormatUint(v+1, 10), err
+	})
+	return err
+}
+
+// update sets key to what next returns for the value it has, or false if it
+// has none, unless it's changed meanwhile. It tries again then. It returns
+// the revision key is set at.
+func (c *V3Coordinator) update(key string, next func(prev string, ok bool) (string, error)) (int64, error) {
+	for {
+		resp, err := c.get(key)
+		if err != nil {
+			return 0, err
+		}
+		var prev string
+		var rev int64
+		if len(resp.Kvs) > 0 {
+			prev, rev = string(resp.Kvs[0].Value), resp.Kvs[0].ModRevision
+		}
+		value, err := next(prev, len(resp.Kvs) > 0)
+		if err != nil {
+			return 0, err
+		}
+		tresp, err := c.txn([]clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(key), "=", rev)},
+			[]clientv3.Op{clientv3.OpPut(key, value)}, nil)
+		if err != nil {
+			return 0, err
+		}
+		if tresp.Succeeded {
+			return tresp.Header.Revision, nil
+		}
+		// Updated by someone else meanwhile. Try again.
+	}
+}
+
+// heartbeatLease calls beat every interval until stop, like heartbeat, with a
+// lease of the TTL heartbeat computes, kept alive. moved is true at the
+// first call, for beat to put keys onto the lease, since the ones they have
+// been put with are of v3TaskTTL. The lease isn't revoked once stopped, but
+// expires, as keys do on etcd v2.
+func (c *V3Coordinator) heartbeatLease(interval time.Duration, stop chan bool, beat func(lease clientv3.LeaseID, moved bool) error) error {
+	var lease clientv3.LeaseID
+	return heartbeat(interval, stop, func(ttl uint64) error {
+		if lease != 0 {
+			// Keys go along with the lease if it expired, so beat tells.
+			kerr := c.keepAlive(lease)
+			if err := beat(lease, false); err != nil {
+				return err
+			}
+			return kerr
+		}
+		id, err := c.grant(int64(ttl))
+		if err != nil {
+			return err
+		}
+		if err := beat(id, true); err != nil {
+			c.revoke(id)
+			return err
+		}
+		lease = id
+		return nil
+	})
+}
+
+// get reads key, bounded by v3RequestTimeout, as all requests are.
+func (c *V3Coordinator) get(key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), v3RequestTimeout)
+	defer cancel()
+	resp, err := c.client.Get(ctx, key, opts...)
+	return resp, wrapV3Error(err)
+}
+
+// value returns the value of key, or false if it's not set.
+func (c *V3Coordinator) value(key string) (string, bool, error) {
+	resp, err := c.get(key)
+	if err != nil || len(resp.Kvs) == 0 {
+		return "", false, err
+	}
+	return string(resp.Kvs[0].Value), true, nil
+}
+
+// revision returns the current revision of etcd, for watches from then on.
+func (c *V3Coordinator) revision(key string) (int64, error) {
+	resp, err := c.get(key, clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
+func (c *V3Coordinator) put(key, value string, opts ...clientv3.OpOption) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v3RequestTimeout)
+	defer cancel()
+	_, err := c.client.Put(ctx, key, value, opts...)
+	return wrapV3Error(err)
+}
+
+func (c *V3Coordinator) del(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v3RequestTimeout)
+	defer cancel()
+	_, err := c.client.Delete(ctx, key)
+	return wrapV3Error(err)
+}
+
+// txn runs then if all of cmps hold, and els otherwise, at once.
+func (c *V3Coordinator) txn(cmps []clientv3.Cmp, then, els []clientv3.Op) (*clientv3.TxnResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), v3RequestTimeout)
+	defer cancel()
+	resp, err := c.client.Txn(ctx).If(cmps...).Then(then...).Else(els...).Commit()
+	return resp, wrapV3Error(err)
+}
+
+func (c *V3Coordinator) grant(ttl int64) (clientv3.LeaseID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), v3RequestTimeout)
+	defer cancel()
+	resp, err := c.client.Grant(ctx, ttl)
+	if err != nil {
+		return 0, wrapV3Error(err)
+	}
+	return resp.ID, nil
+}
+
+func (c *V3Coordinator) keepAlive(lease clientv3.LeaseID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v3RequestTimeout)
+	defer cancel()
+	_, err := c.client.KeepAliveOnce(ctx, lease)
+	return wrapV3Error(err)
+}
+
+// revoke revokes the lease, deleting keys put with it. It's fine to fail, as
+// the lease expires anyway.
+func (c *V3Coordinator) revoke(lease clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.Background(), v3RequestTimeout)
+	defer cancel()
+	c.client.Revoke(ctx, lease)
+}
+
+// watch passes changes of key, or of keys under it if prefix, from revision
+// rev on to handle, until handle returns false, or stop. It blocks until
+// then.
+func (c *V3Coordinator) watch(key string, rev int64, prefix bool, stop chan bool, handle func(ev *clientv3.Event) bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	opts := []clientv3.OpOption{clientv3.WithRev(rev)}
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	for resp := range c.client.Watch(ctx, key, opts...) {
+		if err := resp.Err(); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("etcdutil: watching %s failed: %v", key, err)
+			}
+			return
+		}
+		for _, ev := range resp.Events {
+			if !handle(ev) {
+				return
+			}
+		}
+	}
+}
+
+// dirKey returns the prefix of keys under dir, so that watches and gets with
+// clientv3.WithPrefix don't take keys of other directories sharing it.
+func dirKey(dir string) string {
+	return dir + "/"
+}
+
+// notExists holds if key has never been created, or been deleted since.
+func notExists(key string) clientv3.Cmp {
+	return clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+}
+
+// registeredAt holds if the task of the master key is registered at addr.
+func registeredAt(master, addr string) clientv3.Cmp {
+	return clientv3.Compare(clientv3.Value(master), "=", addr)
+}
+
+// wrapV3Error is WrapError for errors of clientv3.
+func wrapV3Error(err error) error {
+	if err == context.DeadlineExceeded || status.Code(err) == codes.Unavailable {
+		return merrors.Wrap(merrors.ErrEtcdUnavailable, err)
+	}
+	return err
+}
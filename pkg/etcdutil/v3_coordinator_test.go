@@ -0,0 +1,62 @@
+package etcdutil
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/integration"
+	merrors "github.com/go-distributed/meritop/errors"
+)
+
+func TestV3CoordinatorSetupJob(t *testing.T) {
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+	c := NewV3Coordinator(clus.RandClient())
+
+	if err := c.SetupJob("job", 2); err != nil {
+		t.Fatalf("SetupJob failed: %v", err)
+	}
+	if err := c.SetupJob("job", 3); !merrors.Is(err, merrors.ErrJobExists) {
+		t.Errorf("SetupJob of job set up = %v, want of kind %v", err, merrors.ErrJobExists)
+	}
+	if n, ok, err := c.NumOfTasks("job"); n != 2 || !ok || err != nil {
+		t.Errorf("NumOfTasks = (%d, %v, %v), want (2, true, nil)", n, ok, err)
+	}
+}
+
+// TestV3CoordinatorBarrier checks that the epoch advances, and the barrier
+// passed is cleaned up, at once.
+func TestV3CoordinatorBarrier(t *testing.T) {
+	clus := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer clus.Terminate(t)
+	c := NewV3Coordinator(clus.RandClient())
+	if err := c.SetupJob("job", 2); err != nil {
+		t.Fatalf("SetupJob failed: %v", err)
+	}
+
+	for id := uint64(0); id < 2; id++ {
+		if err := c.MarkEpochDone("job", 0, id); err != nil {
+			t.Fatalf("MarkEpochDone failed: %v", err)
+		}
+	}
+	if passed, err := c.TryPassBarrier("job", 0, 2); passed || err != nil {
+		t.Fatalf("TryPassBarrier not asked to advance = (%v, %v), want (false, nil)", passed, err)
+	}
+	if err := c.MarkEpochAdvance("job", 0); err != nil {
+		t.Fatalf("MarkEpochAdvance failed: %v", err)
+	}
+	if passed, err := c.TryPassBarrier("job", 0, 2); !passed || err != nil {
+		t.Fatalf("TryPassBarrier = (%v, %v), want (true, nil)", passed, err)
+	}
+	if epoch, err := c.Epoch("job"); epoch != 1 || err != nil {
+		t.Errorf("Epoch = (%d, %v), want (1, nil)", epoch, err)
+	}
+	resp, err := c.get(dirKey(BarrierPath("job", 0)), clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil || resp.Count != 0 {
+		t.Errorf("barrier passed has %d keys left, %v", resp.Count, err)
+	}
+	events, err := c.Events("job")
+	if err != nil || len(events) != 1 || events[0].Kind != EventEpoch || events[0].Epoch != 1 {
+		t.Errorf("Events = (%+v, %v), want epoch 1 advanced", events, err)
+	}
+}
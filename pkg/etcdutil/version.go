@@ -0,0 +1,27 @@
+package etcdutil
+
+import (
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// SetProtocolVersion registers the version of the data protocol the node
+// having taken the task talks.
+func SetProtocolVersion(client Client, name string, taskID uint64, version int) error {
+	_, err := client.Set(TaskVersionPath(name, taskID), strconv.Itoa(version), 0)
+	return err
+}
+
+// GetProtocolVersion returns the version of the data protocol registered for
+// the task, or 0 if there is none, e.g. by nodes of older releases.
+func GetProtocolVersion(client Client, name string, taskID uint64) (int, error) {
+	resp, err := client.Get(TaskVersionPath(name, taskID), false, false)
+	if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == ecodeKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(resp.Node.Value)
+}
@@ -0,0 +1,150 @@
+package etcdutil
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// etcd has cleared the events being waited for from its history.
+const ecodeEventIndexCleared = 401
+
+// watchRetryInterval is how long Watch waits before watching again once etcd
+// can't be reached.
+var watchRetryInterval = 500 * time.Millisecond
+
+// Watch is like etcd.Client.Watch with a receiver, but it doesn't give up once
+// the watch breaks, e.g. etcd restarted or the connection dropped. It watches
+// again from the index after the last change passed to receiver until stop.
+// If etcd has cleared the changes missed meanwhile, nodes changed since then
+// are got and passed as "get" responses instead, and nodes gone since are
+// passed as "expire" responses if they had a TTL, or "delete" ones otherwise.
+// receiver is closed once Watch returns.
+func Watch(client Client, key string, index uint64, recursive bool, receiver chan<- *etcd.Response, stop chan bool) {
+	defer close(receiver)
+	// Nodes known to be there, to tell the ones gone while changes are
+	// missed. They're got at the current index of etcd, not at index, so
+	// nodes deleted between index and the Get are only passed on by the
+	// watch. If etcd clears those changes before they're watched, their
+	// deletes are missed.
+	known := make(map[string]*etcd.Node)
+	if resp, err := client.Get(key, false, recursive); err == nil {
+		for _, n := range leafNodes(resp.Node) {
+			known[n.Key] = n
+		}
+	}
+	for {
+		resp, err := client.Watch(key, index, recursive, nil, stop)
+		switch e, ok := err.(*etcd.EtcdError); {
+		case err == nil:
+			index = resp.Node.ModifiedIndex + 1
+			track(known, resp)
+			select {
+			case receiver <- resp:
+			case <-stop:
+				return
+			}
+			continue
+		case err == etcd.ErrWatchStoppedByUser:
+			return
+		case ok && e.ErrorCode == ecodeEventIndexCleared:
+			next, ok := reconcile(client, key, index, recursive, known, receiver, stop)
+			if !ok {
+				return
+			}
+			if next != 0 {
+				index = next
+				continue
+			}
+		}
+		select {
+		case <-time.After(watchRetryInterval):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// track keeps known up to date with the change.
+func track(known map[string]*etcd.Node, resp *etcd.Response) {
+	switch resp.Action {
+	case "set", "create", "update", "compareAndSwap", "get":
+		if !resp.Node.Dir {
+			known[resp.Node.Key] = resp.Node
+		}
+	default:
+		for k := range known {
+			if k == resp.Node.Key || strings.HasPrefix(k, resp.Node.Key+"/") {
+				delete(known, k)
+			}
+		}
+	}
+}
+
+// reconcile passes nodes under key changed since index, and known nodes gone
+// since, to receiver. It returns the index to watch from, which is 0 if key
+// can't be got, and false if stopped.
+func reconcile(client Client, key string, index uint64, recursive bool, known map[string]*etcd.Node, receiver chan<- *etcd.Response, stop chan bool) (uint64, bool) {
+	var nodes []*etcd.Node
+	var etcdIndex uint64
+	resp, err := client.Get(key, false, recursive)
+	switch e, ok := err.(*etcd.EtcdError); {
+	case err == nil:
+		nodes, etcdIndex = leafNodes(resp.Node), resp.EtcdIndex
+	case ok && e.ErrorCode == ecodeKeyNotFound:
+		etcdIndex = e.Index
+	default:
+		return 0, true
+	}
+	var changes []*etcd.Response
+	there := make(map[string]bool)
+	for _, n := range nodes {
+		there[n.Key] = true
+		if n.ModifiedIndex < index {
+			continue
+		}
+		changes = append(changes, &etcd.Response{Action: "get", Node: n, EtcdIndex: etcdIndex})
+	}
+	var gone []string
+	for k := range known {
+		if !there[k] {
+			gone = append(gone, k)
+		}
+	}
+	sort.Strings(gone)
+	for _, k := range gone {
+		prev := known[k]
+		action := "delete"
+		if prev.Expiration != nil {
+			action = "expire"
+		}
+		changes = append(changes, &etcd.Response{
+			Action:    action,
+			Node:      &etcd.Node{Key: k, CreatedIndex: prev.CreatedIndex, ModifiedIndex: etcdIndex},
+			PrevNode:  prev,
+			EtcdIndex: etcdIndex,
+		})
+	}
+	for _, c := range changes {
+		track(known, c)
+		select {
+		case receiver <- c:
+		case <-stop:
+			return 0, false
+		}
+	}
+	return etcdIndex + 1, true
+}
+
+func leafNodes(n *etcd.Node) []*etcd.Node {
+	if !n.Dir {
+		return []*etcd.Node{n}
+	}
+	var nodes []*etcd.Node
+	for _, c := range n.Nodes {
+		nodes = append(nodes, leafNodes(c)...)
+	}
+	return nodes
+}
@@ -0,0 +1,118 @@
+package etcdutil
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// flakyClient fails watches while disconnected, as if etcd can't be reached.
+type flakyClient struct {
+	Client
+	mu           sync.Mutex
+	disconnected bool
+}
+
+func (c *flakyClient) setDisconnected(disconnected bool) {
+	c.mu.Lock()
+	c.disconnected = disconnected
+	c.mu.Unlock()
+}
+
+func (c *flakyClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	c.mu.Lock()
+	disconnected := c.disconnected
+	c.mu.Unlock()
+	if disconnected {
+		return nil, errors.New("connection refused")
+	}
+	type result struct {
+		resp *etcd.Response
+		err  error
+	}
+	// Break off once disconnected, as the connection drops.
+	done := make(chan result, 1)
+	watchStop := make(chan bool)
+	go func() {
+		resp, err := c.Client.Watch(prefix, waitIndex, recursive, receiver, watchStop)
+		done <- result{resp, err}
+	}()
+	for {
+		select {
+		case r := <-done:
+			return r.resp, r.err
+		case <-stop:
+			close(watchStop)
+			<-done
+			return nil, etcd.ErrWatchStoppedByUser
+		case <-time.After(10 * time.Millisecond):
+			c.mu.Lock()
+			disconnected := c.disconnected
+			c.mu.Unlock()
+			if disconnected {
+				close(watchStop)
+				<-done
+				return nil, errors.New("connection reset")
+			}
+		}
+	}
+}
+
+// TestWatchReconcileGone checks that keys gone while the watch is disconnected,
+// and etcd has cleared the changes since, are passed on after all.
+func TestWatchReconcileGone(t *testing.T) {
+	mem := NewMemClient()
+	defer mem.Close()
+	client := &flakyClient{Client: mem}
+	if _, err := mem.Set("/dir/expiring", "v", 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := mem.Set("/dir/deleted", "v", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := mem.Set("/dir/kept", "v", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	resp, err := mem.Get("/dir", false, true)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	receiver := make(chan *etcd.Response, 10)
+	stop := make(chan bool)
+	defer close(stop)
+	client.setDisconnected(true)
+	go Watch(client, "/dir", resp.EtcdIndex+1, true, receiver, stop)
+	// Let it get nodes there before it watches.
+	time.Sleep(100 * time.Millisecond)
+
+	mem.Delete("/dir/deleted", false)
+	mem.Set("/dir/added", "v", 0)
+	// Wait for the key to expire, and clear the history.
+	time.Sleep(1500 * time.Millisecond)
+	for i := 0; i < memHistory; i++ {
+		mem.Set("/other", "v", 0)
+	}
+	client.setDisconnected(false)
+
+	want := map[string]string{
+		"/dir/added":    "get",
+		"/dir/deleted":  "delete",
+		"/dir/expiring": "expire",
+	}
+	for len(want) > 0 {
+		select {
+		case resp := <-receiver:
+			action, ok := want[resp.Node.Key]
+			if !ok || action != resp.Action {
+				t.Fatalf("unexpected %s of %s", resp.Action, resp.Node.Key)
+			}
+			delete(want, resp.Node.Key)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("changes not passed on: %v", want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Package toposchedule composes topologies over epochs, e.g. a reduce tree for
+// ten epochs, and then a broadcast star for one, over and over.
+package toposchedule
+
+import (
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/pkg/topoutil"
+)
+
+// Phase is a topology used for a number of epochs in a row.
+type Phase struct {
+	Topology meritop.Topology
+	Epochs   uint64
+}
+
+// Schedule is a Topology which goes through its phases in order, and starts
+// over after the last one. Topology of a phase is asked with epochs counted
+// only in that phase, so it sees 0, 1, 2, ... however the phases repeat.
+type Schedule struct {
+	phases []Phase
+	cycle  uint64
+}
+
+// New creates a schedule of given phases. Each phase lasts at least one epoch.
+func New(phases ...Phase) *Schedule {
+	if len(phases) == 0 {
+		panic("topology schedule needs at least one phase")
+	}
+	s := &Schedule{phases: phases}
+	for _, p := range phases {
+		if p.Epochs == 0 {
+			panic("phase of topology schedule must last at least one epoch")
+		}
+		s.cycle += p.Epochs
+	}
+	return s
+}
+
+// Repeat is a shortcut of a phase using t for n epochs.
+func Repeat(t meritop.Topology, n uint64) Phase {
+	return Phase{Topology: t, Epochs: n}
+}
+
+func (s *Schedule) SetTaskID(taskID uint64) {
+	for _, p := range s.phases {
+		p.Topology.SetTaskID(taskID)
+	}
+}
+
+func (s *Schedule) SetNumberOfTasks(numOfTasks uint64) {
+	for _, p := range s.phases {
+		p.Topology.SetNumberOfTasks(numOfTasks)
+	}
+}
+
+func (s *Schedule) GetParents(epoch uint64) []uint64 {
+	t, e := s.At(epoch)
+	return t.GetParents(e)
+}
+
+func (s *Schedule) GetChildren(epoch uint64) []uint64 {
+	t, e := s.At(epoch)
+	return t.GetChildren(e)
+}
+
+func (s *Schedule) GetPeers(epoch uint64) []uint64 {
+	t, e := s.At(epoch)
+	return topoutil.Neighbors(t, topoutil.LinkPeer, e)
+}
+
+// GetLinkTypes returns link types of all phases.
+func (s *Schedule) GetLinkTypes() []string {
+	var linkTypes []string
+	seen := make(map[string]bool)
+	for _, p := range s.phases {
+		lt, ok := p.Topology.(meritop.LinkTopology)
+		if !ok {
+			continue
+		}
+		for _, linkType := range lt.GetLinkTypes() {
+			if !seen[linkType] {
+				seen[linkType] = true
+				linkTypes = append(linkTypes, linkType)
+			}
+		}
+	}
+	return linkTypes
+}
+
+func (s *Schedule) GetNeighbors(linkType string, epoch uint64) []uint64 {
+	t, e := s.At(epoch)
+	return topoutil.Neighbors(t, linkType, e)
+}
+
+// At returns the topology used at given epoch, and the epoch in its phase.
+func (s *Schedule) At(epoch uint64) (meritop.Topology, uint64) {
+	n, offset := epoch/s.cycle, epoch%s.cycle
+	for _, p := range s.phases {
+		if offset < p.Epochs {
+			return p.Topology, n*p.Epochs + offset
+		}
+		offset -= p.Epochs
+	}
+	panic("unreachable")
+}
@@ -0,0 +1,34 @@
+package toposchedule
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-distributed/meritop/example"
+)
+
+func TestSchedule(t *testing.T) {
+	// A binary tree of 4 tasks for 2 epochs, then a hypercube for 1 epoch.
+	s := New(Repeat(example.NewTreeTopology(2, 4), 2), Repeat(example.NewHypercubeTopology(4), 1))
+	s.SetTaskID(1)
+	tests := []struct {
+		epoch             uint64
+		parents, children []uint64
+	}{
+		{0, []uint64{0}, []uint64{3}},
+		{1, []uint64{0}, []uint64{3}},
+		// hypercube at its epoch 0 pairs along dimension 0
+		{2, []uint64{0}, []uint64{}},
+		{3, []uint64{0}, []uint64{3}},
+		// hypercube at its epoch 1 pairs along dimension 1
+		{5, []uint64{}, []uint64{3}},
+	}
+	for i, tt := range tests {
+		if p := s.GetParents(tt.epoch); !reflect.DeepEqual(p, tt.parents) {
+			t.Errorf("#%d: parents want = %v, get = %v", i, tt.parents, p)
+		}
+		if c := s.GetChildren(tt.epoch); !reflect.DeepEqual(c, tt.children) {
+			t.Errorf("#%d: children want = %v, get = %v", i, tt.children, c)
+		}
+	}
+}
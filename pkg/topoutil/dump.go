@@ -0,0 +1,83 @@
+package topoutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/go-distributed/meritop"
+)
+
+// Dump writes the graph of a topology at given epoch in DOT format, which can
+// be rendered by Graphviz. Parent to child edges are drawn as arrows, and links
+// of other types as lines labeled with the link type.
+// Topology only knows the links of a task, so newTopology is called to get one
+// for each of numOfTasks tasks.
+func Dump(w io.Writer, newTopology func() meritop.Topology, numOfTasks, epoch uint64) error {
+	edges := make(map[edge]bool)
+	for id := uint64(0); id < numOfTasks; id++ {
+		t := newTopology()
+		t.SetTaskID(id)
+		for _, p := range t.GetParents(epoch) {
+			edges[edge{p, id, ""}] = true
+		}
+		for _, c := range t.GetChildren(epoch) {
+			edges[edge{id, c, ""}] = true
+		}
+		lt, ok := t.(meritop.LinkTopology)
+		if !ok {
+			continue
+		}
+		for _, linkType := range lt.GetLinkTypes() {
+			for _, n := range lt.GetNeighbors(linkType, epoch) {
+				e := edge{id, n, linkType}
+				if n < id {
+					e = edge{n, id, linkType}
+				}
+				edges[e] = true
+			}
+		}
+	}
+	sorted := make(byLink, 0, len(edges))
+	for e := range edges {
+		sorted = append(sorted, e)
+	}
+	sort.Sort(sorted)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph \"epoch %d\" {\n", epoch)
+	for id := uint64(0); id < numOfTasks; id++ {
+		fmt.Fprintf(bw, "\t%d;\n", id)
+	}
+	for _, e := range sorted {
+		if e.linkType == "" {
+			fmt.Fprintf(bw, "\t%d -> %d;\n", e.from, e.to)
+		} else {
+			fmt.Fprintf(bw, "\t%d -> %d [label=%q, dir=none, style=dashed];\n", e.from, e.to, e.linkType)
+		}
+	}
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+type edge struct {
+	from, to uint64
+	// linkType is empty for parent to child edges.
+	linkType string
+}
+
+type byLink []edge
+
+func (s byLink) Len() int      { return len(s) }
+func (s byLink) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byLink) Less(i, j int) bool {
+	a, b := s[i], s[j]
+	if a.linkType != b.linkType {
+		return a.linkType < b.linkType
+	}
+	if a.from != b.from {
+		return a.from < b.from
+	}
+	return a.to < b.to
+}
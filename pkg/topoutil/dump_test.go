@@ -0,0 +1,30 @@
+package topoutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/example"
+)
+
+func TestDump(t *testing.T) {
+	var buf bytes.Buffer
+	err := Dump(&buf, func() meritop.Topology { return example.NewTreeTopology(2, 4) }, 4, 7)
+	if err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+	want := `digraph "epoch 7" {
+	0;
+	1;
+	2;
+	3;
+	0 -> 1;
+	0 -> 2;
+	1 -> 3;
+}
+`
+	if buf.String() != want {
+		t.Errorf("dump want = %q, get = %q", want, buf.String())
+	}
+}
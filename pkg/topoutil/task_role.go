@@ -2,6 +2,14 @@ package topoutil
 
 import "github.com/go-distributed/meritop"
 
+// Link types of parent and child relations.
+const (
+	LinkParent = "parent"
+	LinkChild  = "child"
+	// LinkPeer links peers of PeerTopology.
+	LinkPeer = "peer"
+)
+
 func IsParent(t meritop.Topology, epoch, taskID uint64) bool {
 	for _, id := range t.GetParents(epoch) {
 		if taskID == id {
@@ -19,3 +27,47 @@ func IsChild(t meritop.Topology, epoch, taskID uint64) bool {
 	}
 	return false
 }
+
+// Neighbors returns tasks linked to this task with given link type. Link types
+// "parent" and "child" are answered by any Topology.
+func Neighbors(t meritop.Topology, linkType string, epoch uint64) []uint64 {
+	switch linkType {
+	case LinkParent:
+		return t.GetParents(epoch)
+	case LinkChild:
+		return t.GetChildren(epoch)
+	case LinkPeer:
+		if pt, ok := t.(meritop.PeerTopology); ok {
+			return pt.GetPeers(epoch)
+		}
+	}
+	if lt, ok := t.(meritop.LinkTopology); ok {
+		return lt.GetNeighbors(linkType, epoch)
+	}
+	return nil
+}
+
+// LinkTypeOf returns the link type with which taskID is linked to this task,
+// other than parent and child. Peers are tried first, and then link types in
+// order.
+func LinkTypeOf(t meritop.Topology, epoch, taskID uint64) (string, bool) {
+	if pt, ok := t.(meritop.PeerTopology); ok {
+		for _, id := range pt.GetPeers(epoch) {
+			if id == taskID {
+				return LinkPeer, true
+			}
+		}
+	}
+	lt, ok := t.(meritop.LinkTopology)
+	if !ok {
+		return "", false
+	}
+	for _, linkType := range lt.GetLinkTypes() {
+		for _, id := range lt.GetNeighbors(linkType, epoch) {
+			if id == taskID {
+				return linkType, true
+			}
+		}
+	}
+	return "", false
+}
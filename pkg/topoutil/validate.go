@@ -0,0 +1,112 @@
+package topoutil
+
+import (
+	"fmt"
+
+	"github.com/go-distributed/meritop"
+)
+
+// Validate checks a topology of numOfTasks tasks at epochs 0 to epochs-1:
+// tasks link only to tasks 0 to numOfTasks-1 other than themselves, every task
+// is linked at some epoch unless it's the only one, and links are mutual, i.e.
+// a child of a task has it as a parent, and neighbors of other link types have
+// each other. Tasks of a topology breaking any of these wait for one another
+// forever. As with Dump, newTopology is called to get one for each task.
+func Validate(newTopology func() meritop.Topology, numOfTasks, epochs uint64) error {
+	topologies := make([]meritop.Topology, numOfTasks)
+	for id := range topologies {
+		t := newTopology()
+		t.SetTaskID(uint64(id))
+		topologies[id] = t
+	}
+	linked := make([]bool, numOfTasks)
+	for epoch := uint64(0); epoch < epochs; epoch++ {
+		parents := make(map[edge]bool)
+		for id, t := range topologies {
+			for _, p := range t.GetParents(epoch) {
+				if err := checkLink(uint64(id), p, "parent", numOfTasks, epoch); err != nil {
+					return err
+				}
+				parents[edge{p, uint64(id), ""}] = true
+			}
+		}
+		for id, t := range topologies {
+			for _, c := range t.GetChildren(epoch) {
+				if err := checkLink(uint64(id), c, "child", numOfTasks, epoch); err != nil {
+					return err
+				}
+				e := edge{uint64(id), c, ""}
+				if !parents[e] {
+					return fmt.Errorf("topoutil: task %d has child %d at epoch %d, which doesn't have it as a parent", id, c, epoch)
+				}
+				delete(parents, e)
+				linked[id], linked[c] = true, true
+			}
+		}
+		for id, t := range topologies {
+			for _, p := range t.GetParents(epoch) {
+				if parents[edge{p, uint64(id), ""}] {
+					return fmt.Errorf("topoutil: task %d has parent %d at epoch %d, which doesn't have it as a child", id, p, epoch)
+				}
+			}
+		}
+		if err := validateLinks(topologies, numOfTasks, epoch, linked); err != nil {
+			return err
+		}
+	}
+	if numOfTasks < 2 || epochs == 0 {
+		return nil
+	}
+	for id := range linked {
+		if !linked[id] {
+			return fmt.Errorf("topoutil: task %d isn't linked to any task at epochs 0 to %d", id, epochs-1)
+		}
+	}
+	return nil
+}
+
+// validateLinks checks neighbors of link types other than parent and child have
+// each other at the epoch, marking tasks linked.
+func validateLinks(topologies []meritop.Topology, numOfTasks, epoch uint64, linked []bool) error {
+	neighbors := make(map[edge]bool)
+	for id, t := range topologies {
+		lt, ok := t.(meritop.LinkTopology)
+		if !ok {
+			continue
+		}
+		for _, linkType := range lt.GetLinkTypes() {
+			for _, n := range lt.GetNeighbors(linkType, epoch) {
+				if err := checkLink(uint64(id), n, linkType+" neighbor", numOfTasks, epoch); err != nil {
+					return err
+				}
+				neighbors[edge{uint64(id), n, linkType}] = true
+			}
+		}
+	}
+	for id, t := range topologies {
+		lt, ok := t.(meritop.LinkTopology)
+		if !ok {
+			continue
+		}
+		for _, linkType := range lt.GetLinkTypes() {
+			for _, n := range lt.GetNeighbors(linkType, epoch) {
+				if !neighbors[edge{n, uint64(id), linkType}] {
+					return fmt.Errorf("topoutil: task %d has %s neighbor %d at epoch %d, which doesn't have it as one",
+						id, linkType, n, epoch)
+				}
+				linked[id] = true
+			}
+		}
+	}
+	return nil
+}
+
+func checkLink(id, to uint64, role string, numOfTasks, epoch uint64) error {
+	if to >= numOfTasks {
+		return fmt.Errorf("topoutil: task %d has %s %d at epoch %d, out of tasks 0 to %d", id, role, to, epoch, numOfTasks-1)
+	}
+	if to == id {
+		return fmt.Errorf("topoutil: task %d has itself as %s at epoch %d", id, role, epoch)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package topoutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-distributed/meritop"
+	"github.com/go-distributed/meritop/example"
+)
+
+// orphanTree forgets parents of task 2.
+type orphanTree struct {
+	*example.TreeTopology
+	taskID uint64
+}
+
+func (t *orphanTree) SetTaskID(taskID uint64) {
+	t.taskID = taskID
+	t.TreeTopology.SetTaskID(taskID)
+}
+
+func (t *orphanTree) GetParents(epoch uint64) []uint64 {
+	if t.taskID == 2 {
+		return nil
+	}
+	return t.TreeTopology.GetParents(epoch)
+}
+
+func TestValidate(t *testing.T) {
+	for i, tt := range []struct {
+		newTopology func() meritop.Topology
+		numOfTasks  uint64
+		err         string
+	}{
+		{func() meritop.Topology { return example.NewTreeTopology(2, 7) }, 7, ""},
+		{func() meritop.Topology { return example.NewHypercubeTopology(8) }, 8, ""},
+		{func() meritop.Topology { return example.NewButterflyTopology(8) }, 8, ""},
+		{func() meritop.Topology { return example.NewTreeTopology(2, 1) }, 1, ""},
+		{func() meritop.Topology { return example.NewTreeTopology(2, 7) }, 5, "task 2 has child 5 at epoch 0, out of tasks 0 to 4"},
+		{func() meritop.Topology { return example.NewTreeTopology(2, 3) }, 5, "task 3 isn't linked to any task"},
+		{func() meritop.Topology { return &orphanTree{TreeTopology: example.NewTreeTopology(2, 3)} }, 3,
+			"task 0 has child 2 at epoch 0, which doesn't have it as a parent"},
+	} {
+		err := Validate(tt.newTopology, tt.numOfTasks, 8)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("#%d: Validate failed: %v", i, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("#%d: Validate error = %v, want %q", i, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package topoutil
+
+import (
+	"sort"
+
+	"github.com/go-distributed/meritop"
+)
+
+// SortByWeight returns task IDs sorted by the cost of talking to them, from
+// the cheapest. Tasks of the same cost keep their order. IDs are returned as
+// they are if the topology has no weights.
+func SortByWeight(t meritop.Topology, epoch uint64, ids []uint64) []uint64 {
+	wt, ok := t.(meritop.WeightedTopology)
+	if !ok {
+		return ids
+	}
+	s := &byWeight{ids: make([]uint64, len(ids)), weights: make([]float64, len(ids))}
+	for i, id := range ids {
+		s.ids[i] = id
+		s.weights[i] = wt.GetWeight(epoch, id)
+	}
+	sort.Stable(s)
+	return s.ids
+}
+
+type byWeight struct {
+	ids     []uint64
+	weights []float64
+}
+
+func (s *byWeight) Len() int           { return len(s.ids) }
+func (s *byWeight) Less(i, j int) bool { return s.weights[i] < s.weights[j] }
+func (s *byWeight) Swap(i, j int) {
+	s.ids[i], s.ids[j] = s.ids[j], s.ids[i]
+	s.weights[i], s.weights[j] = s.weights[j], s.weights[i]
+}
@@ -0,0 +1,32 @@
+package topoutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-distributed/meritop/example"
+)
+
+// weightedTree makes children of odd IDs expensive.
+type weightedTree struct {
+	*example.TreeTopology
+}
+
+func (t weightedTree) GetWeight(epoch, taskID uint64) float64 {
+	return float64(taskID % 2)
+}
+
+func TestSortByWeight(t *testing.T) {
+	tree := example.NewTreeTopology(4, 5)
+	tree.SetTaskID(0)
+	children := tree.GetChildren(0)
+	if ids := SortByWeight(tree, 0, children); !reflect.DeepEqual(ids, []uint64{1, 2, 3, 4}) {
+		t.Errorf("unweighted ids want = [1 2 3 4], get = %v", ids)
+	}
+	if ids := SortByWeight(weightedTree{tree}, 0, children); !reflect.DeepEqual(ids, []uint64{2, 4, 1, 3}) {
+		t.Errorf("weighted ids want = [2 4 1 3], get = %v", ids)
+	}
+	if !reflect.DeepEqual(children, []uint64{1, 2, 3, 4}) {
+		t.Errorf("ids changed by sorting: %v", children)
+	}
+}
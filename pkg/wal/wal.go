@@ -0,0 +1,124 @@
+// Package wal implements a local write-ahead log, for tasks to keep updates
+// to large states on the node, e.g. between checkpoints.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrCorrupt is returned by Replay if a record in the middle of the log is
+// broken. A broken record at the end, e.g. torn by a crash while being
+// written, is dropped instead.
+var ErrCorrupt = errors.New("wal: corrupt record")
+
+// headerSize is the size of record header: length and CRC of data.
+const headerSize = 8
+
+// WAL appends records to a file, each synced to disk before Append returns.
+type WAL struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens the log at path, creating it if it doesn't exist.
+func Open(path string) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WAL{file: f}, nil
+}
+
+// Append writes data as a record at the end of the log and syncs it.
+func (w *WAL) Append(data []byte) error {
+	buf := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(data))
+	copy(buf[headerSize:], data)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(buf); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Replay passes records in the log to fn in order they were appended. It
+// stops at the first error fn returns.
+func (w *WAL) Replay(fn func(data []byte) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var offset int64
+	r := bufio.NewReader(w.file)
+	for {
+		data, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF || (err == ErrCorrupt && isLast(r)) {
+			// Torn at the end. Drop it, so that records appended later
+			// won't follow it.
+			return w.file.Truncate(offset)
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+		offset += int64(headerSize + len(data))
+	}
+}
+
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
+
+func isLast(r *bufio.Reader) bool {
+	_, err := r.Peek(1)
+	return err == io.EOF
+}
+
+// Truncate drops all records, e.g. once the state they update is saved.
+func (w *WAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close closes the log file.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
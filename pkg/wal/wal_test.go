@@ -0,0 +1,72 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "task.wal")
+
+	w, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	records := [][]byte{[]byte("a"), {}, []byte("gradient")}
+	for _, r := range records {
+		if err := w.Append(r); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	w.Close()
+
+	// Torn while appending the last record.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 5, 1, 2})
+	f.Close()
+
+	if w, err = Open(path); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer w.Close()
+	if get := replay(t, w); !reflect.DeepEqual(get, records) {
+		t.Errorf("records want = %q, get = %q", records, get)
+	}
+	if err := w.Append([]byte("b")); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	want := append(records, []byte("b"))
+	if get := replay(t, w); !reflect.DeepEqual(get, want) {
+		t.Errorf("records want = %q, get = %q", want, get)
+	}
+
+	if err := w.Truncate(); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if get := replay(t, w); len(get) != 0 {
+		t.Errorf("records after Truncate = %q, want none", get)
+	}
+}
+
+func replay(t *testing.T, w *WAL) [][]byte {
+	var records [][]byte
+	err := w.Replay(func(data []byte) error {
+		records = append(records, data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	return records
+}
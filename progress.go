@@ -0,0 +1,24 @@
+package meritop
+
+import "time"
+
+// Progress is what a task reports of its work in an epoch, e.g. for a
+// dashboard to tell how a job is going, see ProgressReporter.
+type Progress struct {
+	// Items are how many items, e.g. examples, the task processed.
+	Items uint64 `json:"items"`
+	// Loss is of the model the task trains, if it does.
+	Loss float64 `json:"loss"`
+	// Duration is how long the task has worked in the epoch.
+	Duration time.Duration `json:"duration"`
+}
+
+// ProgressReporter lets tasks report their progress in the epoch of the
+// context, which the controller aggregates for the job, see
+// controller.Controller.Progress. A task can report many times in an epoch;
+// the last report counts. Duration left 0 is taken to be since the task
+// started the epoch. Context of framework implements it, so tasks can get it
+// by asserting Context.
+type ProgressReporter interface {
+	ReportProgress(p Progress)
+}
@@ -0,0 +1,13 @@
+package meritop
+
+// StateStore keeps the latest checkpoint of each task, see
+// Framework.SaveState. Framework keeps them in etcd by default, which is only
+// fit for small states. Tasks with larger ones could plug in a blob store.
+type StateStore interface {
+	// Save replaces the checkpoint of the task with data taken at epoch.
+	Save(taskID, epoch uint64, data []byte) error
+
+	// Load returns the latest checkpoint of the task. It returns false if
+	// the task has none.
+	Load(taskID uint64) (epoch uint64, data []byte, ok bool, err error)
+}
@@ -0,0 +1,23 @@
+package meritop
+
+// Store is a small key-value store of the task, see Framework.Store. Values
+// are kept in etcd along with the task, so whoever takes the task over finds
+// them. It's only fit for small values, e.g. counters and offsets.
+type Store interface {
+	// Get returns the value of key. It returns false if key isn't set.
+	Get(key string) (value []byte, ok bool, err error)
+
+	// Set sets key to value.
+	Set(key string, value []byte) error
+
+	// CompareAndSwap sets key to value only if it's prev now, or isn't set if
+	// prev is nil. It returns false if it isn't.
+	CompareAndSwap(key string, prev, value []byte) (bool, error)
+
+	// Delete unsets key.
+	Delete(key string) error
+
+	// Watch passes values key is set to from now on, and nil once it's
+	// unset, until stop is closed. The channel returned is closed then.
+	Watch(key string, stop chan bool) <-chan []byte
+}
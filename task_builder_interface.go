@@ -10,3 +10,22 @@ type TaskBuilder interface {
 	// right task implementation for given node/task.
 	GetTask(taskID uint64) Task
 }
+
+// TaskBuilderV2 can be implemented by builders picking tasks by the job rather
+// than by task ID alone, e.g. which tasks are masters by the topology or the
+// configuration. Framework calls BuildTask instead of GetTask if so.
+type TaskBuilderV2 interface {
+	TaskBuilder
+	BuildTask(info TaskInfo) Task
+}
+
+// TaskInfo describes the task to build, and the job it's part of.
+type TaskInfo struct {
+	TaskID uint64
+	// NumOfTasks is the number of tasks of the job as the task starts.
+	NumOfTasks uint64
+	// Config is the configuration of the job loaded from etcd.
+	Config Config
+	// Topology has been set up for the task.
+	Topology Topology
+}
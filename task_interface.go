@@ -1,5 +1,11 @@
 package meritop
 
+import (
+	"io"
+
+	"golang.org/x/net/context"
+)
+
 // Task is a logic repersentation of a computing unit.
 // Each task contain at least one Node.
 // Each task has exact one master Node and might have multiple salve Nodes.
@@ -27,8 +33,208 @@ type Task interface {
 	ServeAsChild(fromID uint64, req string) []byte
 }
 
-type UpdateLog interface {
-	UpdateID()
+// LinkedTask is an interface that task should implement when its Topology is a
+// LinkTopology. Framework calls it for metas and data requests from neighbors,
+// along with the link type which the neighbor is linked with. Parents and
+// children are always handled by Task, even if they are neighbors too.
+type LinkedTask interface {
+	NeighborMetaReady(ctx Context, linkType string, fromID uint64, meta string)
+	ServeAsNeighbor(linkType string, fromID uint64, req string) []byte
+	NeighborDataReady(ctx Context, linkType string, fromID uint64, req string, resp []byte)
+}
+
+// PeerTask is an interface that task should implement when its Topology is a
+// PeerTopology. Framework calls it for metas and data requests from peers.
+type PeerTask interface {
+	PeerMetaReady(ctx Context, fromID uint64, meta string)
+	ServeAsPeer(fromID uint64, req string) []byte
+	PeerDataReady(ctx Context, fromID uint64, req string, resp []byte)
+}
+
+// TypedMetaReceiver can be implemented by tasks to receive metas with their
+// payload. If so, framework calls these instead of ParentMetaReady and
+// ChildMetaReady. Metas flagged as plain strings arrive with the string as
+// kind and no payload.
+type TypedMetaReceiver interface {
+	ParentTypedMetaReady(ctx Context, parentID uint64, meta *Meta)
+	ChildTypedMetaReady(ctx Context, childID uint64, meta *Meta)
+}
+
+// TypedTask can be implemented by tasks to exchange values of their own types
+// with parents and children instead of bytes. Values served are marshaled with
+// the codec of the job (see Framework.GetCodec), and data received is
+// unmarshaled into a value of the type registered with it, so types need to be
+// registered on both ends. Framework calls these instead of ServeAsParent,
+// ServeAsChild, ParentDataReady and ChildDataReady then.
+type TypedTask interface {
+	ServeAsParentTyped(fromID uint64, req string) interface{}
+	ServeAsChildTyped(fromID uint64, req string) interface{}
+	ParentTypedDataReady(ctx Context, parentID uint64, req string, v interface{})
+	ChildTypedDataReady(ctx Context, childID uint64, req string, v interface{})
+}
+
+// ConfigWatcher can be implemented by tasks to pick up changes to the
+// configuration of the job while it's running, e.g. a learning rate tuned by
+// the operator. Framework calls ConfigUpdated with the whole configuration
+// each time the controller changes it (see Controller.SetConfig).
+type ConfigWatcher interface {
+	ConfigUpdated(ctx Context, config Config)
+}
+
+// ResizeHandler can be implemented by tasks to find out the job has been
+// resized (see controller.Resize), e.g. to repartition data. Topology has been
+// updated by then. Framework calls NumOfTasksChanged at the start of the first
+// epoch with the new number of tasks, before SetEpoch. Tasks the job has been
+// shrunk off exit instead.
+type ResizeHandler interface {
+	NumOfTasksChanged(ctx Context, numOfTasks uint64)
+}
+
+// EpochGate can be implemented by tasks which mustn't be torn out of their work
+// by the epoch changing, e.g. in the middle of a long computation.
+type EpochGate interface {
+	// ReadyForEpoch is asked before framework moves on to epoch next. If it
+	// returns false, framework waits for WaitReady to return before it does,
+	// handling events of the current epoch meanwhile. The job shutting down
+	// isn't waited for.
+	ReadyForEpoch(next uint64) bool
+	WaitReady()
+}
+
+// BatchDataReceiver can be implemented by tasks requesting data with
+// Context.DataRequestAll or DataRequestQuorum. Responses of such a request are
+// passed in one call, keyed by responding task. Otherwise they are passed to
+// ParentDataReady/ChildDataReady one by one.
+type BatchDataReceiver interface {
+	DataAllReady(ctx Context, req string, resps map[uint64][]byte)
+}
+
+// DataPushReceiver can be implemented by tasks accepting data pushed by
+// parents or children with Context.DataPush, e.g. parameters pushed by parent
+// at the start of an epoch. Pushed data is dropped if the task doesn't
+// implement it.
+type DataPushReceiver interface {
+	DataPushed(ctx Context, fromID uint64, req string, data []byte)
+}
+
+// DataRequestFailureHandler can be implemented by tasks to learn about data
+// requests framework has given up on, e.g. after retries or because the
+// serving task moved to another epoch. The task can then request again, skip
+// the neighbor or abort, telling the failures apart with meritop/errors, e.g.
+// ErrNeighborUnreachable or ErrEpochConflict. Requests canceled by framework on
+// epoch change are not reported.
+type DataRequestFailureHandler interface {
+	DataRequestFailed(ctx Context, toID uint64, req string, err error)
+}
+
+// StreamingReducer is an interface that task can implement to reduce data of
+// children while it is still being transferred. Child serves the data in
+// segments sent on the returned channel, and closes it after the last one.
+// Framework calls ChildDataChunk on parent for each segment as it arrives,
+// instead of ChildDataReady, and then once more with last set and no chunk.
+// Both ends of the transfer are expected to implement it.
+type StreamingReducer interface {
+	ServeAsChildChunks(fromID uint64, req string) <-chan []byte
+	ChildDataChunk(ctx Context, fromID uint64, req string, chunk []byte, last bool)
+}
+
+// Restorable is an interface that task can implement to recover from the
+// checkpoint saved with Framework.SaveState, e.g. after it failed and was taken
+// over by a standby. Framework calls Restore right after Init if there is one.
+type Restorable interface {
+	Restore(epoch uint64, data []byte)
+}
+
+// TaskV2 is an interface that task can implement to report failures of the
+// calls framework makes to it, which Task can't. Framework calls these instead
+// of Init, SetEpoch, ServeAsParent and ServeAsChild then.
+//   - TryInit and TrySetEpoch failing is fatal. The task is stopped, and taken
+//     over by a standby, which starts the epoch over.
+//   - TryServeAsParent and TryServeAsChild can fail with an error of kind
+//     meritop/errors.ErrNotReady, which makes the requester retry later, or
+//     ErrBadRequest, which is passed to its DataRequestFailureHandler. Other
+//     errors are retried like network failures.
+type TaskV2 interface {
+	TryInit(taskID uint64, framework Framework) error
+	TrySetEpoch(ctx Context, epoch uint64) error
+	TryServeAsParent(fromID uint64, req string) ([]byte, error)
+	TryServeAsChild(fromID uint64, req string) ([]byte, error)
+}
+
+// ContextTask is an interface that task can implement to give up work which is
+// no longer needed, e.g. blocking calls to etcd or other services. Framework
+// calls these instead of Init, SetEpoch, ServeAsParent and ServeAsChild then.
+// ctx of InitContext is canceled once framework stops, and the others also
+// once the epoch changes.
+type ContextTask interface {
+	InitContext(ctx context.Context, taskID uint64, framework Framework)
+	SetEpochContext(ctx context.Context, epochCtx Context, epoch uint64)
+	ServeAsParentContext(ctx context.Context, fromID uint64, req string) []byte
+	ServeAsChildContext(ctx context.Context, fromID uint64, req string) []byte
+}
+
+// EpochStallHandler is an interface that task can implement to find out
+// that an epoch doesn't advance, e.g. since a message was lost. With a deadline
+// set up (see framework.WithEpochDeadline), framework calls EpochStalled on
+// the task designated each time the epoch has stayed for that long. The task
+// can request data again, drop stragglers, or shut down the job.
+type EpochStallHandler interface {
+	EpochStalled(ctx Context, epoch uint64)
+}
+
+// Checkpointer is an interface that task can implement to take part in
+// checkpoints of the whole job the controller asks for (see
+// controller.Checkpoint). Framework calls Checkpoint at the start of the epoch
+// asked, before SetEpoch, and saves the returned state to the state store, to
+// be passed to Restorable.Restore once the job resumes from there.
+type Checkpointer interface {
+	Checkpoint(epoch uint64) []byte
+}
+
+// EpochRollbacker is an interface that task can implement to tell the job
+// rolling back, e.g. to start again the epoch a task died in, from moving
+// forward. Framework calls RollbackEpoch then instead of SetEpoch. Metas and
+// data of the epoch from before are gone.
+type EpochRollbacker interface {
+	RollbackEpoch(ctx Context, epoch uint64)
+}
+
+// ChildrenReadyHandler is an interface that task can implement to aggregate
+// data of children without waiting for every one of them, e.g. if a leaf
+// died. With a policy set up (see framework.WithChildrenReady), framework
+// calls ChildrenReady once per epoch, after enough children have had their
+// data delivered or the timeout elapsed. partial tells whether some children
+// haven't.
+type ChildrenReadyHandler interface {
+	ChildrenReady(ctx Context, partial bool)
+}
+
+// StatefulTask is an interface that task can implement to learn about its
+// parents and children failing and being taken over, e.g. to stop waiting for
+// data of a dead child. Die is called once framework detects the failure,
+// which takes a few heartbeats. Restart is called once another node has taken
+// the task over, which starts over the current epoch.
+type StatefulTask interface {
+	ParentDie(ctx Context, parentID uint64)
+	ChildDie(ctx Context, childID uint64)
+	ParentRestart(ctx Context, parentID uint64)
+	ChildRestart(ctx Context, childID uint64)
+}
+
+// FrameworkErrorHandler is an interface that task can implement to learn about
+// errors framework ran into, instead of framework only logging them. err is a
+// *FrameworkError, whose severity tells whether the task keeps running, and
+// whose kind can be told with meritop/errors.
+type FrameworkErrorHandler interface {
+	OnFrameworkError(err error)
+}
+
+// UpdateLog is an update of task state shipped from primary to backups. IDs
+// are assigned by framework, increasing by one for each update of the task,
+// so backups can tell where they are in the log.
+type UpdateLog struct {
+	ID   uint64
+	Data []byte
 }
 
 // Backupable is an interface that task need to implement if they want to have
@@ -39,6 +245,33 @@ type Backupable interface {
 	BecameBackup()
 
 	// Framework notify this copy to update. This should be the only way that
-	// one update the state of copy.
+	// one update the state of copy. Logs are passed in order of IDs. A new
+	// backup is passed all logs kept so far before the ones shipped later.
 	Update(log UpdateLog)
 }
+
+// StaleDataHandler is an interface that task can implement to learn about
+// data responses arriving after the epoch they were requested in is over.
+// Framework drops such responses instead of calling ParentDataReady or
+// ChildDataReady.
+type StaleDataHandler interface {
+	StaleDataReady(epoch uint64, fromID uint64, req string, resp []byte)
+}
+
+// DataStreamer is an interface that task can implement if the data it serves
+// is too large to be held in memory at once. Framework then sends out the
+// returned stream as it is read, instead of calling ServeAsParent/ServeAsChild,
+// and closes it once done.
+type DataStreamer interface {
+	ServeAsParentStream(fromID uint64, req string) io.ReadCloser
+	ServeAsChildStream(fromID uint64, req string) io.ReadCloser
+}
+
+// DataStreamReceiver is an interface that task can implement to receive large
+// data chunk by chunk. Framework calls these instead of ParentDataReady and
+// ChildDataReady, and each read on r fetches the next chunk off the wire.
+// r is only valid until the call returns.
+type DataStreamReceiver interface {
+	ParentDataStream(ctx Context, parentID uint64, req string, r io.Reader)
+	ChildDataStream(ctx Context, childID uint64, req string, r io.Reader)
+}
@@ -30,3 +30,36 @@ type Topology interface {
 	// Inform the new NumberOfTasks, this allow the number of tasks to change.
 	SetNumberOfTasks(numOfTasks uint64)
 }
+
+// WeightedTopology can be implemented by Topology to tell the cost of talking
+// to linked tasks, e.g. higher over slow links of a mixed cluster. Framework
+// sends requests of DataRequestAll to cheaper tasks first, so that with a
+// quorum, data from the cheapest ones is likely what arrives first.
+type WeightedTopology interface {
+	// GetWeight returns the cost of the edge between this task and taskID at
+	// the given epoch. Edges without a cost can be weighted 0.
+	GetWeight(epoch uint64, taskID uint64) float64
+}
+
+// PeerTopology can be implemented by Topology to let sibling tasks, e.g.
+// workers under the same parent, exchange data directly instead of through
+// their parent. Peers flag meta with FlagMetaToPeer and are served by PeerTask
+// callbacks.
+type PeerTopology interface {
+	// GetPeers returns the peers' IDs of this task at the given epoch.
+	GetPeers(epoch uint64) []uint64
+}
+
+// LinkTopology can be implemented by Topology to link tasks in more ways than
+// parent and child, e.g. to have a reduce tree and a broadcast tree in one job.
+// Tasks linked by such a link type are neighbors of each other, which flag
+// meta with FlagMetaToNeighbors and are served by LinkedTask callbacks.
+type LinkTopology interface {
+	// GetLinkTypes returns the link types other than parent and child.
+	GetLinkTypes() []string
+
+	// GetNeighbors returns the IDs of tasks linked to this task with given
+	// link type at the given epoch. Link types "parent" and "child" are the
+	// same as GetParents and GetChildren.
+	GetNeighbors(linkType string, epoch uint64) []uint64
+}